@@ -132,6 +132,15 @@ func Locate(context Context, uri string) (*url.URL, error) {
 	return GetLocation(with(context, bearerMedia), uri)
 }
 
+// Lyrics returns the lyrics for the track location, which is like
+// /api/tracks/{uuid}/location.
+func Lyrics(context Context, location string) (view.Lyrics, error) {
+	var result view.Lyrics
+	uri := strings.TrimSuffix(location, "/location") + "/lyrics"
+	err := get(context, uri, &result)
+	return result, err
+}
+
 func Progress(context Context) (view.Progress, error) {
 	var result view.Progress
 	err := get(context, "/api/progress", &result)
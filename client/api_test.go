@@ -237,6 +237,24 @@ func (s testApiServer) RoundTrip(r *http.Request) (*http.Response, error) {
 			StatusCode: 307,
 			Header:     headers,
 		}, nil
+	} else if r.URL.Path == "/api/tracks/test-track-uuid/lyrics" {
+		if bearer(r) == "" {
+			return &http.Response{
+				StatusCode: 401,
+				Header:     headers,
+			}, nil
+		}
+		headers.Add("Content-type", "application/json")
+		result := view.Lyrics{
+			Synced: true,
+			Lines:  []view.LyricsLine{{Time: 12.5, Text: "test line"}},
+		}
+		data, _ := json.Marshal(result)
+		return &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(bytes.NewBuffer(data)),
+			Header:     headers,
+		}, nil
 	} else if r.URL.Path == "/api/progress" {
 		if bearer(r) == "" {
 			return &http.Response{
@@ -381,6 +399,19 @@ func TestLocate(t *testing.T) {
 	}
 }
 
+func TestLyrics(t *testing.T) {
+	c := newTestApiContext(t)
+	c.accessToken = "test-access-token"
+	c.refreshToken = "test-refresh-token"
+	lyrics, err := Lyrics(c, "/api/tracks/test-track-uuid/location")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lyrics.Synced || len(lyrics.Lines) != 1 || lyrics.Lines[0].Text != "test line" {
+		t.Error("expect lyrics", lyrics)
+	}
+}
+
 func TestProgress(t *testing.T) {
 	c := newTestApiContext(t)
 	_, err := Progress(c)
@@ -0,0 +1,83 @@
+// Copyright 2024 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"takeoutfm.dev/takeout/internal/server"
+)
+
+var activityCmd = &cobra.Command{
+	Use:   "activity",
+	Short: "manage user activity",
+	Long:  `Manage user activity such as listening history.`,
+}
+
+var activityImportCmd = &cobra.Command{
+	Use:   "import FILE",
+	Short: "import listening history",
+	Long:  `Import listens from a ListenBrainz JSON export or Last.fm CSV/JSON export.`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return importActivity(args[0])
+	},
+}
+
+var activityUser string
+var activityFormat string
+
+func importActivity(file string) error {
+	cfg, err := getConfig()
+	if err != nil {
+		return err
+	}
+	if activityUser == "" {
+		return errors.New("user required")
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	report, err := server.ImportActivity(cfg, activityUser, activityFormat, f)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("listens:    %d\n", report.Listens)
+	fmt.Printf("imported:   %d\n", report.Imported)
+	fmt.Printf("duplicates: %d\n", report.Duplicates)
+	fmt.Printf("unmatched:  %d\n", report.Unmatched)
+	fmt.Printf("invalid:    %d\n", report.Invalid)
+	for _, m := range report.Missing {
+		fmt.Printf("  %s\n", m)
+	}
+	return nil
+}
+
+func init() {
+	activityImportCmd.Flags().StringVarP(&configFile, "config", "c", "", "config file")
+	activityImportCmd.Flags().StringVarP(&activityUser, "user", "u", "", "user name")
+	activityImportCmd.Flags().StringVarP(&activityFormat, "format", "f", "listenbrainz", "listenbrainz or lastfm")
+	activityCmd.AddCommand(activityImportCmd)
+	rootCmd.AddCommand(activityCmd)
+}
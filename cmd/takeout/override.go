@@ -0,0 +1,206 @@
+// Copyright 2024 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"takeoutfm.dev/takeout/internal/config"
+	"takeoutfm.dev/takeout/internal/film"
+	"takeoutfm.dev/takeout/internal/music"
+	"takeoutfm.dev/takeout/internal/tv"
+	"takeoutfm.dev/takeout/model"
+)
+
+var overrideCmd = &cobra.Command{
+	Use:   "override",
+	Short: "metadata overrides",
+	Long: `Pin release folders to MusicBrainz releases, force track titles and
+numbering, and pin movie and tv files to TMDB ids.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return override()
+	},
+}
+
+var overrideFolder, overrideREID, overrideRGID, overrideName string
+var overrideKey, overrideTitle string
+var overrideTrack, overrideDisc int
+var overrideMovie string
+var overrideTMID int64
+var overrideSeries, overrideYear string
+var overrideTVID int64
+var overrideDelete string
+var overrideID int
+var overrideList bool
+
+var ErrUnknownOverride = errors.New("unknown override; use release, track, movie or tv")
+
+func override() error {
+	cfg, err := getConfig()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case overrideList:
+		return listOverrides(cfg)
+	case overrideDelete != "":
+		return deleteOverride(cfg)
+	case overrideFolder != "":
+		return withMusic(cfg, func(m *music.Music) error {
+			return m.SaveReleaseOverride(&model.ReleaseOverride{
+				Folder: overrideFolder,
+				REID:   overrideREID,
+				RGID:   overrideRGID,
+				Name:   overrideName,
+			})
+		})
+	case overrideKey != "":
+		return withMusic(cfg, func(m *music.Music) error {
+			return m.SaveTrackOverride(&model.TrackOverride{
+				Key:      overrideKey,
+				Title:    overrideTitle,
+				TrackNum: overrideTrack,
+				DiscNum:  overrideDisc,
+			})
+		})
+	case overrideMovie != "":
+		return withFilm(cfg, func(f *film.Film) error {
+			return f.SaveMovieOverride(&model.MovieMatch{
+				Key:  overrideMovie,
+				TMID: overrideTMID,
+			})
+		})
+	case overrideSeries != "":
+		return withTV(cfg, func(t *tv.TV) error {
+			return t.SaveTVOverride(&model.TVMatch{
+				Name: overrideSeries,
+				Year: overrideYear,
+				TVID: overrideTVID,
+			})
+		})
+	}
+	return listOverrides(cfg)
+}
+
+func listOverrides(cfg *config.Config) error {
+	err := withMusic(cfg, func(m *music.Music) error {
+		for _, o := range m.ReleaseOverrides() {
+			fmt.Printf("release %d %s -> reid=%s rgid=%s name=%s\n",
+				o.ID, o.Folder, o.REID, o.RGID, o.Name)
+		}
+		for _, o := range m.TrackOverrides() {
+			fmt.Printf("track %d %s -> title=%s track=%d disc=%d\n",
+				o.ID, o.Key, o.Title, o.TrackNum, o.DiscNum)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = withFilm(cfg, func(f *film.Film) error {
+		for _, o := range f.MovieOverrides() {
+			fmt.Printf("movie %d %s -> tmid=%d\n", o.ID, o.Key, o.TMID)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return withTV(cfg, func(t *tv.TV) error {
+		for _, o := range t.TVOverrides() {
+			fmt.Printf("tv %d %s (%s) -> tvid=%d\n", o.ID, o.Name, o.Year, o.TVID)
+		}
+		return nil
+	})
+}
+
+func deleteOverride(cfg *config.Config) error {
+	switch overrideDelete {
+	case "release":
+		return withMusic(cfg, func(m *music.Music) error {
+			return m.DeleteReleaseOverride(overrideID)
+		})
+	case "track":
+		return withMusic(cfg, func(m *music.Music) error {
+			return m.DeleteTrackOverride(overrideID)
+		})
+	case "movie":
+		return withFilm(cfg, func(f *film.Film) error {
+			return f.DeleteMovieOverride(overrideID)
+		})
+	case "tv":
+		return withTV(cfg, func(t *tv.TV) error {
+			return t.DeleteTVOverride(overrideID)
+		})
+	}
+	return ErrUnknownOverride
+}
+
+func withMusic(cfg *config.Config, fn func(*music.Music) error) error {
+	m := music.NewMusic(cfg)
+	err := m.Open()
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	return fn(m)
+}
+
+func withFilm(cfg *config.Config, fn func(*film.Film) error) error {
+	f := film.NewFilm(cfg)
+	err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return fn(f)
+}
+
+func withTV(cfg *config.Config, fn func(*tv.TV) error) error {
+	t := tv.NewTV(cfg)
+	err := t.Open()
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+	return fn(t)
+}
+
+func init() {
+	overrideCmd.Flags().StringVarP(&configFile, "config", "c", "", "config file")
+	overrideCmd.Flags().BoolVarP(&overrideList, "list", "l", false, "List overrides")
+	overrideCmd.Flags().StringVar(&overrideFolder, "folder", "", "Release folder (bucket key prefix)")
+	overrideCmd.Flags().StringVar(&overrideREID, "reid", "", "MusicBrainz release id")
+	overrideCmd.Flags().StringVar(&overrideRGID, "rgid", "", "MusicBrainz release group id")
+	overrideCmd.Flags().StringVar(&overrideName, "name", "", "Release name")
+	overrideCmd.Flags().StringVar(&overrideKey, "key", "", "Track bucket key")
+	overrideCmd.Flags().StringVar(&overrideTitle, "title", "", "Track title")
+	overrideCmd.Flags().IntVar(&overrideTrack, "track", 0, "Track number")
+	overrideCmd.Flags().IntVar(&overrideDisc, "disc", 0, "Disc number")
+	overrideCmd.Flags().StringVar(&overrideMovie, "movie", "", "Movie bucket key")
+	overrideCmd.Flags().Int64Var(&overrideTMID, "tmid", 0, "TMDB movie id")
+	overrideCmd.Flags().StringVar(&overrideSeries, "series", "", "TV series name")
+	overrideCmd.Flags().StringVar(&overrideYear, "year", "", "TV series year")
+	overrideCmd.Flags().Int64Var(&overrideTVID, "tvid", 0, "TMDB tv id")
+	overrideCmd.Flags().StringVar(&overrideDelete, "delete", "", "Delete override type: release, track, movie or tv")
+	overrideCmd.Flags().IntVar(&overrideID, "id", 0, "Override id to delete")
+	rootCmd.AddCommand(overrideCmd)
+}
@@ -28,12 +28,16 @@ import (
 	"takeoutfm.dev/takeout/internal/film"
 	"takeoutfm.dev/takeout/internal/music"
 	"takeoutfm.dev/takeout/internal/podcast"
+	"takeoutfm.dev/takeout/internal/tv"
 	"takeoutfm.dev/takeout/lib/date"
+	"takeoutfm.dev/takeout/lib/lastfm"
+	"takeoutfm.dev/takeout/lib/listenbrainz"
 	"takeoutfm.dev/takeout/lib/log"
 	. "takeoutfm.dev/takeout/model"
 
 	"errors"
 	"strconv"
+	"sync"
 	"time"
 )
 
@@ -42,10 +46,13 @@ var (
 	ErrInvalidTrackEvent   = errors.New("invalid track event")
 	ErrInvalidEpisodeEvent = errors.New("invalid episode event")
 	ErrInvalidMovieEvent   = errors.New("invalid movie event")
+	ErrInvalidTVEvent      = errors.New("invalid tv episode event")
+	ErrInvalidSkipEvent    = errors.New("invalid track skip event")
 	ErrTrackNotFound       = errors.New("track not found")
 	ErrMovieNotFound       = errors.New("movie not found")
 	ErrEpisodeNotFound     = errors.New("episode not found")
 	ErrReleaseNotFound     = errors.New("release not found")
+	ErrInvalidScrobble     = errors.New("invalid scrobble")
 )
 
 type Context interface {
@@ -53,16 +60,26 @@ type Context interface {
 	Podcast() *podcast.Podcast
 	User() auth.User
 	Film() *film.Film
+	TV() *tv.TV
 }
 
 type Activity struct {
 	config *config.Config
 	db     *gorm.DB
+	lastfm *lastfm.Lastfm
+	lbz    *listenbrainz.ListenBrainz
+
+	recommendMu sync.Mutex
+	recommend   map[string]recommendEntry // user recommendations
 }
 
 func NewActivity(config *config.Config) *Activity {
+	client := config.NewGetter()
 	return &Activity{
-		config: config,
+		config:    config,
+		lastfm:    lastfm.NewLastfm(config.LastFM, client),
+		lbz:       listenbrainz.NewListenBrainz(client),
+		recommend: make(map[string]recommendEntry),
 	}
 }
 
@@ -91,15 +108,30 @@ func (a *Activity) DeleteUserEvents(ctx Context) error {
 		log.Println("track delete error: ", err)
 		return err
 	}
+	err = a.deleteTVEpisodeEvents(user.Name)
+	if err != nil {
+		log.Println("tv delete error: ", err)
+		return err
+	}
+	err = a.deleteTrackSkipEvents(user.Name)
+	if err != nil {
+		log.Println("skip delete error: ", err)
+		return err
+	}
 	return nil
 }
 
 func (a *Activity) resolveMovieEvent(ctx Context, e MovieEvent) (ActivityMovie, error) {
 	f := ctx.Film()
-	if e.IMID == "" {
-		return ActivityMovie{}, ErrMovieNotFound
+	var err error
+	var movie Movie
+	if e.IMID != "" {
+		movie, err = f.FindMovie("imid:" + e.IMID)
+	} else if e.TMID != "" {
+		movie, err = f.FindMovie("tmid:" + e.TMID)
+	} else {
+		err = ErrMovieNotFound
 	}
-	movie, err := f.FindMovie(e.IMID)
 	if err != nil {
 		return ActivityMovie{}, err
 	}
@@ -217,35 +249,43 @@ func (a *Activity) TrackCountsByMonth(ctx Context, start, end time.Time) []Activ
 	return fillMonthGaps(start, end, counts)
 }
 
-func countGroupByDay(events []TrackEvent, location *time.Location) []*ActivityCount {
-	counts := make(map[string]*ActivityCount)
-	for _, e := range events {
-		d := e.Date.In(location)
-		key := date.YMD(d)
-		_, ok := counts[key]
-		if !ok {
-			counts[key] = &ActivityCount{Date: date.StartOfDay(d), Count: 1}
-		} else {
-			counts[key].Count++
-		}
+func trackCounts(events []TrackEvent) []ActivityCount {
+	counts := make([]ActivityCount, len(events))
+	for i, e := range events {
+		counts[i] = ActivityCount{Date: e.Date, Count: 1}
 	}
-	result := maps.Values(counts)
-	sort.SliceStable(result, func(i, j int) bool {
-		return result[i].Date.Before(result[j].Date)
-	})
-	return result
+	return counts
+}
+
+func countGroupByDay(events []TrackEvent, location *time.Location) []*ActivityCount {
+	return sumGroupByDay(trackCounts(events), location)
 }
 
 func countGroupByMonth(events []TrackEvent, location *time.Location) []*ActivityCount {
+	return sumGroupByMonth(trackCounts(events), location)
+}
+
+// sumGroupByDay sums counts, such as listens or minutes watched, by day.
+func sumGroupByDay(values []ActivityCount, location *time.Location) []*ActivityCount {
+	return sumGroupBy(values, location, date.YMD, date.StartOfDay)
+}
+
+// sumGroupByMonth sums counts by month.
+func sumGroupByMonth(values []ActivityCount, location *time.Location) []*ActivityCount {
+	return sumGroupBy(values, location, date.YM1, date.StartOfMonth)
+}
+
+func sumGroupBy(values []ActivityCount, location *time.Location,
+	key func(time.Time) string, start func(time.Time) time.Time) []*ActivityCount {
 	counts := make(map[string]*ActivityCount)
-	for _, e := range events {
-		d := e.Date.In(location)
-		key := date.YM1(d)
-		_, ok := counts[key]
+	for _, v := range values {
+		d := v.Date.In(location)
+		k := key(d)
+		_, ok := counts[k]
 		if !ok {
-			counts[key] = &ActivityCount{Date: date.StartOfMonth(d), Count: 1}
+			counts[k] = &ActivityCount{Date: start(d), Count: v.Count}
 		} else {
-			counts[key].Count++
+			counts[k].Count += v.Count
 		}
 	}
 	result := maps.Values(counts)
@@ -385,34 +425,34 @@ func sortByCount(counts map[string]int) []string {
 	return keys
 }
 
-// Add a scrobble with an MBID that should match a track we have
+// Add a scrobble with an MBID that should match a track we have. The
+// scrobble is queued for each of the user's linked scrobble services.
 func (a *Activity) UserScrobble(user auth.User, s Scrobble, music *music.Music) error {
-	// ensure there's a valid user
-	// if s.User == "" {
-	// 	s.User = user.Name
-	// } else if s.User != user.Name {
-	// 	return ErrInvalidUser
-	// }
-
-	// if s.MBID != "" {
-	// 	_, err := music.FindTrack(s.MBID)
-	// 	if err != nil {
-	// 		// no track with that MBID (RID)
-	// 		// code below will hopefully find a new one
-	// 		s.MBID = ""
-	// 	}
-	// }
-	// if s.MBID == "" {
-	// 	tracks := music.SearchTracks(s.Track, s.PreferredArtist(), s.Album)
-	// 	if len(tracks) > 0 {
-	// 		// use first matching track MBZ recording ID
-	// 		s.MBID = tracks[0].RID
-	// 	}
-	// }
-
-	// // MBID may still be empty but allow anyway for now
-	// return a.createScrobble(&s)
-	return nil
+	if s.Artist == "" || s.Track == "" || s.Timestamp.IsZero() {
+		return ErrInvalidScrobble
+	}
+
+	var track Track
+	var err error
+	if s.MBID != "" {
+		track, err = music.FindTrack(s.MBID)
+		if err != nil {
+			// no track with that MBID (RID)
+			// code below will hopefully find a new one
+			s.MBID = ""
+		}
+	}
+	if s.MBID == "" {
+		tracks := music.SearchTracks(s.Track, s.PreferredArtist(), s.Album)
+		if len(tracks) > 0 {
+			// use first matching track MBZ recording ID
+			track = tracks[0]
+			s.MBID = track.RID
+		}
+	}
+
+	// MBID may still be empty but allow anyway
+	return a.queueScrobble(user.Name, s, track.REID, track.RGID)
 }
 
 func (a *Activity) CreateEvents(ctx Context, events Events) error {
@@ -438,6 +478,28 @@ func (a *Activity) CreateEvents(ctx Context, events Events) error {
 		}
 	}
 
+	for _, e := range events.TVEpisodeEvents {
+		e.User = user.Name
+		e.Date = e.Date.UTC()
+		if e.ETag != "" {
+			// resolve using ETag
+			episode, err := ctx.TV().LookupETag(e.ETag)
+			if err != nil {
+				return err
+			}
+			e.TVID = episode.TVID
+			e.Season = episode.Season
+			e.Episode = episode.Episode
+		}
+		if e.IsValid() == false {
+			return ErrInvalidTVEvent
+		}
+		err := a.createTVEpisodeEvent(&e)
+		if err != nil {
+			return err
+		}
+	}
+
 	for _, e := range events.EpisodeEvents {
 		e.User = user.Name
 		e.Date = e.Date.UTC()
@@ -469,7 +531,107 @@ func (a *Activity) CreateEvents(ctx Context, events Events) error {
 		if err != nil {
 			return err
 		}
+		a.queueTrackEvent(ctx, e)
+	}
+
+	for _, e := range events.TrackSkipEvents {
+		e.User = user.Name
+		e.Date = e.Date.UTC()
+		if e.ETag != "" {
+			// resolve using ETag
+			track, err := ctx.Music().LookupETag(e.ETag)
+			if err != nil {
+				return err
+			}
+			e.RID = track.RID
+			e.RGID = track.RGID
+		}
+		if e.IsValid() == false {
+			return ErrInvalidSkipEvent
+		}
+		err := a.createTrackSkipEvent(&e)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
+
+// ArtistPlays returns the number of listens for each artist the user has
+// played, keyed by artist name.
+func (a *Activity) ArtistPlays(ctx Context) map[string]int {
+	plays := a.TrackPlays(ctx.User().Name)
+	rids := make([]string, 0, len(plays))
+	for rid, p := range plays {
+		if p.Count > 0 {
+			rids = append(rids, rid)
+		}
+	}
+	counts := make(map[string]int)
+	for _, t := range ctx.Music().FindTracks(rids) {
+		counts[t.Artist] += plays[t.RID].Count
+	}
+	return counts
+}
+
+// MoviePlays returns the number of times the user watched each movie, keyed
+// by TMID or by IMID for events without a TMID.
+func (a *Activity) MoviePlays(user string) map[string]int {
+	counts := make(map[string]int)
+	for _, e := range a.movieEvents(user) {
+		if e.TMID != "" {
+			counts[e.TMID]++
+		} else {
+			counts[e.IMID]++
+		}
+	}
+	return counts
+}
+
+// TVSeriesPlays returns the number of episodes the user watched for each
+// series, keyed by TVID.
+func (a *Activity) TVSeriesPlays(user string) map[int64]int {
+	counts := make(map[int64]int)
+	for _, e := range a.tvEpisodeEvents(user) {
+		counts[e.TVID]++
+	}
+	return counts
+}
+
+// SeriesPlays returns the number of episodes the user played for each
+// podcast series, keyed by SID.
+func (a *Activity) SeriesPlays(ctx Context) map[string]int {
+	episodes := make(map[string]int)
+	for _, e := range a.episodeEvents(ctx.User().Name) {
+		episodes[e.EID]++
+	}
+	counts := make(map[string]int)
+	for eid, count := range episodes {
+		e, err := ctx.Podcast().LookupEID(eid)
+		if err == nil {
+			counts[e.SID] += count
+		}
+	}
+	return counts
+}
+
+// TrackPlays returns listen counts, last listen time and skip counts for
+// each recording the user has played or skipped, keyed by RID.
+func (a *Activity) TrackPlays(user string) map[string]TrackPlays {
+	plays := make(map[string]TrackPlays)
+	for _, e := range a.trackEvents(user) {
+		p := plays[e.RID]
+		p.Count++
+		if e.Date.After(p.Last) {
+			p.Last = e.Date
+		}
+		plays[e.RID] = p
+	}
+	for _, e := range a.trackSkipEvents(user) {
+		p := plays[e.RID]
+		p.Skips++
+		plays[e.RID] = p
+	}
+	return plays
+}
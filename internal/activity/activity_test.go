@@ -97,3 +97,72 @@ func TestFillMonthGaps(t *testing.T) {
 		t.Error("expect 9 zeros")
 	}
 }
+
+func TestLongestStreak(t *testing.T) {
+	l := time.Now().Location()
+
+	counts := []*model.ActivityCount{
+		{Date: time.Date(2024, time.December, 4, 0, 0, 0, 0, l), Count: 10},
+		{Date: time.Date(2024, time.December, 8, 0, 0, 0, 0, l), Count: 5},
+		{Date: time.Date(2024, time.December, 9, 0, 0, 0, 0, l), Count: 5},
+		{Date: time.Date(2024, time.December, 10, 0, 0, 0, 0, l), Count: 5},
+		{Date: time.Date(2024, time.December, 30, 0, 0, 0, 0, l), Count: 1},
+		{Date: time.Date(2024, time.December, 31, 0, 0, 0, 0, l), Count: 1},
+	}
+
+	streak, start := longestStreak(counts)
+	if streak != 3 {
+		t.Error("expect 3 day streak", streak)
+	}
+	if start.Day() != 8 {
+		t.Error("expect streak start", start)
+	}
+
+	streak, _ = longestStreak(nil)
+	if streak != 0 {
+		t.Error("expect no streak")
+	}
+}
+
+func TestTopGenres(t *testing.T) {
+	artists := []model.ActivityArtist{
+		{Artist: model.Artist{Name: "a", Genre: "rock"}, Count: 3},
+		{Artist: model.Artist{Name: "b", Genre: "jazz"}, Count: 5},
+		{Artist: model.Artist{Name: "c", Genre: "rock"}, Count: 4},
+		{Artist: model.Artist{Name: "d"}, Count: 9},
+	}
+	genres := topGenres(artists, 10)
+	if len(genres) != 2 {
+		t.Fatal("expect 2 genres", genres)
+	}
+	if genres[0].Genre != "rock" || genres[0].Count != 7 {
+		t.Error("expect rock first", genres[0])
+	}
+	genres = topGenres(artists, 1)
+	if len(genres) != 1 {
+		t.Error("expect limit", genres)
+	}
+}
+
+func TestSumGroupByDay(t *testing.T) {
+	l := time.Now().Location()
+
+	values := []model.ActivityCount{
+		{Date: time.Date(2024, time.December, 4, 10, 0, 0, 0, l), Count: 90},
+		{Date: time.Date(2024, time.December, 4, 20, 0, 0, 0, l), Count: 45},
+		{Date: time.Date(2024, time.December, 8, 12, 0, 0, 0, l), Count: 120},
+	}
+
+	counts := sumGroupByDay(values, l)
+	if len(counts) != 2 {
+		t.Fatal("expect 2 days", len(counts))
+	}
+	if counts[0].Count != 135 || counts[0].Date.Day() != 4 {
+		t.Error("expect 135 minutes on the 4th", counts[0])
+	}
+
+	counts = sumGroupByMonth(values, l)
+	if len(counts) != 1 || counts[0].Count != 255 {
+		t.Error("expect 255 minutes in december", counts)
+	}
+}
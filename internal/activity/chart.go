@@ -36,7 +36,16 @@ func (a *Activity) TrackMonthCounts(ctx Context, d date.DateRange) *view.TrackCo
 	return view
 }
 
+type countsFunc func(Context, date.DateRange) *view.TrackCounts
+
 func (a *Activity) BuildChart(ctx Context, d date.DateRange) *view.TrackCharts {
+	return buildChart(ctx, d, "Listens", a.TrackDayCounts, a.TrackMonthCounts)
+}
+
+// buildChart compares counts for the date range with the previous range of
+// the same size, using monthly counts for years and daily counts otherwise.
+func buildChart(ctx Context, d date.DateRange, label string,
+	dayCounts, monthCounts countsFunc) *view.TrackCharts {
 	charts := &view.TrackCharts{}
 
 	if d.IsYear() {
@@ -46,19 +55,19 @@ func (a *Activity) BuildChart(ctx Context, d date.DateRange) *view.TrackCharts {
 		}
 		charts.AddCounts(
 			fmt.Sprintf("%d", prev.Start.Year()),
-			a.TrackMonthCounts(ctx, prev))
+			monthCounts(ctx, prev))
 		charts.AddCounts(
 			fmt.Sprintf("%d", d.Start.Year()),
-			a.TrackMonthCounts(ctx, d))
+			monthCounts(ctx, d))
 	} else if d.IsDay() {
 		prev := d.PreviousDay()
-		charts.Labels = []string{"Listens"}
+		charts.Labels = []string{label}
 		charts.AddCounts(
 			prev.Start.Weekday().String(),
-			a.TrackDayCounts(ctx, prev))
+			dayCounts(ctx, prev))
 		charts.AddCounts(
 			d.Start.Weekday().String(),
-			a.TrackDayCounts(ctx, d))
+			dayCounts(ctx, d))
 	} else if d.IsWeek() {
 		prev := d.PreviousWeek()
 		charts.Labels = []string{"Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"}
@@ -66,12 +75,12 @@ func (a *Activity) BuildChart(ctx Context, d date.DateRange) *view.TrackCharts {
 			fmt.Sprintf("%s %d - %s %d",
 				prev.Start.Month().String()[:3], prev.Start.Day(),
 				prev.End.Month().String()[:3], prev.End.Day()),
-			a.TrackDayCounts(ctx, prev))
+			dayCounts(ctx, prev))
 		charts.AddCounts(
 			fmt.Sprintf("%s %d - %s %d",
 				d.Start.Month().String()[:3], d.Start.Day(),
 				d.End.Month().String()[:3], d.End.Day()),
-			a.TrackDayCounts(ctx, d))
+			dayCounts(ctx, d))
 	} else if d.IsMonth() {
 		prev := d.PreviousMonth()
 		labels := make([]string, 31)
@@ -81,10 +90,10 @@ func (a *Activity) BuildChart(ctx Context, d date.DateRange) *view.TrackCharts {
 		charts.Labels = labels
 		charts.AddCounts(
 			fmt.Sprintf("%s %d", prev.Start.Month().String()[:3], prev.Start.Year()),
-			a.TrackDayCounts(ctx, prev))
+			dayCounts(ctx, prev))
 		charts.AddCounts(
 			fmt.Sprintf("%s %d", d.Start.Month().String()[:3], d.Start.Year()),
-			a.TrackDayCounts(ctx, d))
+			dayCounts(ctx, d))
 	} else {
 		days := d.DayCount()
 		labels := make([]string, days)
@@ -93,7 +102,7 @@ func (a *Activity) BuildChart(ctx Context, d date.DateRange) *view.TrackCharts {
 			labels[i] = fmt.Sprintf("%s %d", d.Month().String()[:3], d.Day())
 		}
 		charts.Labels = labels
-		charts.AddCounts("Listens", a.TrackDayCounts(ctx, d))
+		charts.AddCounts(label, dayCounts(ctx, d))
 	}
 
 	return charts
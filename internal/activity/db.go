@@ -44,7 +44,16 @@ func (a *Activity) openDB() (err error) {
 		return
 	}
 
-	a.db.AutoMigrate(&MovieEvent{}, &EpisodeEvent{}, &TrackEvent{})
+	a.db.AutoMigrate(&MovieEvent{}, &EpisodeEvent{}, &TrackEvent{},
+		&TVEpisodeEvent{}, &TrackSkipEvent{}, &ScrobbleAccount{}, &QueuedScrobble{})
+
+	// track event dates were unique across all users, now per user
+	if a.db.Migrator().HasIndex(&TrackEvent{}, "idx_track_date") {
+		a.db.Migrator().DropIndex(&TrackEvent{}, "idx_track_date")
+	}
+	if a.db.Migrator().HasIndex(&TrackSkipEvent{}, "idx_track_skip_date") {
+		a.db.Migrator().DropIndex(&TrackSkipEvent{}, "idx_track_skip_date")
+	}
 	return
 }
 
@@ -83,6 +92,15 @@ func (a *Activity) trackEventsFrom(user string, start, end time.Time, limit int)
 	return events
 }
 
+// trackEventRIDsBefore returns the distinct recordings listened to before t.
+func (a *Activity) trackEventRIDsBefore(user string, t time.Time) []string {
+	var rids []string
+	a.db.Model(TrackEvent{}).
+		Where("user = ? and date < ?", user, t).
+		Distinct().Pluck("r_id", &rids)
+	return rids
+}
+
 func (a *Activity) movieEventsFrom(user string, start, end time.Time, limit int) []MovieEvent {
 	var events []MovieEvent
 	a.db.Where("user = ? and date between ? and ?", user, start, end).
@@ -97,6 +115,13 @@ func (a *Activity) episodeEventsFrom(user string, start, end time.Time, limit in
 	return events
 }
 
+func (a *Activity) tvEpisodeEventsFrom(user string, start, end time.Time, limit int) []TVEpisodeEvent {
+	var events []TVEpisodeEvent
+	a.db.Where("user = ? and date between ? and ?", user, start, end).
+		Order("date desc").Limit(limit).Find(&events)
+	return events
+}
+
 func (a *Activity) movieEvents(user string) []MovieEvent {
 	var movies []MovieEvent
 	a.db.Where("user = ?", user).
@@ -111,6 +136,20 @@ func (a *Activity) episodeEvents(user string) []EpisodeEvent {
 	return events
 }
 
+func (a *Activity) tvEpisodeEvents(user string) []TVEpisodeEvent {
+	var events []TVEpisodeEvent
+	a.db.Where("user = ?", user).
+		Order("date desc").Find(&events)
+	return events
+}
+
+func (a *Activity) trackSkipEvents(user string) []TrackSkipEvent {
+	var events []TrackSkipEvent
+	a.db.Where("user = ?", user).
+		Order("date desc").Find(&events)
+	return events
+}
+
 func (a *Activity) trackEvents(user string) []TrackEvent {
 	var tracks []TrackEvent
 	a.db.Where("user = ?", user).
@@ -152,14 +191,36 @@ func (a *Activity) deleteEpisodeEvents(user string) error {
 	return a.db.Unscoped().Where("user = ?", user).Delete(EpisodeEvent{}).Error
 }
 
+func (a *Activity) deleteTrackSkipEvents(user string) error {
+	return a.db.Unscoped().Where("user = ?", user).Delete(TrackSkipEvent{}).Error
+}
+
+func (a *Activity) deleteTVEpisodeEvents(user string) error {
+	return a.db.Unscoped().Where("user = ?", user).Delete(TVEpisodeEvent{}).Error
+}
+
 func (a *Activity) createMovieEvent(m *MovieEvent) error {
 	return a.db.Create(m).Error
 }
 
+func (a *Activity) createTVEpisodeEvent(e *TVEpisodeEvent) error {
+	return a.db.Create(e).Error
+}
+
+func (a *Activity) createTrackSkipEvent(t *TrackSkipEvent) error {
+	return a.db.Create(t).Error
+}
+
 func (a *Activity) createTrackEvent(t *TrackEvent) error {
 	return a.db.Create(t).Error
 }
 
+func (a *Activity) trackEventExists(user string, date time.Time) bool {
+	var count int64
+	a.db.Model(&TrackEvent{}).Where("user = ? and date = ?", user, date).Count(&count)
+	return count > 0
+}
+
 func (a *Activity) createEpisodeEvent(m *EpisodeEvent) error {
 	return a.db.Create(m).Error
 }
@@ -187,3 +248,66 @@ func (a *Activity) deleteMovieEvent(m *MovieEvent) error {
 func (a *Activity) deleteEpisodeEvent(m *EpisodeEvent) error {
 	return a.db.Unscoped().Delete(m).Error
 }
+
+func (a *Activity) scrobbleAccounts(user string) []ScrobbleAccount {
+	var accounts []ScrobbleAccount
+	a.db.Where("user = ?", user).Order("service").Find(&accounts)
+	return accounts
+}
+
+func (a *Activity) scrobbleAccount(user, service string) (ScrobbleAccount, error) {
+	var account ScrobbleAccount
+	err := a.db.Where("user = ? and service = ?", user, service).First(&account).Error
+	return account, err
+}
+
+// Create or replace the scrobble account for the same user and service.
+func (a *Activity) saveScrobbleAccount(account *ScrobbleAccount) error {
+	curr, err := a.scrobbleAccount(account.User, account.Service)
+	if err == nil {
+		account.ID = curr.ID
+		account.CreatedAt = curr.CreatedAt
+		return a.db.Save(account).Error
+	}
+	return a.db.Create(account).Error
+}
+
+func (a *Activity) deleteScrobbleAccount(user, service string) error {
+	return a.db.Unscoped().Where("user = ? and service = ?", user, service).
+		Delete(&ScrobbleAccount{}).Error
+}
+
+func (a *Activity) createQueuedScrobble(s *QueuedScrobble) error {
+	return a.db.Create(s).Error
+}
+
+// Scrobbles ready to be sent, oldest first.
+func (a *Activity) dueScrobbles(now time.Time, limit int) []QueuedScrobble {
+	var list []QueuedScrobble
+	a.db.Where("next_attempt <= ?", now).Order("timestamp").Limit(limit).Find(&list)
+	return list
+}
+
+func (a *Activity) queuedScrobbles(user string) []QueuedScrobble {
+	var list []QueuedScrobble
+	a.db.Where("user = ?", user).Order("timestamp").Find(&list)
+	return list
+}
+
+func (a *Activity) updateQueuedScrobble(s *QueuedScrobble) error {
+	return a.db.Save(s).Error
+}
+
+func (a *Activity) deleteQueuedScrobble(s QueuedScrobble) error {
+	return a.db.Unscoped().Delete(&s).Error
+}
+
+func (a *Activity) deleteQueuedScrobbles(user, service string) error {
+	return a.db.Unscoped().Where("user = ? and service = ?", user, service).
+		Delete(&QueuedScrobble{}).Error
+}
+
+func (a *Activity) deleteExpiredScrobbles(before time.Time) error {
+	return a.db.Unscoped().Where("timestamp < ?", before).
+		Delete(&QueuedScrobble{}).Error
+}
@@ -18,9 +18,11 @@
 package activity
 
 import (
+	"errors"
 	"testing"
 	"time"
 
+	"takeoutfm.dev/takeout/internal/auth"
 	"takeoutfm.dev/takeout/internal/config"
 	"takeoutfm.dev/takeout/model"
 )
@@ -101,6 +103,73 @@ func TestTrackEvent(t *testing.T) {
 	}
 }
 
+func TestTrackEventExists(t *testing.T) {
+	a := makeActivity(t)
+	date := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	e := model.TrackEvent{
+		User: "takeout",
+		Date: date,
+		RID:  "7b486d22-ade1-4d61-940b-334071aad0cf",
+		RGID: "c5e5e8ad-dc89-319e-8b2d-b3ff5e59fcea",
+	}
+	err := a.createTrackEvent(&e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.deleteTrackEvents("takeout")
+
+	if !a.trackEventExists("takeout", date) {
+		t.Error("expect event")
+	}
+	if a.trackEventExists("other", date) {
+		t.Error("expect no event for other user")
+	}
+
+	// same date for another user
+	other := e
+	other.ID = 0
+	other.User = "other"
+	err = a.createTrackEvent(&other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.deleteTrackEvents("other")
+	if !a.trackEventExists("other", date) {
+		t.Error("expect event for other user")
+	}
+
+	// same date for the same user
+	dup := e
+	dup.ID = 0
+	if a.createTrackEvent(&dup) == nil {
+		t.Error("expect duplicate error")
+	}
+}
+
+func TestTrackSkipEventUsers(t *testing.T) {
+	a := makeActivity(t)
+	date := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, user := range []string{"takeout", "other"} {
+		e := model.TrackSkipEvent{
+			User: user,
+			Date: date,
+			RID:  "7b486d22-ade1-4d61-940b-334071aad0cf",
+		}
+		err := a.createTrackSkipEvent(&e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer a.deleteTrackSkipEvents(user)
+	}
+	dup := model.TrackSkipEvent{User: "takeout", Date: date, RID: "7b486d22-ade1-4d61-940b-334071aad0cf"}
+	if a.createTrackSkipEvent(&dup) == nil {
+		t.Error("expect duplicate error")
+	}
+	if len(a.trackSkipEvents("other")) != 1 {
+		t.Error("expect skip for other user")
+	}
+}
+
 func TestEpisodeEvent(t *testing.T) {
 	user := "takeout"
 	eid := "5c3b551b626a8e9fa04186b448f2d3ed"
@@ -130,6 +199,40 @@ func TestEpisodeEvent(t *testing.T) {
 	}
 }
 
+func TestTVEpisodeEvent(t *testing.T) {
+	user := "takeout"
+	tvid := int64(1399)
+
+	a := makeActivity(t)
+	e := model.TVEpisodeEvent{
+		User:    user,
+		Date:    time.Now(),
+		TVID:    tvid,
+		Season:  1,
+		Episode: 3,
+	}
+	if e.IsValid() == false {
+		t.Error("expect valid event")
+	}
+	err := a.createTVEpisodeEvent(&e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := a.tvEpisodeEventsFrom(user, time.Now().Add(-time.Hour), time.Now(), -1)
+	if len(events) == 0 {
+		t.Error("expect events")
+	}
+	if events[0].TVID != tvid || events[0].Episode != 3 {
+		t.Errorf("expect %d", tvid)
+	}
+
+	a.deleteTVEpisodeEvents(user)
+	if len(a.tvEpisodeEvents(user)) != 0 {
+		t.Error("expect no events")
+	}
+}
+
 func TestTopTrackEvents(t *testing.T) {
 	user := "takeout"
 	rid := "7b486d22-ade1-4d61-940b-334071aad0cf"
@@ -241,3 +344,99 @@ func TestTopTrackEventsFrom(t *testing.T) {
 		t.Error("expect no events")
 	}
 }
+
+func TestQueuedScrobble(t *testing.T) {
+	user := "scrobbler"
+
+	a := makeActivity(t)
+	account := model.ScrobbleAccount{
+		User:    user,
+		Service: ServiceListenBrainz,
+		Token:   "token",
+	}
+	err := a.saveScrobbleAccount(&account)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := model.Scrobble{
+		Artist:    "Gary Numan",
+		Track:     "Cars",
+		Timestamp: time.Now().Add(-time.Minute),
+		MBID:      "cc8ebd10-0c05-4a1d-9d51-8a0ee0c9e1a5",
+	}
+	err = a.queueScrobble(user, s, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	due := a.dueScrobbles(time.Now(), 10)
+	if len(due) != 1 {
+		t.Fatalf("expect 1 due scrobble got %d", len(due))
+	}
+	if due[0].Scrobble.Track != "Cars" || due[0].Service != ServiceListenBrainz {
+		t.Error("expect queued scrobble")
+	}
+
+	a.scrobbleSent(due[0], errors.New("offline"))
+	if len(a.dueScrobbles(time.Now(), 10)) != 0 {
+		t.Error("expect retry later")
+	}
+	list := a.queuedScrobbles(user)
+	if len(list) != 1 || list[0].Attempts != 1 || list[0].LastError != "offline" {
+		t.Error("expect failed attempt")
+	}
+
+	a.scrobbleSent(list[0], nil)
+	if len(a.queuedScrobbles(user)) != 0 {
+		t.Error("expect sent scrobble removed")
+	}
+
+	err = a.queueScrobble(user, s, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = a.UnlinkScrobbler(auth.User{Name: user}, ServiceListenBrainz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.queuedScrobbles(user)) != 0 || len(a.scrobbleAccounts(user)) != 0 {
+		t.Error("expect unlinked")
+	}
+}
+
+type userContext struct {
+	Context
+	user auth.User
+}
+
+func (c userContext) User() auth.User {
+	return c.user
+}
+
+func TestRecommendCache(t *testing.T) {
+	a := makeActivity(t)
+	ctx := userContext{user: auth.User{Name: "test"}}
+	rows := []model.RecommendMusic{{Name: "cached"}}
+	a.recommend["test"] = recommendEntry{time: time.Now(), music: rows}
+	result := a.RecommendMusic(ctx)
+	if len(result) != 1 || result[0].Name != "cached" {
+		t.Error("expect cached recommendations", result)
+	}
+}
+
+func TestRecommendTracksCache(t *testing.T) {
+	a := makeActivity(t)
+	ctx := userContext{user: auth.User{Name: "test"}}
+	rows := []model.RecommendMusic{{Name: "forgotten",
+		Ref:    "/music/recommend/" + RecommendForgotten,
+		Tracks: []model.Track{{Title: "cached title"}}}}
+	a.recommend["test"] = recommendEntry{time: time.Now(), music: rows}
+	tracks, err := a.RecommendTracks(ctx, RecommendForgotten, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tracks) != 1 || tracks[0].Title != "cached title" {
+		t.Error("expect cached tracks", tracks)
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright 2024 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package activity
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"takeoutfm.dev/takeout/lib/listenbrainz"
+	. "takeoutfm.dev/takeout/model"
+)
+
+const (
+	ExportJson         = "json"
+	ExportCSV          = "csv"
+	ExportListenBrainz = "listenbrainz"
+
+	EventTrack     = "track"
+	EventMovie     = "movie"
+	EventTVEpisode = "tvepisode"
+	EventEpisode   = "episode"
+)
+
+var (
+	ErrInvalidExportFormat = errors.New("invalid export format; use json, csv or listenbrainz")
+)
+
+// ExportEvents returns all the user's track, movie, tv and podcast events, most
+// recent first. Events are resolved using the user's media where possible;
+// events for media no longer available still include the external IDs.
+func (a *Activity) ExportEvents(ctx Context) []ExportEvent {
+	user := ctx.User()
+	var events []ExportEvent
+
+	m := ctx.Music()
+	tracks := make(map[string]Track)
+	for _, e := range a.trackEvents(user.Name) {
+		t, ok := tracks[e.RID]
+		if !ok {
+			t, _ = m.FindTrack("rid:" + e.RID)
+			tracks[e.RID] = t
+		}
+		events = append(events, ExportEvent{
+			Type:    EventTrack,
+			Date:    e.Date,
+			Artist:  t.PreferredArtist(),
+			Release: t.ReleaseTitle,
+			Title:   t.Title,
+			RID:     e.RID,
+			REID:    t.REID,
+			RGID:    e.RGID,
+		})
+	}
+
+	f := ctx.Film()
+	for _, e := range a.movieEvents(user.Name) {
+		var movie Movie
+		if e.IMID != "" {
+			movie, _ = f.FindMovie("imid:" + e.IMID)
+		} else if e.TMID != "" {
+			movie, _ = f.FindMovie("tmid:" + e.TMID)
+		}
+		events = append(events, ExportEvent{
+			Type:  EventMovie,
+			Date:  e.Date,
+			Title: movie.Title,
+			TMID:  e.TMID,
+			IMID:  e.IMID,
+		})
+	}
+
+	for _, e := range a.tvEpisodeEvents(user.Name) {
+		episode, _ := a.resolveTVEpisodeEvent(ctx, e)
+		events = append(events, ExportEvent{
+			Type:    EventTVEpisode,
+			Date:    e.Date,
+			Series:  episode.Series.Name,
+			Title:   episode.Episode.Name,
+			TVID:    e.TVID,
+			Season:  e.Season,
+			Episode: e.Episode,
+		})
+	}
+
+	p := ctx.Podcast()
+	series := make(map[string]Series)
+	for _, e := range a.episodeEvents(user.Name) {
+		episode, _ := p.FindEpisode(e.EID)
+		s, ok := series[episode.SID]
+		if !ok && episode.SID != "" {
+			s, _ = p.FindSeries(episode.SID)
+			series[episode.SID] = s
+		}
+		events = append(events, ExportEvent{
+			Type:   EventEpisode,
+			Date:   e.Date,
+			Series: s.Title,
+			Title:  episode.Title,
+			EID:    e.EID,
+		})
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Date.After(events[j].Date)
+	})
+	return events
+}
+
+// WriteExport writes all user events in the format: json, csv, or
+// listenbrainz which is one listen per line in the ListenBrainz import
+// format.
+func (a *Activity) WriteExport(ctx Context, format string, w io.Writer) error {
+	switch format {
+	case ExportJson, ExportCSV, ExportListenBrainz:
+		return WriteEvents(a.ExportEvents(ctx), format, w)
+	}
+	return ErrInvalidExportFormat
+}
+
+// WriteEvents writes the events in the format used by WriteExport.
+func WriteEvents(events []ExportEvent, format string, w io.Writer) error {
+	switch format {
+	case ExportJson:
+		return json.NewEncoder(w).Encode(events)
+	case ExportCSV:
+		return writeExportCSV(events, w)
+	case ExportListenBrainz:
+		return writeExportListens(events, w)
+	}
+	return ErrInvalidExportFormat
+}
+
+func writeExportCSV(events []ExportEvent, w io.Writer) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"type", "date", "artist", "release", "series", "title",
+		"rid", "reid", "rgid", "tmid", "imid", "eid", "tvid", "season", "episode"})
+	for _, e := range events {
+		var tvid, season, episode string
+		if e.Type == EventTVEpisode {
+			tvid = strconv.FormatInt(e.TVID, 10)
+			season = strconv.Itoa(e.Season)
+			episode = strconv.Itoa(e.Episode)
+		}
+		out.Write([]string{e.Type, e.Date.UTC().Format(time.RFC3339),
+			e.Artist, e.Release, e.Series, e.Title,
+			e.RID, e.REID, e.RGID, e.TMID, e.IMID, e.EID, tvid, season, episode})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// writeExportListens writes track events that have an artist and title;
+// ListenBrainz requires both.
+func writeExportListens(events []ExportEvent, w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	for _, e := range events {
+		if e.Type != EventTrack || e.Artist == "" || e.Title == "" {
+			continue
+		}
+		listen := listenbrainz.Listen{
+			ListenedAt: e.Date.Unix(),
+			TrackMetadata: listenbrainz.TrackMetadata{
+				ArtistName:  e.Artist,
+				TrackName:   e.Title,
+				ReleaseName: e.Release,
+				AdditionalInfo: listenbrainz.AdditionalInfo{
+					RecordingMBID:    e.RID,
+					ReleaseMBID:      e.REID,
+					ReleaseGroupMBID: e.RGID,
+				},
+			},
+		}
+		err := encoder.Encode(listen)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExportExtension returns the file extension used for the export format.
+func ExportExtension(format string) string {
+	switch format {
+	case ExportCSV:
+		return "csv"
+	case ExportListenBrainz:
+		return "jsonl"
+	}
+	return "json"
+}
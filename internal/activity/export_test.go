@@ -0,0 +1,71 @@
+// Copyright 2024 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package activity
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"takeoutfm.dev/takeout/model"
+)
+
+func TestWriteExportListens(t *testing.T) {
+	date := time.Unix(1700000000, 0)
+	events := []model.ExportEvent{
+		{Type: EventTrack, Date: date, Artist: "Gary Numan", Title: "Cars",
+			Release: "The Pleasure Principle", RID: "rid1", RGID: "rgid1"},
+		{Type: EventTrack, Date: date, RID: "rid2", RGID: "rgid2"},
+		{Type: EventMovie, Date: date, Title: "Alien", TMID: "348"},
+	}
+
+	var buf bytes.Buffer
+	err := writeExportListens(events, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// exported listens can be imported
+	listens, err := parseListenBrainz(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listens) != 1 {
+		t.Fatalf("expect 1 listen got %d", len(listens))
+	}
+	l := listens[0]
+	if l.Artist != "Gary Numan" || l.Track != "Cars" || l.RID != "rid1" || l.RGID != "rgid1" {
+		t.Error("expect listen", l)
+	}
+	if !l.Date.Equal(date) {
+		t.Error("expect date", l.Date)
+	}
+
+	buf.Reset()
+	err = writeExportCSV(events, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("expect header and 3 events got %d", len(lines))
+	}
+	if !strings.HasPrefix(lines[3], "movie,2023-11-14T22:13:20Z,,,,Alien,") {
+		t.Error("expect movie", lines[3])
+	}
+}
@@ -0,0 +1,368 @@
+// Copyright 2024 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package activity
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"takeoutfm.dev/takeout/internal/music"
+	. "takeoutfm.dev/takeout/model"
+)
+
+const (
+	importMissingLimit = 100
+)
+
+var (
+	ErrInvalidImportFormat = errors.New("invalid import format; use listenbrainz or lastfm")
+)
+
+// listen read from an export file
+type importListen struct {
+	Artist string
+	Track  string
+	Album  string
+	RID    string
+	RGID   string
+	Date   time.Time
+}
+
+// ListenBrainz export listen; exports are either a JSON array or one listen
+// per line.
+type lbzExportListen struct {
+	ListenedAt    int64 `json:"listened_at"`
+	TrackMetadata struct {
+		ArtistName     string `json:"artist_name"`
+		TrackName      string `json:"track_name"`
+		ReleaseName    string `json:"release_name"`
+		AdditionalInfo struct {
+			RecordingMBID    string `json:"recording_mbid"`
+			ReleaseGroupMBID string `json:"release_group_mbid"`
+		} `json:"additional_info"`
+		MBIDMapping struct {
+			RecordingMBID string `json:"recording_mbid"`
+		} `json:"mbid_mapping"`
+	} `json:"track_metadata"`
+}
+
+// Last.fm recent track as exported using user.getRecentTracks.
+type lastfmExportTrack struct {
+	Name   string `json:"name"`
+	MBID   string `json:"mbid"`
+	Artist struct {
+		Text string `json:"#text"`
+		Name string `json:"name"`
+	} `json:"artist"`
+	Album struct {
+		Text string `json:"#text"`
+	} `json:"album"`
+	Date struct {
+		UTS string `json:"uts"`
+	} `json:"date"`
+}
+
+type lastfmExportPage struct {
+	Track        []lastfmExportTrack `json:"track"`
+	RecentTracks struct {
+		Track []lastfmExportTrack `json:"track"`
+	} `json:"recenttracks"`
+}
+
+// ImportListens reads listening history exported from ListenBrainz or Last.fm
+// and creates track events for listens that match tracks in the user's
+// music collection. Listens that already have an event are skipped so the
+// same export can be imported more than once.
+func (a *Activity) ImportListens(ctx Context, format string, r io.Reader) (ImportReport, error) {
+	var report ImportReport
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return report, err
+	}
+
+	var listens []importListen
+	switch format {
+	case ServiceListenBrainz:
+		listens, err = parseListenBrainz(data)
+	case ServiceLastfm:
+		listens, err = parseLastfm(data)
+	default:
+		err = ErrInvalidImportFormat
+	}
+	if err != nil {
+		return report, err
+	}
+
+	user := ctx.User()
+	m := ctx.Music()
+	cache := make(map[string]Track)
+	for _, l := range listens {
+		report.Listens++
+		if l.Artist == "" || l.Track == "" || l.Date.IsZero() {
+			report.Invalid++
+			continue
+		}
+		date := l.Date.UTC()
+		if a.trackEventExists(user.Name, date) {
+			report.Duplicates++
+			continue
+		}
+
+		key := strings.ToLower(fmt.Sprintf("%s/%s/%s/%s", l.RID, l.Artist, l.Album, l.Track))
+		t, ok := cache[key]
+		if !ok {
+			t = resolveListen(m, l)
+			cache[key] = t
+		}
+		e := TrackEvent{User: user.Name, Date: date, RID: t.RID, RGID: t.RGID}
+		if e.RID == "" && l.RID != "" && l.RGID != "" {
+			// not in the collection but the export has the MBIDs
+			e.RID, e.RGID = l.RID, l.RGID
+		}
+		if !e.IsValid() {
+			report.Unmatched++
+			if len(report.Missing) < importMissingLimit {
+				report.Missing = append(report.Missing,
+					fmt.Sprintf("%s – %s", l.Artist, l.Track))
+			}
+			continue
+		}
+
+		err = a.createTrackEvent(&e)
+		if err != nil {
+			return report, err
+		}
+		report.Imported++
+	}
+	return report, nil
+}
+
+// resolveListen finds the collection track using the recording MBID or
+// otherwise the artist, title and release names.
+func resolveListen(m *music.Music, l importListen) Track {
+	if l.RID != "" {
+		t, err := m.FindTrack("rid:" + l.RID)
+		if err == nil {
+			return t
+		}
+	}
+	if l.Album != "" {
+		tracks := m.SearchTracks(l.Track, l.Artist, l.Album)
+		if len(tracks) > 0 {
+			return tracks[0]
+		}
+	}
+	tracks := m.SearchTracks(l.Track, l.Artist, "")
+	if len(tracks) > 0 {
+		return tracks[0]
+	}
+	return Track{}
+}
+
+func parseListenBrainz(data []byte) ([]importListen, error) {
+	var export []lbzExportListen
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("[")) {
+		err := json.Unmarshal(data, &export)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		for {
+			var l lbzExportListen
+			err := decoder.Decode(&l)
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			export = append(export, l)
+		}
+	}
+
+	var listens []importListen
+	for _, l := range export {
+		md := l.TrackMetadata
+		rid := md.AdditionalInfo.RecordingMBID
+		if rid == "" {
+			rid = md.MBIDMapping.RecordingMBID
+		}
+		var date time.Time
+		if l.ListenedAt > 0 {
+			date = time.Unix(l.ListenedAt, 0)
+		}
+		listens = append(listens, importListen{
+			Artist: md.ArtistName,
+			Track:  md.TrackName,
+			Album:  md.ReleaseName,
+			RID:    rid,
+			RGID:   md.AdditionalInfo.ReleaseGroupMBID,
+			Date:   date,
+		})
+	}
+	return listens, nil
+}
+
+func parseLastfm(data []byte) ([]importListen, error) {
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("[")) || bytes.HasPrefix(data, []byte("{")) {
+		return parseLastfmJson(data)
+	}
+	return parseLastfmCSV(data)
+}
+
+// parseLastfmJson reads an array of recent tracks, an array of
+// user.getRecentTracks pages, or a single page.
+func parseLastfmJson(data []byte) ([]importListen, error) {
+	var pages []lastfmExportPage
+	if bytes.HasPrefix(data, []byte("{")) {
+		var page lastfmExportPage
+		err := json.Unmarshal(data, &page)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, page)
+	} else {
+		var tracks []lastfmExportTrack
+		err := json.Unmarshal(data, &tracks)
+		if err == nil && len(tracks) > 0 && tracks[0].Name != "" {
+			pages = append(pages, lastfmExportPage{Track: tracks})
+		} else {
+			err = json.Unmarshal(data, &pages)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var listens []importListen
+	for _, page := range pages {
+		tracks := append(page.Track, page.RecentTracks.Track...)
+		for _, t := range tracks {
+			if t.Date.UTS == "" {
+				// now playing
+				continue
+			}
+			artist := t.Artist.Text
+			if artist == "" {
+				artist = t.Artist.Name
+			}
+			listens = append(listens, importListen{
+				Artist: artist,
+				Track:  t.Name,
+				Album:  t.Album.Text,
+				RID:    t.MBID,
+				Date:   parseListenDate(t.Date.UTS),
+			})
+		}
+	}
+	return listens, nil
+}
+
+// parseLastfmCSV reads scrobbles with a header row naming the columns, or
+// without one as artist, album, track, date.
+func parseLastfmCSV(data []byte) ([]importListen, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	columns := map[string]int{"artist": 0, "album": 1, "track": 2, "date": 3, "mbid": -1}
+	header := make(map[string]int)
+	for i, name := range records[0] {
+		header[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := header["artist"]; ok {
+		records = records[1:]
+		pick := func(col string, names ...string) {
+			columns[col] = -1
+			for _, name := range names {
+				if i, ok := header[name]; ok {
+					columns[col] = i
+					return
+				}
+			}
+		}
+		pick("artist", "artist")
+		pick("album", "album")
+		pick("track", "track", "title", "name")
+		pick("date", "uts", "timestamp", "date", "utc_time")
+		pick("mbid", "track_mbid", "mbid")
+	}
+
+	field := func(record []string, col string) string {
+		i := columns[col]
+		if i < 0 || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var listens []importListen
+	for _, record := range records {
+		listens = append(listens, importListen{
+			Artist: field(record, "artist"),
+			Track:  field(record, "track"),
+			Album:  field(record, "album"),
+			RID:    field(record, "mbid"),
+			Date:   parseListenDate(field(record, "date")),
+		})
+	}
+	return listens, nil
+}
+
+var listenDateLayouts = []string{
+	"02 Jan 2006 15:04",
+	"2 Jan 2006 15:04",
+	"02 Jan 2006, 15:04",
+	"2 Jan 2006, 15:04",
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+}
+
+// parseListenDate parses unix seconds or common export date formats in UTC.
+func parseListenDate(s string) time.Time {
+	if s == "" {
+		return time.Time{}
+	}
+	if uts, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(uts, 0)
+	}
+	for _, layout := range listenDateLayouts {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
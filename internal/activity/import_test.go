@@ -0,0 +1,100 @@
+// Copyright 2024 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package activity
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseListenBrainz(t *testing.T) {
+	data := `{"listened_at": 1700000000, "track_metadata": {"artist_name": "Gary Numan", "track_name": "Cars", "release_name": "The Pleasure Principle", "additional_info": {"recording_mbid": "rid1", "release_group_mbid": "rgid1"}}}
+{"listened_at": 1700000300, "track_metadata": {"artist_name": "Gary Numan", "track_name": "Films", "mbid_mapping": {"recording_mbid": "rid2"}}}
+`
+	listens, err := parseListenBrainz([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listens) != 2 {
+		t.Fatalf("expect 2 listens got %d", len(listens))
+	}
+	l := listens[0]
+	if l.Artist != "Gary Numan" || l.Track != "Cars" || l.Album != "The Pleasure Principle" {
+		t.Error("expect metadata", l)
+	}
+	if l.RID != "rid1" || l.RGID != "rgid1" {
+		t.Error("expect mbids", l)
+	}
+	if !l.Date.Equal(time.Unix(1700000000, 0)) {
+		t.Error("expect date", l.Date)
+	}
+	if listens[1].RID != "rid2" {
+		t.Error("expect mapped rid", listens[1].RID)
+	}
+
+	listens, err = parseListenBrainz([]byte("[" + `{"listened_at": 1700000000, "track_metadata": {"artist_name": "A", "track_name": "B"}}` + "]"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listens) != 1 || listens[0].Track != "B" {
+		t.Error("expect array listen", listens)
+	}
+}
+
+func TestParseLastfm(t *testing.T) {
+	data := `uts,utc_time,artist,artist_mbid,album,album_mbid,track,track_mbid
+1700000000,"14 Nov 2023, 22:13",Gary Numan,,The Pleasure Principle,,Cars,rid1
+`
+	listens, err := parseLastfm([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listens) != 1 {
+		t.Fatalf("expect 1 listen got %d", len(listens))
+	}
+	l := listens[0]
+	if l.Artist != "Gary Numan" || l.Track != "Cars" || l.Album != "The Pleasure Principle" || l.RID != "rid1" {
+		t.Error("expect header columns", l)
+	}
+	if !l.Date.Equal(time.Unix(1700000000, 0)) {
+		t.Error("expect date", l.Date)
+	}
+
+	// no header: artist, album, track, date
+	listens, err = parseLastfm([]byte("Gary Numan,The Pleasure Principle,Cars,14 Nov 2023 22:13\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listens) != 1 || listens[0].Track != "Cars" {
+		t.Fatal("expect positional listen", listens)
+	}
+	if !listens[0].Date.Equal(time.Date(2023, 11, 14, 22, 13, 0, 0, time.UTC)) {
+		t.Error("expect date", listens[0].Date)
+	}
+
+	data = `{"recenttracks": {"track": [
+{"name": "Cars", "mbid": "rid1", "artist": {"#text": "Gary Numan"}, "album": {"#text": "The Pleasure Principle"}, "date": {"uts": "1700000000"}},
+{"name": "Now", "artist": {"#text": "Gary Numan"}, "album": {"#text": ""}}]}}`
+	listens, err = parseLastfm([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listens) != 1 || listens[0].RID != "rid1" || listens[0].Artist != "Gary Numan" {
+		t.Error("expect json listen", listens)
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2023 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package activity
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"takeoutfm.dev/takeout/internal/music"
+	. "takeoutfm.dev/takeout/model"
+)
+
+// CreateMixStations updates the user's mix stations with new mixes built
+// from clusters of the user's top artists. Existing stations keep their ids
+// so refs stay the same, and stations no longer needed are removed.
+func (a *Activity) CreateMixStations(ctx Context) error {
+	m := ctx.Music()
+	user := ctx.User()
+	existing := m.UserStations(user, music.TypeMix)
+	count := 0
+
+	plays := a.TrackPlays(user.Name)
+	for i, cluster := range a.mixClusters(ctx) {
+		tracks := a.mixTracks(ctx, cluster, plays)
+		if len(tracks) == 0 {
+			continue
+		}
+		var names []string
+		for _, artist := range cluster {
+			names = append(names, artist.Name)
+		}
+		station := Station{
+			User:        user.Name,
+			Name:        fmt.Sprintf(a.config.Activity.MixTitle, i+1),
+			Creator:     "Takeout",
+			Image:       m.ArtistImage(cluster[0]),
+			Description: strings.Join(names, ", "),
+		}
+		if count < len(existing) {
+			station.Model = existing[count].Model
+		}
+		err := m.CreateMixStation(&station, tracks)
+		if err != nil {
+			return err
+		}
+		count++
+	}
+	for i := count; i < len(existing); i++ {
+		err := m.DeleteStation(&existing[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mixClusters groups the user's top artists with other top artists that are
+// similar or share tags. Each cluster starts with the most listened artist
+// not already in a cluster.
+func (a *Activity) mixClusters(ctx Context) [][]Artist {
+	m := ctx.Music()
+	end := time.Now()
+	start := end.Add(-a.config.Activity.RecommendHistory)
+
+	var top []Artist
+	for _, v := range a.TopArtists(ctx, a.TopTracks(ctx, start, end)) {
+		if len(top) == a.config.Activity.MixArtists {
+			break
+		}
+		top = append(top, v.Artist)
+	}
+
+	var clusters [][]Artist
+	assigned := make(map[string]bool)
+	for _, seed := range top {
+		if len(clusters) == a.config.Activity.MixCount {
+			break
+		}
+		if assigned[seed.Name] {
+			continue
+		}
+		assigned[seed.Name] = true
+		similar := make(map[string]bool)
+		for _, v := range m.SimilarArtists(seed) {
+			similar[v.Name] = true
+		}
+		for _, v := range m.TaggedArtists(seed) {
+			similar[v.Name] = true
+		}
+		cluster := []Artist{seed}
+		for _, other := range top {
+			if !assigned[other.Name] && similar[other.Name] {
+				assigned[other.Name] = true
+				cluster = append(cluster, other)
+			}
+		}
+		clusters = append(clusters, cluster)
+	}
+	return clusters
+}
+
+// mixTracks returns shuffled popular tracks from the cluster artists and
+// artists similar to the cluster seed, skipping tracks played recently.
+func (a *Activity) mixTracks(ctx Context, cluster []Artist, plays map[string]TrackPlays) []Track {
+	m := ctx.Music()
+	depth := a.config.Activity.RecommendDepth
+	recent := time.Now().Add(-a.config.Activity.MixRecent)
+
+	artists := append([]Artist{}, cluster...)
+	artists = append(artists, m.SimilarArtists(cluster[0])...)
+
+	seen := make(map[string]bool)
+	var tracks []Track
+	for _, artist := range artists {
+		if seen[artist.Name] {
+			continue
+		}
+		seen[artist.Name] = true
+		list := m.ArtistPopularTracks(artist, depth)
+		if len(list) == 0 {
+			list = m.ArtistSingleTracks(artist, depth)
+		}
+		for _, t := range list {
+			if plays[t.RID].Last.After(recent) {
+				continue
+			}
+			tracks = append(tracks, t)
+		}
+	}
+
+	tracks = music.Shuffle(tracks)
+	if len(tracks) > a.config.Activity.MixLimit {
+		tracks = tracks[:a.config.Activity.MixLimit]
+	}
+	return tracks
+}
@@ -0,0 +1,233 @@
+// Copyright 2023 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package activity
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"takeoutfm.dev/takeout/internal/music"
+	"takeoutfm.dev/takeout/lib/date"
+	. "takeoutfm.dev/takeout/model"
+)
+
+const (
+	RecommendBecause   = "because"
+	RecommendForgotten = "forgotten"
+	RecommendUnplayed  = "unplayed"
+)
+
+var (
+	ErrInvalidRecommend = errors.New("invalid recommendation")
+)
+
+type recommendEntry struct {
+	time  time.Time
+	music []RecommendMusic
+}
+
+// RecommendMusic returns rows of tracks based on the user's listening
+// activity: tracks from artists similar to recent top artists, favorites
+// that haven't been played in a while and tracks never played. Results are
+// kept for each user for the RecommendCache duration since this is used for
+// each home view.
+func (a *Activity) RecommendMusic(ctx Context) []RecommendMusic {
+	user := ctx.User().Name
+	ttl := a.config.Activity.RecommendCache
+	if ttl > 0 {
+		a.recommendMu.Lock()
+		entry, ok := a.recommend[user]
+		a.recommendMu.Unlock()
+		if ok && time.Since(entry.time) < ttl {
+			return entry.music
+		}
+	}
+
+	recommend := a.recommendMusic(ctx)
+
+	if ttl > 0 {
+		a.recommendMu.Lock()
+		a.recommend[user] = recommendEntry{time: time.Now(), music: recommend}
+		a.recommendMu.Unlock()
+	}
+	return recommend
+}
+
+func (a *Activity) recommendMusic(ctx Context) []RecommendMusic {
+	var recommend []RecommendMusic
+	plays := a.TrackPlays(ctx.User().Name)
+
+	add := func(name, ref string, tracks []Track) {
+		if len(tracks) > 0 {
+			recommend = append(recommend, RecommendMusic{
+				Name:   name,
+				Ref:    ref,
+				Tracks: tracks,
+			})
+		}
+	}
+
+	for _, artist := range a.recommendArtists(ctx) {
+		add(fmt.Sprintf(a.config.Activity.BecauseTitle, artist.Name),
+			fmt.Sprintf("/music/recommend/%s/%s", RecommendBecause, artist.ARID),
+			a.becauseTracks(ctx, artist, plays))
+	}
+	add(a.config.Activity.ForgottenTitle,
+		fmt.Sprintf("/music/recommend/%s", RecommendForgotten),
+		a.forgottenTracks(ctx, plays))
+	add(a.config.Activity.UnplayedTitle,
+		fmt.Sprintf("/music/recommend/%s", RecommendUnplayed),
+		a.unplayedTracks(ctx, plays))
+
+	return recommend
+}
+
+// RecommendTracks returns the tracks for a recommendation ref. The id is the
+// artist ARID for because recommendations. Cached recommendations are used
+// when available so the tracks match those shown in the home view.
+func (a *Activity) RecommendTracks(ctx Context, name, id string) ([]Track, error) {
+	ref := fmt.Sprintf("/music/recommend/%s", name)
+	if id != "" {
+		ref += "/" + id
+	}
+	if tracks, ok := a.cachedRecommendTracks(ctx.User().Name, ref); ok {
+		return tracks, nil
+	}
+
+	plays := a.TrackPlays(ctx.User().Name)
+	switch name {
+	case RecommendBecause:
+		artist, err := ctx.Music().LookupARID(id)
+		if err != nil {
+			return nil, err
+		}
+		return a.becauseTracks(ctx, artist, plays), nil
+	case RecommendForgotten:
+		return a.forgottenTracks(ctx, plays), nil
+	case RecommendUnplayed:
+		return a.unplayedTracks(ctx, plays), nil
+	}
+	return nil, ErrInvalidRecommend
+}
+
+// cachedRecommendTracks returns the tracks for the ref from the user's
+// cached recommendations.
+func (a *Activity) cachedRecommendTracks(user, ref string) ([]Track, bool) {
+	ttl := a.config.Activity.RecommendCache
+	if ttl <= 0 {
+		return nil, false
+	}
+	a.recommendMu.Lock()
+	entry, ok := a.recommend[user]
+	a.recommendMu.Unlock()
+	if !ok || time.Since(entry.time) >= ttl {
+		return nil, false
+	}
+	for _, r := range entry.music {
+		if r.Ref == ref {
+			return r.Tracks, true
+		}
+	}
+	return nil, false
+}
+
+// recommendArtists returns the user's recent top artists.
+func (a *Activity) recommendArtists(ctx Context) []Artist {
+	end := time.Now()
+	start := end.Add(-a.config.Activity.RecommendHistory)
+	var artists []Artist
+	for _, v := range a.TopArtists(ctx, a.TopTracks(ctx, start, end)) {
+		if len(artists) == a.config.Activity.RecommendArtists {
+			break
+		}
+		if v.Artist.ARID == "" {
+			continue
+		}
+		artists = append(artists, v.Artist)
+	}
+	return artists
+}
+
+// becauseTracks returns popular tracks from artists similar, related or
+// sharing tags with the artist, skipping tracks played recently.
+func (a *Activity) becauseTracks(ctx Context, artist Artist, plays map[string]TrackPlays) []Track {
+	m := ctx.Music()
+	depth := a.config.Activity.RecommendDepth
+	recent := time.Now().Add(-a.config.Activity.RecommendRecent)
+
+	var candidates []Artist
+	candidates = append(candidates, m.SimilarArtists(artist)...)
+	candidates = append(candidates, m.RelatedArtists(artist)...)
+	candidates = append(candidates, m.TaggedArtists(artist)...)
+
+	seen := make(map[string]bool)
+	seen[artist.Name] = true
+	var tracks []Track
+	for _, c := range candidates {
+		if seen[c.Name] {
+			continue
+		}
+		seen[c.Name] = true
+		list := m.ArtistPopularTracks(c, depth)
+		if len(list) == 0 {
+			list = m.ArtistSingleTracks(c, depth)
+		}
+		for _, t := range list {
+			if plays[t.RID].Last.After(recent) {
+				continue
+			}
+			tracks = append(tracks, t)
+		}
+	}
+
+	tracks = music.Shuffle(tracks)
+	if len(tracks) > a.config.Activity.RecommendLimit {
+		tracks = tracks[:a.config.Activity.RecommendLimit]
+	}
+	return tracks
+}
+
+// forgottenTracks returns the most played tracks that haven't been played in
+// a while.
+func (a *Activity) forgottenTracks(ctx Context, plays map[string]TrackPlays) []Track {
+	before := time.Now().Add(-a.config.Activity.ForgottenAge)
+	p := SmartPlaylist{
+		Rule: SmartRule{Match: music.SmartMatchAll, Rules: []SmartRule{
+			{Field: music.SmartFieldPlays, Op: music.SmartOpGreater,
+				Value: strconv.Itoa(a.config.Activity.ForgottenPlays - 1)},
+			{Field: music.SmartFieldLastPlayed, Op: music.SmartOpBefore,
+				Value: date.YMD(before)},
+		}},
+		Sort:  music.SmartFieldPlays,
+		Order: music.SmartOrderDesc,
+		Limit: a.config.Activity.RecommendLimit,
+	}
+	return ctx.Music().SmartPlaylistTracks(p, plays)
+}
+
+// unplayedTracks returns random tracks the user has never played.
+func (a *Activity) unplayedTracks(ctx Context, plays map[string]TrackPlays) []Track {
+	p := SmartPlaylist{
+		Rule:  SmartRule{Field: music.SmartFieldNeverPlayed, Op: music.SmartOpIs, Value: "true"},
+		Sort:  music.SmartFieldRandom,
+		Limit: a.config.Activity.RecommendLimit,
+	}
+	return ctx.Music().SmartPlaylistTracks(p, plays)
+}
@@ -0,0 +1,160 @@
+// Copyright 2024 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package activity
+
+import (
+	"time"
+
+	"takeoutfm.dev/takeout/lib/date"
+	. "takeoutfm.dev/takeout/model"
+	"takeoutfm.dev/takeout/view"
+)
+
+// TrackReport summarizes the user's listening for the date range, typically a
+// year or a month.
+func (a *Activity) TrackReport(ctx Context, interval string, d date.DateRange) *view.TrackReport {
+	user := ctx.User()
+	location := d.Start.Location()
+	limit := a.config.Activity.ReportLimit
+
+	report := &view.TrackReport{
+		Interval: interval,
+		Start:    d.Start,
+		End:      d.End,
+		Hours:    make([]int, 24),
+	}
+
+	// no limit; a year can have many listens
+	events := a.trackEventsFrom(user.Name, d.Start.UTC(), d.End.UTC(), -1)
+	report.ListenCount = len(events)
+
+	counts := make(map[string]int)
+	for _, e := range events {
+		counts[e.RID]++
+		report.Hours[e.Date.In(location).Hour()]++
+	}
+
+	// all tracks listened to, most listened first
+	rids := sortByCount(counts)
+	found := make(map[string]Track)
+	for _, t := range ctx.Music().FindTracks(rids) {
+		found[t.RID] = t
+	}
+	var tracks []ActivityTrack
+	for _, rid := range rids {
+		count := counts[rid]
+		t, ok := found[rid]
+		length := a.config.Activity.TrackLength
+		if ok {
+			tracks = append(tracks, ActivityTrack{Track: t, Count: count})
+			if t.Length > 0 {
+				length = t.Length
+			}
+		}
+		report.ListenTime += count * length
+	}
+
+	artists := a.groupByArtist(ctx, tracks)
+	releases := a.groupByRelease(ctx, tracks)
+	report.ArtistCount = len(artists)
+	report.ReleaseCount = len(releases)
+	report.TrackCount = len(tracks)
+	report.Genres = topGenres(artists, limit)
+	report.NewArtists = limitArtists(a.newArtists(ctx, d.Start, artists), limit)
+	report.Artists = limitArtists(artists, limit)
+	if len(releases) > limit {
+		releases = releases[:limit]
+	}
+	report.Releases = releases
+	if len(tracks) > a.config.Activity.ReportTracksLimit {
+		tracks = tracks[:a.config.Activity.ReportTracksLimit]
+	}
+	report.Tracks = tracks
+
+	days := countGroupByDay(events, location)
+	for _, c := range days {
+		if c.Count > report.TopDay.Count {
+			report.TopDay = *c
+		}
+	}
+	report.LongestStreak, report.StreakStart = longestStreak(days)
+
+	return report
+}
+
+// newArtists returns artists without listens before start.
+func (a *Activity) newArtists(ctx Context, start time.Time, artists []ActivityArtist) []ActivityArtist {
+	user := ctx.User()
+	previous := make(map[string]bool)
+	rids := a.trackEventRIDsBefore(user.Name, start.UTC())
+	for _, t := range ctx.Music().FindTracks(rids) {
+		previous[t.Artist] = true
+	}
+	var result []ActivityArtist
+	for _, artist := range artists {
+		if !previous[artist.Artist.Name] {
+			result = append(result, artist)
+		}
+	}
+	return result
+}
+
+func limitArtists(artists []ActivityArtist, limit int) []ActivityArtist {
+	if len(artists) > limit {
+		return artists[:limit]
+	}
+	return artists
+}
+
+// topGenres counts listens by artist genre.
+func topGenres(artists []ActivityArtist, limit int) []ActivityGenre {
+	counts := make(map[string]int)
+	for _, a := range artists {
+		if a.Artist.Genre != "" {
+			counts[a.Artist.Genre] += a.Count
+		}
+	}
+	var genres []ActivityGenre
+	for _, genre := range sortByCount(counts) {
+		genres = append(genres, ActivityGenre{Genre: genre, Count: counts[genre]})
+		if len(genres) == limit {
+			break
+		}
+	}
+	return genres
+}
+
+// longestStreak returns the most consecutive days with listens and the first
+// day of that streak. Days must be sorted by date.
+func longestStreak(days []*ActivityCount) (int, time.Time) {
+	var longest, streak int
+	var start, streakStart time.Time
+	for i, d := range days {
+		if i > 0 && date.YMD(date.NextDay(days[i-1].Date)) == date.YMD(d.Date) {
+			streak++
+		} else {
+			streak = 1
+			streakStart = d.Date
+		}
+		if streak > longest {
+			longest = streak
+			start = streakStart
+		}
+	}
+	return longest, start
+}
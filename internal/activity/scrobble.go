@@ -0,0 +1,278 @@
+// Copyright 2024 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package activity
+
+import (
+	"errors"
+	"time"
+
+	"takeoutfm.dev/takeout/internal/auth"
+	"takeoutfm.dev/takeout/lib/lastfm"
+	"takeoutfm.dev/takeout/lib/listenbrainz"
+	"takeoutfm.dev/takeout/lib/log"
+	. "takeoutfm.dev/takeout/model"
+)
+
+const (
+	ServiceListenBrainz = "listenbrainz"
+	ServiceLastfm       = "lastfm"
+
+	maxScrobbleRetry = 24 * time.Hour
+)
+
+var (
+	ErrInvalidService = errors.New("invalid scrobble service")
+)
+
+// ScrobbleAccounts returns the scrobble services linked by the user.
+func (a *Activity) ScrobbleAccounts(user auth.User) []ScrobbleAccount {
+	return a.scrobbleAccounts(user.Name)
+}
+
+// QueuedScrobbles returns the user's scrobbles waiting to be sent.
+func (a *Activity) QueuedScrobbles(user auth.User) []QueuedScrobble {
+	return a.queuedScrobbles(user.Name)
+}
+
+// LinkListenBrainz validates and saves the user's ListenBrainz token.
+func (a *Activity) LinkListenBrainz(user auth.User, token string) error {
+	name, err := a.lbz.ValidateToken(token)
+	if err != nil {
+		return err
+	}
+	account := ScrobbleAccount{
+		User:    user.Name,
+		Service: ServiceListenBrainz,
+		Name:    name,
+		Token:   token,
+	}
+	return a.saveScrobbleAccount(&account)
+}
+
+// LinkLastfm obtains and saves a Last.fm session key for the user.
+func (a *Activity) LinkLastfm(user auth.User, name, password string) error {
+	session, err := a.lastfm.MobileSession(name, password)
+	if err != nil {
+		return err
+	}
+	account := ScrobbleAccount{
+		User:    user.Name,
+		Service: ServiceLastfm,
+		Name:    name,
+		Token:   session,
+	}
+	return a.saveScrobbleAccount(&account)
+}
+
+// UnlinkScrobbler removes the linked service and any scrobbles queued for it.
+func (a *Activity) UnlinkScrobbler(user auth.User, service string) error {
+	if service != ServiceListenBrainz && service != ServiceLastfm {
+		return ErrInvalidService
+	}
+	err := a.deleteScrobbleAccount(user.Name, service)
+	if err != nil {
+		return err
+	}
+	return a.deleteQueuedScrobbles(user.Name, service)
+}
+
+func trackScrobble(t Track, date time.Time) Scrobble {
+	return Scrobble{
+		Artist:      t.PreferredArtist(),
+		Track:       t.Title,
+		Timestamp:   date,
+		Album:       t.ReleaseTitle,
+		AlbumArtist: t.Artist,
+		TrackNumber: t.TrackNum,
+		MBID:        t.RID,
+	}
+}
+
+// queueTrackEvent queues a scrobble for the track event, if the user has
+// linked services and the track can be found.
+func (a *Activity) queueTrackEvent(ctx Context, e TrackEvent) {
+	if len(a.scrobbleAccounts(e.User)) == 0 {
+		return
+	}
+	var track Track
+	var err error
+	m := ctx.Music()
+	if e.ETag != "" {
+		track, err = m.LookupETag(e.ETag)
+	} else {
+		track, err = m.FindTrack("rid:" + e.RID)
+	}
+	if err != nil {
+		log.Printf("scrobble %s: %s\n", e.RID, err)
+		return
+	}
+	err = a.queueScrobble(e.User, trackScrobble(track, e.Date), track.REID, track.RGID)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (a *Activity) queueScrobble(user string, s Scrobble, reid, rgid string) error {
+	for _, account := range a.scrobbleAccounts(user) {
+		q := QueuedScrobble{
+			User:        user,
+			Service:     account.Service,
+			Scrobble:    s,
+			REID:        reid,
+			RGID:        rgid,
+			NextAttempt: time.Now(),
+		}
+		err := a.createQueuedScrobble(&q)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func lbzListen(s Scrobble, reid, rgid string) listenbrainz.Listen {
+	return listenbrainz.Listen{
+		ListenedAt: s.Timestamp.Unix(),
+		TrackMetadata: listenbrainz.TrackMetadata{
+			ArtistName:  s.Artist,
+			TrackName:   s.Track,
+			ReleaseName: s.Album,
+			AdditionalInfo: listenbrainz.AdditionalInfo{
+				RecordingMBID:    s.MBID,
+				ReleaseMBID:      reid,
+				ReleaseGroupMBID: rgid,
+				TrackNumber:      s.TrackNumber,
+				DurationMs:       s.Duration * 1000,
+			},
+		},
+	}
+}
+
+func lastfmScrobble(s Scrobble) lastfm.Scrobble {
+	return lastfm.Scrobble{
+		Artist:      s.Artist,
+		Track:       s.Track,
+		Album:       s.Album,
+		AlbumArtist: s.AlbumArtist,
+		TrackNumber: s.TrackNumber,
+		Duration:    s.Duration,
+		MBID:        s.MBID,
+		Timestamp:   s.Timestamp,
+	}
+}
+
+// SendScrobbles delivers queued scrobbles that are due. ListenBrainz listens
+// are sent in batches per user; Last.fm scrobbles are sent one at a time.
+// Failures are retried with an increasing delay until the scrobble expires.
+func (a *Activity) SendScrobbles() error {
+	now := time.Now()
+	if a.config.Activity.ScrobbleExpire > 0 {
+		err := a.deleteExpiredScrobbles(now.Add(-a.config.Activity.ScrobbleExpire))
+		if err != nil {
+			return err
+		}
+	}
+
+	type accountKey struct {
+		user, service string
+	}
+	batches := make(map[accountKey][]QueuedScrobble)
+	var keys []accountKey
+	for _, q := range a.dueScrobbles(now, a.config.Activity.ScrobbleBatchSize) {
+		key := accountKey{q.User, q.Service}
+		if _, ok := batches[key]; !ok {
+			keys = append(keys, key)
+		}
+		batches[key] = append(batches[key], q)
+	}
+
+	for _, key := range keys {
+		batch := batches[key]
+		account, err := a.scrobbleAccount(key.user, key.service)
+		if err != nil {
+			// service was unlinked
+			a.deleteQueuedScrobbles(key.user, key.service)
+			continue
+		}
+		switch account.Service {
+		case ServiceListenBrainz:
+			var listens []listenbrainz.Listen
+			for _, q := range batch {
+				listens = append(listens, lbzListen(q.Scrobble, q.REID, q.RGID))
+			}
+			listenType := listenbrainz.ListenTypeSingle
+			if len(listens) > 1 {
+				listenType = listenbrainz.ListenTypeImport
+			}
+			err = a.lbz.SubmitListens(account.Token, listenType, listens)
+			for _, q := range batch {
+				a.scrobbleSent(q, err)
+			}
+		case ServiceLastfm:
+			for _, q := range batch {
+				err = a.lastfm.Scrobble(account.Token, lastfmScrobble(q.Scrobble))
+				a.scrobbleSent(q, err)
+			}
+		}
+	}
+	return nil
+}
+
+// scrobbleSent removes the delivered scrobble or schedules another attempt.
+func (a *Activity) scrobbleSent(q QueuedScrobble, err error) {
+	if err == nil {
+		err = a.deleteQueuedScrobble(q)
+		if err != nil {
+			log.Println(err)
+		}
+		return
+	}
+	log.Printf("scrobble %s %s: %s\n", q.User, q.Service, err)
+	retry := a.config.Activity.ScrobbleRetry << q.Attempts
+	if retry <= 0 || retry > maxScrobbleRetry {
+		retry = maxScrobbleRetry
+	}
+	q.Attempts++
+	q.LastError = err.Error()
+	q.NextAttempt = time.Now().Add(retry)
+	err = a.updateQueuedScrobble(&q)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// NowPlaying updates the user's linked services with the track currently
+// playing. This isn't queued since it's only relevant right now.
+func (a *Activity) NowPlaying(user auth.User, t Track) {
+	s := trackScrobble(t, time.Now())
+	for _, account := range a.scrobbleAccounts(user.Name) {
+		var err error
+		switch account.Service {
+		case ServiceListenBrainz:
+			listen := lbzListen(s, t.REID, t.RGID)
+			listen.ListenedAt = 0
+			err = a.lbz.SubmitListens(account.Token, listenbrainz.ListenTypePlayingNow,
+				[]listenbrainz.Listen{listen})
+		case ServiceLastfm:
+			err = a.lastfm.NowPlaying(account.Token, lastfmScrobble(s))
+		}
+		if err != nil {
+			log.Printf("now playing %s %s: %s\n", user.Name, account.Service, err)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+// Copyright 2024 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package activity
+
+import (
+	"fmt"
+	"time"
+
+	"takeoutfm.dev/takeout/lib/date"
+	. "takeoutfm.dev/takeout/model"
+	"takeoutfm.dev/takeout/view"
+)
+
+// Movie, TV and podcast activity. Watch time charts use the movie and episode
+// runtime in minutes; podcast charts count episodes.
+
+func (a *Activity) resolveTVEpisodeEvent(ctx Context, e TVEpisodeEvent) (ActivityTVEpisode, error) {
+	series, err := ctx.TV().LookupTVID(int(e.TVID))
+	if err != nil {
+		return ActivityTVEpisode{}, err
+	}
+	episode, err := ctx.TV().FindSeasonEpisode(series, e.Season, e.Episode)
+	if err != nil {
+		return ActivityTVEpisode{}, err
+	}
+	return ActivityTVEpisode{Series: series, Episode: episode}, nil
+}
+
+func (a *Activity) resolveTVEpisodeEvents(ctx Context, events []TVEpisodeEvent) []ActivityTVEpisode {
+	episodes := []ActivityTVEpisode{}
+	for _, e := range events {
+		episode, err := a.resolveTVEpisodeEvent(ctx, e)
+		if err == nil {
+			episodes = append(episodes, episode)
+		}
+	}
+	return episodes
+}
+
+// TVEpisodes returns watched episodes, most recent first.
+func (a *Activity) TVEpisodes(ctx Context, start, end time.Time) []ActivityTVEpisode {
+	user := ctx.User()
+	events := a.tvEpisodeEventsFrom(user.Name, start.UTC(), end.UTC(), a.config.Activity.TVLimit)
+	return a.resolveTVEpisodeEvents(ctx, events)
+}
+
+// PodcastEpisodes returns played podcast episodes, most recent first.
+func (a *Activity) PodcastEpisodes(ctx Context, start, end time.Time) []ActivityEpisode {
+	user := ctx.User()
+	events := a.episodeEventsFrom(user.Name, start.UTC(), end.UTC(), a.config.Activity.PodcastLimit)
+	return a.resolveEpisodeEvents(events, ctx)
+}
+
+// TopMovies returns movies watched most often.
+func (a *Activity) TopMovies(ctx Context, start, end time.Time) []ActivityMovie {
+	user := ctx.User()
+	counts := make(map[string]int)
+	movies := make(map[string]Movie)
+	for _, e := range a.movieEventsFrom(user.Name, start.UTC(), end.UTC(), -1) {
+		key := movieEventKey(e)
+		if _, ok := movies[key]; !ok {
+			m, err := a.resolveMovieEvent(ctx, e)
+			if err != nil {
+				continue
+			}
+			movies[key] = m.Movie
+		}
+		counts[key]++
+	}
+	result := []ActivityMovie{}
+	for _, key := range sortByCount(counts) {
+		result = append(result, ActivityMovie{Movie: movies[key], Count: counts[key]})
+	}
+	if len(result) > a.config.Activity.TopMoviesLimit {
+		result = result[:a.config.Activity.TopMoviesLimit]
+	}
+	return result
+}
+
+// TopTVSeries returns series with the most episodes watched.
+func (a *Activity) TopTVSeries(ctx Context, start, end time.Time) []ActivityTVSeries {
+	user := ctx.User()
+	counts := make(map[string]int)
+	series := make(map[string]TVSeries)
+	for _, e := range a.tvEpisodeEventsFrom(user.Name, start.UTC(), end.UTC(), -1) {
+		key := fmt.Sprintf("%d", e.TVID)
+		if _, ok := series[key]; !ok {
+			s, err := ctx.TV().LookupTVID(int(e.TVID))
+			if err != nil {
+				continue
+			}
+			series[key] = s
+		}
+		counts[key]++
+	}
+	result := []ActivityTVSeries{}
+	for _, key := range sortByCount(counts) {
+		result = append(result, ActivityTVSeries{Series: series[key], Count: counts[key]})
+	}
+	if len(result) > a.config.Activity.TopTVSeriesLimit {
+		result = result[:a.config.Activity.TopTVSeriesLimit]
+	}
+	return result
+}
+
+// TopPodcasts returns podcast series with the most episodes played.
+func (a *Activity) TopPodcasts(ctx Context, start, end time.Time) []ActivitySeries {
+	user := ctx.User()
+	counts := make(map[string]int)
+	series := make(map[string]Series)
+	for _, e := range a.episodeEventsFrom(user.Name, start.UTC(), end.UTC(), -1) {
+		episode, err := ctx.Podcast().FindEpisode(e.EID)
+		if err != nil {
+			continue
+		}
+		if _, ok := series[episode.SID]; !ok {
+			s, err := ctx.Podcast().FindSeries(episode.SID)
+			if err != nil {
+				continue
+			}
+			series[episode.SID] = s
+		}
+		counts[episode.SID]++
+	}
+	result := []ActivitySeries{}
+	for _, key := range sortByCount(counts) {
+		result = append(result, ActivitySeries{Series: series[key], Count: counts[key]})
+	}
+	if len(result) > a.config.Activity.TopPodcastsLimit {
+		result = result[:a.config.Activity.TopPodcastsLimit]
+	}
+	return result
+}
+
+func movieEventKey(e MovieEvent) string {
+	if e.IMID != "" {
+		return e.IMID
+	}
+	return e.TMID
+}
+
+// movieMinutes returns the runtime of each movie watched.
+func (a *Activity) movieMinutes(ctx Context, d date.DateRange) []ActivityCount {
+	user := ctx.User()
+	runtimes := make(map[string]int)
+	var result []ActivityCount
+	for _, e := range a.movieEventsFrom(user.Name, d.Start.UTC(), d.End.UTC(), -1) {
+		key := movieEventKey(e)
+		runtime, ok := runtimes[key]
+		if !ok {
+			m, err := a.resolveMovieEvent(ctx, e)
+			if err == nil {
+				runtime = m.Movie.Runtime
+			}
+			runtimes[key] = runtime
+		}
+		result = append(result, ActivityCount{Date: e.Date, Count: runtime})
+	}
+	return result
+}
+
+// tvMinutes returns the runtime of each episode watched.
+func (a *Activity) tvMinutes(ctx Context, d date.DateRange) []ActivityCount {
+	user := ctx.User()
+	var result []ActivityCount
+	for _, e := range a.tvEpisodeEventsFrom(user.Name, d.Start.UTC(), d.End.UTC(), -1) {
+		runtime := 0
+		episode, err := a.resolveTVEpisodeEvent(ctx, e)
+		if err == nil {
+			runtime = episode.Episode.Runtime
+		}
+		result = append(result, ActivityCount{Date: e.Date, Count: runtime})
+	}
+	return result
+}
+
+func (a *Activity) podcastCounts(ctx Context, d date.DateRange) []ActivityCount {
+	user := ctx.User()
+	var result []ActivityCount
+	for _, e := range a.episodeEventsFrom(user.Name, d.Start.UTC(), d.End.UTC(), -1) {
+		result = append(result, ActivityCount{Date: e.Date, Count: 1})
+	}
+	return result
+}
+
+func dayCounts(d date.DateRange, values []ActivityCount) *view.TrackCounts {
+	counts := sumGroupByDay(values, d.Start.Location())
+	return &view.TrackCounts{Counts: fillGaps(d.Start, d.End, counts)}
+}
+
+func monthCounts(d date.DateRange, values []ActivityCount) *view.TrackCounts {
+	counts := sumGroupByMonth(values, d.Start.Location())
+	return &view.TrackCounts{Counts: fillMonthGaps(d.Start, d.End, counts)}
+}
+
+func (a *Activity) MovieDayCounts(ctx Context, d date.DateRange) *view.TrackCounts {
+	return dayCounts(d, a.movieMinutes(ctx, d))
+}
+
+func (a *Activity) MovieMonthCounts(ctx Context, d date.DateRange) *view.TrackCounts {
+	return monthCounts(d, a.movieMinutes(ctx, d))
+}
+
+func (a *Activity) TVDayCounts(ctx Context, d date.DateRange) *view.TrackCounts {
+	return dayCounts(d, a.tvMinutes(ctx, d))
+}
+
+func (a *Activity) TVMonthCounts(ctx Context, d date.DateRange) *view.TrackCounts {
+	return monthCounts(d, a.tvMinutes(ctx, d))
+}
+
+func (a *Activity) PodcastDayCounts(ctx Context, d date.DateRange) *view.TrackCounts {
+	return dayCounts(d, a.podcastCounts(ctx, d))
+}
+
+func (a *Activity) PodcastMonthCounts(ctx Context, d date.DateRange) *view.TrackCounts {
+	return monthCounts(d, a.podcastCounts(ctx, d))
+}
+
+func (a *Activity) BuildMovieChart(ctx Context, d date.DateRange) *view.TrackCharts {
+	return buildChart(ctx, d, "Minutes", a.MovieDayCounts, a.MovieMonthCounts)
+}
+
+func (a *Activity) BuildTVChart(ctx Context, d date.DateRange) *view.TrackCharts {
+	return buildChart(ctx, d, "Minutes", a.TVDayCounts, a.TVMonthCounts)
+}
+
+func (a *Activity) BuildPodcastChart(ctx Context, d date.DateRange) *view.TrackCharts {
+	return buildChart(ctx, d, "Episodes", a.PodcastDayCounts, a.PodcastMonthCounts)
+}
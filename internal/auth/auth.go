@@ -197,6 +197,13 @@ func (a *Auth) User(userid string) (User, error) {
 	return u, nil
 }
 
+// Users returns all users ordered by name.
+func (a *Auth) Users() []User {
+	var users []User
+	a.db.Order("name").Find(&users)
+	return users
+}
+
 // Check will check if the provided userid and password match a user in the
 // database.
 func (a *Auth) check(userid, pass string) (User, error) {
@@ -41,8 +41,11 @@ import (
 	g "takeoutfm.dev/takeout/lib/gorm"
 	"takeoutfm.dev/takeout/lib/lastfm"
 	"takeoutfm.dev/takeout/lib/log"
-	"takeoutfm.dev/takeout/lib/systemd"
+	"takeoutfm.dev/takeout/lib/relay"
 	"takeoutfm.dev/takeout/lib/search"
+	"takeoutfm.dev/takeout/lib/setlist"
+	"takeoutfm.dev/takeout/lib/systemd"
+	"takeoutfm.dev/takeout/lib/thumb"
 	"takeoutfm.dev/takeout/lib/tmdb"
 
 	"gopkg.in/yaml.v3"
@@ -57,6 +60,9 @@ const (
 	MediaMusic = "music"
 	MediaFilm  = "film"
 	MediaTV    = "tv"
+
+	// MediaRecordings buckets store radio stream recordings.
+	MediaRecordings = "recordings"
 )
 
 type DatabaseConfig struct {
@@ -131,7 +137,10 @@ type MusicConfig struct {
 	TrackRadioDepth      int
 	DB                   DatabaseConfig
 	DeepLimit            int
+	DuplicatePreference  []string
+	FormatPreference     []string
 	PopularLimit         int
+	PreferLocalArtwork   bool
 	RadioGenres          []string
 	RadioLimit           int
 	RadioOther           map[string]string
@@ -143,6 +152,9 @@ type MusicConfig struct {
 	ReleaseCountries     []string
 	SearchIndexName      string
 	SearchLimit          int
+	SuggestArtistLimit   int
+	SuggestReleaseLimit  int
+	SuggestTrackLimit    int
 	SimilarArtistsLimit  int
 	SimilarReleases      time.Duration
 	SimilarReleasesLimit int
@@ -152,6 +164,7 @@ type MusicConfig struct {
 	PopularSyncInterval  time.Duration
 	SimilarSyncInterval  time.Duration
 	CoverSyncInterval    time.Duration
+	SmartSyncInterval    time.Duration
 	RelatedArtists       time.Duration
 }
 
@@ -164,6 +177,8 @@ type FilmConfig struct {
 	RecentLimit          int
 	SearchIndexName      string
 	SearchLimit          int
+	SuggestMovieLimit    int
+	SuggestPersonLimit   int
 	Recommend            RecommendConfig
 	SyncInterval         time.Duration
 	PosterSyncInterval   time.Duration
@@ -188,6 +203,8 @@ type TVConfig struct {
 	RecentLimit          int
 	SearchIndexName      string
 	SearchLimit          int
+	SuggestSeriesLimit   int
+	SuggestPersonLimit   int
 	SyncInterval         time.Duration
 	PosterSyncInterval   time.Duration
 	BackdropSyncInterval time.Duration
@@ -207,20 +224,41 @@ func (c TVConfig) RelevantCrew(credits tmdb.Credits) []tmdb.Crew {
 }
 
 type PodcastConfig struct {
-	DB              DatabaseConfig
-	Series          []string
-	Client          client.Config
-	RecentLimit     int
-	EpisodeLimit    int
-	SyncInterval    time.Duration
-	SearchIndexName string
-	SearchLimit     int
+	DB                 DatabaseConfig
+	Series             []string
+	Client             client.Config
+	RecentLimit        int
+	EpisodeLimit       int
+	SyncInterval       time.Duration
+	SearchIndexName    string
+	SearchLimit        int
+	SuggestSeriesLimit int
 }
 
 type ProgressConfig struct {
 	DB DatabaseConfig
 }
 
+// DLNAConfig is used for the optional UPnP/DLNA media server which exposes a
+// media collection to devices on the local network. The URL is advertised to
+// devices and is derived from the interface address and listen port when
+// empty.
+//
+// The DLNA server has no authentication and serves all media in the
+// collection so it must never be reachable from the internet. Listen without
+// a host, like the default ":8200", only binds to the interface address. Use
+// an explicit host like "0.0.0.0:8200" to listen on every interface.
+type DLNAConfig struct {
+	Enabled      bool
+	Media        string
+	FriendlyName string
+	Listen       string
+	URL          string
+	Interface    string
+	UUID         string
+	MaxAge       time.Duration
+}
+
 type ActivityConfig struct {
 	DB                DatabaseConfig
 	RecentMoviesTitle string
@@ -235,6 +273,35 @@ type ActivityConfig struct {
 	TopReleasesTitle  string
 	TopMoviesLimit    int
 	TopMoviesTitle    string
+	TVLimit           int
+	TopTVSeriesLimit  int
+	PodcastLimit      int
+	TopPodcastsLimit  int
+	ScrobbleInterval  time.Duration
+	ScrobbleRetry     time.Duration
+	ScrobbleExpire    time.Duration
+	ScrobbleBatchSize int
+	ReportLimit       int
+	ReportTracksLimit int
+	ReportTracksTitle string
+	TrackLength       int
+	RecommendArtists  int
+	RecommendLimit    int
+	RecommendDepth    int
+	RecommendHistory  time.Duration
+	RecommendRecent   time.Duration
+	RecommendCache    time.Duration
+	ForgottenAge      time.Duration
+	ForgottenPlays    int
+	BecauseTitle      string
+	ForgottenTitle    string
+	UnplayedTitle     string
+	MixTime           string
+	MixCount          int
+	MixArtists        int
+	MixLimit          int
+	MixRecent         time.Duration
+	MixTitle          string
 }
 
 type RecommendConfig struct {
@@ -280,6 +347,7 @@ type AuthConfig struct {
 	FileToken       TokenConfig
 	TOTP            TOTPConfig
 	PasswordEntropy int
+	Admins          []string // users that can manage shared content
 }
 
 type ServerConfig struct {
@@ -288,8 +356,12 @@ type ServerConfig struct {
 	DataDir     string // exsists for dollar expansion
 	MediaDir    string
 	ImageClient client.Config
+	ImageResize thumb.Config
+	ImageProxy  bool
+	StreamRelay relay.Config
 	IncludeDirs []string
 	ExcludeDirs []string
+	PageLimit   int
 }
 
 type Config struct {
@@ -308,6 +380,8 @@ type Config struct {
 	Podcast   PodcastConfig
 	Progress  ProgressConfig
 	Activity  ActivityConfig
+	DLNA      DLNAConfig
+	Setlist   setlist.Config
 }
 
 func (c Config) NewGetter() client.Getter {
@@ -355,8 +429,22 @@ func configDefaults(v *viper.Viper) {
 	v.SetDefault("Server.ImageClient.CacheDir", filepath.Join(systemd.GetCacheDirectory("."), "imagecache"))
 	v.SetDefault("Server.ImageClient.UserAgent", userAgent())
 	v.SetDefault("Server.ImageClient.MaxAge", "720h") // 30 days
+	v.SetDefault("Server.ImageProxy", false)
+	v.SetDefault("Server.ImageResize.CacheDir", filepath.Join(systemd.GetCacheDirectory("."), "imageresize"))
+	v.SetDefault("Server.ImageResize.Widths", []int{92, 154, 185, 250, 342, 500, 780, 1280})
+	v.SetDefault("Server.ImageResize.Quality", thumb.DefaultQuality)
+	v.SetDefault("Server.ImageResize.Format", thumb.FormatJPEG)
+	v.SetDefault("Server.ImageResize.MaxPixels", thumb.DefaultMaxPixels)
+	v.SetDefault("Server.ImageResize.MaxAge", thumb.DefaultMaxAge)
+	v.SetDefault("Server.StreamRelay.Enabled", false)
+	v.SetDefault("Server.StreamRelay.BufferSize", relay.DefaultBufferSize)
+	v.SetDefault("Server.StreamRelay.IdleTimeout", "10s")
+	v.SetDefault("Server.StreamRelay.HistoryLimit", relay.DefaultHistoryLimit)
+	v.SetDefault("Server.StreamRelay.MaxRecording", "4h")
+	v.SetDefault("Server.StreamRelay.AllowPrivate", false)
 	// potential include could be /media, /mnt, /opt, /srv
 	v.SetDefault("Server.IncludeDirs", []string{})
+	v.SetDefault("Server.PageLimit", 100)
 	// by default provide some reasonable excludes
 	v.SetDefault("Server.ExcludeDirs", []string{
 		"/bin/", "/boot/", "/dev/", "/etc/", "/lib/", "/proc/", "/run/", "/sbin/", "/root/", "/sys/",
@@ -405,6 +493,40 @@ func configDefaults(v *viper.Viper) {
 	v.SetDefault("Activity.TopReleasesTitle", "Top Releases")
 	v.SetDefault("Activity.TopMoviesLimit", "999")
 	v.SetDefault("Activity.TopMoviesTitle", "Top Movies")
+	v.SetDefault("Activity.TVLimit", "999")
+	v.SetDefault("Activity.TopTVSeriesLimit", "999")
+	v.SetDefault("Activity.PodcastLimit", "999")
+	v.SetDefault("Activity.TopPodcastsLimit", "999")
+	v.SetDefault("Activity.ScrobbleInterval", "1m")
+	v.SetDefault("Activity.ScrobbleRetry", "5m")      // doubled after each failure
+	v.SetDefault("Activity.ScrobbleExpire", "336h")   // 14 days; Last.fm limit
+	v.SetDefault("Activity.ScrobbleBatchSize", "100") // queued scrobbles per interval
+	v.SetDefault("Activity.ReportLimit", "10")
+	v.SetDefault("Activity.ReportTracksLimit", "100")
+	v.SetDefault("Activity.ReportTracksTitle", "Top Tracks of %s")
+	v.SetDefault("Activity.TrackLength", "210") // seconds when length is unknown
+	v.SetDefault("Activity.RecommendArtists", "3")
+	v.SetDefault("Activity.RecommendLimit", "25")
+	v.SetDefault("Activity.RecommendDepth", "3")       // popular tracks per similar artist
+	v.SetDefault("Activity.RecommendHistory", "2160h") // 90 days of top artists
+	v.SetDefault("Activity.RecommendRecent", "720h")   // skip tracks played in 30 days
+	v.SetDefault("Activity.RecommendCache", "1h")      // reuse home recommendations
+	v.SetDefault("Activity.ForgottenAge", "4320h")     // 180 days
+	v.SetDefault("Activity.ForgottenPlays", "5")
+	v.SetDefault("Activity.BecauseTitle", "Because you listened to %s")
+	v.SetDefault("Activity.ForgottenTitle", "Forgotten Favorites")
+	v.SetDefault("Activity.UnplayedTitle", "Unplayed in Your Library")
+	v.SetDefault("Activity.MixTime", "03:00") // daily in UTC; empty to disable
+	v.SetDefault("Activity.MixCount", "3")
+	v.SetDefault("Activity.MixArtists", "25") // top artists to cluster
+	v.SetDefault("Activity.MixLimit", "50")
+	v.SetDefault("Activity.MixRecent", "72h") // skip tracks played in 3 days
+	v.SetDefault("Activity.MixTitle", "Daily Mix %d")
+
+	v.SetDefault("DLNA.Enabled", false)
+	v.SetDefault("DLNA.FriendlyName", takeout.AppName)
+	v.SetDefault("DLNA.Listen", ":8200")
+	v.SetDefault("DLNA.MaxAge", "30m")
 
 	// TODO apply as default
 	// v.SetDefault("Bucket.URLExpiration", "15m")
@@ -418,6 +540,8 @@ func configDefaults(v *viper.Viper) {
 	v.SetDefault("LastFM.Key", "")
 	v.SetDefault("LastFM.Secret", "")
 
+	v.SetDefault("Setlist.MaxAge", "24h") // reuse fetched setlists
+
 	v.SetDefault("Music.ArtistRadioBreadth", "10")
 	v.SetDefault("Music.ArtistRadioDepth", "3")
 	v.SetDefault("Music.TrackRadioBreadth", "10")
@@ -431,6 +555,9 @@ func configDefaults(v *viper.Viper) {
 	v.SetDefault("Music.RecentLimit", "50")
 	v.SetDefault("Music.SearchIndexName", "music")
 	v.SetDefault("Music.SearchLimit", "100")
+	v.SetDefault("Music.SuggestArtistLimit", "5")
+	v.SetDefault("Music.SuggestReleaseLimit", "5")
+	v.SetDefault("Music.SuggestTrackLimit", "5")
 	v.SetDefault("Music.SimilarArtistsLimit", "10")
 	v.SetDefault("Music.SimilarReleases", "8760h") // +/- 1 year
 	v.SetDefault("Music.SimilarReleasesLimit", "10")
@@ -439,6 +566,8 @@ func configDefaults(v *viper.Viper) {
 	v.SetDefault("Music.PopularSyncInterval", "24h")
 	v.SetDefault("Music.SimilarSyncInterval", "24h")
 	v.SetDefault("Music.CoverSyncInterval", "24h")
+	v.SetDefault("Music.PreferLocalArtwork", "false")
+	v.SetDefault("Music.SmartSyncInterval", "24h")
 	v.SetDefault("Music.RelatedArtists", "43800h") // +/- 5 years
 
 	// see https://wiki.musicbrainz.org/Release_Country
@@ -448,6 +577,16 @@ func configDefaults(v *viper.Viper) {
 		"XE", // Europe
 	})
 
+	// criteria used in order to pick the primary copy of duplicate tracks
+	v.SetDefault("Music.DuplicatePreference", []string{
+		"format", // FormatPreference rank
+		"size",   // larger file for the same recording
+		"bucket", // bucket configuration order
+	})
+	v.SetDefault("Music.FormatPreference", []string{
+		"flac", "alac", "m4a", "ogg", "opus", "mp3",
+	})
+
 	v.SetDefault("Music.DB.Driver", "sqlite3")
 	v.SetDefault("Music.DB.Source", "music.db")
 	v.SetDefault("Music.DB.Logger", "default")
@@ -480,6 +619,8 @@ func configDefaults(v *viper.Viper) {
 	v.SetDefault("Film.RecentLimit", "50")
 	v.SetDefault("Film.SearchIndexName", "film")
 	v.SetDefault("Film.SearchLimit", "100")
+	v.SetDefault("Film.SuggestMovieLimit", "5")
+	v.SetDefault("Film.SuggestPersonLimit", "5")
 	v.SetDefault("Film.SyncInterval", "1h")
 	v.SetDefault("Film.PosterSyncInterval", "24h")
 	v.SetDefault("Film.BackdropSyncInterval", "24h")
@@ -504,6 +645,8 @@ func configDefaults(v *viper.Viper) {
 	v.SetDefault("TV.RecentLimit", "50")
 	v.SetDefault("TV.SearchIndexName", "tv")
 	v.SetDefault("TV.SearchLimit", "100")
+	v.SetDefault("TV.SuggestSeriesLimit", "5")
+	v.SetDefault("TV.SuggestPersonLimit", "5")
 	v.SetDefault("TV.SyncInterval", "1h")
 	v.SetDefault("TV.PosterSyncInterval", "24h")
 	v.SetDefault("TV.BackdropSyncInterval", "24h")
@@ -556,6 +699,7 @@ func configDefaults(v *viper.Viper) {
 	v.SetDefault("Podcast.RecentLimit", "25")
 	v.SetDefault("Podcast.SearchIndexName", "podcast")
 	v.SetDefault("Podcast.SearchLimit", "100")
+	v.SetDefault("Podcast.SuggestSeriesLimit", "5")
 	v.SetDefault("Podcast.SyncInterval", "1h")
 	v.SetDefault("Podcast.Series", []string{
 		"https://feeds.twit.tv/twit.xml",
@@ -0,0 +1,716 @@
+// Copyright 2023 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package dlna
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"takeoutfm.dev/takeout/internal/auth"
+	"takeoutfm.dev/takeout/internal/config"
+	"takeoutfm.dev/takeout/internal/film"
+	"takeoutfm.dev/takeout/internal/music"
+	"takeoutfm.dev/takeout/internal/podcast"
+	"takeoutfm.dev/takeout/internal/tv"
+	"takeoutfm.dev/takeout/lib/header"
+	"takeoutfm.dev/takeout/lib/upnp"
+	"takeoutfm.dev/takeout/model"
+)
+
+// Object IDs are either a fixed container or a type prefix followed by the
+// ID or UUID of the media, like release/42 or track/{uuid}.
+const (
+	idRoot     = "0"
+	idMusic    = "music"
+	idArtists  = "artists"
+	idStations = "stations"
+	idMovies   = "movies"
+	idTV       = "tv"
+	idPodcasts = "podcasts"
+
+	typeArtist    = "artist"
+	typeRelease   = "release"
+	typeTrack     = "track"
+	typeStation   = "station"
+	typeMovie     = "movie"
+	typeTVSeries  = "tvseries"
+	typeTVEpisode = "tvepisode"
+	typeSeries    = "series"
+	typeEpisode   = "episode"
+
+	browseMetadata       = "BrowseMetadata"
+	browseDirectChildren = "BrowseDirectChildren"
+)
+
+var (
+	ErrNoSuchObject = errors.New("no such object")
+)
+
+// objects are the containers and items for a browse result. Containers are
+// always listed before items.
+type objects struct {
+	containers []upnp.Container
+	items      []upnp.Item
+}
+
+func (o objects) len() int {
+	return len(o.containers) + len(o.items)
+}
+
+// page returns count objects starting at start, or all remaining objects
+// when count is zero.
+func (o objects) page(start, count int) objects {
+	total := o.len()
+	start = min(start, total)
+	end := total
+	if count > 0 {
+		end = min(start+count, total)
+	}
+	n := len(o.containers)
+	var result objects
+	if start < n {
+		result.containers = o.containers[start:min(end, n)]
+	}
+	if end > n {
+		result.items = o.items[max(start-n, 0) : end-n]
+	}
+	return result
+}
+
+func (o *objects) add(c upnp.Container) {
+	o.containers = append(o.containers, c)
+}
+
+func (o *objects) addItem(i upnp.Item) {
+	o.items = append(o.items, i)
+}
+
+func (d *DLNA) contentDirectory(w http.ResponseWriter, r *http.Request) {
+	action, err := upnp.ParseAction(r)
+	if err != nil {
+		upnp.WriteFault(w, upnp.ErrorInvalidAction, err.Error())
+		return
+	}
+	switch action.Name {
+	case "Browse":
+		d.browse(w, action)
+	case "GetSearchCapabilities":
+		upnp.WriteResponse(w, action, []upnp.Arg{{Name: "SearchCaps", Value: ""}})
+	case "GetSortCapabilities":
+		upnp.WriteResponse(w, action, []upnp.Arg{{Name: "SortCaps", Value: ""}})
+	case "GetSystemUpdateID":
+		upnp.WriteResponse(w, action, []upnp.Arg{{Name: "Id", Value: systemUpdateID}})
+	default:
+		upnp.WriteFault(w, upnp.ErrorInvalidAction, upnp.ErrInvalidAction.Error())
+	}
+}
+
+func (d *DLNA) browse(w http.ResponseWriter, action upnp.Action) {
+	id := action.Args["ObjectID"]
+	start, _ := strconv.Atoi(action.Args["StartingIndex"])
+	count, _ := strconv.Atoi(action.Args["RequestedCount"])
+	if start < 0 || count < 0 {
+		upnp.WriteFault(w, upnp.ErrorInvalidArgs, "invalid index or count")
+		return
+	}
+
+	var result objects
+	var total int
+	var err error
+	switch action.Args["BrowseFlag"] {
+	case browseMetadata:
+		result, err = d.metadata(id)
+		total = result.len()
+	case browseDirectChildren:
+		result, err = d.children(id)
+		total = result.len()
+		result = result.page(start, count)
+	default:
+		upnp.WriteFault(w, upnp.ErrorInvalidArgs, "invalid browse flag")
+		return
+	}
+	if err != nil {
+		upnp.WriteFault(w, upnp.ErrorNoSuchObject, err.Error())
+		return
+	}
+
+	didl, err := upnp.DIDL(result.containers, result.items)
+	if err != nil {
+		upnp.WriteFault(w, upnp.ErrorActionFailed, err.Error())
+		return
+	}
+	upnp.WriteResponse(w, action, []upnp.Arg{
+		{Name: "Result", Value: didl},
+		{Name: "NumberReturned", Value: strconv.Itoa(result.len())},
+		{Name: "TotalMatches", Value: strconv.Itoa(total)},
+		{Name: "UpdateID", Value: systemUpdateID},
+	})
+}
+
+// children returns the contents of the container.
+func (d *DLNA) children(id string) (objects, error) {
+	var o objects
+	switch id {
+	case idRoot:
+		for _, c := range []string{idMusic, idMovies, idTV, idPodcasts} {
+			o.add(d.folder(c, idRoot))
+		}
+		return o, nil
+	case idMusic:
+		o.add(d.folder(idArtists, idMusic))
+		o.add(d.folder(idStations, idMusic))
+		return o, nil
+	case idArtists:
+		for _, a := range d.music.Artists() {
+			o.add(d.artistContainer(a))
+		}
+		return o, nil
+	case idStations:
+		for _, s := range d.music.Stations(auth.User{}) {
+			if item, ok := d.stationItem(s); ok {
+				o.addItem(item)
+			}
+		}
+		return o, nil
+	case idMovies:
+		for _, m := range d.film.Movies() {
+			o.addItem(d.movieItem(m))
+		}
+		return o, nil
+	case idTV:
+		for _, s := range d.tv.Series() {
+			o.add(d.tvSeriesContainer(s))
+		}
+		return o, nil
+	case idPodcasts:
+		for _, s := range d.podcast.Series() {
+			o.add(d.seriesContainer(s))
+		}
+		return o, nil
+	}
+
+	kind, value, _ := strings.Cut(id, "/")
+	switch kind {
+	case typeArtist:
+		a, err := d.music.FindArtist(value)
+		if err != nil {
+			return o, ErrNoSuchObject
+		}
+		for _, r := range d.music.ArtistReleases(a) {
+			o.add(d.releaseContainer(r, id))
+		}
+	case typeRelease:
+		r, err := d.music.FindRelease(value)
+		if err != nil {
+			return o, ErrNoSuchObject
+		}
+		for _, t := range d.music.ReleaseTracks(r) {
+			o.addItem(d.trackItem(t, id))
+		}
+	case typeTVSeries:
+		s, err := d.tv.FindSeries(value)
+		if err != nil {
+			return o, ErrNoSuchObject
+		}
+		for _, e := range d.tv.SeriesEpisodes(s) {
+			o.addItem(d.tvEpisodeItem(e, id))
+		}
+	case typeSeries:
+		s, err := d.podcast.FindSeries(value)
+		if err != nil {
+			return o, ErrNoSuchObject
+		}
+		for _, e := range d.podcast.Episodes(s) {
+			o.addItem(d.episodeItem(e, id))
+		}
+	default:
+		return o, ErrNoSuchObject
+	}
+	return o, nil
+}
+
+// metadata returns the object itself.
+func (d *DLNA) metadata(id string) (objects, error) {
+	var o objects
+	switch id {
+	case idRoot:
+		root := d.folder(idRoot, "-1")
+		root.Title = d.config.DLNA.FriendlyName
+		o.add(root)
+		return o, nil
+	case idMusic, idMovies, idTV, idPodcasts:
+		o.add(d.folder(id, idRoot))
+		return o, nil
+	case idArtists, idStations:
+		o.add(d.folder(id, idMusic))
+		return o, nil
+	}
+
+	kind, value, _ := strings.Cut(id, "/")
+	switch kind {
+	case typeArtist:
+		a, err := d.music.FindArtist(value)
+		if err != nil {
+			return o, ErrNoSuchObject
+		}
+		o.add(d.artistContainer(a))
+	case typeRelease:
+		r, err := d.music.FindRelease(value)
+		if err != nil {
+			return o, ErrNoSuchObject
+		}
+		parent := idArtists
+		if a, err := d.music.Artist(r.Artist); err == nil {
+			parent = objectID(typeArtist, a.ID)
+		}
+		o.add(d.releaseContainer(r, parent))
+	case typeTrack:
+		t, err := d.music.LookupUUID(value)
+		if err != nil {
+			return o, ErrNoSuchObject
+		}
+		parent := idArtists
+		if r, err := d.music.FindRelease(t.REID); err == nil {
+			parent = objectID(typeRelease, r.ID)
+		}
+		o.addItem(d.trackItem(t, parent))
+	case typeStation:
+		id, _ := strconv.Atoi(value)
+		s, err := d.music.LookupStation(id)
+		if err != nil {
+			return o, ErrNoSuchObject
+		}
+		item, ok := d.stationItem(s)
+		if !ok {
+			return o, ErrNoSuchObject
+		}
+		o.addItem(item)
+	case typeMovie:
+		m, err := d.film.LookupUUID(value)
+		if err != nil {
+			return o, ErrNoSuchObject
+		}
+		o.addItem(d.movieItem(m))
+	case typeTVSeries:
+		s, err := d.tv.FindSeries(value)
+		if err != nil {
+			return o, ErrNoSuchObject
+		}
+		o.add(d.tvSeriesContainer(s))
+	case typeTVEpisode:
+		e, err := d.tv.LookupUUID(value)
+		if err != nil {
+			return o, ErrNoSuchObject
+		}
+		parent := idTV
+		if s, err := d.tv.LookupTVID(int(e.TVID)); err == nil {
+			parent = objectID(typeTVSeries, s.ID)
+		}
+		o.addItem(d.tvEpisodeItem(e, parent))
+	case typeSeries:
+		s, err := d.podcast.FindSeries(value)
+		if err != nil {
+			return o, ErrNoSuchObject
+		}
+		o.add(d.seriesContainer(s))
+	case typeEpisode:
+		e, err := d.podcast.FindEpisode(value)
+		if err != nil {
+			return o, ErrNoSuchObject
+		}
+		parent := idPodcasts
+		if s, err := d.podcast.FindSeries(e.SID); err == nil {
+			parent = objectID(typeSeries, s.ID)
+		}
+		o.addItem(d.episodeItem(e, parent))
+	default:
+		return o, ErrNoSuchObject
+	}
+	return o, nil
+}
+
+func objectID(kind string, id any) string {
+	return kind + "/" + url.PathEscape(fmt.Sprint(id))
+}
+
+var folderTitles = map[string]string{
+	idRoot:     "Takeout",
+	idMusic:    "Music",
+	idArtists:  "Artists",
+	idStations: "Radio",
+	idMovies:   "Movies",
+	idTV:       "TV",
+	idPodcasts: "Podcasts",
+}
+
+func (d *DLNA) folder(id, parent string) upnp.Container {
+	return upnp.Container{Object: upnp.Object{
+		ID:         id,
+		ParentID:   parent,
+		Restricted: 1,
+		Title:      folderTitles[id],
+		Class:      upnp.ClassStorageFolder,
+	}}
+}
+
+func (d *DLNA) artistContainer(a model.Artist) upnp.Container {
+	return upnp.Container{Object: upnp.Object{
+		ID:         objectID(typeArtist, a.ID),
+		ParentID:   idArtists,
+		Restricted: 1,
+		Title:      a.Name,
+		Class:      upnp.ClassMusicArtist,
+		Genre:      a.Genre,
+	}}
+}
+
+func (d *DLNA) releaseContainer(r model.Release, parent string) upnp.Container {
+	return upnp.Container{Object: upnp.Object{
+		ID:          objectID(typeRelease, r.ID),
+		ParentID:    parent,
+		Restricted:  1,
+		Title:       r.Name,
+		Creator:     r.Artist,
+		Artist:      r.Artist,
+		Date:        formatDate(r.Date),
+		Class:       upnp.ClassMusicAlbum,
+		AlbumArtURI: d.imageURL(music.Cover(r, "250")),
+	}}
+}
+
+func (d *DLNA) trackItem(t model.Track, parent string) upnp.Item {
+	artist := t.TrackArtist
+	if artist == "" {
+		artist = t.Artist
+	}
+	res := upnp.Res{
+		ProtocolInfo: upnp.ProtocolInfo(contentType(t.Key), true),
+		Size:         t.Size,
+		URL:          d.resourceURL(typeTrack, t.UUID),
+	}
+	if t.Length > 0 {
+		res.Duration = upnp.Duration(time.Duration(t.Length) * time.Second)
+	}
+	return upnp.Item{Object: upnp.Object{
+		ID:          objectID(typeTrack, t.UUID),
+		ParentID:    parent,
+		Restricted:  1,
+		Title:       t.Title,
+		Creator:     artist,
+		Artist:      artist,
+		Album:       t.ReleaseTitle,
+		Date:        formatDate(t.ReleaseDate),
+		TrackNumber: t.TrackNum,
+		Class:       upnp.ClassMusicTrack,
+		AlbumArtURI: d.imageURL(music.TrackCover(t, "250")),
+		Res:         []upnp.Res{res},
+	}}
+}
+
+// stationItem returns an item for internet radio stations with a stream
+// that can be played directly. Other stations are resolved dynamically and
+// are not included.
+func (d *DLNA) stationItem(s model.Station) (upnp.Item, bool) {
+	if s.Type != music.TypeStream {
+		return upnp.Item{}, false
+	}
+	stream, ok := stationStream(s.Ref)
+	if !ok {
+		return upnp.Item{}, false
+	}
+	return upnp.Item{Object: upnp.Object{
+		ID:          objectID(typeStation, s.ID),
+		ParentID:    idStations,
+		Restricted:  1,
+		Title:       s.Name,
+		Creator:     s.Creator,
+		Description: s.Description,
+		Class:       upnp.ClassAudioBroadcast,
+		AlbumArtURI: d.imageURL(s.Image),
+		Res: []upnp.Res{{
+			ProtocolInfo: upnp.ProtocolInfo(stream.ContentType, false),
+			URL:          stream.URL,
+		}},
+	}}, true
+}
+
+// stationStream returns the first stream in the station ref which is either
+// a list of sources or a stream URL. Playlists are not supported.
+func stationStream(ref string) (config.ContentDescription, bool) {
+	if strings.HasPrefix(ref, "[{") {
+		var sources []config.ContentDescription
+		if json.Unmarshal([]byte(ref), &sources) != nil {
+			return config.ContentDescription{}, false
+		}
+		for _, src := range sources {
+			if src.URL == "" || strings.HasSuffix(src.URL, ".pls") {
+				continue
+			}
+			if src.ContentType == "" {
+				src.ContentType = contentType(src.URL)
+			}
+			return src, true
+		}
+		return config.ContentDescription{}, false
+	}
+	switch path.Ext(ref) {
+	case ".mp3", ".aac", ".ogg", ".flac":
+		return config.ContentDescription{URL: ref, ContentType: contentType(ref)}, true
+	}
+	return config.ContentDescription{}, false
+}
+
+func (d *DLNA) movieItem(m model.Movie) upnp.Item {
+	res := upnp.Res{
+		ProtocolInfo: upnp.ProtocolInfo(contentType(m.Key), true),
+		Size:         m.Size,
+		URL:          d.resourceURL(typeMovie, m.UUID),
+	}
+	if m.Runtime > 0 {
+		res.Duration = upnp.Duration(time.Duration(m.Runtime) * time.Minute)
+	}
+	return upnp.Item{Object: upnp.Object{
+		ID:          objectID(typeMovie, m.UUID),
+		ParentID:    idMovies,
+		Restricted:  1,
+		Title:       m.Title,
+		Date:        formatDate(m.Date),
+		Description: m.Overview,
+		Class:       upnp.ClassMovie,
+		AlbumArtURI: d.imageURL(film.MoviePoster(m)),
+		Res:         []upnp.Res{res},
+	}}
+}
+
+func (d *DLNA) tvSeriesContainer(s model.TVSeries) upnp.Container {
+	return upnp.Container{
+		ChildCount: s.EpisodeCount,
+		Object: upnp.Object{
+			ID:          objectID(typeTVSeries, s.ID),
+			ParentID:    idTV,
+			Restricted:  1,
+			Title:       s.Name,
+			Date:        formatDate(s.Date),
+			Description: s.Overview,
+			Class:       upnp.ClassStorageFolder,
+			AlbumArtURI: d.imageURL(tv.SeriesPoster(s)),
+		},
+	}
+}
+
+func (d *DLNA) tvEpisodeItem(e model.TVEpisode, parent string) upnp.Item {
+	res := upnp.Res{
+		ProtocolInfo: upnp.ProtocolInfo(contentType(e.Key), true),
+		Size:         e.Size,
+		URL:          d.resourceURL(typeTVEpisode, e.UUID),
+	}
+	if e.Runtime > 0 {
+		res.Duration = upnp.Duration(time.Duration(e.Runtime) * time.Minute)
+	}
+	return upnp.Item{Object: upnp.Object{
+		ID:          objectID(typeTVEpisode, e.UUID),
+		ParentID:    parent,
+		Restricted:  1,
+		Title:       e.Name,
+		Date:        formatDate(e.Date),
+		Description: e.Overview,
+		Season:      e.Season,
+		Episode:     e.Episode,
+		Class:       upnp.ClassVideoItem,
+		AlbumArtURI: d.imageURL(tv.EpisodeStillImage(e)),
+		Res:         []upnp.Res{res},
+	}}
+}
+
+func (d *DLNA) seriesContainer(s model.Series) upnp.Container {
+	return upnp.Container{Object: upnp.Object{
+		ID:          objectID(typeSeries, s.ID),
+		ParentID:    idPodcasts,
+		Restricted:  1,
+		Title:       s.Title,
+		Creator:     s.Author,
+		Date:        formatDate(s.Date),
+		Class:       upnp.ClassStorageFolder,
+		AlbumArtURI: d.imageURL(podcast.SeriesImage(s)),
+	}}
+}
+
+// episodeItem streams podcast episodes directly from the source.
+func (d *DLNA) episodeItem(e model.Episode, parent string) upnp.Item {
+	class := upnp.ClassAudioItem
+	ct := e.ContentType
+	if ct == "" {
+		ct = contentType(e.URL)
+	}
+	if strings.HasPrefix(ct, "video/") {
+		class = upnp.ClassVideoItem
+	}
+	image := podcast.EpisodeImage(e)
+	if image == "" {
+		if s, err := d.podcast.FindSeries(e.SID); err == nil {
+			image = podcast.SeriesImage(s)
+		}
+	}
+	return upnp.Item{Object: upnp.Object{
+		ID:          objectID(typeEpisode, e.ID),
+		ParentID:    parent,
+		Restricted:  1,
+		Title:       e.Title,
+		Creator:     e.Author,
+		Date:        formatDate(e.Date),
+		Class:       class,
+		AlbumArtURI: d.imageURL(image),
+		Res: []upnp.Res{{
+			ProtocolInfo: upnp.ProtocolInfo(ct, false),
+			Size:         e.Size,
+			URL:          e.URL,
+		}},
+	}}
+}
+
+func (d *DLNA) resourceURL(kind, id string) string {
+	return d.url + "/dlna/res/" + kind + "/" + url.PathEscape(id)
+}
+
+// imageURL returns an absolute URL for image paths served by Takeout.
+// Placeholder icons are skipped since devices expect JPEG or PNG art.
+func (d *DLNA) imageURL(image string) string {
+	switch {
+	case image == "" || strings.HasSuffix(image, ".svg"):
+		return ""
+	case strings.HasPrefix(image, "/"):
+		return d.url + image
+	}
+	return image
+}
+
+func formatDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.DateOnly)
+}
+
+var contentTypes = map[string]string{
+	".flac": "audio/flac",
+	".m4a":  "audio/mp4",
+	".mp3":  "audio/mpeg",
+	".ogg":  "audio/ogg",
+	".opus": "audio/ogg",
+	".wav":  "audio/wav",
+	".aac":  "audio/aac",
+	".mp4":  "video/mp4",
+	".m4v":  "video/mp4",
+	".mkv":  "video/x-matroska",
+	".webm": "video/webm",
+	".avi":  "video/x-msvideo",
+}
+
+// contentType uses the file extension since some systems don't have mime
+// types for common media formats.
+func contentType(name string) string {
+	if u, err := url.Parse(name); err == nil && u.Scheme != "" {
+		name = u.Path
+	}
+	ext := strings.ToLower(path.Ext(name))
+	if v, ok := contentTypes[ext]; ok {
+		return v
+	}
+	if v := mime.TypeByExtension(ext); v != "" {
+		return v
+	}
+	return "application/octet-stream"
+}
+
+// resource streams local files directly, with range support, and redirects
+// to the bucket URL otherwise.
+func (d *DLNA) resource(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var u *url.URL
+	var key string
+	switch r.PathValue("type") {
+	case typeTrack:
+		t, err := d.music.LookupUUID(id)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		u, key = d.music.TrackURL(t), t.Key
+	case typeMovie:
+		m, err := d.film.LookupUUID(id)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		u, key = d.film.MovieURL(m), m.Key
+	case typeTVEpisode:
+		e, err := d.tv.LookupUUID(id)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		u, key = d.tv.EpisodeURL(e), e.Key
+	}
+	if u == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if u.Scheme != "file" {
+		http.Redirect(w, r, u.String(), http.StatusTemporaryRedirect)
+		return
+	}
+	w.Header().Set(header.ContentType, contentType(key))
+	w.Header().Set("transferMode.dlna.org", "Streaming")
+	w.Header().Set("contentFeatures.dlna.org", "DLNA.ORG_OP=01;DLNA.ORG_CI=0")
+	http.ServeFile(w, r, u.Path)
+}
+
+// localArtwork serves local image files and embedded pictures.
+func (d *DLNA) localArtwork(w http.ResponseWriter, r *http.Request) {
+	img, contentType, err := d.music.LocalArtworkImage(r.PathValue("key"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set(header.ContentType, contentType)
+	w.Header().Set(header.ContentLength, strconv.Itoa(len(img)))
+	w.Write(img)
+}
+
+// sourceProtocolInfo lists the content types that can be served.
+func sourceProtocolInfo() string {
+	seen := make(map[string]bool)
+	var list []string
+	for _, ct := range contentTypes {
+		if !seen[ct] {
+			seen[ct] = true
+			list = append(list, upnp.ProtocolInfo(ct, true))
+		}
+	}
+	slices.Sort(list)
+	return strings.Join(list, ",")
+}
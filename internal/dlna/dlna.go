@@ -0,0 +1,297 @@
+// Copyright 2023 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package dlna provides an optional UPnP/DLNA media server which exposes a
+// media collection to devices on the local network using SSDP discovery and
+// a ContentDirectory service.
+package dlna
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+
+	"github.com/google/uuid"
+	"takeoutfm.dev/takeout"
+	"takeoutfm.dev/takeout/internal/config"
+	"takeoutfm.dev/takeout/internal/film"
+	"takeoutfm.dev/takeout/internal/music"
+	"takeoutfm.dev/takeout/internal/podcast"
+	"takeoutfm.dev/takeout/internal/tv"
+	"takeoutfm.dev/takeout/lib/header"
+	"takeoutfm.dev/takeout/lib/log"
+	"takeoutfm.dev/takeout/lib/upnp"
+)
+
+const (
+	DescriptionPath = "/dlna/device.xml"
+
+	// system update id is constant since changes are not evented
+	systemUpdateID = "1"
+)
+
+var (
+	ErrNoAddress = errors.New("no network address")
+)
+
+type DLNA struct {
+	config  *config.Config
+	music   *music.Music
+	film    *film.Film
+	tv      *tv.TV
+	podcast *podcast.Podcast
+	images  http.Handler
+	url     string
+	udn     string
+}
+
+// NewDLNA creates a media server for the media. Images requested using /img
+// paths are served by the images handler.
+func NewDLNA(config *config.Config, m *music.Music, f *film.Film, t *tv.TV,
+	p *podcast.Podcast, images http.Handler) *DLNA {
+	return &DLNA{
+		config:  config,
+		music:   m,
+		film:    f,
+		tv:      t,
+		podcast: p,
+		images:  images,
+		udn:     deviceUDN(config.DLNA),
+	}
+}
+
+// deviceUDN uses the configured UUID or one derived from the host and media
+// name so devices see the same server after a restart.
+func deviceUDN(c config.DLNAConfig) string {
+	if c.UUID != "" {
+		return "uuid:" + c.UUID
+	}
+	host, _ := os.Hostname()
+	return "uuid:" + uuid.NewSHA1(uuid.NameSpaceURL,
+		[]byte(fmt.Sprintf("takeout://%s/dlna/%s", host, c.Media))).String()
+}
+
+// ListenAndServe starts SSDP discovery and serves requests until there's an
+// error.
+func (d *DLNA) ListenAndServe() error {
+	c := d.config.DLNA
+	var iface *net.Interface
+	if c.Interface != "" {
+		var err error
+		iface, err = net.InterfaceByName(c.Interface)
+		if err != nil {
+			return err
+		}
+	}
+
+	listen, err := listenAddr(c.Listen, func() (net.IP, error) {
+		return interfaceAddr(iface)
+	})
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	d.url = c.URL
+	if d.url == "" {
+		addr, err := interfaceAddr(iface)
+		if err != nil {
+			return err
+		}
+		port := listener.Addr().(*net.TCPAddr).Port
+		d.url = "http://" + net.JoinHostPort(addr.String(), strconv.Itoa(port))
+	}
+
+	advertiser := &upnp.Advertiser{
+		UUID:      d.udn,
+		Location:  d.url + DescriptionPath,
+		Server:    fmt.Sprintf("%s UPnP/1.0 %s/%s", runtime.GOOS, takeout.AppName, takeout.Version),
+		Types:     []string{upnp.DeviceMediaServer, upnp.ServiceContentDirectory, upnp.ServiceConnectionManager},
+		MaxAge:    c.MaxAge,
+		Interface: iface,
+	}
+	err = advertiser.Start()
+	if err != nil {
+		return err
+	}
+	defer advertiser.Close()
+
+	log.Printf("dlna %s at %s\n", c.FriendlyName, d.url)
+	return http.Serve(listener, d.Handler())
+}
+
+// listenAddr uses the interface address when the listen address has no host
+// so the server isn't reachable on every interface. There's no
+// authentication for DLNA devices.
+func listenAddr(listen string, ifaceAddr func() (net.IP, error)) (string, error) {
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return "", err
+	}
+	if host == "" {
+		addr, err := ifaceAddr()
+		if err != nil {
+			return "", err
+		}
+		host = addr.String()
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// interfaceAddr finds the first IPv4 address for the interface or any
+// multicast interface that's up when nil.
+func interfaceAddr(iface *net.Interface) (net.IP, error) {
+	var ifaces []net.Interface
+	if iface != nil {
+		ifaces = append(ifaces, *iface)
+	} else {
+		var err error
+		ifaces, err = net.Interfaces()
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, i := range ifaces {
+		if i.Flags&net.FlagUp == 0 || i.Flags&net.FlagLoopback != 0 ||
+			i.Flags&net.FlagMulticast == 0 {
+			continue
+		}
+		addrs, err := i.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+				return ipnet.IP.To4(), nil
+			}
+		}
+	}
+	return nil, ErrNoAddress
+}
+
+func (d *DLNA) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+DescriptionPath, d.description)
+	mux.HandleFunc("GET /dlna/cds.xml", scpd(upnp.ContentDirectorySCPD))
+	mux.HandleFunc("GET /dlna/cms.xml", scpd(upnp.ConnectionManagerSCPD))
+	mux.HandleFunc("POST /dlna/cds/control", d.contentDirectory)
+	mux.HandleFunc("POST /dlna/cms/control", d.connectionManager)
+	mux.HandleFunc("SUBSCRIBE /dlna/{service}/event", subscribe)
+	mux.HandleFunc("UNSUBSCRIBE /dlna/{service}/event", unsubscribe)
+	mux.HandleFunc("GET /dlna/res/{type}/{id}", d.resource)
+	mux.HandleFunc("GET /img/local/{key}", d.localArtwork)
+	if d.images != nil {
+		mux.Handle("GET /img/", d.images)
+	}
+	return mux
+}
+
+func (d *DLNA) description(w http.ResponseWriter, r *http.Request) {
+	device := upnp.Device{
+		DeviceType:      upnp.DeviceMediaServer,
+		FriendlyName:    d.config.DLNA.FriendlyName,
+		Manufacturer:    takeout.AppName,
+		ManufacturerURL: "https://takeoutfm.com/",
+		ModelName:       takeout.AppName,
+		ModelNumber:     takeout.Version,
+		UDN:             d.udn,
+		DLNADoc:         "DMS-1.50",
+		ServiceList: []upnp.Service{
+			{
+				ServiceType: upnp.ServiceContentDirectory,
+				ServiceID:   upnp.ServiceIDContentDirectory,
+				SCPDURL:     "/dlna/cds.xml",
+				ControlURL:  "/dlna/cds/control",
+				EventSubURL: "/dlna/cds/event",
+			},
+			{
+				ServiceType: upnp.ServiceConnectionManager,
+				ServiceID:   upnp.ServiceIDConnectionManager,
+				SCPDURL:     "/dlna/cms.xml",
+				ControlURL:  "/dlna/cms/control",
+				EventSubURL: "/dlna/cms/event",
+			},
+		},
+	}
+	data, err := device.Description()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set(header.ContentType, upnp.ContentTypeXML)
+	w.Write(data)
+}
+
+func scpd(doc string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(header.ContentType, upnp.ContentTypeXML)
+		w.Write([]byte(doc))
+	}
+}
+
+// subscribe accepts event subscriptions so devices don't give up on the
+// server, however no events are sent since the content isn't evented.
+func subscribe(w http.ResponseWriter, r *http.Request) {
+	sid := r.Header.Get("SID")
+	if sid == "" {
+		sid = "uuid:" + uuid.NewString()
+	}
+	w.Header().Set("SID", sid)
+	w.Header().Set("TIMEOUT", "Second-1800")
+	w.WriteHeader(http.StatusOK)
+}
+
+func unsubscribe(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+func (d *DLNA) connectionManager(w http.ResponseWriter, r *http.Request) {
+	action, err := upnp.ParseAction(r)
+	if err != nil {
+		upnp.WriteFault(w, upnp.ErrorInvalidAction, err.Error())
+		return
+	}
+	switch action.Name {
+	case "GetProtocolInfo":
+		upnp.WriteResponse(w, action, []upnp.Arg{
+			{Name: "Source", Value: sourceProtocolInfo()},
+			{Name: "Sink", Value: ""},
+		})
+	case "GetCurrentConnectionIDs":
+		upnp.WriteResponse(w, action, []upnp.Arg{{Name: "ConnectionIDs", Value: "0"}})
+	case "GetCurrentConnectionInfo":
+		upnp.WriteResponse(w, action, []upnp.Arg{
+			{Name: "RcsID", Value: "-1"},
+			{Name: "AVTransportID", Value: "-1"},
+			{Name: "ProtocolInfo", Value: ""},
+			{Name: "PeerConnectionManager", Value: ""},
+			{Name: "PeerConnectionID", Value: "-1"},
+			{Name: "Direction", Value: "Output"},
+			{Name: "Status", Value: "OK"},
+		})
+	default:
+		upnp.WriteFault(w, upnp.ErrorInvalidAction, upnp.ErrInvalidAction.Error())
+	}
+}
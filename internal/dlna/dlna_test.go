@@ -0,0 +1,174 @@
+// Copyright 2023 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package dlna
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"takeoutfm.dev/takeout/internal/config"
+	"takeoutfm.dev/takeout/lib/upnp"
+)
+
+func TestPage(t *testing.T) {
+	var o objects
+	for i := 0; i < 3; i++ {
+		o.add(upnp.Container{})
+	}
+	for i := 0; i < 4; i++ {
+		o.addItem(upnp.Item{})
+	}
+	cases := []struct {
+		start, count      int
+		containers, items int
+	}{
+		{0, 0, 3, 4},
+		{0, 2, 2, 0},
+		{2, 3, 1, 2},
+		{3, 10, 0, 4},
+		{6, 5, 0, 1},
+		{9, 5, 0, 0},
+	}
+	for _, c := range cases {
+		p := o.page(c.start, c.count)
+		if len(p.containers) != c.containers || len(p.items) != c.items {
+			t.Error("expect page", c, len(p.containers), len(p.items))
+		}
+	}
+}
+
+func TestStationStream(t *testing.T) {
+	ref := `[{"contentType":"audio/aac","url":"https://radio.example.com/stream.pls"},` +
+		`{"contentType":"audio/mpeg","url":"https://radio.example.com/stream"}]`
+	stream, ok := stationStream(ref)
+	if !ok || stream.URL != "https://radio.example.com/stream" || stream.ContentType != "audio/mpeg" {
+		t.Error("expect source stream", stream)
+	}
+	stream, ok = stationStream("https://radio.example.com/live.flac")
+	if !ok || stream.ContentType != "audio/flac" {
+		t.Error("expect flac stream", stream)
+	}
+	if _, ok = stationStream("https://radio.example.com/live.pls"); ok {
+		t.Error("expect no stream for playlist")
+	}
+	if _, ok = stationStream("/music/search?q=genre:jazz&radio=1"); ok {
+		t.Error("expect no stream for search")
+	}
+}
+
+func TestContentType(t *testing.T) {
+	for name, expect := range map[string]string{
+		"Artist/Release/01-Track.FLAC":           "audio/flac",
+		"Movies/Title (1999).mkv":                "video/x-matroska",
+		"https://cdn.example.com/ep.m4a?x=1.mp3": "audio/mp4",
+		"notes":                                  "application/octet-stream",
+	} {
+		if v := contentType(name); v != expect {
+			t.Error("expect content type", name, expect, v)
+		}
+	}
+}
+
+func testDLNA() *DLNA {
+	c := &config.Config{DLNA: config.DLNAConfig{FriendlyName: "Test", UUID: "0b5b3a6c-4ad3-4bd5-9a3e-3e1c2c0b7f3a"}}
+	d := NewDLNA(c, nil, nil, nil, nil, nil)
+	d.url = "http://192.168.1.2:8200"
+	return d
+}
+
+func TestImageURL(t *testing.T) {
+	d := testDLNA()
+	for image, expect := range map[string]string{
+		"/img/mb/re/abc/front":          "http://192.168.1.2:8200/img/mb/re/abc/front",
+		"https://example.com/cover.jpg": "https://example.com/cover.jpg",
+		"/static/album-white-36dp.svg":  "",
+		"":                              "",
+	} {
+		if v := d.imageURL(image); v != expect {
+			t.Error("expect image url", image, expect, v)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	d := testDLNA()
+	handler := d.Handler()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", DescriptionPath, nil))
+	if w.Code != http.StatusOK ||
+		!strings.Contains(w.Body.String(), "<UDN>uuid:0b5b3a6c-4ad3-4bd5-9a3e-3e1c2c0b7f3a</UDN>") {
+		t.Error("expect description", w.Code, w.Body.String())
+	}
+
+	browse := func(id, flag string) string {
+		body := `<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>` +
+			`<u:Browse xmlns:u="urn:schemas-upnp-org:service:ContentDirectory:1">` +
+			`<ObjectID>` + id + `</ObjectID><BrowseFlag>` + flag + `</BrowseFlag>` +
+			`<Filter>*</Filter><StartingIndex>0</StartingIndex><RequestedCount>0</RequestedCount>` +
+			`<SortCriteria></SortCriteria></u:Browse></s:Body></s:Envelope>`
+		r := httptest.NewRequest("POST", "/dlna/cds/control", strings.NewReader(body))
+		r.Header.Set("SOAPACTION", `"`+upnp.ServiceContentDirectory+`#Browse"`)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Body.String()
+	}
+
+	result := browse(idRoot, browseDirectChildren)
+	for _, s := range []string{
+		"<NumberReturned>4</NumberReturned>",
+		"<TotalMatches>4</TotalMatches>",
+		"&lt;container id=&#34;music&#34; parentID=&#34;0&#34; restricted=&#34;1&#34;&gt;&lt;dc:title&gt;Music&lt;/dc:title&gt;",
+	} {
+		if !strings.Contains(result, s) {
+			t.Error("expect", s, result)
+		}
+	}
+
+	result = browse(idRoot, browseMetadata)
+	if !strings.Contains(result, "&lt;dc:title&gt;Test&lt;/dc:title&gt;") ||
+		!strings.Contains(result, "<NumberReturned>1</NumberReturned>") {
+		t.Error("expect root metadata", result)
+	}
+
+	result = browse("unknown/1", browseDirectChildren)
+	if !strings.Contains(result, "<errorCode>701</errorCode>") {
+		t.Error("expect no such object", result)
+	}
+}
+
+func TestListenAddr(t *testing.T) {
+	ip := func() (net.IP, error) {
+		return net.ParseIP("192.168.1.10"), nil
+	}
+	addr, err := listenAddr(":8200", ip)
+	if err != nil || addr != "192.168.1.10:8200" {
+		t.Error("expect interface address", addr, err)
+	}
+	addr, err = listenAddr("0.0.0.0:8200", ip)
+	if err != nil || addr != "0.0.0.0:8200" {
+		t.Error("expect listen address", addr, err)
+	}
+	_, err = listenAddr("8200", ip)
+	if err == nil {
+		t.Error("expect error")
+	}
+}
@@ -26,6 +26,7 @@ import (
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"takeoutfm.dev/takeout/internal/people"
+	tgorm "takeoutfm.dev/takeout/lib/gorm"
 	. "takeoutfm.dev/takeout/model"
 )
 
@@ -48,7 +49,8 @@ func (f *Film) openDB() (err error) {
 		return
 	}
 
-	f.db.AutoMigrate(&Cast{}, &Collection{}, &Crew{}, &Genre{}, &Keyword{}, &Movie{}, &Person{}, &Trailer{})
+	f.db.AutoMigrate(&Cast{}, &Collection{}, &Crew{}, &Genre{}, &Keyword{}, &Movie{}, &MovieMatch{},
+		&Person{}, &Trailer{}, &UnmatchedMovie{})
 	return
 }
 
@@ -66,6 +68,16 @@ func (f *Film) Movies() []Movie {
 	return movies
 }
 
+// MoviesPage returns a page of movies and the total matching movies.
+func (f *Film) MoviesPage(page tgorm.Page) ([]Movie, int, error) {
+	if page.Order == "" {
+		page.Order = "sort_title"
+	}
+	var movies []Movie
+	total, err := page.Find(f.db, &movies)
+	return movies, total, err
+}
+
 func (f *Film) Genre(name string) []Movie {
 	var movies []Movie
 	f.db.Where("movies.tm_id in (select tm_id from genres where name = ?)", name).
@@ -73,6 +85,20 @@ func (f *Film) Genre(name string) []Movie {
 	return movies
 }
 
+// GenrePage returns a page of movies in the genre and the total matching
+// movies.
+func (f *Film) GenrePage(name string, page tgorm.Page) ([]Movie, int, error) {
+	page.Where = append(page.Where, tgorm.Cond{
+		Query: "movies.tm_id in (select tm_id from genres where name = ?)",
+		Args:  []interface{}{name}})
+	if page.Order == "" {
+		page.Order = "movies.date"
+	}
+	var movies []Movie
+	total, err := page.Find(f.db, &movies)
+	return movies, total, err
+}
+
 func (f *Film) Genres(m Movie) []string {
 	var genres []Genre
 	var list []string
@@ -90,6 +116,20 @@ func (f *Film) Keyword(name string) []Movie {
 	return movies
 }
 
+// KeywordPage returns a page of movies with the keyword and the total
+// matching movies.
+func (f *Film) KeywordPage(name string, page tgorm.Page) ([]Movie, int, error) {
+	page.Where = append(page.Where, tgorm.Cond{
+		Query: "movies.tm_id in (select tm_id from keywords where name = ?)",
+		Args:  []interface{}{name}})
+	if page.Order == "" {
+		page.Order = "movies.date"
+	}
+	var movies []Movie
+	total, err := page.Find(f.db, &movies)
+	return movies, total, err
+}
+
 func (f *Film) Keywords(m Movie) []string {
 	var keywords []Keyword
 	var list []string
@@ -170,6 +210,14 @@ func (f *Film) deleteMovie(tmid int) {
 	}
 }
 
+func (f *Film) deleteMovieKey(key string) {
+	var list []Movie
+	f.db.Where("key = ?", key).Find(&list)
+	for _, o := range list {
+		f.db.Unscoped().Delete(o)
+	}
+}
+
 func (f *Film) deleteCast(tmid int) {
 	var list []Cast
 	f.db.Where("tm_id = ?", tmid).Find(&list)
@@ -222,6 +270,17 @@ func (f *Film) Person(peid int) (Person, error) {
 	return people.Person(f.db, peid)
 }
 
+func (f *Film) peopleNamed(names []string) []Person {
+	return people.PeopleNamed(f.db, names)
+}
+
+// moviesTitled returns the movies with one of the titles.
+func (f *Film) moviesTitled(titles []string) []Movie {
+	var movies []Movie
+	f.db.Where("title in (?)", titles).Order("date").Find(&movies)
+	return movies
+}
+
 func (f *Film) UpdateMovie(m *Movie) error {
 	return f.db.Save(m).Error
 }
@@ -381,3 +440,81 @@ func (f *Film) createPerson(p *Person) error {
 func (f *Film) createTrailer(t *Trailer) error {
 	return f.db.Create(t).Error
 }
+
+func (f *Film) UnmatchedMovies() []UnmatchedMovie {
+	var list []UnmatchedMovie
+	f.db.Order("key").Find(&list)
+	return list
+}
+
+func (f *Film) LookupUnmatchedMovie(id int) (UnmatchedMovie, error) {
+	var u UnmatchedMovie
+	err := f.db.First(&u, id).Error
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		return UnmatchedMovie{}, errors.New("unmatched movie not found")
+	}
+	return u, err
+}
+
+// Create or replace the unmatched movie with the same key.
+func (f *Film) saveUnmatchedMovie(u *UnmatchedMovie) error {
+	var curr UnmatchedMovie
+	err := f.db.First(&curr, "key = ?", u.Key).Error
+	if err == nil {
+		u.ID = curr.ID
+		u.CreatedAt = curr.CreatedAt
+		return f.db.Save(u).Error
+	}
+	return f.db.Create(u).Error
+}
+
+func (f *Film) deleteUnmatchedMovie(key string) {
+	var list []UnmatchedMovie
+	f.db.Where("key = ?", key).Find(&list)
+	for _, o := range list {
+		f.db.Unscoped().Delete(o)
+	}
+}
+
+func (f *Film) deleteUnmatchedMovies() {
+	f.db.Unscoped().Where("1 = 1").Delete(&UnmatchedMovie{})
+}
+
+func (f *Film) movieMatch(key string) (MovieMatch, error) {
+	var match MovieMatch
+	err := f.db.First(&match, "key = ?", key).Error
+	return match, err
+}
+
+// Create or replace the movie match with the same key.
+func (f *Film) saveMovieMatch(match *MovieMatch) error {
+	curr, err := f.movieMatch(match.Key)
+	if err == nil {
+		match.ID = curr.ID
+		match.CreatedAt = curr.CreatedAt
+		return f.db.Save(match).Error
+	}
+	return f.db.Create(match).Error
+}
+
+func (f *Film) movieMatches() []MovieMatch {
+	var list []MovieMatch
+	f.db.Order("key").Find(&list)
+	return list
+}
+
+func (f *Film) deleteMovieMatch(id int) error {
+	return f.db.Unscoped().Delete(&MovieMatch{}, id).Error
+}
+
+func (f *Film) movieForKey(key string) (Movie, error) {
+	var m Movie
+	err := f.db.First(&m, "key = ?", key).Error
+	return m, err
+}
+
+func (f *Film) unmatchedMovieForKey(key string) (UnmatchedMovie, error) {
+	var u UnmatchedMovie
+	err := f.db.First(&u, "key = ?", key).Error
+	return u, err
+}
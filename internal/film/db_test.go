@@ -22,6 +22,7 @@ import (
 	"time"
 
 	"takeoutfm.dev/takeout/internal/config"
+	tgorm "takeoutfm.dev/takeout/lib/gorm"
 	"takeoutfm.dev/takeout/lib/str"
 	"takeoutfm.dev/takeout/model"
 )
@@ -230,7 +231,13 @@ func TestMovie(t *testing.T) {
 		t.Error("expect updated title")
 	}
 
-	f.deleteMovie(100)
+	f.deleteMovieKey("other key")
+	_, err = f.LookupMovie(int(m.ID))
+	if err != nil {
+		t.Error("expect movie with other key to remain")
+	}
+
+	f.deleteMovieKey(m.Key)
 
 	_, err = f.LookupMovie(int(m.ID))
 	if err == nil {
@@ -271,3 +278,81 @@ func TestPerson(t *testing.T) {
 		t.Error("expect to find person by peid")
 	}
 }
+
+func TestUnmatchedMovie(t *testing.T) {
+	f := makeFilm(t)
+
+	u := model.UnmatchedMovie{
+		Key:    "/movies/test movie.mkv",
+		Title:  "test movie",
+		Reason: ReasonInvalidName,
+	}
+	err := f.saveUnmatchedMovie(&u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.ID == 0 {
+		t.Error("expect ID")
+	}
+
+	u, err = f.LookupUnmatchedMovie(int(u.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Reason != ReasonInvalidName {
+		t.Error("expect reason")
+	}
+
+	match := model.MovieMatch{Key: u.Key, TMID: 603}
+	err = f.saveMovieMatch(&match)
+	if err != nil {
+		t.Fatal(err)
+	}
+	match, err = f.movieMatch(u.Key)
+	if err != nil || match.TMID != 603 {
+		t.Error("expect movie match")
+	}
+
+	f.deleteUnmatchedMovie(u.Key)
+	_, err = f.LookupUnmatchedMovie(int(u.ID))
+	if err == nil {
+		t.Error("expect unmatched movie deleted")
+	}
+}
+
+func TestGenrePage(t *testing.T) {
+	f := makeFilm(t)
+
+	for i, title := range []string{"page one", "page two", "page three"} {
+		tmid := int64(900 + i)
+		m := model.Movie{TMID: tmid, Title: title, SortTitle: title, Key: title,
+			Date: time.Date(1990+i, 1, 1, 0, 0, 0, 0, time.UTC)}
+		if err := f.createMovie(&m); err != nil {
+			t.Fatal(err)
+		}
+		g := model.Genre{TMID: tmid, Name: "page genre"}
+		if err := f.createGenre(&g); err != nil {
+			t.Fatal(err)
+		}
+		defer f.deleteGenres(int(tmid))
+		defer f.deleteMovie(int(tmid))
+	}
+
+	movies, total, err := f.GenrePage("page genre", tgorm.Page{
+		Order: "date desc", Offset: 1, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 3 || len(movies) != 1 || movies[0].Title != "page two" {
+		t.Error("expect second page", total, movies)
+	}
+
+	movies, total, err = f.GenrePage("page genre", tgorm.Page{
+		Where: []tgorm.Cond{{Query: "date < ?", Args: []interface{}{time.Date(1991, 6, 1, 0, 0, 0, 0, time.UTC)}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2 || len(movies) != 2 || movies[0].Title != "page one" {
+		t.Error("expect filtered movies", total, movies)
+	}
+}
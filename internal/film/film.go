@@ -83,35 +83,86 @@ func (f *Film) FindMovies(identifiers []string) []Movie {
 	return f.lookupIMIDs(identifiers)
 }
 
-func (f *Film) newSearch() (search.Searcher, error) {
-	keywords := []string{
+// searchFacets are the facets counted for movie search requests.
+var searchFacets = []search.Facet{
+	{Name: FieldGenre, Field: FieldGenre},
+	{Name: search.FacetDecade, Field: FieldDate, Decades: true},
+	{Name: FieldRating, Field: FieldRating},
+}
+
+// searchMapping has the fields indexed as keywords and suggest fields.
+var searchMapping = search.Mapping{
+	Keywords: []string{
 		FieldGenre,
 		FieldKeyword,
-	}
+		FieldRating,
+	},
+	Suggest: []string{
+		FieldCast,
+		FieldCrew,
+		FieldTitle,
+	},
+}
+
+func (f *Film) newSearch() (search.Searcher, error) {
 	s := f.config.NewSearcher()
-	err := s.Open(f.config.Film.SearchIndexName, keywords)
+	err := s.Open(f.config.Film.SearchIndexName, searchMapping)
 	if err != nil {
 		return nil, err
 	}
 	return s, nil
 }
 
-func (f *Film) Search(q string, limit ...int) []Movie {
+// Suggest returns movies and cast or crew with names matching the prefix,
+// most common first.
+func (f *Film) Suggest(prefix string) (movies []Movie, people []Person) {
 	s, err := f.newSearch()
 	if err != nil {
-		return []Movie{}
+		return
 	}
 	defer s.Close()
+	names, _ := s.Suggest(prefix, f.config.Film.SuggestMovieLimit, FieldTitle)
+	movies = search.Named(names, f.moviesTitled(names),
+		func(m Movie) string { return m.Title })
+	names, _ = s.Suggest(prefix, f.config.Film.SuggestPersonLimit, FieldCast, FieldCrew)
+	people = search.Named(names, f.peopleNamed(names),
+		func(p Person) string { return p.Name })
+	return
+}
 
+func (f *Film) Search(q string, limit ...int) []Movie {
 	l := f.config.Film.SearchLimit
 	if len(limit) == 1 {
 		l = limit[0]
 	}
+	movies, _, _ := f.search(search.Request{Query: q, Limit: l, Advanced: true})
+	return movies
+}
+
+// SearchRequest searches movies with the request filters, sort and paging
+// and returns the movies in result order, the score for each movie and the
+// result with counts for the genre, decade and rating facets.
+// The limit is at most the configured search limit.
+func (f *Film) SearchRequest(r search.Request) ([]Movie, []float64, search.Result) {
+	if r.Limit == 0 || r.Limit > f.config.Film.SearchLimit {
+		r.Limit = f.config.Film.SearchLimit
+	}
+	r.Facets = searchFacets
+	return f.search(r)
+}
 
-	keys, err := s.Search(q, l)
+func (f *Film) search(r search.Request) ([]Movie, []float64, search.Result) {
+	s, err := f.newSearch()
 	if err != nil {
-		return nil
+		return []Movie{}, nil, search.Result{}
 	}
+	defer s.Close()
+
+	result, err := s.SearchRequest(r)
+	if err != nil {
+		return nil, nil, result
+	}
+	keys := result.Keys()
 
 	// split potentially large # of result keys into chunks to query
 	chunkSize := 100
@@ -125,7 +176,10 @@ func (f *Film) Search(q string, limit ...int) []Movie {
 		movies = append(movies, f.moviesFor(chunk)...)
 	}
 
-	return movies
+	movies, scores := search.Ordered(result.Hits, movies, func(m Movie) string {
+		return m.Key
+	})
+	return movies, scores, result
 }
 
 func (f *Film) MovieURL(m Movie) *url.URL {
@@ -0,0 +1,86 @@
+// Copyright 2024 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package film
+
+import (
+	"errors"
+
+	"takeoutfm.dev/takeout/lib/search"
+	. "takeoutfm.dev/takeout/model"
+)
+
+var (
+	ErrInvalidOverride = errors.New("invalid override")
+)
+
+// MovieOverrides returns all saved TMDB ids for bucket keys, including those
+// chosen from unmatched movies.
+func (f *Film) MovieOverrides() []MovieMatch {
+	return f.movieMatches()
+}
+
+// SaveMovieOverride pins the bucket key to the TMDB id. An existing movie or
+// unmatched movie with the key is synced again using the TMDB id.
+func (f *Film) SaveMovieOverride(match *MovieMatch) error {
+	if match.Key == "" || match.TMID == 0 {
+		return ErrInvalidOverride
+	}
+
+	if u, err := f.unmatchedMovieForKey(match.Key); err == nil {
+		return f.MatchMovie(u, int(match.TMID))
+	}
+
+	err := f.saveMovieMatch(match)
+	if err != nil {
+		return err
+	}
+
+	m, err := f.movieForKey(match.Key)
+	if err != nil || m.TMID == match.TMID {
+		// nothing synced yet or already the same movie
+		return nil
+	}
+
+	s, err := f.newSearch()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	tmid := int(m.TMID)
+	f.deleteMovieKey(m.Key)
+	f.deleteCast(tmid)
+	f.deleteCollections(tmid)
+	f.deleteCrew(tmid)
+	f.deleteGenres(tmid)
+	f.deleteKeywords(tmid)
+	f.deleteTrailers(tmid)
+
+	fields, err := f.syncMovie(f.tmdb, int(match.TMID), m.Key, m.Size, m.ETag, m.LastModified)
+	if err != nil {
+		return err
+	}
+	s.Index(search.IndexMap{m.Key: fields})
+	return nil
+}
+
+// DeleteMovieOverride removes the saved TMDB id. The movie remains as-is
+// until the next sync.
+func (f *Film) DeleteMovieOverride(id int) error {
+	return f.deleteMovieMatch(id)
+}
@@ -65,6 +65,28 @@ func (f *Film) Sync() error {
 	return f.SyncSince(time.Time{})
 }
 
+// Reindex rebuilds the search index when it was created with an older
+// mapping version. The index is migrated from its stored fields, or all
+// movies are synced again to index them when that fails.
+func (f *Film) Reindex() error {
+	name := f.config.Film.SearchIndexName
+	outdated, err := search.Outdated(f.config.Search, name)
+	if err != nil || !outdated {
+		return err
+	}
+	log.Printf("reindex %s\n", name)
+	err = search.Migrate(f.config.Search, name, searchMapping)
+	if err == nil {
+		return nil
+	}
+	log.Printf("migrate %s: %s\n", name, err)
+	err = search.Remove(f.config.Search, name)
+	if err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
 func (f *Film) SyncSince(lastSync time.Time) error {
 	for _, bucket := range f.buckets {
 		err := f.syncBucket(bucket, lastSync)
@@ -75,12 +97,39 @@ func (f *Film) SyncSince(lastSync time.Time) error {
 	return nil
 }
 
+// SyncDirs syncs all movies within the directories of local buckets,
+// regardless of modified time. Unmatched movies elsewhere are kept.
+func (f *Film) SyncDirs(dirs []string) error {
+	for _, b := range f.buckets {
+		l, ok := b.(bucket.DirLister)
+		if !ok {
+			continue
+		}
+		for _, dir := range dirs {
+			objectCh, err := l.ListDir(dir)
+			if err == bucket.ErrNotInBucket {
+				continue
+			} else if err != nil {
+				return err
+			}
+			err = f.syncObjects(objectCh)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 var (
 	fuzzyNameRegexp = regexp.MustCompile(`[^a-zA-Z0-9]`)
 
 	// Movies/Thriller/Zero Dark Thirty (2012).mkv
 	// Movies/Thriller/Zero Dark Thirty (2012) - HD.mkv
 	movieRegexp = regexp.MustCompile(`.*/(.+?)\s*\(([\d]+)\)(\s-\s(.+))?\.(mkv|mp4)$`)
+
+	// Movies/Thriller/Zero Dark Thirty.mkv
+	videoRegexp = regexp.MustCompile(`.*/(.+?)\.(mkv|mp4)$`)
 )
 
 func (f *Film) syncBucket(bucket bucket.Bucket, lastSync time.Time) error {
@@ -88,7 +137,14 @@ func (f *Film) syncBucket(bucket bucket.Bucket, lastSync time.Time) error {
 	if err != nil {
 		return err
 	}
+	if lastSync.IsZero() {
+		// full sync will find all unmatched movies again
+		f.deleteUnmatchedMovies()
+	}
+	return f.syncObjects(objectCh)
+}
 
+func (f *Film) syncObjects(objectCh chan *bucket.Object) error {
 	client := tmdb.NewTMDB(f.config.TMDB.Config, f.config.NewGetter())
 
 	s, err := f.newSearch()
@@ -109,6 +165,10 @@ func (f *Film) syncBucket(bucket bucket.Bucket, lastSync time.Time) error {
 			}
 			continue
 		}
+		matches = videoRegexp.FindStringSubmatch(o.Path)
+		if matches != nil {
+			f.unmatchedMovie(o, matches[1], "", ReasonInvalidName)
+		}
 	}
 	return nil
 }
@@ -118,12 +178,31 @@ func fuzzyName(name string) string {
 }
 
 func (f *Film) doMovie(o *bucket.Object, client *tmdb.TMDB, s search.Searcher, title, year string) error {
+	index := make(search.IndexMap)
+
+	if match, err := f.movieMatch(o.Key); err == nil {
+		// manually matched movie takes precedence
+		fields, err := f.syncMovie(client, int(match.TMID),
+			o.Key, o.Size, o.ETag, o.LastModified)
+		if err != nil {
+			f.unmatchedMovie(o, title, year, err.Error())
+			return err
+		}
+		index[o.Key] = fields
+		s.Index(index)
+		f.deleteUnmatchedMovie(o.Key)
+		return nil
+	}
+
 	results, err := client.MovieSearch(title)
 	if err != nil {
 		return err
 	}
 
-	index := make(search.IndexMap)
+	reason := ReasonNoResults
+	if len(results) > 0 {
+		reason = ReasonNoMatch
+	}
 
 	for _, r := range results {
 		//fmt.Printf("result %s %s\n", r.Title, r.ReleaseDate)
@@ -136,15 +215,23 @@ func (f *Film) doMovie(o *bucket.Object, client *tmdb.TMDB, s search.Searcher, t
 				if err != ErrDuplicateFound {
 					log.Println(err)
 				}
+				reason = err.Error()
 				continue
 			}
 			index[o.Key] = fields
+			reason = ""
 			break
 		}
 	}
 
 	s.Index(index)
 
+	if reason != "" {
+		f.unmatchedMovie(o, title, year, reason)
+	} else {
+		f.deleteUnmatchedMovie(o.Key)
+	}
+
 	return nil
 }
 
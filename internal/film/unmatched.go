@@ -0,0 +1,79 @@
+// Copyright 2024 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package film
+
+import (
+	"takeoutfm.dev/takeout/lib/bucket"
+	"takeoutfm.dev/takeout/lib/log"
+	"takeoutfm.dev/takeout/lib/search"
+	"takeoutfm.dev/takeout/lib/tmdb"
+	. "takeoutfm.dev/takeout/model"
+)
+
+const (
+	ReasonInvalidName = "name doesn't match 'Title (Year).mkv'"
+	ReasonNoResults   = "no search results"
+	ReasonNoMatch     = "no result with the same title and year"
+)
+
+func (f *Film) unmatchedMovie(o *bucket.Object, title, year, reason string) {
+	log.Printf("unmatched %s: %s\n", o.Key, reason)
+	u := UnmatchedMovie{
+		Key:          o.Key,
+		Title:        title,
+		Year:         year,
+		Reason:       reason,
+		Size:         o.Size,
+		ETag:         o.ETag,
+		LastModified: o.LastModified,
+	}
+	err := f.saveUnmatchedMovie(&u)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// MovieCandidates searches TMDB for movies that may match the unmatched
+// movie.
+func (f *Film) MovieCandidates(u UnmatchedMovie) ([]tmdb.MovieResult, error) {
+	return f.tmdb.MovieSearch(u.Title)
+}
+
+// MatchMovie syncs the unmatched movie using the TMDB id. The choice is saved
+// and used by future syncs.
+func (f *Film) MatchMovie(u UnmatchedMovie, tmid int) error {
+	match := MovieMatch{Key: u.Key, TMID: int64(tmid)}
+	err := f.saveMovieMatch(&match)
+	if err != nil {
+		return err
+	}
+
+	s, err := f.newSearch()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	fields, err := f.syncMovie(f.tmdb, tmid, u.Key, u.Size, u.ETag, u.LastModified)
+	if err != nil {
+		return err
+	}
+	s.Index(search.IndexMap{u.Key: fields})
+	f.deleteUnmatchedMovie(u.Key)
+	return nil
+}
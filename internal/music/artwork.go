@@ -0,0 +1,282 @@
+// Copyright 2023 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package music
+
+import (
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dhowden/tag"
+	"takeoutfm.dev/takeout/lib/bucket"
+	"takeoutfm.dev/takeout/lib/log"
+	. "takeoutfm.dev/takeout/model"
+)
+
+// coverName returns the lower case name of a cover image file, or an empty
+// string if the file isn't a cover image. Names sort in order of preference:
+// cover < folder < front.
+func coverName(path string) string {
+	matches := coverRegexp.FindStringSubmatch(filepath.Base(path))
+	if matches == nil {
+		return ""
+	}
+	return strings.ToLower(matches[1])
+}
+
+// coverFile returns the preferred artwork image file in the directory.
+func coverFile(dir string) string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	var file, name string
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		n := coverName(e.Name())
+		if n == "" {
+			continue
+		}
+		if name == "" || n < name {
+			file, name = e.Name(), n
+		}
+	}
+	if file == "" {
+		return ""
+	}
+	return filepath.Join(dir, file)
+}
+
+// EmbeddedPicture returns the picture embedded in the audio file.
+func EmbeddedPicture(path string) (*tag.Picture, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	m, err := tag.ReadFrom(file)
+	if err != nil {
+		return nil, err
+	}
+	pic := m.Picture()
+	if pic == nil || len(pic.Data) == 0 {
+		return nil, ErrArtworkNotFound
+	}
+	return pic, nil
+}
+
+// localArtwork finds artwork for the tracks in a directory, preferring an
+// image file over pictures embedded in the tracks.
+func localArtwork(dir string, tracks []Track) (LocalArtwork, bool) {
+	if path := coverFile(dir); path != "" {
+		return LocalArtwork{Dir: dir, Path: path}, true
+	}
+	for _, t := range tracks {
+		if _, err := EmbeddedPicture(t.Key); err == nil {
+			return LocalArtwork{Dir: dir, Path: t.Key, Embedded: true}, true
+		}
+	}
+	return LocalArtwork{}, false
+}
+
+func (m *Music) syncLocalArtwork() error {
+	return m.syncLocalArtworkFor(m.allTracks())
+}
+
+// syncLocalArtworkFor records local artwork for the track directories and
+// assigns it to the tracks and their releases. Local artwork is only used
+// instead of Cover Art Archive images when preferred or when there are no
+// remote images.
+func (m *Music) syncLocalArtworkFor(tracks []Track) error {
+	type bucketDir struct {
+		bucket int
+		dir    string
+	}
+	dirs := make(map[bucketDir][]Track)
+	for _, t := range tracks {
+		if t.Bucket < len(m.buckets) {
+			d := bucketDir{t.Bucket, filepath.Dir(t.Key)}
+			dirs[d] = append(dirs[d], t)
+		}
+	}
+
+	prefer := m.config.Music.PreferLocalArtwork
+	releases := make(map[string]string)
+	for d, list := range dirs {
+		art, ok, err := m.dirArtwork(d.bucket, d.dir, list)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		log.Printf("local artwork %s\n", art.Path)
+		for _, t := range list {
+			path := art.Path
+			if !prefer && t.Artwork {
+				path = ""
+			}
+			err = m.updateTrackLocalArtwork(t, path)
+			if err != nil {
+				return err
+			}
+			if t.REID != "" {
+				releases[t.REID] = art.Path
+			}
+		}
+	}
+
+	for reid, path := range releases {
+		r, err := m.LookupREID(reid)
+		if err != nil {
+			continue
+		}
+		if !prefer && r.Artwork {
+			path = ""
+		}
+		err = m.updateReleaseLocalArtwork(r, path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dirArtwork finds artwork for the tracks in a bucket directory. Cover
+// images found while listing the bucket are used first, otherwise local
+// directories are checked for image files and embedded pictures.
+func (m *Music) dirArtwork(b int, dir string, tracks []Track) (LocalArtwork, bool, error) {
+	art, err := m.localArtworkForDir(dir)
+	if err == nil && !art.Embedded {
+		return art, true, nil
+	}
+	if !m.buckets[b].IsLocal() {
+		return LocalArtwork{}, false, nil
+	}
+	art, ok := localArtwork(dir, tracks)
+	if !ok {
+		return art, false, nil
+	}
+	art.Bucket = b
+	err = m.updateLocalArtwork(&art)
+	return art, err == nil, err
+}
+
+// updateCovers records cover images listed in the bucket as the artwork for
+// their directory, replacing embedded pictures and less preferred covers.
+func (m *Music) updateCovers(b int, covers []*bucket.Object) error {
+	for _, o := range covers {
+		dir := filepath.Dir(o.Key)
+		existing, err := m.localArtworkForDir(dir)
+		if err == nil && !existing.Embedded && coverName(existing.Path) <= coverName(o.Key) {
+			continue
+		}
+		art := LocalArtwork{Dir: dir, Path: o.Key, Bucket: b}
+		err = m.updateLocalArtwork(&art)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Music) deleteLocalArtwork() {
+	m.db.Exec("delete from local_artworks")
+}
+
+func (m *Music) updateLocalArtwork(art *LocalArtwork) error {
+	art.Key = LocalArtworkKey(art.Path)
+	err := m.db.Unscoped().Where("dir = ?", art.Dir).Delete(&LocalArtwork{}).Error
+	if err != nil {
+		return err
+	}
+	return m.db.Create(art).Error
+}
+
+func (m *Music) localArtworkForDir(dir string) (LocalArtwork, error) {
+	var art LocalArtwork
+	err := m.db.Where("dir = ?", dir).First(&art).Error
+	if err != nil {
+		return LocalArtwork{}, ErrArtworkNotFound
+	}
+	return art, nil
+}
+
+// localArtworkSince returns artwork recorded after the time.
+func (m *Music) localArtworkSince(t time.Time) []LocalArtwork {
+	var list []LocalArtwork
+	m.db.Where("created_at > ?", t).Find(&list)
+	return list
+}
+
+// LocalArtwork returns the local artwork with the key.
+func (m *Music) LocalArtwork(key string) (LocalArtwork, error) {
+	var art LocalArtwork
+	err := m.db.Where("key = ?", key).First(&art).Error
+	if err != nil {
+		return LocalArtwork{}, ErrArtworkNotFound
+	}
+	return art, nil
+}
+
+func (m *Music) updateTrackLocalArtwork(t Track, path string) error {
+	return m.db.Model(&t).Update("local_artwork", path).Error
+}
+
+func (m *Music) updateReleaseLocalArtwork(r Release, path string) error {
+	return m.db.Model(&r).Update("local_artwork", path).Error
+}
+
+// LocalArtworkImage returns the image data and content type of the local
+// artwork with the key. Image files are read from the bucket and embedded
+// pictures from the track.
+func (m *Music) LocalArtworkImage(key string) ([]byte, string, error) {
+	art, err := m.LocalArtwork(key)
+	if err != nil {
+		return nil, "", err
+	}
+	if art.Embedded {
+		pic, err := EmbeddedPicture(art.Path)
+		if err != nil {
+			return nil, "", err
+		}
+		return pic.Data, pic.MIMEType, nil
+	}
+	if art.Bucket >= len(m.buckets) {
+		return nil, "", ErrArtworkNotFound
+	}
+	r, ok := m.buckets[art.Bucket].(bucket.Reader)
+	if !ok {
+		return nil, "", ErrArtworkNotFound
+	}
+	rc, err := r.Open(art.Path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, mime.TypeByExtension(filepath.Ext(art.Path)), nil
+}
@@ -0,0 +1,158 @@
+// Copyright 2023 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package music
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"takeoutfm.dev/takeout/lib/bucket"
+	"takeoutfm.dev/takeout/model"
+)
+
+func TestCoverFile(t *testing.T) {
+	dir := t.TempDir()
+	if coverFile(dir) != "" {
+		t.Error("expect no cover")
+	}
+	for _, name := range []string{"01-Airlane.flac", "Front.PNG", "folder.jpg", "back.jpg"} {
+		err := os.WriteFile(filepath.Join(dir, name), []byte{}, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if coverFile(dir) != filepath.Join(dir, "folder.jpg") {
+		t.Error("expect folder.jpg", coverFile(dir))
+	}
+	err := os.WriteFile(filepath.Join(dir, "cover.jpeg"), []byte{}, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if coverFile(dir) != filepath.Join(dir, "cover.jpeg") {
+		t.Error("expect cover.jpeg", coverFile(dir))
+	}
+
+	art, ok := localArtwork(dir, nil)
+	if !ok || art.Embedded || art.Dir != dir {
+		t.Error("expect local artwork", art)
+	}
+}
+
+func TestLocalCover(t *testing.T) {
+	r := model.Release{LocalArtwork: "/music/Gary Numan/The Pleasure Principle/cover.jpg",
+		Artwork: true, FrontArtwork: true, REID: "test-reid"}
+	if Cover(r, "250") != "/img/local/"+LocalArtworkKey(r.LocalArtwork)+"?w=250" {
+		t.Error("expect local cover", Cover(r, "250"))
+	}
+	r.LocalArtwork = ""
+	if Cover(r, "250") != "/img/mb/re/test-reid/front" {
+		t.Error("expect remote cover", Cover(r, "250"))
+	}
+}
+
+func TestLocalArtwork(t *testing.T) {
+	m := makeMusic(t)
+	art := model.LocalArtwork{Dir: "/music/test", Path: "/music/test/01-test.flac", Embedded: true}
+	err := m.updateLocalArtwork(&art)
+	if err != nil {
+		t.Fatal(err)
+	}
+	art.Path = "/music/test/cover.jpg"
+	art.Embedded = false
+	art.ID = 0
+	err = m.updateLocalArtwork(&art)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.LocalArtwork(LocalArtworkKey("/music/test/01-test.flac"))
+	if err != ErrArtworkNotFound {
+		t.Error("expect replaced artwork")
+	}
+	found, err := m.LocalArtwork(LocalArtworkKey("/music/test/cover.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Dir != "/music/test" || found.Embedded {
+		t.Error("expect artwork", found)
+	}
+}
+
+func TestUpdateCovers(t *testing.T) {
+	m := makeMusic(t)
+	root := t.TempDir()
+	b, err := bucket.Open(bucket.Config{FS: bucket.FSConfig{Root: root}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.buckets = []bucket.Bucket{b}
+
+	// existing track without artwork
+	dir := filepath.Join(root, "Gary Numan", "Telekon (1980)")
+	track := model.Track{Artist: "Gary Numan", Release: "Telekon", Title: "This Wreckage",
+		Key: filepath.Join(dir, "01-This Wreckage.flac")}
+	err = m.createTrack(&track)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// cover added later and found by an incremental listing
+	start := time.Now().Add(-time.Second)
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	covers := []*bucket.Object{{Key: filepath.Join(dir, "front.jpg")}, {Key: filepath.Join(dir, "cover.jpg")}}
+	for _, o := range covers {
+		err = os.WriteFile(o.Key, []byte("image"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = m.updateCovers(0, covers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var art model.LocalArtwork
+	for _, v := range m.localArtworkSince(start) {
+		if v.Dir == dir {
+			art = v
+		}
+	}
+	if art.Path != covers[1].Key {
+		t.Fatal("expect preferred cover", art)
+	}
+
+	err = m.syncLocalArtworkFor(m.folderTracks(art.Dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracks := m.folderTracks(dir)
+	if len(tracks) != 1 || tracks[0].LocalArtwork != covers[1].Key {
+		t.Error("expect track artwork", tracks)
+	}
+
+	img, contentType, err := m.LocalArtworkImage(art.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(img) != "image" || contentType != "image/jpeg" {
+		t.Error("expect image", string(img), contentType)
+	}
+}
@@ -31,8 +31,10 @@ import (
 	. "takeoutfm.dev/takeout/model"
 )
 
-// Asynchronously obtain all tracks from the bucket.
-func (m *Music) syncFromBucket(bucket bucket.Bucket, lastSync time.Time) (trackCh chan *Track, err error) {
+// Asynchronously obtain all tracks from the bucket. Cover images are added to
+// covers, which is complete once the track channel is closed.
+func (m *Music) syncFromBucket(bucket bucket.Bucket, lastSync time.Time,
+	covers *[]*bucket.Object) (trackCh chan *Track, err error) {
 	trackCh = make(chan *Track)
 
 	go func() {
@@ -42,14 +44,34 @@ func (m *Music) syncFromBucket(bucket bucket.Bucket, lastSync time.Time) (trackC
 			return
 		}
 		for o := range objectCh {
-			m.checkObject(bucket, o, trackCh)
+			m.checkObject(bucket, o, trackCh, covers)
 		}
 	}()
 
 	return
 }
 
-func (m *Music) checkObject(b bucket.Bucket, object *bucket.Object, trackCh chan *Track) {
+// checkObjects sends a track for each of the listed objects. Cover images
+// are added to covers, which is complete once the track channel is closed.
+func (m *Music) checkObjects(b bucket.Bucket, objectCh chan *bucket.Object,
+	covers *[]*bucket.Object) chan *Track {
+	trackCh := make(chan *Track)
+	go func() {
+		defer close(trackCh)
+		for o := range objectCh {
+			m.checkObject(b, o, trackCh, covers)
+		}
+	}()
+	return trackCh
+}
+
+func (m *Music) checkObject(b bucket.Bucket, object *bucket.Object, trackCh chan *Track,
+	covers *[]*bucket.Object) {
+	if coverName(object.Key) != "" {
+		*covers = append(*covers, object)
+		return
+	}
+
 	t := &Track{
 		Key:          object.Key,
 		ETag:         object.ETag,
@@ -72,12 +94,13 @@ func (m *Music) checkObject(b bucket.Bucket, object *bucket.Object, trackCh chan
 	})
 }
 
+// Local artwork file names in order of preference.
+var coverRegexp = regexp.MustCompile(`(?i)^(cover|folder|front)\.(png|jpe?g)$`)
+
 // Examples:
 // The Raconteurs / Help Us Stranger (2019) / 01-Bored and Razed.flac
 // Tubeway Army / Replicas - The First Recordings (2019) / 1-01-You Are in My Vision (early version).flac
 // Tubeway Army / Replicas - The First Recordings (2019) / 2-01-Replicas (early version 2).flac
-var coverRegexp = regexp.MustCompile(`cover\.(png|jpg)$`)
-
 var pathRegexp = regexp.MustCompile(`([^\/]+)\/([^\/]+)\/([^\/]+)$`)
 
 func (m *Music) matchPath(b bucket.Bucket, path string, t *Track, trackCh chan *Track,
@@ -229,7 +252,9 @@ func matchTrack(file string, t *Track) []Track {
 
 // Generate a presigned url which expires based on config settings.
 func (m *Music) bucketURL(t Track) *url.URL {
-	// TODO FIXME assume first bucket!!!
+	if t.Bucket > 0 && t.Bucket < len(m.buckets) {
+		return m.buckets[t.Bucket].ObjectURL(t.Key)
+	}
 	return m.buckets[0].ObjectURL(t.Key)
 }
 
@@ -257,6 +282,12 @@ func parseMetadata(u *url.URL, t *Track) error {
 	t.TrackNum, t.TrackCount = m.Track()
 	t.DiscNum, t.DiscCount = m.Disc()
 
+	// prefer sidecar lyrics which are more likely to be synced
+	t.Lyrics = sidecarLyrics(path)
+	if t.Lyrics == "" {
+		t.Lyrics = trim(m.Lyrics())
+	}
+
 	info := mbz.Extract(m)
 	t.RID = trim(info.Get(mbz.Recording))
 	t.RGID = trim(info.Get(mbz.ReleaseGroup))
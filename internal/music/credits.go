@@ -34,6 +34,7 @@ const (
 	FieldGenre       = "genre"
 	FieldLabel       = "label"
 	FieldLength      = "length"
+	FieldLyrics      = "lyrics"
 	FieldMedia       = "media"
 	FieldMediaTitle  = "media_title"
 	FieldPopularity  = "popularity"
@@ -69,6 +70,7 @@ type trackIndex struct {
 	Title    string
 	Artist   string
 	RID      string
+	Length   int // seconds
 	// these are the indexed fields to store in the search db
 	Fields   search.FieldMap
 }
@@ -169,6 +171,7 @@ func (m *Music) creditsIndex(reid string) ([]trackIndex, error) {
 				Title:    fixName(t.Recording.Title),
 				Artist:   fixName(t.Artist()),
 				RID:      t.Recording.ID,
+				Length:   t.Recording.Length / 1000,
 				Fields:   trackFields,
 			}
 			// fmt.Printf("%d/%d/%s/%s\n", index.DiscNum, index.TrackNum, index.Title, index.RID)
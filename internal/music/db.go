@@ -21,12 +21,14 @@ import (
 	"errors"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"gorm.io/driver/mysql"
 	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"takeoutfm.dev/takeout/internal/auth"
+	tgorm "takeoutfm.dev/takeout/lib/gorm"
 	. "takeoutfm.dev/takeout/model"
 )
 
@@ -36,6 +38,9 @@ var (
 	ErrReleaseNotFound  = errors.New("release not found")
 	ErrPlaylistNotFound = errors.New("playlist not found")
 	ErrStationNotFound  = errors.New("station not found")
+	ErrSmartNotFound    = errors.New("smart playlist not found")
+	ErrLyricsNotFound   = errors.New("lyrics not found")
+	ErrArtworkNotFound  = errors.New("artwork not found")
 )
 
 func (m *Music) openDB() (err error) {
@@ -57,8 +62,15 @@ func (m *Music) openDB() (err error) {
 		return
 	}
 
+	// buckets weren't saved for tracks synced by older versions
+	unknownBuckets := m.db.Migrator().HasTable(&Track{}) &&
+		!m.db.Migrator().HasColumn(&Track{}, "Bucket")
 	m.db.AutoMigrate(&Artist{}, &ArtistBackground{}, &ArtistImage{}, &ArtistTag{}, &Media{}, &Playlist{},
-		&Popular{}, &Similar{}, &Station{}, &Release{}, &Track{})
+		&Popular{}, &Similar{}, &Station{}, &Release{}, &ReleaseOverride{}, &Track{},
+		&TrackOverride{}, &SmartPlaylist{}, &Lyrics{}, &LocalArtwork{})
+	if unknownBuckets {
+		m.db.Model(&Track{}).Where("1 = 1").Update("bucket", -1)
+	}
 	return
 }
 
@@ -82,6 +94,7 @@ func (m *Music) lastModified() time.Time {
 
 func (m *Music) deleteTracks() {
 	m.db.Exec("delete from tracks")
+	m.deleteLyrics()
 }
 
 func (m *Music) createTrack(track *Track) error {
@@ -108,6 +121,13 @@ func (m *Music) ArtistLike(artist string) (Artist, error) {
 	return a, nil
 }
 
+// artistsNamed returns the artists with one of the names.
+func (m *Music) artistsNamed(names []string) []Artist {
+	var artists []Artist
+	m.db.Where("name in (?)", names).Find(&artists)
+	return artists
+}
+
 func (m *Music) ArtistsForARIDs(arids []string) []Artist {
 	var artists []Artist
 	m.db.Where("ar_id in (?)", arids).Find(&artists)
@@ -331,6 +351,20 @@ func (m *Music) updateTrackRID(t Track, rid string) (err error) {
 	return
 }
 
+func (m *Music) updateTrackLength(t Track, length int) (err error) {
+	err = m.db.Model(t).Update("length", length).Error
+	return
+}
+
+// trackLengthReleases returns the releases with tracks that don't have a
+// recording length.
+func (m *Music) trackLengthReleases() []string {
+	var reids []string
+	m.db.Model(&Track{}).Where("length = 0 and re_id != ''").
+		Distinct().Pluck("re_id", &reids)
+	return reids
+}
+
 // Part of the sync process to find releases that match the track. The
 // preferred release will be the first one so dates corresponding to
 // original release dates.
@@ -505,7 +539,7 @@ func (m *Music) ArtistSingleTracks(a Artist, limit ...int) []Track {
 	// 'Iron Maiden' and title in (select distinct single_name from
 	// releases where artist = 'Iron Maiden' and type = 'Single') group by
 	// title having min(date) order by date"
-	m.db.Where("artist = ? and title in"+
+	m.db.Where("artist = ? and alternate = 0 and title in"+
 		" (select distinct single_name from releases where artist = ? and type = 'Single')",
 		a.Name, a.Name).
 		Group("title").
@@ -531,7 +565,7 @@ func (m *Music) ArtistPopularTracks(a Artist, limit ...int) []Track {
 	// group by tracks.title
 	// order by popular.rank;
 
-	m.db.Where("b.title is null and tracks.artist = ?", a.Name).
+	m.db.Where("b.title is null and tracks.artist = ? and tracks.alternate = 0", a.Name).
 		Joins("left outer join tracks b on tracks.title = b.title and tracks.date > b.date").
 		Joins("inner join popular on tracks.artist = popular.artist and tracks.title = popular.title").
 		Group("tracks.title").
@@ -549,7 +583,7 @@ func (m *Music) artistDeepTracks(a Artist, limit ...int) []Track {
 	}
 	popularTracks := "select popular.title from popular where tracks.artist = popular.artist"
 	singleTracks := "select releases.single_name from releases where tracks.artist = releases.artist and releases.type = 'Single'"
-	m.db.Where("tracks.artist = ?"+
+	m.db.Where("tracks.artist = ? and tracks.alternate = 0"+
 		" and tracks.title not in ("+popularTracks+")"+
 		" and tracks.title not in ("+singleTracks+")",
 		a.Name).
@@ -565,7 +599,7 @@ func (m *Music) ArtistTracks(a Artist) []Track {
 
 func (m *Music) artistTracks(artist string) []Track {
 	var tracks []Track
-	m.db.Where("artist = ?", artist).
+	m.db.Where("artist = ? and alternate = 0", artist).
 		Order("release, date, disc_num, track_num").
 		Find(&tracks)
 	return tracks
@@ -578,6 +612,16 @@ func (m *Music) Artists() []Artist {
 	return artists
 }
 
+// ArtistsPage returns a page of artists and the total matching artists.
+func (m *Music) ArtistsPage(page tgorm.Page) ([]Artist, int, error) {
+	if page.Order == "" {
+		page.Order = "sort_name asc"
+	}
+	var artists []Artist
+	total, err := page.Find(m.db, &artists)
+	return artists, total, err
+}
+
 // All artists with corresponding MusicBrainz artist IDs.
 func (m *Music) artistsByMBID(arids []string) []Artist {
 	var artists []Artist
@@ -595,6 +639,25 @@ func (m *Music) similarArtistsByTags(a *Artist) []Artist {
 	return artists
 }
 
+// Artists sharing tags with the artist, most shared tags first.
+func (m *Music) TaggedArtists(a Artist, limit ...int) []Artist {
+	l := m.config.Music.SimilarArtistsLimit
+	if len(limit) == 1 {
+		l = limit[0]
+	}
+	var artists []Artist
+	for _, t := range m.similarArtistsByTags(&a) {
+		if t.Name == a.Name {
+			continue
+		}
+		artists = append(artists, t)
+		if len(artists) == l {
+			break
+		}
+	}
+	return artists
+}
+
 // Similar artists based on similarity rank from Last.fm.
 func (m *Music) SimilarArtists(a Artist, limit ...int) []Artist {
 	var artists []Artist
@@ -734,6 +797,14 @@ func (m *Music) release(reid string) (Release, error) {
 // Obtain all the tracks for this release, ordered by disc and track
 // number.
 func (m *Music) ReleaseTracks(release Release) []Track {
+	var tracks []Track
+	m.db.Where("re_id = ? and alternate = 0", release.REID).
+		Order("disc_num, track_num").Find(&tracks)
+	return tracks
+}
+
+// Obtain all the tracks for this release including alternate copies.
+func (m *Music) releaseTracks(release Release) []Track {
 	var tracks []Track
 	m.db.Where("re_id = ?", release.REID).Order("disc_num, track_num").Find(&tracks)
 	return tracks
@@ -747,7 +818,7 @@ func (m *Music) releaseMedia(release Release) []Media {
 
 func (m *Music) ReleaseSingles(release Release) []Track {
 	var tracks []Track
-	m.db.Where("tracks.re_id = ? and"+
+	m.db.Where("tracks.re_id = ? and tracks.alternate = 0 and"+
 		" exists (select releases.single_name from releases where tracks.artist = releases.artist"+
 		" and releases.type = 'Single' and releases.single_name = tracks.title)",
 		release.REID).
@@ -757,7 +828,7 @@ func (m *Music) ReleaseSingles(release Release) []Track {
 
 func (m *Music) ReleasePopular(release Release) []Track {
 	var tracks []Track
-	m.db.Where("re_id = ? and"+
+	m.db.Where("re_id = ? and alternate = 0 and"+
 		" exists (select popular.title from popular where"+
 		" tracks.artist = popular.artist and tracks.title = popular.title)",
 		release.REID).
@@ -774,6 +845,16 @@ func (m *Music) ReleasesLike(name string) []Release {
 	return releases
 }
 
+// releasesNamed returns releases with tracks that have one of the names.
+func (m *Music) releasesNamed(names []string) []Release {
+	var releases []Release
+	m.db.Where("name in (?) and re_id in"+
+		" (select distinct(re_id) from tracks)", names).
+		Order("date").
+		Find(&releases)
+	return releases
+}
+
 func (m *Music) ReleasesForREIDs(reids []string) []Release {
 	var releases []Release
 	m.db.Where("re_id in (?)", reids).Find(&releases)
@@ -841,6 +922,29 @@ func (m *Music) LookupARID(arid string) (Artist, error) {
 }
 
 // Lookup a track given the internal record ID.
+// LookupTracks returns the tracks in the same order as the ids. Tracks that
+// don't exist are skipped.
+func (m *Music) LookupTracks(ids []int) []Track {
+	found := make(map[uint]Track)
+	// split potentially large # of ids into chunks to query
+	chunkSize := 500
+	for i := 0; i < len(ids); i += chunkSize {
+		end := min(i+chunkSize, len(ids))
+		var chunk []Track
+		m.db.Where("id in (?)", ids[i:end]).Find(&chunk)
+		for _, t := range chunk {
+			found[t.ID] = t
+		}
+	}
+	tracks := make([]Track, 0, len(ids))
+	for _, id := range ids {
+		if t, ok := found[uint(id)]; ok {
+			tracks = append(tracks, t)
+		}
+	}
+	return tracks
+}
+
 func (m *Music) LookupTrack(id int) (Track, error) {
 	var track Track
 	err := m.db.First(&track, id).Error
@@ -850,15 +954,97 @@ func (m *Music) LookupTrack(id int) (Track, error) {
 	return track, err
 }
 
+func (m *Music) allTracks() []Track {
+	var tracks []Track
+	m.db.Find(&tracks)
+	return tracks
+}
+
+func (m *Music) updateTrackAlternate(t Track, alternate bool) error {
+	return m.db.Model(&t).Update("alternate", alternate).Error
+}
+
+// Tracks with any of the titles, including alternates.
+func (m *Music) tracksTitled(titles []string) []Track {
+	var tracks []Track
+	// split potentially large # of titles into chunks to query
+	chunkSize := 500
+	for i := 0; i < len(titles); i += chunkSize {
+		end := min(i+chunkSize, len(titles))
+		var chunk []Track
+		m.db.Where("title in (?)", titles[i:end]).Find(&chunk)
+		tracks = append(tracks, chunk...)
+	}
+	return tracks
+}
+
+// Tracks synced before the bucket was saved with each track.
+func (m *Music) tracksWithoutBucket() []Track {
+	var tracks []Track
+	m.db.Where("bucket < 0").Find(&tracks)
+	return tracks
+}
+
+func (m *Music) updateTrackBucket(t Track, bucket int) error {
+	return m.db.Model(&t).Update("bucket", bucket).Error
+}
+
+func (m *Music) trackAlternates(t Track) []Track {
+	var tracks []Track
+	if t.REID != "" {
+		m.db.Where("re_id = ? and disc_num = ? and track_num = ? and alternate = 1",
+			t.REID, t.DiscNum, t.TrackNum).Find(&tracks)
+	} else {
+		m.db.Where("artist = ? and `release` = ? and disc_num = ? and track_num = ?"+
+			" and title = ? and alternate = 1",
+			t.Artist, t.Release, t.DiscNum, t.TrackNum, t.Title).Find(&tracks)
+	}
+	return tracks
+}
+
+// alternateTracks returns the alternate copies for a list of tracks, either
+// by release or by artist and release names for unassigned tracks.
+func (m *Music) alternateTracks(tracks []Track) []Track {
+	var reids, artists, releases []string
+	for _, t := range tracks {
+		if t.REID != "" {
+			reids = append(reids, t.REID)
+		} else {
+			artists = append(artists, t.Artist)
+			releases = append(releases, t.Release)
+		}
+	}
+	var result []Track
+	if len(reids) > 0 {
+		var list []Track
+		m.db.Where("re_id in (?) and alternate = 1", reids).Find(&list)
+		result = append(result, list...)
+	}
+	if len(artists) > 0 {
+		var list []Track
+		m.db.Where("re_id = '' and artist in (?) and `release` in (?) and alternate = 1",
+			artists, releases).Find(&list)
+		result = append(result, list...)
+	}
+	return result
+}
+
 func (m *Music) tracksForRIDs(rids []string) []Track {
 	var tracks []Track
-	m.db.Where("r_id in (?)", rids).Find(&tracks)
+	// split potentially large # of rids into chunks to query
+	chunkSize := 500
+	for i := 0; i < len(rids); i += chunkSize {
+		end := min(i+chunkSize, len(rids))
+		var chunk []Track
+		m.db.Where("r_id in (?) and alternate = 0", rids[i:end]).Find(&chunk)
+		tracks = append(tracks, chunk...)
+	}
 	return tracks
 }
 
 func (m *Music) tracksFor(keys []string) []Track {
 	var tracks []Track
-	m.db.Where("key in (?)", keys).Find(&tracks)
+	m.db.Where("key in (?) and alternate = 0", keys).Find(&tracks)
 	return tracks
 }
 
@@ -897,7 +1083,7 @@ func (m *Music) Query(query string) ([]Artist, []Release, []Track, []Station) {
 	}
 
 	queryTitles := func(name string) {
-		m.db.Where("title like ?", name).
+		m.db.Where("title like ? and alternate = 0", name).
 			Order("title").Limit(m.config.Music.SearchLimit).Find(&tracks)
 	}
 
@@ -993,11 +1179,24 @@ func (m *Music) StationsLike(name string) []Station {
 }
 
 func (m *Music) clearStationPlaylists() {
-	m.db.Exec(`update stations set playlist = ""`)
+	m.db.Exec(`update stations set playlist = "" where user = ?`, TakeoutUser)
 }
 
+// deleteStations removes the shared stations; user stations are kept.
 func (m *Music) deleteStations() {
-	m.db.Exec(`delete from stations`)
+	m.db.Exec(`delete from stations where user = ?`, TakeoutUser)
+}
+
+// UserStations returns the user's own stations of the type, oldest first.
+func (m *Music) UserStations(user auth.User, stationType string) []Station {
+	var stations []Station
+	m.db.Where("user = ? and type = ?", user.Name, stationType).Order("id").Find(&stations)
+	return stations
+}
+
+func (m *Music) DeleteUserStations(user auth.User, stationType string) error {
+	return m.db.Unscoped().Where("user = ? and type = ?", user.Name, stationType).
+		Delete(Station{}).Error
 }
 
 // Obtain user station by id.
@@ -1108,10 +1307,10 @@ func (m *Music) searchTracks(title, artist, album string) []Track {
 	var tracks []Track
 	var tx *gorm.DB
 	if len(album) != 0 {
-		tx = m.db.Where("title = ? and artist = ? and (`release` = ? or `release_title` = ?)",
+		tx = m.db.Where("title = ? and artist = ? and alternate = 0 and (`release` = ? or `release_title` = ?)",
 			title, artist, album, album)
 	} else {
-		tx = m.db.Where("title = ? and artist = ?", title, artist)
+		tx = m.db.Where("title = ? and artist = ? and alternate = 0", title, artist)
 	}
 	tx.Order("date").Find(&tracks)
 	return tracks
@@ -1157,6 +1356,79 @@ func (m *Music) CreatePlaylist(p *Playlist) error {
 	return m.db.Create(p).Error
 }
 
+func (m *Music) SmartPlaylists(user auth.User) []SmartPlaylist {
+	var playlists []SmartPlaylist
+	m.db.Where("user = ?", user.Name).Order("name").Find(&playlists)
+	return playlists
+}
+
+func (m *Music) allSmartPlaylists() []SmartPlaylist {
+	var playlists []SmartPlaylist
+	m.db.Find(&playlists)
+	return playlists
+}
+
+func (m *Music) LookupSmartPlaylist(user auth.User, id int) (SmartPlaylist, error) {
+	var p SmartPlaylist
+	err := m.db.Where("user = ? and id = ?", user.Name, id).First(&p).Error
+	if err != nil {
+		return SmartPlaylist{}, ErrSmartNotFound
+	}
+	return p, nil
+}
+
+func (m *Music) lookupSmartPlaylistName(user auth.User, name string) (SmartPlaylist, error) {
+	var p SmartPlaylist
+	err := m.db.Where("user = ? and name = ?", user.Name, name).First(&p).Error
+	if err != nil {
+		return SmartPlaylist{}, ErrSmartNotFound
+	}
+	return p, nil
+}
+
+func (m *Music) CreateSmartPlaylist(p *SmartPlaylist) error {
+	return m.db.Create(p).Error
+}
+
+func (m *Music) UpdateSmartPlaylist(p *SmartPlaylist) error {
+	return m.db.Save(p).Error
+}
+
+func (m *Music) DeleteSmartPlaylist(user auth.User, id int) error {
+	return m.db.Unscoped().Where("user = ? and id = ?", user.Name, id).Delete(SmartPlaylist{}).Error
+}
+
+// popularRanks returns the popular rank keyed by lowercase artist and title.
+func (m *Music) popularRanks() map[string]int {
+	var popular []Popular
+	m.db.Find(&popular)
+	ranks := make(map[string]int)
+	for _, p := range popular {
+		ranks[strings.ToLower(p.Artist+"/"+p.Title)] = p.Rank
+	}
+	return ranks
+}
+
+// smartRow is a track with the artist genre and release type.
+type smartRow struct {
+	Track
+	Genre string
+	RType string
+}
+
+// smartRows returns preferred tracks matching the optional condition.
+func (m *Music) smartRows(query string, args []interface{}) []smartRow {
+	var rows []smartRow
+	tx := m.db.Model(&Track{}).
+		Select("tracks.*, " + smartGenreColumn + " as genre, " + smartTypeColumn + " as r_type").
+		Where("tracks.alternate = 0")
+	if query != "" {
+		tx = tx.Where(query, args...)
+	}
+	tx.Scan(&rows)
+	return rows
+}
+
 func (m *Music) CreateStation(s *Station) error {
 	return m.db.Create(s).Error
 }
@@ -1169,6 +1441,113 @@ func (m *Music) createArtistImage(img *ArtistImage) error {
 	return m.db.Create(img).Error
 }
 
+func (m *Music) releaseOverrides() []ReleaseOverride {
+	var overrides []ReleaseOverride
+	m.db.Order("folder").Find(&overrides)
+	return overrides
+}
+
+func (m *Music) releaseOverride(folder string) (ReleaseOverride, error) {
+	var o ReleaseOverride
+	err := m.db.Where("folder = ?", folder).First(&o).Error
+	return o, err
+}
+
+func (m *Music) releaseOverrideFor(reid string) (ReleaseOverride, error) {
+	var o ReleaseOverride
+	err := m.db.Where("re_id = ?", reid).First(&o).Error
+	return o, err
+}
+
+// Create or replace the release override for the same folder.
+func (m *Music) saveReleaseOverride(o *ReleaseOverride) error {
+	curr, err := m.releaseOverride(o.Folder)
+	if err == nil {
+		o.ID = curr.ID
+		o.CreatedAt = curr.CreatedAt
+		return m.db.Save(o).Error
+	}
+	return m.db.Create(o).Error
+}
+
+func (m *Music) deleteReleaseOverride(id int) error {
+	return m.db.Unscoped().Delete(&ReleaseOverride{}, id).Error
+}
+
+func (m *Music) updateReleaseName(r Release) error {
+	return m.db.Model(&r).Update("name", r.Name).Error
+}
+
+func (m *Music) trackOverrides() []TrackOverride {
+	var overrides []TrackOverride
+	m.db.Order("key").Find(&overrides)
+	return overrides
+}
+
+func (m *Music) trackOverride(key string) (TrackOverride, error) {
+	var o TrackOverride
+	err := m.db.Where("key = ?", key).First(&o).Error
+	return o, err
+}
+
+// Create or replace the track override for the same key.
+func (m *Music) saveTrackOverride(o *TrackOverride) error {
+	curr, err := m.trackOverride(o.Key)
+	if err == nil {
+		o.ID = curr.ID
+		o.CreatedAt = curr.CreatedAt
+		return m.db.Save(o).Error
+	}
+	return m.db.Create(o).Error
+}
+
+func (m *Music) deleteTrackOverride(id int) error {
+	return m.db.Unscoped().Delete(&TrackOverride{}, id).Error
+}
+
+// Tracks with keys within the folder, including sub-folders.
+func (m *Music) folderTracks(folder string) []Track {
+	var tracks []Track
+	prefix := folder + "/"
+	// substr counts characters, not bytes
+	m.db.Where("substr(key, 1, ?) = ?", utf8.RuneCountInString(prefix), prefix).
+		Order("disc_num, track_num").Find(&tracks)
+	return tracks
+}
+
+func (m *Music) trackForKey(key string) (Track, error) {
+	var track Track
+	err := m.db.Where("key = ?", key).First(&track).Error
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		return Track{}, ErrTrackNotFound
+	}
+	return track, err
+}
+
+func (m *Music) updateTrackOverride(t Track, o TrackOverride) error {
+	updates := make(map[string]interface{})
+	if o.Title != "" {
+		updates["title"] = o.Title
+	}
+	if o.TrackNum != 0 {
+		updates["track_num"] = o.TrackNum
+	}
+	if o.DiscNum != 0 {
+		updates["disc_num"] = o.DiscNum
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+	return m.db.Model(&t).Updates(updates).Error
+}
+
+func (m *Music) artistReleasesNamed(artist, name string) []Release {
+	var releases []Release
+	m.db.Where("artist = ? and name = ?", artist, name).
+		Order("release_date").Find(&releases)
+	return releases
+}
+
 // select artist, name, date from releases where type = 'Album' and
 // secondary_type = '' and status = 'Official' and artist = 'Black Sabbath' and
 // lower(name) not in (select distinct lower(release) from tracks where artist
@@ -18,11 +18,15 @@
 package music
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"takeoutfm.dev/takeout/internal/auth"
 	"takeoutfm.dev/takeout/internal/config"
+	"takeoutfm.dev/takeout/lib/bucket"
 	"takeoutfm.dev/takeout/lib/date"
 	"takeoutfm.dev/takeout/model"
 )
@@ -270,6 +274,39 @@ func TestArtistTag(t *testing.T) {
 	}
 }
 
+func TestTaggedArtists(t *testing.T) {
+	m := makeMusic(t)
+
+	for _, name := range []string{"tagged one", "tagged two", "tagged three"} {
+		a := model.Artist{Name: name, ARID: "arid-" + name}
+		err := m.createArtist(&a)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	tags := []model.ArtistTag{
+		{Artist: "tagged one", Tag: "tagged rock"},
+		{Artist: "tagged one", Tag: "tagged pop"},
+		{Artist: "tagged two", Tag: "tagged rock"},
+		{Artist: "tagged two", Tag: "tagged pop"},
+		{Artist: "tagged three", Tag: "tagged pop"},
+	}
+	for i := range tags {
+		err := m.createArtistTag(&tags[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	artists := m.TaggedArtists(model.Artist{Name: "tagged one"})
+	if len(artists) != 2 {
+		t.Fatalf("expect 2 artists got %d", len(artists))
+	}
+	if artists[0].Name != "tagged two" {
+		t.Errorf("expect most shared tags first got %s", artists[0].Name)
+	}
+}
+
 func TestArtistBackground(t *testing.T) {
 	m := makeMusic(t)
 
@@ -368,6 +405,58 @@ func TestStation(t *testing.T) {
 	}
 }
 
+func TestMixStation(t *testing.T) {
+	m := makeMusic(t)
+
+	track := model.Track{Key: "mix/1", Artist: "mix artist", Title: "mix title"}
+	err := m.createTrack(&track)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := auth.User{Name: "mix user"}
+	s := model.Station{User: u.Name, Name: "test mix"}
+	err = m.CreateMixStation(&s, []model.Track{track})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Type != TypeMix || s.Shared {
+		t.Error("expect private mix station")
+	}
+	if s.Ref != fmt.Sprintf("/music/mix/%d", s.ID) {
+		t.Errorf("unexpected ref %s", s.Ref)
+	}
+	if len(s.Playlist) == 0 {
+		t.Error("expect playlist")
+	}
+
+	id := s.ID
+	s.Name = "test mix updated"
+	err = m.CreateMixStation(&s, []model.Track{track})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stations := m.UserStations(u, TypeMix)
+	if s.ID != id || len(stations) != 1 || stations[0].Name != "test mix updated" {
+		t.Error("expect mix station updated in place", stations)
+	}
+
+	m.DeleteStations()
+	_, err = m.LookupStation(int(s.ID))
+	if err != nil {
+		t.Error("expect mix station to be kept")
+	}
+
+	err = m.DeleteUserStations(u, TypeMix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.LookupStation(int(s.ID))
+	if err == nil {
+		t.Error("expect mix station deleted")
+	}
+}
+
 func TestPlaylist(t *testing.T) {
 	user := "takeout"
 
@@ -569,3 +658,446 @@ func TestRelatedArtists(t *testing.T) {
 		t.Errorf("expect 0 related to 3, got %d", len(result))
 	}
 }
+
+func TestTrackReleaseOverride(t *testing.T) {
+	overrides := []model.ReleaseOverride{
+		{Folder: "Music/test artist/test release (1999)", REID: "91ee703e-0ab1-40e9-bd12-c999399387d2"},
+	}
+	o, ok := trackReleaseOverride(overrides, "Music/test artist/test release (1999)/01-one.flac")
+	if !ok || o.REID != "91ee703e-0ab1-40e9-bd12-c999399387d2" {
+		t.Error("expect override")
+	}
+	_, ok = trackReleaseOverride(overrides, "Music/test artist/test release (1999) bonus/01-one.flac")
+	if ok {
+		t.Error("expect no override for sibling folder")
+	}
+}
+
+func TestTrackOverride(t *testing.T) {
+	m := makeMusic(t)
+
+	track := model.Track{
+		Artist:   "test artist",
+		Release:  "test release",
+		Title:    "wrong title",
+		TrackNum: 1,
+		DiscNum:  1,
+		Key:      "Music/test artist/test release/01-wrong title.flac",
+	}
+	err := m.createTrack(&track)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o := model.TrackOverride{Key: track.Key, Title: "right title", TrackNum: 2}
+	err = m.SaveTrackOverride(&o)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	track, err = m.trackForKey(track.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if track.Title != "right title" || track.TrackNum != 2 || track.DiscNum != 1 {
+		t.Errorf("expect overridden track, got %s %d/%d", track.Title, track.DiscNum, track.TrackNum)
+	}
+	if !m.hasTitleOverride(track) {
+		t.Error("expect title override")
+	}
+
+	synced := model.Track{Key: track.Key, Title: "wrong title", TrackNum: 1, DiscNum: 1}
+	m.applyTrackOverride(&synced)
+	if synced.Title != "right title" || synced.TrackNum != 2 || synced.DiscNum != 1 {
+		t.Error("expect override applied to synced track")
+	}
+
+	err = m.SaveTrackOverride(&model.TrackOverride{Key: track.Key})
+	if err != ErrInvalidOverride {
+		t.Error("expect invalid override")
+	}
+}
+
+func TestReleaseOverride(t *testing.T) {
+	m := makeMusic(t)
+
+	release := model.Release{
+		Artist: "test artist",
+		Name:   "test release",
+		REID:   "91ee703e-0ab1-40e9-bd12-c999399387d2",
+		RGID:   "2d02e8a2-0c97-44b8-9b6f-9f81fd527198",
+	}
+	err := m.createRelease(&release)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := []string{
+		"Music/test artist/test release (1999)/01-one.flac",
+		"Music/test artist/test release (1999)/02-two.flac",
+		"Music/test artist/test release (1999) bonus/01-three.flac",
+	}
+	for i, key := range keys {
+		track := model.Track{
+			Artist:   "test artist",
+			Release:  "test release",
+			TrackNum: i + 1,
+			DiscNum:  1,
+			Key:      key,
+		}
+		err = m.createTrack(&track)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	o := model.ReleaseOverride{
+		Folder: "Music/test artist/test release (1999)/",
+		REID:   release.REID,
+		Name:   "better name",
+	}
+	err = m.SaveReleaseOverride(&o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Folder != "Music/test artist/test release (1999)" {
+		t.Errorf("expect folder without trailing slash, got %s", o.Folder)
+	}
+
+	tracks := m.ReleaseTracks(release)
+	if len(tracks) != 2 {
+		t.Fatalf("expect 2 tracks got %d", len(tracks))
+	}
+	for _, track := range tracks {
+		if track.RGID != release.RGID {
+			t.Error("expect assigned rgid")
+		}
+	}
+
+	release, err = m.release(release.REID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if release.Name != "better name" {
+		t.Errorf("expect overridden name, got %s", release.Name)
+	}
+
+	if len(m.ReleaseOverrides()) != 1 {
+		t.Error("expect 1 override")
+	}
+	err = m.DeleteReleaseOverride(int(o.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.ReleaseOverrides()) != 0 {
+		t.Error("expect no overrides")
+	}
+}
+
+func TestFolderTracks(t *testing.T) {
+	m := makeMusic(t)
+
+	keys := []string{
+		"Music/Björk/Homogenic (1997)/01-hunter.flac",
+		"Music/Björk/Homogenic (1997)/02-jóga.flac",
+		"Music/Björk/Homogenic (1997) live/01-hunter.flac",
+	}
+	for i, key := range keys {
+		err := m.createTrack(&model.Track{Artist: "Björk", TrackNum: i + 1, DiscNum: 1, Key: key})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	tracks := m.folderTracks("Music/Björk/Homogenic (1997)")
+	if len(tracks) != 2 {
+		t.Fatalf("expect 2 tracks got %d", len(tracks))
+	}
+}
+
+func TestSyncDuplicates(t *testing.T) {
+	m := makeMusic(t)
+
+	reid := "5b11f4ce-a62d-471e-81fc-a69a8278c7da"
+	tracks := []model.Track{
+		{Key: "Music/A/B/01-one.mp3", REID: reid, DiscNum: 1, TrackNum: 1, Size: 9000},
+		{Key: "Music/A/B/01-one.flac", REID: reid, DiscNum: 1, TrackNum: 1, Size: 3000},
+		{Key: "Music/A/B (copy)/01-one.flac", REID: reid, DiscNum: 1, TrackNum: 1, Size: 2000},
+		{Key: "Music/A/B/02-two.mp3", Title: "two", REID: reid, DiscNum: 1, TrackNum: 2, Size: 1000},
+	}
+	for i := range tracks {
+		err := m.createTrack(&tracks[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := m.syncDuplicates()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release := model.Release{REID: reid}
+	list := m.ReleaseTracks(release)
+	if len(list) != 2 {
+		t.Fatalf("expect 2 tracks got %d", len(list))
+	}
+	if list[0].Key != "Music/A/B/01-one.flac" {
+		t.Errorf("expect larger flac preferred, got %s", list[0].Key)
+	}
+	if list[1].Key != "Music/A/B/02-two.mp3" {
+		t.Errorf("expect single copy, got %s", list[1].Key)
+	}
+
+	alternates := m.TrackAlternates(list[0])
+	if len(alternates) != 2 {
+		t.Errorf("expect 2 alternates got %d", len(alternates))
+	}
+	if len(m.TrackAlternates(list[1])) != 0 {
+		t.Error("expect no alternates")
+	}
+	batch := m.TracksAlternates(list)
+	if len(batch[list[0].ID]) != 2 {
+		t.Errorf("expect 2 batch alternates got %d", len(batch[list[0].ID]))
+	}
+	if len(batch[list[1].ID]) != 0 {
+		t.Error("expect no batch alternates")
+	}
+	if len(m.releaseTracks(release)) != 4 {
+		t.Error("expect all release tracks")
+	}
+
+	// only tracks with the same title are considered
+	dup := model.Track{Key: "Music/A/B (copy)/02-two.flac", Title: "two",
+		REID: reid, DiscNum: 1, TrackNum: 2, Size: 500}
+	other := model.Track{Key: "Music/A/B (copy)/01-three.flac", Title: "three",
+		REID: reid, DiscNum: 1, TrackNum: 1, Size: 500}
+	for _, track := range []*model.Track{&dup, &other} {
+		if err := m.createTrack(track); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = m.syncDuplicatesFor([]model.Track{dup})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := make(map[string]bool)
+	for _, track := range m.ReleaseTracks(release) {
+		keys[track.Key] = true
+	}
+	if len(keys) != 3 || !keys[dup.Key] || !keys[other.Key] {
+		t.Errorf("expect flac preferred and other title unchanged, got %v", keys)
+	}
+}
+
+func TestAssignTrackBuckets(t *testing.T) {
+	m := makeMusic(t)
+
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b"} {
+		b, err := bucket.Open(bucket.Config{FS: bucket.FSConfig{Root: filepath.Join(dir, name)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.buckets = append(m.buckets, b)
+	}
+	track := model.Track{Key: filepath.Join(dir, "b", "Artist", "Release", "01-one.flac"), Bucket: -1}
+	err := m.createTrack(&track)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.assignTrackBuckets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	track, err = m.LookupTrack(int(track.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if track.Bucket != 1 {
+		t.Errorf("expect bucket 1 got %d", track.Bucket)
+	}
+	if len(m.tracksWithoutBucket()) != 0 {
+		t.Error("expect all buckets assigned")
+	}
+}
+
+func TestSmartPlaylist(t *testing.T) {
+	m := makeMusic(t)
+
+	artist := model.Artist{Name: "smart artist", Genre: "smart rock"}
+	err := m.createArtist(&artist)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracks := []model.Track{
+		{Key: "smart/1", Artist: "smart artist", Title: "one", RID: "smart-rid-1",
+			ReleaseDate: date.ParseDate("1999-05-01")},
+		{Key: "smart/2", Artist: "smart artist", Title: "two", RID: "smart-rid-2",
+			ReleaseDate: date.ParseDate("2005-01-01")},
+		{Key: "smart/3", Artist: "smart artist", Title: "three", RID: "smart-rid-3",
+			ReleaseDate: date.ParseDate("2010-01-01")},
+	}
+	for i := range tracks {
+		err := m.createTrack(&tracks[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	plays := map[string]model.TrackPlays{
+		"smart-rid-1": {Count: 5, Last: time.Now()},
+		"smart-rid-2": {Count: 1, Last: time.Now().Add(-90 * 24 * time.Hour), Skips: 3},
+	}
+
+	p := model.SmartPlaylist{
+		User: "smart user",
+		Name: "smart",
+		Rule: model.SmartRule{Match: SmartMatchAll, Rules: []model.SmartRule{
+			{Field: SmartFieldGenre, Op: SmartOpIs, Value: "smart rock"},
+			{Match: SmartMatchAny, Rules: []model.SmartRule{
+				{Field: SmartFieldNeverPlayed, Op: SmartOpIs, Value: "true"},
+				{Field: SmartFieldLastPlayed, Op: SmartOpWithin, Value: "30d"},
+			}},
+		}},
+		Sort:  SmartFieldDate,
+		Order: SmartOrderDesc,
+	}
+	err = ValidateSmartPlaylist(&p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := m.SmartPlaylistTracks(p, plays)
+	if len(list) != 2 {
+		t.Fatalf("expect 2 tracks got %d", len(list))
+	}
+	if list[0].Title != "three" || list[1].Title != "one" {
+		t.Errorf("expect three, one got %s, %s", list[0].Title, list[1].Title)
+	}
+
+	p.Rule = model.SmartRule{Match: SmartMatchAll, Rules: []model.SmartRule{
+		{Field: SmartFieldArtist, Op: SmartOpIs, Value: "smart artist"},
+		{Field: SmartFieldSkips, Op: SmartOpGreater, Value: "0"},
+	}}
+	list = m.SmartPlaylistTracks(p, plays)
+	if len(list) != 1 || list[0].Title != "two" {
+		t.Error("expect skipped track")
+	}
+
+	p.Rule = model.SmartRule{Field: SmartFieldArtist, Op: SmartOpIs, Value: "smart artist"}
+	p.Sort = SmartFieldPlays
+	p.Limit = 1
+	list = m.SmartPlaylistTracks(p, plays)
+	if len(list) != 1 || list[0].Title != "one" {
+		t.Error("expect most played track")
+	}
+
+	p.Refresh = SmartRefreshSchedule
+	err = m.CreateSmartPlaylist(&p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.RefreshSmartPlaylist(&p, plays)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found, err := m.FindSmartPlaylist(auth.User{Name: "smart user"}, "smart")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.TrackCount != 1 || len(found.Playlist) == 0 {
+		t.Error("expect refreshed playlist")
+	}
+
+	p = model.SmartPlaylist{Rule: model.SmartRule{Match: SmartMatchAll, Rules: []model.SmartRule{
+		{Field: SmartFieldType, Op: SmartOpNot, Value: "Live"},
+		{Field: SmartFieldDate, Op: SmartOpIs, Value: "1999"},
+	}}}
+	list = m.SmartPlaylistTracks(p, nil)
+	if len(list) != 1 || list[0].Title != "one" {
+		t.Error("expect track from 1999")
+	}
+	if SmartPlaylistActivity(p) {
+		t.Error("expect no activity")
+	}
+
+	bad := model.SmartPlaylist{Rule: model.SmartRule{Field: SmartFieldPlays, Op: SmartOpContains, Value: "1"}}
+	if ValidateSmartPlaylist(&bad) == nil {
+		t.Error("expect invalid rule")
+	}
+	rule := model.SmartRule{Field: SmartFieldArtist, Op: SmartOpIs, Value: "smart artist"}
+	for range SmartMaxDepth + 1 {
+		rule = model.SmartRule{Match: SmartMatchAny, Rules: []model.SmartRule{rule}}
+	}
+	bad = model.SmartPlaylist{Rule: rule}
+	if ValidateSmartPlaylist(&bad) == nil {
+		t.Error("expect rules nested too deep")
+	}
+}
+
+func TestLyrics(t *testing.T) {
+	m := makeMusic(t)
+
+	track := model.Track{Key: "test lyrics key", Title: "test title"}
+	_, err := m.TrackLyrics(track)
+	if err != ErrLyricsNotFound {
+		t.Fatal("expect not found")
+	}
+
+	err = m.updateLyrics(track.Key, "plain line one\nplain line two")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.updateLyrics(track.Key, "[00:01.00]line one\n[00:02.50]line two")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := m.lyrics(track.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !l.Synced {
+		t.Error("expect synced")
+	}
+	lyrics, err := m.TrackLyrics(track)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lyrics.Lines) != 2 || lyrics.Lines[1].Time != 2500*time.Millisecond {
+		t.Error("expect lines", lyrics.Lines)
+	}
+	if m.lyricsText(track) != "line one\nline two" {
+		t.Error("expect text", m.lyricsText(track))
+	}
+
+	err = m.updateLyrics(track.Key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.lyrics(track.Key)
+	if err == nil {
+		t.Error("expect removed")
+	}
+	err = m.updateLyrics(track.Key, "line one")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.deleteLyrics()
+	_, err = m.lyrics(track.Key)
+	if err == nil {
+		t.Error("expect deleted")
+	}
+}
+
+func TestSidecarLyrics(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "01-Cars.lrc"), []byte("[00:12.00]Here in my car\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sidecarLyrics(filepath.Join(dir, "01-Cars.flac")) != "[00:12.00]Here in my car\n" {
+		t.Error("expect sidecar lyrics")
+	}
+	if sidecarLyrics(filepath.Join(dir, "02-Films.flac")) != "" {
+		t.Error("expect no sidecar lyrics")
+	}
+}
@@ -0,0 +1,246 @@
+// Copyright 2024 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package music
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"takeoutfm.dev/takeout/lib/bucket"
+	"takeoutfm.dev/takeout/lib/log"
+	. "takeoutfm.dev/takeout/model"
+)
+
+const (
+	PreferFormat = "format"
+	PreferSize   = "size"
+	PreferBucket = "bucket"
+)
+
+// duplicateKey groups copies of the same track. Tracks assigned to a release
+// use the release, disc and track number; otherwise the names from the
+// bucket are used.
+func duplicateKey(t Track) string {
+	if t.REID != "" {
+		return fmt.Sprintf("%s/%d/%d", t.REID, t.DiscNum, t.TrackNum)
+	}
+	return strings.ToLower(fmt.Sprintf("%s/%s/%d/%d/%s",
+		t.Artist, t.Release, t.DiscNum, t.TrackNum, t.Title))
+}
+
+// formatRank is the position of the track file extension in the format
+// preference; unknown formats are last.
+func (m *Music) formatRank(t Track) int {
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(t.Key)), ".")
+	for i, f := range m.config.Music.FormatPreference {
+		if strings.ToLower(f) == ext {
+			return i
+		}
+	}
+	return len(m.config.Music.FormatPreference)
+}
+
+// preferTrack returns true if track a should be used instead of track b.
+// Copies of the same recording have about the same duration so the larger
+// file likely has the higher bitrate.
+func (m *Music) preferTrack(a, b Track) bool {
+	for _, p := range m.config.Music.DuplicatePreference {
+		switch p {
+		case PreferFormat:
+			ra, rb := m.formatRank(a), m.formatRank(b)
+			if ra != rb {
+				return ra < rb
+			}
+		case PreferSize:
+			if a.Size != b.Size {
+				return a.Size > b.Size
+			}
+		case PreferBucket:
+			if a.Bucket != b.Bucket {
+				return a.Bucket < b.Bucket
+			}
+		}
+	}
+	return a.Key < b.Key
+}
+
+// syncDuplicates marks all but the preferred copy of each track as an
+// alternate. Alternates are excluded from views, radio and search, and are
+// offered as additional playlist locations for the preferred track.
+func (m *Music) syncDuplicates() error {
+	return m.markDuplicates(m.allTracks())
+}
+
+// syncDuplicatesFor is like syncDuplicates but only considers tracks with
+// the same titles as the synced tracks.
+func (m *Music) syncDuplicatesFor(synced []Track) error {
+	if len(synced) == 0 {
+		return nil
+	}
+	seen := make(map[string]struct{})
+	var titles []string
+	for _, t := range synced {
+		if _, ok := seen[t.Title]; ok {
+			continue
+		}
+		seen[t.Title] = struct{}{}
+		titles = append(titles, t.Title)
+	}
+	return m.markDuplicates(m.tracksTitled(titles))
+}
+
+// markDuplicates groups copies of the same track and updates which copies
+// are alternates. Tracks that become alternates are removed from the search
+// index.
+func (m *Music) markDuplicates(tracks []Track) error {
+	for _, p := range m.config.Music.DuplicatePreference {
+		if p != PreferFormat && p != PreferSize && p != PreferBucket {
+			log.Printf("unsupported DuplicatePreference '%s'\n", p)
+		}
+	}
+	err := m.assignTrackBuckets()
+	if err != nil {
+		return err
+	}
+
+	groups := make(map[string][]Track)
+	for _, t := range tracks {
+		key := duplicateKey(t)
+		groups[key] = append(groups[key], t)
+	}
+
+	var alternates []string
+	for _, tracks := range groups {
+		sort.SliceStable(tracks, func(i, j int) bool {
+			return m.preferTrack(tracks[i], tracks[j])
+		})
+		for i, t := range tracks {
+			alternate := i > 0
+			if t.Alternate == alternate {
+				continue
+			}
+			if alternate {
+				log.Printf("duplicate %s of %s\n", t.Key, tracks[0].Key)
+				alternates = append(alternates, t.Key)
+			}
+			err := m.updateTrackAlternate(t, alternate)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(alternates) > 0 {
+		s, err := m.newSearch()
+		if err != nil {
+			return err
+		}
+		defer s.Close()
+		return s.Delete(alternates)
+	}
+	return nil
+}
+
+// assignTrackBuckets sets the bucket for tracks synced before the bucket was
+// saved with each track. This is done once and buckets that can't check
+// keys directly are listed.
+func (m *Music) assignTrackBuckets() error {
+	tracks := m.tracksWithoutBucket()
+	if len(tracks) == 0 {
+		return nil
+	}
+	log.Printf("assign bucket for %d tracks\n", len(tracks))
+	keys := make(map[string][]Track)
+	for _, t := range tracks {
+		keys[t.Key] = append(keys[t.Key], t)
+	}
+	assign := func(key string, index int) error {
+		for _, t := range keys[key] {
+			err := m.updateTrackBucket(t, index)
+			if err != nil {
+				return err
+			}
+		}
+		delete(keys, key)
+		return nil
+	}
+	for i, b := range m.buckets {
+		if c, ok := b.(bucket.Container); ok {
+			for key := range keys {
+				if c.Contains(key) {
+					if err := assign(key, i); err != nil {
+						return err
+					}
+				}
+			}
+			continue
+		}
+		objectCh, err := b.List(time.Time{})
+		if err != nil {
+			return err
+		}
+		for o := range objectCh {
+			if _, ok := keys[o.Key]; ok {
+				if err := assign(o.Key, i); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for key := range keys {
+		// not found in any bucket, use the first
+		if err := assign(key, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TrackAlternates returns the duplicate copies of the preferred track.
+func (m *Music) TrackAlternates(t Track) []Track {
+	if t.Alternate {
+		return nil
+	}
+	return m.trackAlternates(t)
+}
+
+// TracksAlternates returns the duplicate copies for each preferred track in
+// the list, keyed by track ID. This uses one lookup for the whole list.
+func (m *Music) TracksAlternates(tracks []Track) map[uint][]Track {
+	result := make(map[uint][]Track)
+	if len(tracks) == 0 {
+		return result
+	}
+	groups := make(map[string][]Track)
+	for _, t := range m.alternateTracks(tracks) {
+		key := duplicateKey(t)
+		groups[key] = append(groups[key], t)
+	}
+	for _, t := range tracks {
+		if t.Alternate {
+			continue
+		}
+		if alts, ok := groups[duplicateKey(t)]; ok {
+			result[t.ID] = alts
+		}
+	}
+	return result
+}
@@ -0,0 +1,83 @@
+// Copyright 2023 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package music
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"takeoutfm.dev/takeout/lib/lrc"
+	. "takeoutfm.dev/takeout/model"
+)
+
+const (
+	LyricsExt = ".lrc"
+)
+
+// sidecarLyrics returns the contents of the LRC file next to the track file,
+// if any.
+func sidecarLyrics(path string) string {
+	data, err := os.ReadFile(strings.TrimSuffix(path, filepath.Ext(path)) + LyricsExt)
+	if err != nil {
+		return ""
+	}
+	return trim(string(data))
+}
+
+func (m *Music) deleteLyrics() {
+	m.db.Exec("delete from lyrics")
+}
+
+// updateLyrics replaces the lyrics for the track key. Empty text removes the
+// lyrics.
+func (m *Music) updateLyrics(key, text string) error {
+	err := m.db.Unscoped().Where("key = ?", key).Delete(&Lyrics{}).Error
+	if err != nil || text == "" {
+		return err
+	}
+	l := Lyrics{Key: key, Text: text, Synced: lrc.Parse(strings.NewReader(text)).Synced}
+	return m.db.Create(&l).Error
+}
+
+func (m *Music) lyrics(key string) (Lyrics, error) {
+	var l Lyrics
+	err := m.db.Where("key = ?", key).First(&l).Error
+	if err != nil {
+		return Lyrics{}, ErrLyricsNotFound
+	}
+	return l, nil
+}
+
+// TrackLyrics returns the parsed lyrics for the track.
+func (m *Music) TrackLyrics(t Track) (lrc.Lyrics, error) {
+	l, err := m.lyrics(t.Key)
+	if err != nil {
+		return lrc.Lyrics{}, err
+	}
+	return lrc.Parse(strings.NewReader(l.Text)), nil
+}
+
+// lyricsText returns the track lyrics without timestamps for indexing.
+func (m *Music) lyricsText(t Track) string {
+	lyrics, err := m.TrackLyrics(t)
+	if err != nil {
+		return ""
+	}
+	return lyrics.Text()
+}
@@ -19,6 +19,8 @@
 package music
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -38,12 +40,19 @@ import (
 	"takeoutfm.dev/takeout/lib/log"
 	"takeoutfm.dev/takeout/lib/musicbrainz"
 	"takeoutfm.dev/takeout/lib/search"
+	"takeoutfm.dev/takeout/lib/setlist"
+	"takeoutfm.dev/takeout/lib/thumb"
 	. "takeoutfm.dev/takeout/model"
 )
 
 const (
 	TakeoutUser    = "takeout"
 	VariousArtists = "Various Artists"
+
+	// Widths for images without sized versions from the source.
+	CoverWidth      = 250
+	ArtistWidth     = 500
+	BackgroundWidth = 1280
 )
 
 var coverCache map[string]string = make(map[string]string)
@@ -53,6 +62,7 @@ type Music struct {
 	db      *gorm.DB
 	buckets []bucket.Bucket
 	lastfm  *lastfm.Lastfm
+	setlist *setlist.Client
 	fanart  *fanart.Fanart
 	mbz     *musicbrainz.MusicBrainz
 	lbz     *listenbrainz.ListenBrainz
@@ -61,11 +71,12 @@ type Music struct {
 func NewMusic(config *config.Config) *Music {
 	client := config.NewGetter()
 	return &Music{
-		config: config,
-		fanart: fanart.NewFanart(config.Fanart, client),
-		lastfm: lastfm.NewLastfm(config.LastFM, client),
-		mbz:    musicbrainz.NewMusicBrainz(client),
-		lbz:    listenbrainz.NewListenBrainz(client),
+		config:  config,
+		fanart:  fanart.NewFanart(config.Fanart, client),
+		lastfm:  lastfm.NewLastfm(config.LastFM, client),
+		mbz:     musicbrainz.NewMusicBrainz(client),
+		lbz:     listenbrainz.NewListenBrainz(client),
+		setlist: setlist.NewClient(config.Setlist, client),
 	}
 }
 
@@ -82,6 +93,9 @@ func (m *Music) Close() {
 }
 
 func Cover(art CoverArt, size string) string {
+	if path := art.LocalArtworkPath(); path != "" {
+		return thumb.URL(LocalCover(path), CoverWidth)
+	}
 	var url string
 	reid, rgid := art.ArtworkMBIDs()
 	if art.HasGroupArtwork() {
@@ -102,6 +116,19 @@ func Cover(art CoverArt, size string) string {
 	}
 }
 
+// URL for local artwork, which is either an image file or an audio file
+// with an embedded picture. The URL uses a key so that filesystem paths
+// aren't exposed.
+func LocalCover(path string) string {
+	return "/img/local/" + LocalArtworkKey(path)
+}
+
+// LocalArtworkKey is the opaque key for the local artwork path.
+func LocalArtworkKey(path string) string {
+	sum := sha256.Sum256([]byte(path))
+	return hex.EncodeToString(sum[:16])
+}
+
 // Get the URL for the release cover from The Cover Art Archive. Use
 // REID front cover.
 //
@@ -142,12 +169,13 @@ func CoverSmall(o interface{}) string {
 // Track cover based on assigned release.
 func TrackCover(t Track, size string) string {
 	// TODO should expire the cache
-	v, ok := coverCache[t.REID]
+	key := t.REID + t.LocalArtwork
+	v, ok := coverCache[key]
 	if ok {
 		return v
 	}
 	v = Cover(t, size)
-	coverCache[t.REID] = v
+	coverCache[key] = v
 	return v
 }
 
@@ -203,6 +231,17 @@ func (m *Music) FindStation(identifier string) (Station, error) {
 	}
 }
 
+func (m *Music) FindSmartPlaylist(user auth.User, identifier string) (SmartPlaylist, error) {
+	id, err := strconv.Atoi(identifier)
+	if err != nil {
+		if strings.HasPrefix(identifier, "name:") {
+			identifier = identifier[5:]
+		}
+		return m.lookupSmartPlaylistName(user, identifier)
+	}
+	return m.LookupSmartPlaylist(user, id)
+}
+
 func (m *Music) FindPlaylist(user auth.User, identifier string) (Playlist, error) {
 	id, err := strconv.Atoi(identifier)
 	if err != nil {
@@ -238,37 +277,97 @@ func (m *Music) FindTracks(identifiers []string) []Track {
 	return m.tracksForRIDs(identifiers)
 }
 
-func (m *Music) newSearch() (search.Searcher, error) {
-	keywords := []string{
+// searchFacets are the facets counted for track search requests.
+var searchFacets = []search.Facet{
+	{Name: FieldGenre, Field: FieldGenre},
+	{Name: FieldType, Field: FieldType},
+	{Name: search.FacetDecade, Field: FieldDate, Decades: true},
+	{Name: FieldArtist, Field: FieldArtist},
+	{Name: FieldRating, Field: FieldRating, Ranges: []float64{1, 2, 3, 4, 5}},
+}
+
+// searchMapping has the fields indexed as keywords and suggest fields.
+var searchMapping = search.Mapping{
+	Keywords: []string{
 		FieldGenre,
 		FieldStatus,
 		FieldTag,
 		FieldType,
-	}
+	},
+	Suggest: []string{
+		FieldArtist,
+		FieldRelease,
+		FieldTitle,
+	},
+}
+
+func (m *Music) newSearch() (search.Searcher, error) {
 	s := m.config.NewSearcher()
-	err := s.Open(m.config.Music.SearchIndexName, keywords)
+	err := s.Open(m.config.Music.SearchIndexName, searchMapping)
 	if err != nil {
 		return nil, err
 	}
 	return s, nil
 }
 
-func (m *Music) Search(q string, limit ...int) []Track {
+// Suggest returns artists, releases and tracks with names matching the
+// prefix, most common first.
+func (m *Music) Suggest(prefix string) (artists []Artist, releases []Release, tracks []Track) {
 	s, err := m.newSearch()
 	if err != nil {
-		return []Track{}
+		return
 	}
 	defer s.Close()
+	names, _ := s.Suggest(prefix, m.config.Music.SuggestArtistLimit, FieldArtist)
+	artists = search.Named(names, m.artistsNamed(names),
+		func(a Artist) string { return a.Name })
+	names, _ = s.Suggest(prefix, m.config.Music.SuggestReleaseLimit, FieldRelease)
+	releases = search.Named(names, m.releasesNamed(names),
+		func(r Release) string { return r.Name })
+	names, _ = s.Suggest(prefix, m.config.Music.SuggestTrackLimit, FieldTitle)
+	var titled []Track
+	for _, t := range m.tracksTitled(names) {
+		if !t.Alternate {
+			titled = append(titled, t)
+		}
+	}
+	tracks = search.Named(names, titled, func(t Track) string { return t.Title })
+	return
+}
 
+func (m *Music) Search(q string, limit ...int) []Track {
 	l := m.config.Music.SearchLimit
 	if len(limit) == 1 {
 		l = limit[0]
 	}
+	tracks, _, _ := m.search(search.Request{Query: q, Limit: l, Advanced: true})
+	return tracks
+}
+
+// SearchRequest searches tracks with the request filters, sort and paging
+// and returns the tracks in result order, the score for each track and the
+// result with counts for the genre, type, decade, artist and rating facets.
+// The limit is at most the configured search limit.
+func (m *Music) SearchRequest(r search.Request) ([]Track, []float64, search.Result) {
+	if r.Limit == 0 || r.Limit > m.config.Music.SearchLimit {
+		r.Limit = m.config.Music.SearchLimit
+	}
+	r.Facets = searchFacets
+	return m.search(r)
+}
+
+func (m *Music) search(r search.Request) ([]Track, []float64, search.Result) {
+	s, err := m.newSearch()
+	if err != nil {
+		return []Track{}, nil, search.Result{}
+	}
+	defer s.Close()
 
-	keys, err := s.Search(q, l)
+	result, err := s.SearchRequest(r)
 	if err != nil {
-		return nil
+		return nil, nil, result
 	}
+	keys := result.Keys()
 
 	// split potentially large # of result keys into chunks to query
 	chunkSize := 100
@@ -282,18 +381,23 @@ func (m *Music) Search(q string, limit ...int) []Track {
 		tracks = append(tracks, m.tracksFor(chunk)...)
 	}
 
-	return tracks
+	tracks, scores := search.Ordered(result.Hits, tracks, func(t Track) string {
+		return t.Key
+	})
+	return tracks, scores, result
 }
 
 const (
 	// rename to Radio* or Station*
-	TypeArtist  = "artist"  // Songs by single artist
-	TypeGenre   = "genre"   // Songs from one or more genres
-	TypeSimilar = "similar" // Songs from similar artists
-	TypePeriod  = "period"  // Songs from one or more time periods
-	TypeSeries  = "series"  // Songs from one or more series (chart)
-	TypeStream  = "stream"  // Internet radio stream
-	TypeOther   = "other"
+	TypeArtist   = "artist"   // Songs by single artist
+	TypeGenre    = "genre"    // Songs from one or more genres
+	TypeSimilar  = "similar"  // Songs from similar artists
+	TypePeriod   = "period"   // Songs from one or more time periods
+	TypeSeries   = "series"   // Songs from one or more series (chart)
+	TypeStream   = "stream"   // Internet radio stream
+	TypeMix      = "mix"      // Daily personal mix for a user
+	TypePlaylist = "playlist" // Snapshot of a user playlist
+	TypeOther    = "other"
 )
 
 func (m *Music) ClearStations() {
@@ -383,6 +487,26 @@ func (m *Music) CreateStations() {
 	}
 }
 
+// CreateMixStation saves a personal mix station with the tracks. The station
+// ref resolves to the saved tracks until the mix is replaced. A station with
+// an ID is updated in place so the ID and ref stay the same.
+func (m *Music) CreateMixStation(s *Station, tracks []Track) error {
+	s.Type = TypeMix
+	var err error
+	if s.ID == 0 {
+		err = m.CreateStation(s)
+		if err != nil {
+			return err
+		}
+	}
+	s.Ref = fmt.Sprintf("/music/mix/%d", s.ID)
+	s.Playlist, err = trackRefPlaylist(s.Name, fmt.Sprintf("/api/stations/%d", s.ID), tracks)
+	if err != nil {
+		return err
+	}
+	return m.UpdateStation(s)
+}
+
 func (m *Music) ArtistRadio(artist Artist) []Track {
 	tracks := m.ArtistSimilar(artist,
 		m.config.Music.ArtistRadioDepth,
@@ -477,7 +601,7 @@ func (m *Music) ArtistImage(artist Artist) string {
 	for _, img := range imgs {
 		if strings.Contains(img, pattern) {
 			parts := strings.Split(img, "/")
-			return fmt.Sprintf("/img/fa/%s/t/%s", artist.ARID, parts[len(parts)-1])
+			return thumb.URL(fmt.Sprintf("/img/fa/%s/t/%s", artist.ARID, parts[len(parts)-1]), ArtistWidth)
 		}
 	}
 	return imgs[0]
@@ -493,7 +617,7 @@ func (m *Music) ArtistBackground(artist Artist) string {
 	for _, img := range imgs {
 		if strings.Contains(img, pattern) {
 			parts := strings.Split(img, "/")
-			return fmt.Sprintf("/img/fa/%s/b/%s", artist.ARID, parts[len(parts)-1])
+			return thumb.URL(fmt.Sprintf("/img/fa/%s/b/%s", artist.ARID, parts[len(parts)-1]), BackgroundWidth)
 		}
 	}
 	return imgs[0]
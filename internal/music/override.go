@@ -0,0 +1,232 @@
+// Copyright 2024 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package music
+
+import (
+	"errors"
+	"strings"
+
+	"takeoutfm.dev/takeout/lib/log"
+	. "takeoutfm.dev/takeout/model"
+)
+
+var (
+	ErrInvalidOverride = errors.New("invalid override")
+)
+
+// ReleaseOverrides returns all release overrides ordered by folder.
+func (m *Music) ReleaseOverrides() []ReleaseOverride {
+	return m.releaseOverrides()
+}
+
+// SaveReleaseOverride creates or replaces the release override for the folder
+// and assigns existing tracks within the folder to the release.
+func (m *Music) SaveReleaseOverride(o *ReleaseOverride) error {
+	o.Folder = strings.TrimSuffix(o.Folder, "/")
+	if o.Folder == "" || (o.REID == "" && o.RGID == "") {
+		return ErrInvalidOverride
+	}
+	tracks := m.folderTracks(o.Folder)
+	if o.REID == "" && len(tracks) > 0 {
+		reid, err := m.findRelease(o.RGID, len(tracks))
+		if err != nil {
+			return err
+		}
+		o.REID = reid
+	}
+	err := m.saveReleaseOverride(o)
+	if err != nil {
+		return err
+	}
+	if len(tracks) == 0 {
+		return nil
+	}
+	_, err = m.applyReleaseOverride(*o)
+	if err != nil {
+		return err
+	}
+
+	artist, err := m.Artist(tracks[0].Artist)
+	if err == nil {
+		artists := []Artist{artist}
+		err = m.fixTrackReleaseTitlesFor(artists)
+		if err != nil {
+			log.Println(err)
+		}
+		err = m.syncIndexFor(artists)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+	return nil
+}
+
+// DeleteReleaseOverride removes the release override. Tracks keep the
+// assigned release until the next full sync.
+func (m *Music) DeleteReleaseOverride(id int) error {
+	return m.deleteReleaseOverride(id)
+}
+
+// TrackOverrides returns all track overrides ordered by key.
+func (m *Music) TrackOverrides() []TrackOverride {
+	return m.trackOverrides()
+}
+
+// SaveTrackOverride creates or replaces the track override for the key and
+// updates the existing track, if any.
+func (m *Music) SaveTrackOverride(o *TrackOverride) error {
+	if o.Key == "" || (o.Title == "" && o.TrackNum == 0 && o.DiscNum == 0) {
+		return ErrInvalidOverride
+	}
+	err := m.saveTrackOverride(o)
+	if err != nil {
+		return err
+	}
+	t, err := m.trackForKey(o.Key)
+	if err == nil {
+		return m.updateTrackOverride(t, *o)
+	}
+	return nil
+}
+
+// DeleteTrackOverride removes the track override. Tracks keep the overridden
+// fields until the next full sync.
+func (m *Music) DeleteTrackOverride(id int) error {
+	return m.deleteTrackOverride(id)
+}
+
+// applyTrackOverride updates the synced track with any overridden fields.
+func (m *Music) applyTrackOverride(t *Track) {
+	o, err := m.trackOverride(t.Key)
+	if err != nil {
+		return
+	}
+	if o.Title != "" {
+		t.Title = o.Title
+	}
+	if o.TrackNum != 0 {
+		t.TrackNum = o.TrackNum
+	}
+	if o.DiscNum != 0 {
+		t.DiscNum = o.DiscNum
+	}
+}
+
+func (m *Music) hasTitleOverride(t Track) bool {
+	o, err := m.trackOverride(t.Key)
+	return err == nil && o.Title != ""
+}
+
+// applyReleaseOverrides assigns the overridden release to tracks within each
+// override folder. This is done before automatic matching so those tracks
+// are skipped.
+func (m *Music) applyReleaseOverrides() (bool, error) {
+	modified := false
+	for _, o := range m.releaseOverrides() {
+		assigned, err := m.applyReleaseOverride(o)
+		if err != nil {
+			log.Printf("override %s: %s\n", o.Folder, err)
+			continue
+		}
+		modified = modified || assigned
+	}
+	return modified, nil
+}
+
+func (m *Music) applyReleaseOverride(o ReleaseOverride) (bool, error) {
+	tracks := m.folderTracks(o.Folder)
+	if len(tracks) == 0 {
+		return false, nil
+	}
+	if o.REID == "" {
+		// resolve the release group once now that tracks exist
+		reid, err := m.findRelease(o.RGID, len(tracks))
+		if err != nil {
+			return false, err
+		}
+		o.REID = reid
+		err = m.saveReleaseOverride(&o)
+		if err != nil {
+			return false, err
+		}
+	}
+	release, err := m.overrideRelease(o, tracks[0].Artist)
+	if err != nil {
+		return false, err
+	}
+	if o.Name != "" && release.Name != o.Name {
+		release.Name = o.Name
+		err = m.updateReleaseName(release)
+		if err != nil {
+			return false, err
+		}
+	}
+	modified := false
+	for _, t := range tracks {
+		if t.REID == release.REID && t.RGID == release.RGID {
+			continue
+		}
+		err = m.assignTrackRelease(t, release)
+		if err != nil {
+			return modified, err
+		}
+		modified = true
+	}
+	return modified, nil
+}
+
+// overrideRelease finds the overridden release, obtaining it from MusicBrainz
+// if it's not one of the synced artist releases.
+func (m *Music) overrideRelease(o ReleaseOverride, artist string) (Release, error) {
+	r, err := m.release(o.REID)
+	if err == nil {
+		return r, nil
+	}
+	mr, err := m.mbz.Release(o.REID)
+	if err != nil {
+		return Release{}, err
+	}
+	if mr.ID == "" {
+		return Release{}, ErrReleaseNotFound
+	}
+	release := doRelease(artist, mr)
+	err = m.syncRelease(release)
+	if err != nil {
+		return Release{}, err
+	}
+	r, err = m.release(o.REID)
+	if err != nil {
+		return Release{}, err
+	}
+	err = m.checkReleaseArtwork(&r)
+	if err != nil {
+		log.Println(err)
+	}
+	return r, nil
+}
+
+// trackReleaseOverride returns the override for the folder containing the
+// track key, if any.
+func trackReleaseOverride(overrides []ReleaseOverride, key string) (ReleaseOverride, bool) {
+	for _, o := range overrides {
+		if strings.HasPrefix(key, o.Folder+"/") {
+			return o, true
+		}
+	}
+	return ReleaseOverride{}, false
+}
@@ -0,0 +1,118 @@
+// Copyright 2023 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package music
+
+import (
+	"errors"
+	"strings"
+
+	"takeoutfm.dev/takeout/lib/setlist"
+	. "takeoutfm.dev/takeout/model"
+)
+
+var (
+	ErrSetlistNotConfigured = errors.New("setlist.fm not configured")
+)
+
+// ArtistSetlists returns the most recent concert setlists for the artist from
+// setlist.fm.
+func (m *Music) ArtistSetlists(a Artist) ([]setlist.Setlist, error) {
+	if m.config.Setlist.ApiKey == "" {
+		return nil, ErrSetlistNotConfigured
+	}
+	page, err := m.setlist.ArtistSetlists(a.ARID, 1)
+	if err != nil {
+		return nil, err
+	}
+	return page.Setlist, nil
+}
+
+// Setlist returns the setlist.fm setlist with the id.
+func (m *Music) Setlist(id string) (setlist.Setlist, error) {
+	if m.config.Setlist.ApiKey == "" {
+		return setlist.Setlist{}, ErrSetlistNotConfigured
+	}
+	sl, err := m.setlist.Setlist(id)
+	if err != nil {
+		return setlist.Setlist{}, err
+	}
+	return *sl, nil
+}
+
+// SetlistTracks matches the setlist songs to local tracks in setlist order.
+// Songs are matched by title to tracks from the performing artist and then
+// the original artist for covers. Songs without a local track are returned as
+// missing.
+func (m *Music) SetlistTracks(sl setlist.Setlist) ([]Track, []setlist.Song) {
+	titles := make(map[string]map[string]Track)
+	artistTitles := func(arid string) map[string]Track {
+		if arid == "" {
+			return nil
+		}
+		if v, ok := titles[arid]; ok {
+			return v
+		}
+		var popular, tracks []Track
+		for _, a := range m.artistsByMBID([]string{arid}) {
+			popular = append(popular, m.ArtistPopularTracks(a)...)
+			tracks = append(tracks, m.artistTracks(a.Name)...)
+		}
+		titles[arid] = setlistTitles(popular, tracks)
+		return titles[arid]
+	}
+
+	var tracks []Track
+	var missing []setlist.Song
+	for _, song := range sl.Songs() {
+		key := setlistKey(song.Name)
+		t, ok := artistTitles(sl.Artist.Mbid)[key]
+		if !ok && song.Cover != nil {
+			t, ok = artistTitles(song.Cover.Mbid)[key]
+		}
+		if ok {
+			tracks = append(tracks, t)
+		} else {
+			missing = append(missing, song)
+		}
+	}
+	return tracks, missing
+}
+
+// setlistTitles maps each title to the best track with that title. Popular
+// tracks are preferred in rank order, otherwise the earliest release is used.
+func setlistTitles(popular, tracks []Track) map[string]Track {
+	result := make(map[string]Track)
+	for _, t := range tracks {
+		if t.Alternate {
+			continue
+		}
+		key := setlistKey(t.Title)
+		if v, ok := result[key]; !ok || t.Date < v.Date {
+			result[key] = t
+		}
+	}
+	// popular is in rank order so the first track for each title is used
+	for i := len(popular) - 1; i >= 0; i-- {
+		result[setlistKey(popular[i].Title)] = popular[i]
+	}
+	return result
+}
+
+func setlistKey(title string) string {
+	return strings.ToLower(FuzzyName(fixName(title)))
+}
@@ -0,0 +1,108 @@
+// Copyright 2023 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package music
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"takeoutfm.dev/takeout/lib/setlist"
+	"takeoutfm.dev/takeout/model"
+)
+
+func TestSetlistTitles(t *testing.T) {
+	tracks := []model.Track{
+		{Title: "Fear of the Dark", Release: "A Real Live One", Date: "1993-03-22"},
+		{Title: "Fear of the Dark", Release: "Fear of the Dark", Date: "1992-05-11"},
+		{Title: "Fear of the Dark", Release: "Best Of", Date: "1990-01-01", Alternate: true},
+		{Title: "Churchill’s Speech", Release: "Live After Death", Date: "1985-10-14"},
+	}
+	titles := setlistTitles(nil, tracks)
+	if v, ok := titles[setlistKey("fear of the dark")]; !ok || v.Release != "Fear of the Dark" {
+		t.Error("expect earliest release", v)
+	}
+	if _, ok := titles[setlistKey("Churchill's Speech")]; !ok {
+		t.Error("expect title match with different quote")
+	}
+	if _, ok := titles[setlistKey("The Trooper")]; ok {
+		t.Error("expect no match")
+	}
+}
+
+func TestSetlistTitlesPopular(t *testing.T) {
+	tracks := []model.Track{
+		{Title: "Heroes", Release: "Heroes", Date: "1977-10-14"},
+		{Title: "Heroes", Release: "Stage", Date: "1978-09-08"},
+		{Title: "Heroes", Release: "Best of Bowie", Date: "2002-11-04"},
+	}
+	popular := []model.Track{tracks[2], tracks[1]}
+	titles := setlistTitles(popular, tracks)
+	if v := titles[setlistKey("Heroes")]; v.Release != "Best of Bowie" {
+		t.Error("expect popular release", v)
+	}
+}
+
+func TestSetlistTracks(t *testing.T) {
+	m := makeMusic(t)
+
+	data, err := os.ReadFile("../../lib/setlist/test/setlist_23be88f3.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sl setlist.Setlist
+	err = json.Unmarshal(data, &sl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	artist := model.Artist{Name: "Iron Maiden", SortName: "Iron Maiden", ARID: sl.Artist.Mbid}
+	err = m.createArtist(&artist)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracks := []model.Track{
+		{Artist: "Iron Maiden", Release: "Senjutsu", Date: "2021-09-03", Title: "Senjutsu", Key: "setlist/1"},
+		{Artist: "Iron Maiden", Release: "A Real Live One", Date: "1993-03-22", Title: "Fear of the Dark", Key: "setlist/2"},
+		{Artist: "Iron Maiden", Release: "Fear of the Dark", Date: "1992-05-11", Title: "Fear of the Dark", Key: "setlist/3"},
+		{Artist: "Iron Maiden", Release: "Piece of Mind", Date: "1983-05-16", Title: "The Trooper", Key: "setlist/4"},
+	}
+	for i := range tracks {
+		err = m.createTrack(&tracks[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	matched, missing := m.SetlistTracks(sl)
+	if len(matched) != 3 {
+		t.Fatal("expect matched tracks", len(matched))
+	}
+	if matched[0].Title != "Senjutsu" || matched[1].Release != "Fear of the Dark" ||
+		matched[2].Title != "The Trooper" {
+		t.Error("expect tracks in setlist order", matched)
+	}
+	if len(matched)+len(missing) != len(sl.Songs()) {
+		t.Error("expect missing songs", len(missing))
+	}
+	for _, song := range missing {
+		if song.Name == "Senjutsu" || song.Name == "The Trooper" {
+			t.Error("expect song matched", song.Name)
+		}
+	}
+}
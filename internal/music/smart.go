@@ -0,0 +1,550 @@
+// Copyright 2023 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package music
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"takeoutfm.dev/takeout/lib/date"
+	. "takeoutfm.dev/takeout/model"
+	"takeoutfm.dev/takeout/spiff"
+)
+
+const (
+	SmartMatchAll = "all"
+	SmartMatchAny = "any"
+
+	SmartFieldGenre       = "genre"
+	SmartFieldArtist      = "artist"
+	SmartFieldRelease     = "release"
+	SmartFieldTitle       = "title"
+	SmartFieldType        = "type"
+	SmartFieldDate        = "date"
+	SmartFieldAdded       = "added"
+	SmartFieldPopularity  = "popularity"
+	SmartFieldPlays       = "plays"
+	SmartFieldLastPlayed  = "lastplayed"
+	SmartFieldNeverPlayed = "neverplayed"
+	SmartFieldSkips       = "skips"
+	SmartFieldRandom      = "random" // sort only
+
+	SmartOpIs       = "is"
+	SmartOpNot      = "not"
+	SmartOpContains = "contains"
+	SmartOpLess     = "lt"
+	SmartOpGreater  = "gt"
+	SmartOpBefore   = "before"
+	SmartOpAfter    = "after"
+	SmartOpWithin   = "within" // duration like 30d or 72h
+
+	SmartOrderAsc  = "asc"
+	SmartOrderDesc = "desc"
+
+	SmartRefreshAccess   = "access"
+	SmartRefreshSchedule = "schedule"
+
+	SmartMaxDepth = 5 // nested all/any rules
+)
+
+var (
+	ErrInvalidSmartRule    = errors.New("invalid smart playlist rule")
+	ErrInvalidSmartSort    = errors.New("invalid smart playlist sort")
+	ErrInvalidSmartRefresh = errors.New("invalid smart playlist refresh")
+)
+
+var smartFieldOps = map[string][]string{
+	SmartFieldGenre:       {SmartOpIs, SmartOpNot, SmartOpContains},
+	SmartFieldArtist:      {SmartOpIs, SmartOpNot, SmartOpContains},
+	SmartFieldRelease:     {SmartOpIs, SmartOpNot, SmartOpContains},
+	SmartFieldTitle:       {SmartOpIs, SmartOpNot, SmartOpContains},
+	SmartFieldType:        {SmartOpIs, SmartOpNot},
+	SmartFieldDate:        {SmartOpIs, SmartOpBefore, SmartOpAfter},
+	SmartFieldAdded:       {SmartOpBefore, SmartOpAfter, SmartOpWithin},
+	SmartFieldPopularity:  {SmartOpIs, SmartOpLess, SmartOpGreater},
+	SmartFieldPlays:       {SmartOpIs, SmartOpLess, SmartOpGreater},
+	SmartFieldLastPlayed:  {SmartOpBefore, SmartOpAfter, SmartOpWithin},
+	SmartFieldNeverPlayed: {SmartOpIs},
+	SmartFieldSkips:       {SmartOpIs, SmartOpLess, SmartOpGreater},
+}
+
+// smartTrack is a track with the release, artist and user activity fields
+// needed to evaluate smart playlist rules.
+type smartTrack struct {
+	Track
+	genre string
+	rtype string
+	rank  int // 0 if not popular
+	plays TrackPlays
+}
+
+// ValidateSmartPlaylist checks the rules, sort and refresh of the smart
+// playlist and assigns defaults.
+func ValidateSmartPlaylist(p *SmartPlaylist) error {
+	if p.Refresh == "" {
+		p.Refresh = SmartRefreshAccess
+	}
+	if p.Refresh != SmartRefreshAccess && p.Refresh != SmartRefreshSchedule {
+		return ErrInvalidSmartRefresh
+	}
+	if p.Order == "" {
+		p.Order = SmartOrderAsc
+	}
+	if p.Order != SmartOrderAsc && p.Order != SmartOrderDesc {
+		return ErrInvalidSmartSort
+	}
+	if p.Sort != "" && p.Sort != SmartFieldRandom {
+		if _, ok := smartFieldOps[p.Sort]; !ok || p.Sort == SmartFieldNeverPlayed {
+			return ErrInvalidSmartSort
+		}
+	}
+	if p.Limit < 0 {
+		return ErrInvalidSmartRule
+	}
+	return validateSmartRule(p.Rule, 0)
+}
+
+func validateSmartRule(rule SmartRule, depth int) error {
+	if rule.Match != "" {
+		if rule.Match != SmartMatchAll && rule.Match != SmartMatchAny {
+			return ErrInvalidSmartRule
+		}
+		if rule.Field != "" || depth >= SmartMaxDepth {
+			return ErrInvalidSmartRule
+		}
+		for _, r := range rule.Rules {
+			if err := validateSmartRule(r, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	ops, ok := smartFieldOps[rule.Field]
+	if !ok {
+		return ErrInvalidSmartRule
+	}
+	valid := false
+	for _, op := range ops {
+		if op == rule.Op {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return ErrInvalidSmartRule
+	}
+	switch rule.Field {
+	case SmartFieldPopularity, SmartFieldPlays, SmartFieldSkips:
+		if _, err := strconv.Atoi(rule.Value); err != nil {
+			return ErrInvalidSmartRule
+		}
+	case SmartFieldNeverPlayed:
+		if _, err := strconv.ParseBool(rule.Value); err != nil {
+			return ErrInvalidSmartRule
+		}
+	case SmartFieldDate, SmartFieldAdded, SmartFieldLastPlayed:
+		if rule.Op == SmartOpWithin {
+			if _, err := parseAge(rule.Value); err != nil {
+				return ErrInvalidSmartRule
+			}
+		} else if date.ParseDate(rule.Value) == date.DayZero() {
+			return ErrInvalidSmartRule
+		}
+	}
+	return nil
+}
+
+// parseAge parses a duration with support for a days suffix, like 30d.
+func parseAge(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+func matchString(op, have, want string) bool {
+	have, want = strings.ToLower(have), strings.ToLower(want)
+	switch op {
+	case SmartOpIs:
+		return have == want
+	case SmartOpNot:
+		return have != want
+	case SmartOpContains:
+		return strings.Contains(have, want)
+	}
+	return false
+}
+
+func matchInt(op string, have int, value string) bool {
+	want, _ := strconv.Atoi(value)
+	switch op {
+	case SmartOpIs:
+		return have == want
+	case SmartOpLess:
+		return have < want
+	case SmartOpGreater:
+		return have > want
+	}
+	return false
+}
+
+func matchTime(op string, have time.Time, value string, now time.Time) bool {
+	if have.IsZero() {
+		return false
+	}
+	switch op {
+	case SmartOpIs:
+		// date values match the year, month or day given
+		want := date.ParseDate(value)
+		switch strings.Count(value, "-") {
+		case 0:
+			return have.Year() == want.Year()
+		case 1:
+			return have.Year() == want.Year() && have.Month() == want.Month()
+		default:
+			return date.YMD(have) == date.YMD(want)
+		}
+	case SmartOpBefore:
+		return have.Before(date.ParseDate(value))
+	case SmartOpAfter:
+		return have.After(date.ParseDate(value))
+	case SmartOpWithin:
+		age, _ := parseAge(value)
+		return have.After(now.Add(-age))
+	}
+	return false
+}
+
+func (t smartTrack) match(rule SmartRule, now time.Time) bool {
+	switch rule.Match {
+	case SmartMatchAll:
+		for _, r := range rule.Rules {
+			if !t.match(r, now) {
+				return false
+			}
+		}
+		return true
+	case SmartMatchAny:
+		for _, r := range rule.Rules {
+			if t.match(r, now) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch rule.Field {
+	case SmartFieldGenre:
+		return matchString(rule.Op, t.genre, rule.Value)
+	case SmartFieldArtist:
+		return matchString(rule.Op, t.Artist, rule.Value)
+	case SmartFieldRelease:
+		return matchString(rule.Op, t.ReleaseTitle, rule.Value)
+	case SmartFieldTitle:
+		return matchString(rule.Op, t.Title, rule.Value)
+	case SmartFieldType:
+		return matchString(rule.Op, t.rtype, rule.Value)
+	case SmartFieldDate:
+		return matchTime(rule.Op, t.ReleaseDate, rule.Value, now)
+	case SmartFieldAdded:
+		return matchTime(rule.Op, t.CreatedAt, rule.Value, now)
+	case SmartFieldPopularity:
+		// tracks without a rank are never popular
+		return t.rank > 0 && matchInt(rule.Op, t.rank, rule.Value)
+	case SmartFieldPlays:
+		return matchInt(rule.Op, t.plays.Count, rule.Value)
+	case SmartFieldLastPlayed:
+		return matchTime(rule.Op, t.plays.Last, rule.Value, now)
+	case SmartFieldNeverPlayed:
+		never, _ := strconv.ParseBool(rule.Value)
+		return (t.plays.Count == 0) == never
+	case SmartFieldSkips:
+		return matchInt(rule.Op, t.plays.Skips, rule.Value)
+	}
+	return false
+}
+
+func smartLess(field string, a, b smartTrack) bool {
+	switch field {
+	case SmartFieldGenre:
+		return a.genre < b.genre
+	case SmartFieldArtist:
+		return a.Artist < b.Artist
+	case SmartFieldRelease:
+		return a.ReleaseTitle < b.ReleaseTitle
+	case SmartFieldTitle:
+		return a.Title < b.Title
+	case SmartFieldType:
+		return a.rtype < b.rtype
+	case SmartFieldDate:
+		return a.ReleaseDate.Before(b.ReleaseDate)
+	case SmartFieldAdded:
+		return a.CreatedAt.Before(b.CreatedAt)
+	case SmartFieldPopularity:
+		// unranked tracks are least popular
+		if a.rank == 0 || b.rank == 0 {
+			return a.rank != 0
+		}
+		return a.rank < b.rank
+	case SmartFieldPlays:
+		return a.plays.Count < b.plays.Count
+	case SmartFieldLastPlayed:
+		return a.plays.Last.Before(b.plays.Last)
+	case SmartFieldSkips:
+		return a.plays.Skips < b.plays.Skips
+	}
+	return false
+}
+
+// smartUses returns true if the smart playlist rules or sort use any of the
+// fields.
+func smartUses(p SmartPlaylist, fields ...string) bool {
+	var uses func(rule SmartRule) bool
+	uses = func(rule SmartRule) bool {
+		for _, r := range rule.Rules {
+			if uses(r) {
+				return true
+			}
+		}
+		return slices.Contains(fields, rule.Field)
+	}
+	return slices.Contains(fields, p.Sort) || uses(p.Rule)
+}
+
+// SmartPlaylistActivity returns true if the smart playlist needs the user
+// listening activity, otherwise plays can be nil.
+func SmartPlaylistActivity(p SmartPlaylist) bool {
+	return smartUses(p, SmartFieldPlays, SmartFieldLastPlayed,
+		SmartFieldNeverPlayed, SmartFieldSkips)
+}
+
+// Smart rule columns for fields from other tables.
+const (
+	smartGenreColumn = "coalesce((select genre from artists where artists.name = tracks.artist limit 1), '')"
+	smartTypeColumn  = "coalesce((select type from releases where releases.re_id = tracks.re_id limit 1), '')"
+)
+
+// smartCondition converts the rule to a SQL condition that selects at least
+// the tracks matched by the rule so most tracks can be skipped before rules
+// are evaluated. Rules for popularity and activity can't be converted and
+// neither can all/any rules that depend on them, in which case ok is false.
+func smartCondition(rule SmartRule, now time.Time) (query string, args []interface{}, ok bool) {
+	switch rule.Match {
+	case SmartMatchAll:
+		var conds []string
+		for _, r := range rule.Rules {
+			q, a, ok := smartCondition(r, now)
+			if ok {
+				conds = append(conds, "("+q+")")
+				args = append(args, a...)
+			}
+		}
+		if len(conds) == 0 {
+			return "", nil, false
+		}
+		return strings.Join(conds, " and "), args, true
+	case SmartMatchAny:
+		if len(rule.Rules) == 0 {
+			return "1 = 0", nil, true
+		}
+		var conds []string
+		for _, r := range rule.Rules {
+			q, a, ok := smartCondition(r, now)
+			if !ok {
+				return "", nil, false
+			}
+			conds = append(conds, "("+q+")")
+			args = append(args, a...)
+		}
+		return strings.Join(conds, " or "), args, true
+	}
+
+	switch rule.Field {
+	case SmartFieldGenre:
+		return stringCondition(smartGenreColumn, rule)
+	case SmartFieldArtist:
+		return stringCondition("tracks.artist", rule)
+	case SmartFieldRelease:
+		return stringCondition("tracks.release_title", rule)
+	case SmartFieldTitle:
+		return stringCondition("tracks.title", rule)
+	case SmartFieldType:
+		return stringCondition(smartTypeColumn, rule)
+	case SmartFieldDate:
+		return timeCondition("tracks.release_date", rule, now)
+	case SmartFieldAdded:
+		return timeCondition("tracks.created_at", rule, now)
+	}
+	return "", nil, false
+}
+
+func stringCondition(column string, rule SmartRule) (string, []interface{}, bool) {
+	value := strings.ToLower(rule.Value)
+	for _, c := range value {
+		if c > unicode.MaxASCII {
+			// sqlite lower only supports ascii
+			return "", nil, false
+		}
+	}
+	switch rule.Op {
+	case SmartOpIs:
+		return "lower(" + column + ") = ?", []interface{}{value}, true
+	case SmartOpNot:
+		return "lower(" + column + ") <> ?", []interface{}{value}, true
+	case SmartOpContains:
+		return "instr(lower(" + column + "), ?) > 0", []interface{}{value}, true
+	}
+	return "", nil, false
+}
+
+// timeCondition allows an extra day before and after since times can be
+// stored with a different zone.
+func timeCondition(column string, rule SmartRule, now time.Time) (string, []interface{}, bool) {
+	const day = 24 * time.Hour
+	switch rule.Op {
+	case SmartOpIs:
+		start := date.ParseDate(rule.Value)
+		var end time.Time
+		switch strings.Count(rule.Value, "-") {
+		case 0:
+			end = start.AddDate(1, 0, 0)
+		case 1:
+			end = start.AddDate(0, 1, 0)
+		default:
+			end = start.AddDate(0, 0, 1)
+		}
+		return column + " > ? and " + column + " < ?",
+			[]interface{}{start.Add(-day), end.Add(day)}, true
+	case SmartOpBefore:
+		return column + " < ?", []interface{}{date.ParseDate(rule.Value).Add(day)}, true
+	case SmartOpAfter:
+		return column + " > ?", []interface{}{date.ParseDate(rule.Value).Add(-day)}, true
+	case SmartOpWithin:
+		age, _ := parseAge(rule.Value)
+		return column + " > ?", []interface{}{now.Add(-age - day)}, true
+	}
+	return "", nil, false
+}
+
+// smartTracks returns preferred tracks that may match the rules with the
+// fields needed to evaluate rules for the user listening activity.
+func (m *Music) smartTracks(p SmartPlaylist, plays map[string]TrackPlays, now time.Time) []smartTrack {
+	var ranks map[string]int
+	if smartUses(p, SmartFieldPopularity) {
+		ranks = m.popularRanks()
+	}
+	query, args, _ := smartCondition(p.Rule, now)
+	rows := m.smartRows(query, args)
+	list := make([]smartTrack, len(rows))
+	for i, r := range rows {
+		list[i] = smartTrack{
+			Track: r.Track,
+			genre: r.Genre,
+			rtype: r.RType,
+			rank:  ranks[strings.ToLower(r.Artist+"/"+r.Title)],
+			plays: plays[r.RID],
+		}
+	}
+	return list
+}
+
+// SmartPlaylistTracks evaluates the smart playlist rules using the provided
+// listening activity and returns the sorted and limited tracks.
+func (m *Music) SmartPlaylistTracks(p SmartPlaylist, plays map[string]TrackPlays) []Track {
+	now := time.Now()
+	var matched []smartTrack
+	for _, t := range m.smartTracks(p, plays, now) {
+		if t.match(p.Rule, now) {
+			matched = append(matched, t)
+		}
+	}
+
+	switch p.Sort {
+	case "":
+	case SmartFieldRandom:
+		rand.Shuffle(len(matched), func(i, j int) {
+			matched[i], matched[j] = matched[j], matched[i]
+		})
+	default:
+		sort.SliceStable(matched, func(i, j int) bool {
+			if p.Order == SmartOrderDesc {
+				return smartLess(p.Sort, matched[j], matched[i])
+			}
+			return smartLess(p.Sort, matched[i], matched[j])
+		})
+	}
+
+	if p.Limit > 0 && len(matched) > p.Limit {
+		matched = matched[:p.Limit]
+	}
+	tracks := make([]Track, len(matched))
+	for i := range matched {
+		tracks[i] = matched[i].Track
+	}
+	return tracks
+}
+
+// trackRefPlaylist creates a playlist with a track ref for each track. Refs
+// are resolved when the playlist is played.
+func trackRefPlaylist(title, location string, tracks []Track) ([]byte, error) {
+	plist := spiff.NewPlaylist(spiff.TypeMusic)
+	plist.Spiff.Title = title
+	plist.Spiff.Location = location
+	plist.Spiff.Entries = make([]spiff.Entry, len(tracks))
+	for i, t := range tracks {
+		plist.Spiff.Entries[i] = spiff.Entry{Ref: fmt.Sprintf("/music/tracks/%d", t.ID)}
+	}
+	return plist.Marshal()
+}
+
+// RefreshSmartPlaylist evaluates the smart playlist and saves the result as
+// a playlist of track refs which are resolved when played.
+func (m *Music) RefreshSmartPlaylist(p *SmartPlaylist, plays map[string]TrackPlays) error {
+	tracks := m.SmartPlaylistTracks(*p, plays)
+	data, err := trackRefPlaylist(p.Name, fmt.Sprintf("/api/smart/%d/playlist", p.ID), tracks)
+	if err != nil {
+		return err
+	}
+	p.Playlist = data
+	p.TrackCount = len(tracks)
+	p.Refreshed = time.Now()
+	return m.UpdateSmartPlaylist(p)
+}
+
+// ScheduledSmartPlaylists returns smart playlists for all users that are
+// periodically rebuilt.
+func (m *Music) ScheduledSmartPlaylists() []SmartPlaylist {
+	var list []SmartPlaylist
+	for _, p := range m.allSmartPlaylists() {
+		if p.Refresh == SmartRefreshSchedule {
+			list = append(list, p)
+		}
+	}
+	return list
+}
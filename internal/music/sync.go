@@ -25,6 +25,7 @@ import (
 	"strings"
 	"time"
 
+	"takeoutfm.dev/takeout/lib/bucket"
 	"takeoutfm.dev/takeout/lib/client"
 	"takeoutfm.dev/takeout/lib/date"
 	"takeoutfm.dev/takeout/lib/log"
@@ -44,6 +45,10 @@ type SyncOptions struct {
 	Artwork  bool
 	Artist   string
 	Resolve  bool
+
+	// Dirs limits the sync to all tracks within the directories of local
+	// buckets, regardless of modified time.
+	Dirs []string
 }
 
 func NewSyncOptions() SyncOptions {
@@ -77,7 +82,7 @@ func (m *Music) LastModified() time.Time {
 }
 
 func (m *Music) Sync(options SyncOptions) {
-	if options.Since.IsZero() {
+	if options.Since.IsZero() && len(options.Dirs) == 0 {
 		if options.Tracks {
 			log.Printf("sync tracks\n")
 			log.CheckError(m.syncBucketTracks())
@@ -87,8 +92,11 @@ func (m *Music) Sync(options SyncOptions) {
 		if options.Releases {
 			log.Printf("sync releases\n")
 			log.CheckError(m.syncReleases())
+			log.Printf("apply release overrides\n")
+			_, err := m.applyReleaseOverrides()
+			log.CheckError(err)
 			log.Printf("fix track releases\n")
-			_, err := m.fixTrackReleases()
+			_, err = m.fixTrackReleases()
 			log.CheckError(err)
 			log.Printf("assign track releases\n")
 			_, err = m.assignTrackReleases()
@@ -98,6 +106,12 @@ func (m *Music) Sync(options SyncOptions) {
 			log.CheckError(err)
 			log.Printf("fix track release titles\n")
 			log.CheckError(m.fixTrackReleaseTitles())
+			log.Printf("sync track lengths\n")
+			log.CheckError(m.syncTrackLengths())
+			log.Printf("sync duplicates\n")
+			log.CheckError(m.syncDuplicates())
+			log.Printf("sync local artwork\n")
+			log.CheckError(m.syncLocalArtwork())
 		}
 		if options.Popular {
 			log.Printf("sync popular\n")
@@ -116,20 +130,31 @@ func (m *Music) Sync(options SyncOptions) {
 			log.CheckError(m.syncIndex())
 		}
 	} else {
+		start := time.Now()
 		if options.Resolve {
 			log.Printf("resolving")
 			err := m.resolve()
 			log.CheckError(err)
 		}
+		var synced []Track
 		if options.Tracks {
-			modified, err := m.syncBucketTracksSince(options.Since)
+			var modified bool
+			var err error
+			if len(options.Dirs) > 0 {
+				synced, err = m.syncBucketDirs(options.Dirs)
+				modified = len(synced) > 0
+			} else {
+				modified, err = m.syncBucketTracksSince(options.Since)
+			}
 			log.CheckError(err)
 			if modified {
 				log.CheckError(m.syncArtists())
 			}
 		}
 		var artists []Artist
-		if options.Artist != "" {
+		if len(options.Dirs) > 0 {
+			artists = m.tracksArtists(synced)
+		} else if options.Artist != "" {
 			a, err := m.Artist(options.Artist)
 			if err == nil {
 				artists = []Artist{a}
@@ -143,15 +168,34 @@ func (m *Music) Sync(options SyncOptions) {
 		}
 		if options.Releases {
 			log.CheckError(m.syncReleasesFor(artists))
-			_, err := m.fixTrackReleases()
+			overridden, err := m.applyReleaseOverrides()
+			log.CheckError(err)
+			_, err = m.fixTrackReleases()
 			log.CheckError(err)
 			modified, err := m.assignTrackReleases()
 			log.CheckError(err)
-			if modified {
+			if modified || overridden {
 				_, err = m.assignTrackReleaseDates()
 				log.CheckError(err)
 				log.CheckError(m.fixTrackReleaseTitles())
 			}
+			// reload since titles and releases may have changed
+			var tracks []Track
+			if len(options.Dirs) > 0 {
+				ids := make([]int, len(synced))
+				for i, t := range synced {
+					ids[i] = int(t.ID)
+				}
+				tracks = m.LookupTracks(ids)
+			} else {
+				tracks = m.tracksAddedSince(options.Since)
+			}
+			log.CheckError(m.syncDuplicatesFor(tracks))
+			// new covers may be in directories with existing tracks
+			for _, art := range m.localArtworkSince(start) {
+				tracks = append(tracks, m.folderTracks(art.Dir)...)
+			}
+			log.CheckError(m.syncLocalArtworkFor(tracks))
 		}
 		if options.Popular {
 			log.CheckError(m.syncPopularFor(artists))
@@ -195,13 +239,15 @@ func (m *Music) Sync(options SyncOptions) {
 
 func (m *Music) syncBucketTracks() error {
 	m.deleteTracks() // !!!
+	m.deleteLocalArtwork()
 	_, err := m.syncBucketTracksSince(time.Time{})
 	return err
 }
 
 func (m *Music) syncBucketTracksSince(lastSync time.Time) (modified bool, err error) {
-	for _, b := range m.buckets {
-		trackCh, err := m.syncFromBucket(b, lastSync)
+	for i, b := range m.buckets {
+		var covers []*bucket.Object
+		trackCh, err := m.syncFromBucket(b, lastSync, &covers)
 		if err != nil {
 			log.Printf("got sync err %s\n", err)
 			return false, err
@@ -212,16 +258,94 @@ func (m *Music) syncBucketTracksSince(lastSync time.Time) (modified bool, err er
 			t.Release = fixName(t.Release)
 			t.Title = fixName(t.Title)
 			// TODO: title may have underscores - picard
+			m.applyTrackOverride(t)
+			t.Bucket = i
 			m.createTrack(t)
+			// lyrics may have been removed from the track
+			log.CheckError(m.updateLyrics(t.Key, t.Lyrics))
 			modified = true
 		}
+		err = m.updateCovers(i, covers)
+		if err != nil {
+			return modified, err
+		}
 		err = m.updateTrackCount()
 	}
 	return
 }
 
+// syncBucketDirs syncs all tracks within the directories so files moved in
+// with older modified times are found. Existing tracks are updated when the
+// file has changed and tracks for files no longer present are removed. The
+// added and updated tracks are returned.
+func (m *Music) syncBucketDirs(dirs []string) (synced []Track, err error) {
+	for i, b := range m.buckets {
+		l, ok := b.(bucket.DirLister)
+		if !ok {
+			continue
+		}
+		for _, dir := range dirs {
+			objectCh, err := l.ListDir(dir)
+			if err == bucket.ErrNotInBucket {
+				continue
+			} else if err != nil {
+				return synced, err
+			}
+			existing := make(map[string]Track)
+			for _, t := range m.folderTracks(dir) {
+				existing[t.Key] = t
+			}
+			var covers []*bucket.Object
+			for t := range m.checkObjects(b, objectCh, &covers) {
+				t.Artist = fixName(t.Artist)
+				t.Release = fixName(t.Release)
+				t.Title = fixName(t.Title)
+				m.applyTrackOverride(t)
+				t.Bucket = i
+				e, ok := existing[t.Key]
+				delete(existing, t.Key)
+				if ok && e.ETag == t.ETag {
+					// unchanged
+					continue
+				}
+				if ok {
+					// keep the same track id and uuid
+					t.Model = e.Model
+					t.UUID = e.UUID
+					err = m.db.Save(t).Error
+				} else {
+					err = m.createTrack(t)
+				}
+				if err != nil {
+					return synced, err
+				}
+				log.CheckError(m.updateLyrics(t.Key, t.Lyrics))
+				synced = append(synced, *t)
+			}
+			for _, t := range existing {
+				// file was removed or moved out
+				log.Printf("remove %s\n", t.Key)
+				err = m.db.Unscoped().Delete(&t).Error
+				if err != nil {
+					return synced, err
+				}
+				log.CheckError(m.updateLyrics(t.Key, ""))
+			}
+			err = m.updateCovers(i, covers)
+			if err != nil {
+				return synced, err
+			}
+		}
+	}
+	return synced, m.updateTrackCount()
+}
+
 func (m *Music) trackArtistsSince(lastSync time.Time) []Artist {
-	tracks := m.tracksAddedSince(lastSync)
+	return m.tracksArtists(m.tracksAddedSince(lastSync))
+}
+
+// tracksArtists returns the artist for each of the tracks.
+func (m *Music) tracksArtists(tracks []Track) []Artist {
 	var artists []Artist
 	h := make(map[string]bool)
 	for _, t := range tracks {
@@ -282,6 +406,9 @@ func (m *Music) syncRelease(r Release) error {
 	for i := range r.Media {
 		r.Media[i].Name = fixName(r.Media[i].Name)
 	}
+	if o, err := m.releaseOverrideFor(r.REID); err == nil && o.Name != "" {
+		r.Name = o.Name
+	}
 
 	curr, err := m.release(r.REID)
 	if err != nil {
@@ -307,7 +434,7 @@ func (m *Music) syncRelease(r Release) error {
 			return err
 		}
 		// update any assigned tracks
-		tracks := m.ReleaseTracks(r)
+		tracks := m.releaseTracks(r)
 		for _, t := range tracks {
 			m.assignTrackRelease(t, r)
 		}
@@ -754,10 +881,16 @@ func (m *Music) fixTrackReleases() (bool, error) {
 	var fixTracks []map[string]interface{}
 	//tracks := m.tracksWithoutReleases()
 	tracks := m.tracksWithoutAssignedRelease()
+	overrides := m.releaseOverrides()
 
 	for _, t := range tracks {
 		key := fmt.Sprintf("%s/%s/%s", t.Artist, t.Release, t.Date)
 
+		if _, ok := trackReleaseOverride(overrides, t.Key); ok {
+			// keep names for overridden releases
+			continue
+		}
+
 		artist, err := m.Artist(t.Artist)
 		if err != nil {
 			log.Printf("artist not found: %s\n", t.Artist)
@@ -845,6 +978,36 @@ func (m *Music) fixTrackReleases() (bool, error) {
 // Generate a ReleaseTitle for each track which in most cases will be the
 // release name. In multi-disc sets the individual media may have a more
 // specific name so that is included also.
+// syncTrackLengths assigns recording lengths to tracks without one, such as
+// tracks synced before lengths were stored, rather than waiting for the next
+// index.
+func (m *Music) syncTrackLengths() error {
+	for _, reid := range m.trackLengthReleases() {
+		indices, err := m.creditsIndex(reid)
+		if err != nil {
+			log.Printf("track lengths for %s: %s\n", reid, err)
+			continue
+		}
+		tracks := m.releaseTracks(Release{REID: reid})
+		for _, index := range indices {
+			if index.Length == 0 {
+				continue
+			}
+			for _, t := range tracks {
+				if t.Length == 0 &&
+					t.DiscNum == index.DiscNum &&
+					t.TrackNum == index.TrackNum {
+					err := m.updateTrackLength(t, index.Length)
+					if err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
 func (m *Music) fixTrackReleaseTitles() error {
 	artists := m.Artists()
 	return m.fixTrackReleaseTitlesFor(artists)
@@ -861,7 +1024,7 @@ func (m *Music) fixTrackReleaseTitlesFor(artists []Artist) error {
 				names[media[i].Position] = media[i]
 			}
 
-			tracks := m.ReleaseTracks(r)
+			tracks := m.releaseTracks(r)
 			for i := range tracks {
 				var mediaTitle, releaseTitle string
 				name := names[tracks[i].DiscNum].Name
@@ -1236,7 +1399,7 @@ func (m *Music) findRelease(rgid string, trackCount int) (string, error) {
 
 func (m *Music) releaseIndex(release Release) (search.IndexMap, error) {
 	var err error
-	tracks := m.ReleaseTracks(release)
+	tracks := m.releaseTracks(release)
 
 	reid := release.REID
 	if reid == "" {
@@ -1258,14 +1421,22 @@ func (m *Music) releaseIndex(release Release) (search.IndexMap, error) {
 		for _, t := range tracks {
 			if t.DiscNum == index.DiscNum &&
 				t.TrackNum == index.TrackNum {
-				// use track key
-				newIndex[t.Key] = index.Fields
-				if t.Title != index.Title {
+				// use track key; alternates aren't indexed
+				if !t.Alternate {
+					newIndex[t.Key] = index.Fields
+					if lyrics := m.lyricsText(t); lyrics != "" {
+						addField(index.Fields, FieldLyrics, lyrics)
+					}
+				}
+				if t.Title != index.Title && !m.hasTitleOverride(t) {
 					m.updateTrackTitle(t, index.Title)
 				}
 				if index.RID != "" {
 					m.updateTrackRID(t, index.RID)
 				}
+				if index.Length > 0 && t.Length != index.Length {
+					m.updateTrackLength(t, index.Length)
+				}
 				if index.Artist != "" {
 					m.assignTrackArtist(t, index.Artist)
 				}
@@ -1364,6 +1535,28 @@ func (m *Music) syncIndex() error {
 	return m.syncIndexFor(artists)
 }
 
+// Reindex rebuilds the search index when it was created with an older
+// mapping version. The index is migrated from its stored fields, or all
+// artists are indexed again when that fails.
+func (m *Music) Reindex() error {
+	name := m.config.Music.SearchIndexName
+	outdated, err := search.Outdated(m.config.Search, name)
+	if err != nil || !outdated {
+		return err
+	}
+	log.Printf("reindex %s\n", name)
+	err = search.Migrate(m.config.Search, name, searchMapping)
+	if err == nil {
+		return nil
+	}
+	log.Printf("migrate %s: %s\n", name, err)
+	err = search.Remove(m.config.Search, name)
+	if err != nil {
+		return err
+	}
+	return m.syncIndex()
+}
+
 func doArtist(artist musicbrainz.Artist) (a Artist, tags []ArtistTag) {
 	a = Artist{
 		Name:     artist.Name,
@@ -0,0 +1,173 @@
+// Copyright 2024 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package music
+
+import (
+	"fmt"
+	"path"
+	"sort"
+
+	"takeoutfm.dev/takeout/lib/log"
+	"takeoutfm.dev/takeout/lib/musicbrainz"
+	. "takeoutfm.dev/takeout/model"
+)
+
+const (
+	ReasonArtistNotFound  = "artist not found"
+	ReasonReleaseNotFound = "release not found"
+	ReasonMediaMismatch   = "no release with the same discs and tracks"
+	ReasonMatchNotFound   = "matched release not found"
+
+	candidateGroupLimit = 5
+)
+
+// UnmatchedReleases groups tracks without an assigned release by artist and
+// release, along with the likely reason each wasn't matched.
+func (m *Music) UnmatchedReleases() []UnmatchedRelease {
+	var list []UnmatchedRelease
+	index := make(map[string]int)
+	for _, t := range m.tracksWithoutAssignedRelease() {
+		key := releaseKey(t)
+		i, ok := index[key]
+		if !ok {
+			i = len(list)
+			index[key] = i
+			list = append(list, UnmatchedRelease{
+				ID:         t.ID,
+				Artist:     t.Artist,
+				Release:    t.Release,
+				Date:       t.Date,
+				TrackCount: t.TrackCount,
+				DiscCount:  t.DiscCount,
+				Reason:     m.unmatchedReason(t),
+			})
+		}
+		list[i].Tracks = append(list[i].Tracks, t)
+	}
+	return list
+}
+
+// UnmatchedRelease finds the unmatched release that includes the track id.
+func (m *Music) UnmatchedRelease(id int) (UnmatchedRelease, error) {
+	t, err := m.LookupTrack(id)
+	if err != nil {
+		return UnmatchedRelease{}, err
+	}
+	for _, u := range m.UnmatchedReleases() {
+		if u.Artist == t.Artist && u.Release == t.Release &&
+			u.TrackCount == t.TrackCount && u.DiscCount == t.DiscCount {
+			return u, nil
+		}
+	}
+	return UnmatchedRelease{}, ErrReleaseNotFound
+}
+
+func (m *Music) unmatchedReason(t Track) string {
+	if _, ok := trackReleaseOverride(m.releaseOverrides(), t.Key); ok {
+		return ReasonMatchNotFound
+	}
+	if _, err := m.Artist(t.Artist); err != nil {
+		return ReasonArtistNotFound
+	}
+	if len(m.artistReleasesNamed(t.Artist, t.Release)) > 0 {
+		return ReasonMediaMismatch
+	}
+	return ReasonReleaseNotFound
+}
+
+// ReleaseCandidates searches MusicBrainz for releases that may match the
+// unmatched tracks. Releases with the same number of discs and tracks are
+// listed first.
+func (m *Music) ReleaseCandidates(u UnmatchedRelease) []Release {
+	var candidates []Release
+	seen := make(map[string]struct{})
+	add := func(r musicbrainz.Release) {
+		if _, ok := seen[r.ID]; ok {
+			return
+		}
+		seen[r.ID] = struct{}{}
+		candidates = append(candidates, doRelease(u.Artist, r))
+	}
+
+	artist, err := m.Artist(u.Artist)
+	if err == nil {
+		result, err := m.mbz.SearchReleaseGroup(artist.ARID, u.Release)
+		if err != nil {
+			log.Println(err)
+		}
+		for i, rg := range result.ReleaseGroups {
+			if i == candidateGroupLimit {
+				break
+			}
+			releases, _ := m.mbz.Releases(rg.ID)
+			for _, r := range releases {
+				add(r)
+			}
+		}
+	}
+
+	if len(u.Tracks) > 0 {
+		t := u.Tracks[0]
+		query := fmt.Sprintf(`artist:"%s" AND recording:"%s"`,
+			musicbrainz.EscapeTerm(u.Artist), musicbrainz.EscapeTerm(t.Title))
+		recordings, _ := m.mbz.SearchRecordings(query)
+		for _, rec := range recordings {
+			for _, r := range rec.Releases {
+				add(r)
+			}
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a := candidates[i].TrackCount == u.TrackCount && candidates[i].DiscCount == u.DiscCount
+		b := candidates[j].TrackCount == u.TrackCount && candidates[j].DiscCount == u.DiscCount
+		return a && !b
+	})
+
+	return candidates
+}
+
+// MatchRelease assigns the unmatched tracks to the MusicBrainz release or
+// release group id. The choice is saved as a release override for each folder
+// with the tracks and used by future syncs.
+func (m *Music) MatchRelease(u UnmatchedRelease, mbid string) error {
+	reid := mbid
+	r, err := m.mbz.Release(mbid)
+	if err != nil || r.ID == "" {
+		// try as a release group
+		reid, err = m.findRelease(mbid, u.TrackCount)
+		if err != nil {
+			return err
+		}
+	}
+
+	folders := make(map[string]struct{})
+	for _, t := range u.Tracks {
+		folder := path.Dir(t.Key)
+		if _, ok := folders[folder]; ok {
+			continue
+		}
+		folders[folder] = struct{}{}
+		o := ReleaseOverride{Folder: folder, REID: reid}
+		err = m.SaveReleaseOverride(&o)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
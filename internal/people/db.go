@@ -43,6 +43,13 @@ func Person(db *gorm.DB, peid int) (model.Person, error) {
 // 	return person, err
 // }
 
+// PeopleNamed returns the people with one of the names.
+func PeopleNamed(db *gorm.DB, names []string) []model.Person {
+	var people []model.Person
+	db.Where("name in (?)", names).Find(&people)
+	return people
+}
+
 func CreatePerson(db *gorm.DB, p *model.Person) error {
 	return db.Create(p).Error
 }
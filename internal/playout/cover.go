@@ -20,6 +20,7 @@ package playout
 import (
 	"net/http"
 	"net/url"
+	"strings"
 
 	"image"
 	_ "image/jpeg"
@@ -51,6 +52,11 @@ func openImage(context client.Context, url *url.URL) (image.Image, error) {
 	}
 
 	req.Header.Add(header.UserAgent, context.UserAgent())
+	if strings.HasPrefix(url.Path, "/img/local/") {
+		// local artwork requires media access
+		req.Header.Add(client.HeaderAuthorization,
+			strings.Join([]string{client.BearerAuthorization, context.MediaToken()}, " "))
+	}
 	client := http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
@@ -21,12 +21,15 @@ import (
 	"fmt"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gdamore/tcell/v2"
 	"github.com/mattn/go-runewidth"
 	"github.com/qeesung/image2ascii/ascii"
+	"takeoutfm.dev/takeout/client"
 	"takeoutfm.dev/takeout/lib/log"
+	"takeoutfm.dev/takeout/lib/lrc"
 	"takeoutfm.dev/takeout/player"
 )
 
@@ -81,7 +84,7 @@ func (v *VisualView) OnStart(p *player.Player) {
 					case ' ':
 						p.Pause()
 					}
-				case *tcell.EventResize:
+				case *tcell.EventResize, *tcell.EventInterrupt:
 					v.update(p)
 				}
 			case <-seconds:
@@ -119,6 +122,14 @@ func (v *VisualView) update(p *player.Player) {
 		renderImage(img, v.screen)
 	}
 
+	if p.IsMusic() {
+		lyrics := trackLyrics(p, v.redraw)
+		if lyrics.Synced {
+			pos, _ := p.Position()
+			renderLyrics(lyrics, pos, v.screen, imgHeight)
+		}
+	}
+
 	renderArtistTrack(p, v.screen, hasProgress)
 
 	if hasProgress {
@@ -128,6 +139,11 @@ func (v *VisualView) update(p *player.Player) {
 	v.screen.Show()
 }
 
+// redraw requests an update from the event loop.
+func (v *VisualView) redraw() {
+	v.screen.PostEvent(tcell.NewEventInterrupt(nil))
+}
+
 func mmss(d time.Duration) string {
 	m := int(d.Minutes())
 	s := int(d.Seconds()) - m*60
@@ -195,6 +211,84 @@ func renderImage(img raster, s tcell.Screen) {
 	}
 }
 
+// lyricsContext is the number of lines shown before and after the current
+// lyrics line.
+const lyricsContext = 2
+
+type lyricsCache struct {
+	mu       sync.Mutex
+	location string
+	lyrics   lrc.Lyrics
+}
+
+var currentLyrics lyricsCache
+
+// trackLyrics returns the lyrics for the current track. Lyrics are fetched in
+// the background when the track changes and done is called once they're
+// available.
+func trackLyrics(p *player.Player, done func()) lrc.Lyrics {
+	currentLyrics.mu.Lock()
+	defer currentLyrics.mu.Unlock()
+	location := p.Location()
+	if location == currentLyrics.location {
+		return currentLyrics.lyrics
+	}
+
+	currentLyrics.location = location
+	currentLyrics.lyrics = lrc.Lyrics{}
+	if strings.HasPrefix(location, "/api/tracks/") {
+		go fetchLyrics(p.Context(), location, done)
+	}
+	return currentLyrics.lyrics
+}
+
+func fetchLyrics(context client.Context, location string, done func()) {
+	result, err := client.Lyrics(context, location)
+	if err != nil {
+		// most tracks don't have lyrics
+		return
+	}
+	var lyrics lrc.Lyrics
+	lyrics.Synced = result.Synced
+	for _, line := range result.Lines {
+		lyrics.Lines = append(lyrics.Lines, lrc.Line{
+			Time: time.Duration(line.Time * float64(time.Second)),
+			Text: line.Text,
+		})
+	}
+
+	currentLyrics.mu.Lock()
+	current := currentLyrics.location == location
+	if current {
+		currentLyrics.lyrics = lyrics
+	}
+	currentLyrics.mu.Unlock()
+	if current {
+		done()
+	}
+}
+
+// renderLyrics shows the current lyrics line centered near the bottom of the
+// image with a few lines of context.
+func renderLyrics(lyrics lrc.Lyrics, pos time.Duration, s tcell.Screen, bottom int) {
+	w, _ := s.Size()
+	current := lyrics.Index(pos)
+	style := tcell.StyleDefault.Background(tcell.ColorBlack)
+	y := bottom - (2*lyricsContext + 1)
+	for i := current - lyricsContext; i <= current+lyricsContext; i, y = i+1, y+1 {
+		if i < 0 || i >= len(lyrics.Lines) || y < 0 {
+			continue
+		}
+		text := lyrics.Lines[i].Text
+		lineStyle := style.Foreground(tcell.ColorDarkGray)
+		if i == current {
+			lineStyle = style.Foreground(tcell.ColorWhite).Bold(true)
+		}
+		x := max((w-runewidth.StringWidth(text))/2, 0)
+		emitStr(s, x, y, lineStyle, text)
+	}
+}
+
 func renderArtistTrack(p *player.Player, s tcell.Screen, hasProgress bool) {
 	_, h := s.Size()
 
@@ -22,6 +22,7 @@ import (
 
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	tgorm "takeoutfm.dev/takeout/lib/gorm"
 	. "takeoutfm.dev/takeout/model"
 )
 
@@ -62,6 +63,23 @@ func (p *Podcast) Series() []Series {
 	return series
 }
 
+// seriesTitled returns the series with one of the titles.
+func (p *Podcast) seriesTitled(titles []string) []Series {
+	var series []Series
+	p.db.Where("title in (?)", titles).Find(&series)
+	return series
+}
+
+// SeriesPage returns a page of series and the total matching series.
+func (p *Podcast) SeriesPage(page tgorm.Page) ([]Series, int, error) {
+	if page.Order == "" {
+		page.Order = "date desc"
+	}
+	var series []Series
+	total, err := page.Find(p.db, &series)
+	return series, total, err
+}
+
 func (p *Podcast) Episodes(series Series) []Episode {
 	var episodes []Episode
 	p.db.Where(`episodes.s_id = ?`, series.SID).
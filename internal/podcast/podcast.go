@@ -21,6 +21,7 @@ package podcast
 import (
 	"net/url"
 	"strconv"
+	"strings"
 
 	"gorm.io/gorm"
 	"takeoutfm.dev/takeout/internal/config"
@@ -43,20 +44,54 @@ func NewPodcast(config *config.Config) *Podcast {
 	}
 }
 
-func (p *Podcast) newSearch() (search.Searcher, error) {
-	keywords := []string{
+// searchFacets are the facets counted for episode search requests.
+var searchFacets = []search.Facet{
+	{Name: FieldAuthor, Field: FieldAuthor},
+	{Name: search.FacetDecade, Field: FieldDate, Decades: true},
+}
+
+// searchMapping has the fields indexed as keywords and suggest fields.
+var searchMapping = search.Mapping{
+	Keywords: []string{
 		FieldAuthor,
 		FieldDescription,
 		FieldTitle,
-	}
+	},
+	Suggest: []string{
+		FieldSeries,
+	},
+}
+
+func (p *Podcast) newSearch() (search.Searcher, error) {
 	s := p.config.NewSearcher()
-	err := s.Open(p.config.Podcast.SearchIndexName, keywords)
+	err := s.Open(p.config.Podcast.SearchIndexName, searchMapping)
 	if err != nil {
 		return nil, err
 	}
 	return s, nil
 }
 
+// Suggest returns podcast series with titles matching the prefix, most
+// common first. Series are indexed with the author which isn't part of the
+// title.
+func (p *Podcast) Suggest(prefix string) []Series {
+	s, err := p.newSearch()
+	if err != nil {
+		return nil
+	}
+	defer s.Close()
+	values, _ := s.Suggest(prefix, p.config.Podcast.SuggestSeriesLimit, FieldSeries)
+	titles := make([]string, 0, len(values))
+	for _, v := range values {
+		if i := strings.LastIndex(v, " / "); i > 0 {
+			v = v[:i]
+		}
+		titles = append(titles, v)
+	}
+	return search.Named(titles, p.seriesTitled(titles),
+		func(s Series) string { return s.Title })
+}
+
 func (p *Podcast) Open() (err error) {
 	err = p.openDB()
 	return
@@ -106,21 +141,40 @@ func (p *Podcast) FindEpisode(identifier string) (Episode, error) {
 }
 
 func (p *Podcast) Search(q string, limit ...int) (series []Series, episodes []Episode) {
+	l := p.config.Podcast.SearchLimit
+	if len(limit) == 1 {
+		l = limit[0]
+	}
+	series, episodes, _, _ = p.searchEpisodes(search.Request{Query: q, Limit: l, Advanced: true})
+	return series, episodes
+}
+
+// SearchRequest searches episodes with the request filters, sort and paging
+// and returns the series for the episodes, the episodes in result order, the
+// score for each episode and the result with counts for the author and
+// decade facets.
+// The limit is at most the configured search limit.
+func (p *Podcast) SearchRequest(r search.Request) ([]Series, []Episode, []float64, search.Result) {
+	if r.Limit == 0 || r.Limit > p.config.Podcast.SearchLimit {
+		r.Limit = p.config.Podcast.SearchLimit
+	}
+	r.Facets = searchFacets
+	return p.searchEpisodes(r)
+}
+
+func (p *Podcast) searchEpisodes(r search.Request) (series []Series, episodes []Episode,
+	scores []float64, result search.Result) {
 	s, err := p.newSearch()
 	if err != nil {
 		return
 	}
 	defer s.Close()
 
-	l := p.config.Podcast.SearchLimit
-	if len(limit) == 1 {
-		l = limit[0]
-	}
-
-	keys, err := s.Search(q, l)
+	result, err = s.SearchRequest(r)
 	if err != nil {
 		return
 	}
+	keys := result.Keys()
 
 	seriesMap := make(map[string]bool)
 
@@ -137,6 +191,9 @@ func (p *Podcast) Search(q string, limit ...int) (series []Series, episodes []Ep
 			seriesMap[e.SID] = true
 		}
 	}
+	episodes, scores = search.Ordered(result.Hits, episodes, func(e Episode) string {
+		return e.EID
+	})
 
 	// include unique series for episode results
 	seriesKeys := make([]string, 0, len(seriesMap))
@@ -145,5 +202,5 @@ func (p *Podcast) Search(q string, limit ...int) (series []Series, episodes []Ep
 	}
 	series = p.seriesFor(seriesKeys)
 
-	return series, episodes
+	return series, episodes, scores, result
 }
@@ -23,6 +23,7 @@ import (
 	"time"
 
 	"takeoutfm.dev/takeout/lib/hash"
+	"takeoutfm.dev/takeout/lib/log"
 	"takeoutfm.dev/takeout/lib/rss"
 	"takeoutfm.dev/takeout/lib/search"
 	. "takeoutfm.dev/takeout/model"
@@ -40,6 +41,28 @@ func (p *Podcast) Sync() error {
 	return p.SyncSince(time.Time{})
 }
 
+// Reindex rebuilds the search index when it was created with an older
+// mapping version. The index is migrated from its stored fields, or all
+// series are synced again to index them when that fails.
+func (p *Podcast) Reindex() error {
+	name := p.config.Podcast.SearchIndexName
+	outdated, err := search.Outdated(p.config.Search, name)
+	if err != nil || !outdated {
+		return err
+	}
+	log.Printf("reindex %s\n", name)
+	err = search.Migrate(p.config.Search, name, searchMapping)
+	if err == nil {
+		return nil
+	}
+	log.Printf("migrate %s: %s\n", name, err)
+	err = search.Remove(p.config.Search, name)
+	if err != nil {
+		return err
+	}
+	return p.Sync()
+}
+
 func (p *Podcast) SyncSince(lastSync time.Time) error {
 	// TODO lastSync isn't used yet
 	for _, url := range p.config.Podcast.Series {
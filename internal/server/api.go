@@ -19,19 +19,32 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
+	"takeoutfm.dev/takeout/internal/activity"
 	"takeoutfm.dev/takeout/internal/auth"
+	"takeoutfm.dev/takeout/internal/config"
+	"takeoutfm.dev/takeout/internal/music"
 	"takeoutfm.dev/takeout/lib/date"
 	"takeoutfm.dev/takeout/lib/encoding/xspf"
 	"takeoutfm.dev/takeout/lib/header"
+	"takeoutfm.dev/takeout/lib/lastfm"
+	"takeoutfm.dev/takeout/lib/listenbrainz"
 	"takeoutfm.dev/takeout/lib/log"
+	"takeoutfm.dev/takeout/lib/relay"
+	"takeoutfm.dev/takeout/lib/search"
+	"takeoutfm.dev/takeout/lib/str"
+	"takeoutfm.dev/takeout/lib/thumb"
 	"takeoutfm.dev/takeout/model"
 	"takeoutfm.dev/takeout/spiff"
 )
@@ -39,18 +52,30 @@ import (
 const (
 	ApplicationJson = "application/json"
 
-	ParamID   = "id"
-	ParamRes  = "res"
-	ParamName = "name"
-	ParamEID  = "eid"
-	ParamUUID = "uuid"
-	ParamPEID = "peid"
+	ParamID      = "id"
+	ParamRes     = "res"
+	ParamName    = "name"
+	ParamEID     = "eid"
+	ParamUUID    = "uuid"
+	ParamPEID    = "peid"
+	ParamService = "service"
 
 	QuerySearch = "q"
 	QueryStart  = "start"
 	QueryEnd    = "end"
 	QueryTime   = "time"
 	QueryToken  = "token"
+	QueryFormat = "format"
+	QueryFilter = "filter"
+	QuerySort   = "sort"
+	QueryOffset = "offset"
+	QueryLimit  = "limit"
+	QueryGenre  = "genre"
+	QueryDecade = "decade"
+	QueryLetter = "letter"
+	QueryWidth  = thumb.WidthParam
+
+	QueryAdvanced = "advanced"
 )
 
 type credentials struct {
@@ -331,28 +356,191 @@ func writePlaylist(w http.ResponseWriter, r *http.Request, plist *spiff.Playlist
 	}
 }
 
-// TODO check
-func recvStation(w http.ResponseWriter, r *http.Request,
-	s *model.Station) error {
+// stationRequest is a user station sent to the API. Ref and Shared aren't
+// included in station json so they're received separately. Stream stations use
+// Source instead of Ref.
+type stationRequest struct {
+	Name        string
+	Creator     string
+	Description string
+	Image       string
+	Type        string
+	Ref         string
+	Shared      bool
+	Source      []config.ContentDescription
+}
+
+// stationRefs are the resolver refs allowed for user stations. Station refs
+// are excluded since a station that refers to itself would never resolve.
+var stationRefs = []*regexp.Regexp{
+	artistsRegexp,
+	releasesRegexp,
+	tracksRegexp,
+	trackRadioRegexp,
+	searchRegexp,
+	playlistsRegexp,
+	smartRegexp,
+	setlistsRegexp,
+	recommendRegexp,
+	moviesRegexp,
+	tvSeriesRegexp,
+	tvEpisodesRegexp,
+	seriesRegexp,
+	episodesRegexp,
+	recentTracksRegexp,
+}
+
+func validStationRef(ref string) bool {
+	for _, re := range stationRefs {
+		if re.MatchString(ref) {
+			return true
+		}
+	}
+	return false
+}
+
+// validStationURL is true for http(s) urls and for local image paths when
+// local is true.
+func validStationURL(v string, local bool) bool {
+	if local && strings.HasPrefix(v, "/img/") {
+		return true
+	}
+	u, err := url.Parse(v)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	return u.Scheme == "http" || u.Scheme == "https"
+}
+
+// publicStationURL is true if the url host only resolves to public
+// addresses. The server connects to stream sources so users must not be able
+// to reach loopback, private or link-local services. The relay checks again
+// when connecting.
+func publicStationURL(v string) bool {
+	u, err := url.Parse(v)
+	if err != nil {
+		return false
+	}
+	host := u.Hostname()
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		ips, err = net.LookupIP(host)
+		if err != nil || len(ips) == 0 {
+			return false
+		}
+	}
+	for _, ip := range ips {
+		if !relay.PublicIP(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// isAdmin is true if the user can manage shared content.
+func isAdmin(ctx Context) bool {
+	return slices.Contains(ctx.Config().Auth.Admins, ctx.User().Name)
+}
+
+// recvStation receives and validates a station. Search and other ref stations
+// must use a ref that can be resolved, playlist stations are a snapshot of the
+// user playlist (/api/playlist) or a saved playlist (/api/playlists/1), and
+// stream stations need one or more http sources.
+func recvStation(w http.ResponseWriter, r *http.Request, s *model.Station) error {
 	ctx := contextValue(r)
-	body, _ := io.ReadAll(r.Body)
-	err := json.Unmarshal(body, s)
+	var req stationRequest
+	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		serverErr(w, err)
+		badRequest(w, err)
 		return err
 	}
-	if s.Name == "" || s.Ref == "" {
-		http.Error(w, "bummer", http.StatusBadRequest)
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		badRequest(w, err)
 		return err
 	}
-	s.User = ctx.User().Name
-	if s.Ref == "/api/playlist" {
-		// copy playlist
-		p, err := ctx.Music().UserPlaylist(ctx.User())
-		if err == nil {
-			s.Playlist = p.Playlist
+	if req.Name == "" {
+		badRequest(w, ErrMissingTitle)
+		return ErrMissingTitle
+	}
+	if req.Image != "" && !validStationURL(req.Image, true) {
+		badRequest(w, ErrInvalidImage)
+		return ErrInvalidImage
+	}
+	if req.Image != "" && !isAdmin(ctx) && !strings.HasPrefix(req.Image, "/img/") {
+		// external images would send client requests to third parties
+		badRequest(w, ErrInvalidImage)
+		return ErrInvalidImage
+	}
+	if req.Shared && !isAdmin(ctx) {
+		accessDenied(w)
+		return ErrAccessDenied
+	}
+
+	s.Name = req.Name
+	s.Creator = req.Creator
+	s.Description = req.Description
+	s.Image = req.Image
+	s.Shared = req.Shared
+	s.Playlist = nil
+
+	switch req.Type {
+	case music.TypeStream:
+		if len(req.Source) == 0 && req.Ref != "" {
+			req.Source = []config.ContentDescription{{URL: req.Ref}}
+		}
+		if len(req.Source) == 0 {
+			badRequest(w, ErrMissingParameter)
+			return ErrMissingParameter
+		}
+		for _, src := range req.Source {
+			if !validStationURL(src.URL, false) {
+				badRequest(w, ErrInvalidSource)
+				return ErrInvalidSource
+			}
+			if !isAdmin(ctx) && !publicStationURL(src.URL) {
+				badRequest(w, ErrInvalidSource)
+				return ErrInvalidSource
+			}
+		}
+		ref, err := json.Marshal(req.Source)
+		if err != nil {
+			serverErr(w, err)
+			return err
+		}
+		s.Ref = string(ref)
+	case music.TypePlaylist:
+		var p model.Playlist
+		if req.Ref == "/api/playlist" {
+			p, err = ctx.Music().UserPlaylist(ctx.User())
+		} else if strings.HasPrefix(req.Ref, "/api/playlists/") {
+			p, err = ctx.FindPlaylist(strings.TrimPrefix(req.Ref, "/api/playlists/"))
+		} else {
+			err = ErrInvalidRef
+		}
+		if err != nil {
+			badRequest(w, ErrInvalidRef)
+			return ErrInvalidRef
+		}
+		s.Ref = req.Ref
+		s.Playlist = p.Playlist
+	case "", music.TypeArtist, music.TypeGenre, music.TypeSimilar,
+		music.TypePeriod, music.TypeSeries, music.TypeOther:
+		if !validStationRef(req.Ref) {
+			badRequest(w, ErrInvalidRef)
+			return ErrInvalidRef
 		}
+		if req.Type == "" {
+			req.Type = music.TypeOther
+		}
+		s.Ref = req.Ref
+	default:
+		badRequest(w, ErrInvalidParameter)
+		return ErrInvalidParameter
 	}
+	s.Type = req.Type
 	return nil
 }
 
@@ -514,6 +702,138 @@ func apiPlaylistsDelete(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// /api/smart
+func apiSmartPlaylists(w http.ResponseWriter, r *http.Request) {
+	ctx := contextValue(r)
+	playlists := ctx.Music().SmartPlaylists(ctx.User())
+	apiView(w, r, SmartPlaylistsView(ctx, playlists))
+}
+
+func recvSmartPlaylist(w http.ResponseWriter, r *http.Request, p *model.SmartPlaylist) error {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		badRequest(w, err)
+		return err
+	}
+	err = json.Unmarshal(body, p)
+	if err != nil {
+		badRequest(w, err)
+		return err
+	}
+	if p.Name == "" {
+		badRequest(w, ErrMissingTitle)
+		return ErrMissingTitle
+	}
+	err = music.ValidateSmartPlaylist(p)
+	if err != nil {
+		badRequest(w, err)
+		return err
+	}
+	return nil
+}
+
+// saveSmartPlaylist persists the smart playlist, refreshing scheduled
+// playlists right away so they're available before the next refresh.
+func saveSmartPlaylist(ctx Context, p *model.SmartPlaylist, save func(*model.SmartPlaylist) error) error {
+	err := save(p)
+	if err != nil {
+		return err
+	}
+	if p.Refresh == music.SmartRefreshSchedule {
+		return ctx.Music().RefreshSmartPlaylist(p, smartPlays(ctx, *p))
+	}
+	return nil
+}
+
+// POST /api/smart < SmartPlaylist{}
+// 200: created
+// 400: bad request
+// 500: error
+func apiSmartPlaylistsCreate(w http.ResponseWriter, r *http.Request) {
+	ctx := contextValue(r)
+	var p model.SmartPlaylist
+	if recvSmartPlaylist(w, r, &p) != nil {
+		return
+	}
+	p.User = ctx.User().Name
+	err := saveSmartPlaylist(ctx, &p, ctx.Music().CreateSmartPlaylist)
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	apiView(w, r, SmartPlaylistView(ctx, p))
+}
+
+func apiSmartPlaylistsGet(w http.ResponseWriter, r *http.Request) {
+	ctx := contextValue(r)
+	id := r.PathValue(ParamID)
+	p, err := ctx.FindSmartPlaylist(id)
+	if err != nil {
+		notFoundErr(w)
+	} else {
+		apiView(w, r, SmartPlaylistView(ctx, p))
+	}
+}
+
+func apiSmartPlaylistsGetPlaylist(w http.ResponseWriter, r *http.Request) {
+	ctx := contextValue(r)
+	id := r.PathValue(ParamID)
+	p, err := ctx.FindSmartPlaylist(id)
+	if err != nil {
+		notFoundErr(w)
+	} else {
+		plist := ResolveSmartPlaylist(ctx, p, r.URL.Path)
+		writePlaylist(w, r, plist)
+	}
+}
+
+// PUT /api/smart/1 < SmartPlaylist{}
+// 200: updated
+// 400: bad request
+// 404: not found
+// 500: error
+func apiSmartPlaylistsUpdate(w http.ResponseWriter, r *http.Request) {
+	ctx := contextValue(r)
+	id := r.PathValue(ParamID)
+	p, err := ctx.FindSmartPlaylist(id)
+	if err != nil {
+		notFoundErr(w)
+		return
+	}
+	var up model.SmartPlaylist
+	if recvSmartPlaylist(w, r, &up) != nil {
+		return
+	}
+	p.Name = up.Name
+	p.Rule = up.Rule
+	p.Sort = up.Sort
+	p.Order = up.Order
+	p.Limit = up.Limit
+	p.Refresh = up.Refresh
+	err = saveSmartPlaylist(ctx, &p, ctx.Music().UpdateSmartPlaylist)
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	apiView(w, r, SmartPlaylistView(ctx, p))
+}
+
+func apiSmartPlaylistsDelete(w http.ResponseWriter, r *http.Request) {
+	ctx := contextValue(r)
+	id := r.PathValue(ParamID)
+	p, err := ctx.FindSmartPlaylist(id)
+	if err != nil {
+		notFoundErr(w)
+		return
+	}
+	err = ctx.Music().DeleteSmartPlaylist(ctx.User(), int(p.ID))
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func doPlaylistPatch(ctx Context, p *model.Playlist, w http.ResponseWriter, r *http.Request) {
 	var err error
 
@@ -563,6 +883,12 @@ func doPlaylistPatch(ctx Context, p *model.Playlist, w http.ResponseWriter, r *h
 	ctx.Music().UpdatePlaylist(p)
 
 	v, _ := spiff.Compare(before, p.Playlist)
+	if plist.Type == spiff.TypeMusic {
+		prev, err := spiff.Unmarshal(before)
+		if err != nil || !v || prev.Index != plist.Index {
+			nowPlaying(ctx, plist)
+		}
+	}
 	if v {
 		// entries didn't change, only metadata
 		w.WriteHeader(http.StatusNoContent)
@@ -573,6 +899,27 @@ func doPlaylistPatch(ctx Context, p *model.Playlist, w http.ResponseWriter, r *h
 	}
 }
 
+// nowPlaying sends the current playlist track to the user's linked scrobble
+// services.
+func nowPlaying(ctx Context, plist *spiff.Playlist) {
+	if plist.Index < 0 || plist.Index >= len(plist.Spiff.Entries) {
+		return
+	}
+	entry := plist.Spiff.Entries[plist.Index]
+	if len(entry.Location) == 0 {
+		return
+	}
+	matches := locationRegexp.FindStringSubmatch(entry.Location[0])
+	if matches == nil {
+		return
+	}
+	track, err := ctx.Music().FindTrack("uuid:" + matches[2])
+	if err != nil {
+		return
+	}
+	go ctx.Activity().NowPlaying(ctx.User(), track)
+}
+
 func apiProgressGet(w http.ResponseWriter, r *http.Request) {
 	ctx := contextValue(r)
 	view := ProgressView(ctx)
@@ -633,20 +980,86 @@ func apiIndex(w http.ResponseWriter, r *http.Request) {
 	apiView(w, r, view)
 }
 
+// GET /api/search?q={pattern}&filter={facet}:{value}&sort={field}&offset={n}&limit={n}&advanced={bool}
 func apiSearch(w http.ResponseWriter, r *http.Request) {
 	ctx := contextValue(r)
-	if v := r.URL.Query().Get(QuerySearch); v != "" {
-		// /api/search?q={pattern}
-		view := SearchView(ctx, strings.TrimSpace(v))
-		apiView(w, r, view)
+	req, err := recvSearch(r)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	apiView(w, r, SearchView(ctx, req))
+}
+
+// GET /api/suggest?q={prefix}
+func apiSuggest(w http.ResponseWriter, r *http.Request) {
+	ctx := contextValue(r)
+	if v := strings.TrimSpace(r.URL.Query().Get(QuerySearch)); v != "" {
+		apiView(w, r, SuggestView(ctx, v))
 	} else {
 		badRequest(w, ErrMissingParameter)
 	}
 }
 
+// recvSearch builds a search request from the query parameters. Filters are
+// facet name and value pairs and sort fields can be repeated or comma
+// separated. The query is plain text unless advanced is true.
+func recvSearch(r *http.Request) (search.Request, error) {
+	var req search.Request
+	values := r.URL.Query()
+	req.Query = strings.TrimSpace(values.Get(QuerySearch))
+	for _, v := range values[QueryFilter] {
+		name, value, ok := strings.Cut(v, ":")
+		if !ok || name == "" || value == "" {
+			return req, ErrInvalidParameter
+		}
+		req.Filters = append(req.Filters, search.Filter{Name: name, Value: value})
+	}
+	if req.Query == "" && len(req.Filters) == 0 {
+		return req, ErrMissingParameter
+	}
+	for _, v := range values[QuerySort] {
+		for _, field := range strings.Split(v, ",") {
+			if field = strings.TrimSpace(field); field != "" {
+				req.Sort = append(req.Sort, field)
+			}
+		}
+	}
+	var err error
+	if v := values.Get(QueryAdvanced); v != "" {
+		req.Advanced, err = strconv.ParseBool(v)
+		if err != nil {
+			return req, ErrInvalidParameter
+		}
+	}
+	if v := values.Get(QueryOffset); v != "" {
+		req.Offset, err = strconv.Atoi(v)
+		if err != nil || req.Offset < 0 {
+			return req, ErrInvalidParameter
+		}
+	}
+	if v := values.Get(QueryLimit); v != "" {
+		req.Limit, err = strconv.Atoi(v)
+		if err != nil || req.Limit < 0 {
+			return req, ErrInvalidParameter
+		}
+	}
+	return req, nil
+}
+
 func apiArtists(w http.ResponseWriter, r *http.Request) {
 	ctx := contextValue(r)
-	apiView(w, r, ArtistsView(ctx))
+	o, err := recvListOptions(ctx, r)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	view, err := ArtistsView(ctx, o)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	apiView(w, r, view)
 }
 
 func apiArtistGet(w http.ResponseWriter, r *http.Request) {
@@ -677,6 +1090,15 @@ func apiArtistGetResource(w http.ResponseWriter, r *http.Request) {
 			apiArtistGetPlaylist(w, r)
 		case "wantlist":
 			apiView(w, r, WantListView(ctx, artist))
+		case "setlists":
+			view, err := SetlistsView(ctx, artist)
+			if err == music.ErrSetlistNotConfigured {
+				notFoundErr(w)
+			} else if err != nil {
+				serverErr(w, err)
+			} else {
+				apiView(w, r, view)
+			}
 		default:
 			notFoundErr(w)
 		}
@@ -699,11 +1121,59 @@ func apiArtistGetPlaylist(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// setlistIDRegexp matches setlist.fm ids, which are used in the setlist.fm
+// API url.
+var setlistIDRegexp = regexp.MustCompile(`^[0-9a-zA-Z]+$`)
+
+// setlistID returns the validated setlist id from the request path.
+func setlistID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := r.PathValue(ParamID)
+	if !setlistIDRegexp.MatchString(id) {
+		badRequest(w, ErrInvalidParameter)
+		return "", false
+	}
+	return id, true
+}
+
+func apiSetlistGet(w http.ResponseWriter, r *http.Request) {
+	ctx := contextValue(r)
+	id, ok := setlistID(w, r)
+	if !ok {
+		return
+	}
+	sl, err := ctx.Music().Setlist(id)
+	if err != nil {
+		notFoundErr(w)
+	} else {
+		apiView(w, r, SetlistView(ctx, sl))
+	}
+}
+
+func apiSetlistGetPlaylist(w http.ResponseWriter, r *http.Request) {
+	ctx := contextValue(r)
+	id, ok := setlistID(w, r)
+	if !ok {
+		return
+	}
+	sl, err := ctx.Music().Setlist(id)
+	if err != nil {
+		notFoundErr(w)
+	} else {
+		plist := ResolveSetlistPlaylist(ctx, SetlistView(ctx, sl), r.URL.Path)
+		writePlaylist(w, r, plist)
+	}
+}
+
 func apiRadioGet(w http.ResponseWriter, r *http.Request) {
 	ctx := contextValue(r)
 	apiView(w, r, RadioView(ctx))
 }
 
+// POST /api/radio < Station{}
+// 201: created
+// 400: bad request
+// 403: shared station and not admin
+// 500: error
 func apiRadioPost(w http.ResponseWriter, r *http.Request) {
 	ctx := contextValue(r)
 	var s model.Station
@@ -711,11 +1181,13 @@ func apiRadioPost(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		return
 	}
+	s.User = ctx.User().Name
 	err = ctx.Music().CreateStation(&s)
 	if err != nil {
 		serverErr(w, err)
 		return
 	}
+	w.Header().Set(header.ContentType, ApplicationJson)
 	w.WriteHeader(http.StatusCreated)
 	enc := json.NewEncoder(w)
 	enc.Encode(s)
@@ -739,7 +1211,17 @@ func apiRadioStationGetPlaylist(w http.ResponseWriter, r *http.Request) {
 
 func apiMovies(w http.ResponseWriter, r *http.Request) {
 	ctx := contextValue(r)
-	apiView(w, r, MoviesView(ctx))
+	o, err := recvListOptions(ctx, r)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	view, err := MoviesView(ctx, o)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	apiView(w, r, view)
 }
 
 func apiMovieGet(w http.ResponseWriter, r *http.Request) {
@@ -781,14 +1263,34 @@ func apiMovieGenreGet(w http.ResponseWriter, r *http.Request) {
 	ctx := contextValue(r)
 	name := r.PathValue(ParamName)
 	// TODO sanitize
-	apiView(w, r, GenreView(ctx, name))
+	o, err := recvListOptions(ctx, r)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	view, err := GenreView(ctx, name, o)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	apiView(w, r, view)
 }
 
 func apiMovieKeywordGet(w http.ResponseWriter, r *http.Request) {
 	ctx := contextValue(r)
 	name := r.PathValue(ParamName)
 	// TODO sanitize
-	apiView(w, r, KeywordView(ctx, name))
+	o, err := recvListOptions(ctx, r)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	view, err := KeywordView(ctx, name, o)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	apiView(w, r, view)
 }
 
 func apiTVList(w http.ResponseWriter, r *http.Request) {
@@ -798,7 +1300,17 @@ func apiTVList(w http.ResponseWriter, r *http.Request) {
 
 func apiTVShows(w http.ResponseWriter, r *http.Request) {
 	ctx := contextValue(r)
-	apiView(w, r, TVShowsView(ctx))
+	o, err := recvListOptions(ctx, r)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	view, err := TVShowsView(ctx, o)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	apiView(w, r, view)
 }
 
 func apiTVSeriesGet(w http.ResponseWriter, r *http.Request) {
@@ -858,7 +1370,17 @@ func apiTVEpisodeGetPlaylist(w http.ResponseWriter, r *http.Request) {
 
 func apiPodcasts(w http.ResponseWriter, r *http.Request) {
 	ctx := contextValue(r)
-	apiView(w, r, PodcastsView(ctx))
+	o, err := recvListOptions(ctx, r)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	view, err := PodcastsView(ctx, o)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	apiView(w, r, view)
 }
 
 func apiPodcastsSubscribed(w http.ResponseWriter, r *http.Request) {
@@ -953,81 +1475,67 @@ func apiPodcastEpisodeGetPlaylist(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// TODO check
-//
-// PUT /api/radio/1 < Station{}
-// 204: no content
-// 404: not found
-// 500: error
-//
-// PATCH /api/radio/1 < json+patch > 204
-// 204: no content
+// editableStation finds a station that the user can change. Users can change
+// their own stations and admins can also change shared stations.
+func editableStation(w http.ResponseWriter, r *http.Request) (model.Station, bool) {
+	ctx := contextValue(r)
+	s, err := ctx.FindStation(r.PathValue(ParamID))
+	if err != nil || !s.Visible(ctx.User().Name) {
+		notFoundErr(w)
+		return s, false
+	}
+	if s.User != ctx.User().Name && !isAdmin(ctx) {
+		accessDenied(w)
+		return s, false
+	}
+	return s, true
+}
+
+// PUT /api/radio/1 < Station{}
+// 200: updated
+// 400: bad request
+// 403: not allowed
 // 404: not found
 // 500: error
-//
+func apiRadioUpdate(w http.ResponseWriter, r *http.Request) {
+	ctx := contextValue(r)
+	s, ok := editableStation(w, r)
+	if !ok {
+		return
+	}
+	if s.Type == music.TypeMix {
+		accessDenied(w)
+		return
+	}
+	err := recvStation(w, r, &s)
+	if err != nil {
+		return
+	}
+	err = ctx.Music().UpdateStation(&s)
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	apiView(w, r, s)
+}
+
 // DELETE /api/radio/1
 // 204: success, no content
+// 403: not allowed
 // 404: not found
 // 500: error
-func apiStation(w http.ResponseWriter, r *http.Request, id int) {
+func apiRadioDelete(w http.ResponseWriter, r *http.Request) {
 	ctx := contextValue(r)
-	s, err := ctx.Music().LookupStation(id)
-	if err != nil {
-		notFoundErr(w)
+	s, ok := editableStation(w, r)
+	if !ok {
 		return
 	}
-	if !s.Visible(ctx.User().Name) {
-		notFoundErr(w)
+	err := ctx.Music().DeleteStation(&s)
+	if err != nil {
+		serverErr(w, err)
 		return
 	}
-
-	switch r.Method {
-	case http.MethodGet:
-		RefreshStation(ctx, &s)
-		w.WriteHeader(http.StatusOK)
-		w.Write(s.Playlist)
-	case http.MethodPut:
-		var up model.Station
-		err := recvStation(w, r, &up)
-		if err != nil {
-			return
-		}
-		s.Name = up.Name
-		s.Ref = up.Ref
-		s.Playlist = up.Playlist
-		err = ctx.Music().UpdateStation(&s)
-		if err != nil {
-			serverErr(w, err)
-			return
-		}
-		w.WriteHeader(http.StatusNoContent)
-	case http.MethodPatch:
-		patch, _ := io.ReadAll(r.Body)
-		s.Playlist, err = spiff.Patch(s.Playlist, patch)
-		if err != nil {
-			serverErr(w, err)
-			return
-		}
-		// unmarshal & resovle
-		plist, _ := spiff.Unmarshal(s.Playlist)
-		Resolve(ctx, plist)
-		if plist.Spiff.Entries == nil {
-			plist.Spiff.Entries = []spiff.Entry{}
-		}
-		// marshal & persist
-		s.Playlist, _ = plist.Marshal()
-		ctx.Music().UpdateStation(&s)
-		w.WriteHeader(http.StatusNoContent)
-	case http.MethodDelete:
-		err = ctx.Music().DeleteStation(&s)
-		if err != nil {
-			serverErr(w, err)
-			return
-		}
-		w.WriteHeader(http.StatusNoContent)
-	default:
-		http.Error(w, "bummer", http.StatusBadRequest)
-	}
+	w.WriteHeader(http.StatusNoContent)
 }
 
 func apiReleaseGet(w http.ResponseWriter, r *http.Request) {
@@ -1067,6 +1575,22 @@ func apiTrackPlaylist(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func apiTrackLyrics(w http.ResponseWriter, r *http.Request) {
+	ctx := contextValue(r)
+	uuid := r.PathValue(ParamUUID)
+	track, err := ctx.FindTrack("uuid:" + uuid)
+	if err != nil {
+		notFoundErr(w)
+		return
+	}
+	view, err := LyricsView(ctx, track)
+	if err != nil {
+		notFoundErr(w)
+		return
+	}
+	apiView(w, r, view)
+}
+
 func apiTrackLocation(w http.ResponseWriter, r *http.Request) {
 	ctx := contextValue(r)
 	uuid := r.PathValue(ParamUUID)
@@ -1181,8 +1705,8 @@ func dateRange(r *http.Request) date.DateRange {
 		interval = date.NewInterval(t, res)
 	} else {
 		// default to the past year
-		start := time.Now()
-		end := date.BackYear(start)
+		end := time.Now()
+		start := date.BackYear(end)
 
 		s := r.URL.Query().Get(QueryStart)
 		if s != "" {
@@ -1237,6 +1761,149 @@ func apiActivityTrackCounts(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// activityView writes the view for the request date range.
+func activityView(w http.ResponseWriter, r *http.Request,
+	viewFunc func(ctx Context, d date.DateRange) interface{}) {
+	d := dateRange(r)
+	if d.End.IsZero() {
+		badRequest(w, ErrInvalidParameter)
+		return
+	}
+	ctx := contextValue(r)
+	apiView(w, r, viewFunc(ctx, d))
+}
+
+// /api/activity/movies/lastmonth
+func apiActivityMovieHistory(w http.ResponseWriter, r *http.Request) {
+	activityView(w, r, func(ctx Context, d date.DateRange) interface{} {
+		return MovieHistoryView(ctx, d)
+	})
+}
+
+// /api/activity/movies/lastmonth/stats
+func apiActivityMovieStats(w http.ResponseWriter, r *http.Request) {
+	activityView(w, r, func(ctx Context, d date.DateRange) interface{} {
+		return MovieStatsView(ctx, r.PathValue(ParamRes), d)
+	})
+}
+
+// /api/activity/movies/lastmonth/counts
+func apiActivityMovieCounts(w http.ResponseWriter, r *http.Request) {
+	activityView(w, r, func(ctx Context, d date.DateRange) interface{} {
+		if d.IsYear() {
+			return ctx.Activity().MovieMonthCounts(ctx, d)
+		}
+		return ctx.Activity().MovieDayCounts(ctx, d)
+	})
+}
+
+// /api/activity/movies/lastmonth/chart
+func apiActivityMovieChart(w http.ResponseWriter, r *http.Request) {
+	activityView(w, r, func(ctx Context, d date.DateRange) interface{} {
+		return ctx.Activity().BuildMovieChart(ctx, d)
+	})
+}
+
+// /api/activity/tv/lastmonth
+func apiActivityTVHistory(w http.ResponseWriter, r *http.Request) {
+	activityView(w, r, func(ctx Context, d date.DateRange) interface{} {
+		return TVHistoryView(ctx, d)
+	})
+}
+
+// /api/activity/tv/lastmonth/stats
+func apiActivityTVStats(w http.ResponseWriter, r *http.Request) {
+	activityView(w, r, func(ctx Context, d date.DateRange) interface{} {
+		return TVStatsView(ctx, r.PathValue(ParamRes), d)
+	})
+}
+
+// /api/activity/tv/lastmonth/counts
+func apiActivityTVCounts(w http.ResponseWriter, r *http.Request) {
+	activityView(w, r, func(ctx Context, d date.DateRange) interface{} {
+		if d.IsYear() {
+			return ctx.Activity().TVMonthCounts(ctx, d)
+		}
+		return ctx.Activity().TVDayCounts(ctx, d)
+	})
+}
+
+// /api/activity/tv/lastmonth/chart
+func apiActivityTVChart(w http.ResponseWriter, r *http.Request) {
+	activityView(w, r, func(ctx Context, d date.DateRange) interface{} {
+		return ctx.Activity().BuildTVChart(ctx, d)
+	})
+}
+
+// /api/activity/podcasts/lastmonth
+func apiActivityPodcastHistory(w http.ResponseWriter, r *http.Request) {
+	activityView(w, r, func(ctx Context, d date.DateRange) interface{} {
+		return PodcastHistoryView(ctx, d)
+	})
+}
+
+// /api/activity/podcasts/lastmonth/stats
+func apiActivityPodcastStats(w http.ResponseWriter, r *http.Request) {
+	activityView(w, r, func(ctx Context, d date.DateRange) interface{} {
+		return PodcastStatsView(ctx, r.PathValue(ParamRes), d)
+	})
+}
+
+// /api/activity/podcasts/lastmonth/counts
+func apiActivityPodcastCounts(w http.ResponseWriter, r *http.Request) {
+	activityView(w, r, func(ctx Context, d date.DateRange) interface{} {
+		if d.IsYear() {
+			return ctx.Activity().PodcastMonthCounts(ctx, d)
+		}
+		return ctx.Activity().PodcastDayCounts(ctx, d)
+	})
+}
+
+// /api/activity/podcasts/lastmonth/chart
+func apiActivityPodcastChart(w http.ResponseWriter, r *http.Request) {
+	activityView(w, r, func(ctx Context, d date.DateRange) interface{} {
+		return ctx.Activity().BuildPodcastChart(ctx, d)
+	})
+}
+
+func reportRange(r *http.Request) date.DateRange {
+	return date.NewReportInterval(clientTime(r), r.PathValue(ParamRes))
+}
+
+// /api/activity/report/2024
+func apiActivityReport(w http.ResponseWriter, r *http.Request) {
+	ctx := contextValue(r)
+	v, err := TrackReportView(ctx, r.PathValue(ParamRes), reportRange(r))
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	apiView(w, r, v)
+}
+
+// /api/activity/report/2024/playlist
+func apiActivityReportPlaylist(w http.ResponseWriter, r *http.Request) {
+	ctx := contextValue(r)
+	v, err := TrackReportView(ctx, r.PathValue(ParamRes), reportRange(r))
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	writePlaylist(w, r, ResolveTrackReportPlaylist(ctx, v, r.URL.Path))
+}
+
+// /api/activity/report/2024/chart
+func apiActivityReportChart(w http.ResponseWriter, r *http.Request) {
+	d := reportRange(r)
+	if d.IsZero() {
+		badRequest(w, ErrInvalidParameter)
+		return
+	}
+	ctx := contextValue(r)
+	charts := ctx.Activity().BuildChart(ctx, d)
+	apiView(w, r, charts)
+}
+
 // /api/activity/tracks/yesterday/chart
 func apiActivityTrackChart(w http.ResponseWriter, r *http.Request) {
 	ctx := contextValue(r)
@@ -1249,6 +1916,153 @@ func apiActivityTrackChart(w http.ResponseWriter, r *http.Request) {
 	apiView(w, r, charts)
 }
 
+func apiUnmatched(w http.ResponseWriter, r *http.Request) {
+	ctx := contextValue(r)
+	apiView(w, r, UnmatchedView(ctx))
+}
+
+type matchRequest struct {
+	ID string
+}
+
+func recvMatch(w http.ResponseWriter, r *http.Request) (string, error) {
+	var match matchRequest
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		badRequest(w, err)
+		return "", err
+	}
+	err = json.Unmarshal(body, &match)
+	if err != nil {
+		badRequest(w, err)
+		return "", err
+	}
+	if match.ID == "" {
+		badRequest(w, ErrMissingParameter)
+		return "", ErrMissingParameter
+	}
+	return match.ID, nil
+}
+
+// /api/unmatched/releases/{id}/candidates
+func apiUnmatchedReleaseCandidates(w http.ResponseWriter, r *http.Request) {
+	ctx := contextValue(r)
+	id := str.Atoi(r.PathValue(ParamID))
+	u, err := ctx.Music().UnmatchedRelease(id)
+	if err != nil {
+		notFoundErr(w)
+		return
+	}
+	apiView(w, r, ReleaseCandidatesView(ctx, u))
+}
+
+// /api/unmatched/releases/{id}/match
+func apiUnmatchedReleaseMatch(w http.ResponseWriter, r *http.Request) {
+	ctx := contextValue(r)
+	id := str.Atoi(r.PathValue(ParamID))
+	u, err := ctx.Music().UnmatchedRelease(id)
+	if err != nil {
+		notFoundErr(w)
+		return
+	}
+	mbid, err := recvMatch(w, r)
+	if err != nil {
+		return
+	}
+	err = ctx.Music().MatchRelease(u, mbid)
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// /api/unmatched/movies/{id}/candidates
+func apiUnmatchedMovieCandidates(w http.ResponseWriter, r *http.Request) {
+	ctx := contextValue(r)
+	id := str.Atoi(r.PathValue(ParamID))
+	u, err := ctx.Film().LookupUnmatchedMovie(id)
+	if err != nil {
+		notFoundErr(w)
+		return
+	}
+	view, err := MovieCandidatesView(ctx, u)
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	apiView(w, r, view)
+}
+
+// /api/unmatched/movies/{id}/match
+func apiUnmatchedMovieMatch(w http.ResponseWriter, r *http.Request) {
+	ctx := contextValue(r)
+	id := str.Atoi(r.PathValue(ParamID))
+	u, err := ctx.Film().LookupUnmatchedMovie(id)
+	if err != nil {
+		notFoundErr(w)
+		return
+	}
+	v, err := recvMatch(w, r)
+	if err != nil {
+		return
+	}
+	tmid := str.Atoi(v)
+	if tmid == 0 {
+		badRequest(w, ErrInvalidParameter)
+		return
+	}
+	err = ctx.Film().MatchMovie(u, tmid)
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// /api/unmatched/tv/{id}/candidates
+func apiUnmatchedTVCandidates(w http.ResponseWriter, r *http.Request) {
+	ctx := contextValue(r)
+	id := str.Atoi(r.PathValue(ParamID))
+	u, err := ctx.TV().LookupUnmatchedEpisode(id)
+	if err != nil {
+		notFoundErr(w)
+		return
+	}
+	view, err := SeriesCandidatesView(ctx, u)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	apiView(w, r, view)
+}
+
+// /api/unmatched/tv/{id}/match
+func apiUnmatchedTVMatch(w http.ResponseWriter, r *http.Request) {
+	ctx := contextValue(r)
+	id := str.Atoi(r.PathValue(ParamID))
+	u, err := ctx.TV().LookupUnmatchedEpisode(id)
+	if err != nil {
+		notFoundErr(w)
+		return
+	}
+	v, err := recvMatch(w, r)
+	if err != nil {
+		return
+	}
+	tvid := str.Atoi(v)
+	if tvid == 0 {
+		badRequest(w, ErrInvalidParameter)
+		return
+	}
+	err = ctx.TV().MatchSeries(u, tvid)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func doRedirect(w http.ResponseWriter, r *http.Request, u *url.URL, code int) {
 	if u.Scheme == "file" {
 		ctx := contextValue(r)
@@ -1276,3 +2090,224 @@ func apiDownload(w http.ResponseWriter, r *http.Request) {
 	path := strings.TrimPrefix(r.URL.Path, prefix)
 	http.ServeFile(w, r, path)
 }
+
+// /api/overrides
+func apiOverrides(w http.ResponseWriter, r *http.Request) {
+	ctx := contextValue(r)
+	apiView(w, r, OverridesView(ctx))
+}
+
+func recvOverride(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		badRequest(w, err)
+		return err
+	}
+	err = json.Unmarshal(body, v)
+	if err != nil {
+		badRequest(w, err)
+		return err
+	}
+	return nil
+}
+
+func saveOverride(w http.ResponseWriter, r *http.Request, v interface{}, save func() error) {
+	err := save()
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	apiView(w, r, v)
+}
+
+func deleteOverride(w http.ResponseWriter, r *http.Request, del func(id int) error) {
+	id := str.Atoi(r.PathValue(ParamID))
+	if id == 0 {
+		badRequest(w, ErrInvalidParameter)
+		return
+	}
+	err := del(id)
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// /api/overrides/releases
+func apiReleaseOverridePost(w http.ResponseWriter, r *http.Request) {
+	ctx := contextValue(r)
+	var o model.ReleaseOverride
+	if recvOverride(w, r, &o) != nil {
+		return
+	}
+	if o.Folder == "" || (o.REID == "" && o.RGID == "") {
+		badRequest(w, ErrMissingParameter)
+		return
+	}
+	saveOverride(w, r, &o, func() error {
+		return ctx.Music().SaveReleaseOverride(&o)
+	})
+}
+
+// /api/overrides/releases/{id}
+func apiReleaseOverrideDelete(w http.ResponseWriter, r *http.Request) {
+	ctx := contextValue(r)
+	deleteOverride(w, r, ctx.Music().DeleteReleaseOverride)
+}
+
+// /api/overrides/tracks
+func apiTrackOverridePost(w http.ResponseWriter, r *http.Request) {
+	ctx := contextValue(r)
+	var o model.TrackOverride
+	if recvOverride(w, r, &o) != nil {
+		return
+	}
+	if o.Key == "" || (o.Title == "" && o.TrackNum == 0 && o.DiscNum == 0) {
+		badRequest(w, ErrMissingParameter)
+		return
+	}
+	saveOverride(w, r, &o, func() error {
+		return ctx.Music().SaveTrackOverride(&o)
+	})
+}
+
+// /api/overrides/tracks/{id}
+func apiTrackOverrideDelete(w http.ResponseWriter, r *http.Request) {
+	ctx := contextValue(r)
+	deleteOverride(w, r, ctx.Music().DeleteTrackOverride)
+}
+
+// /api/overrides/movies
+func apiMovieOverridePost(w http.ResponseWriter, r *http.Request) {
+	ctx := contextValue(r)
+	var o model.MovieMatch
+	if recvOverride(w, r, &o) != nil {
+		return
+	}
+	if o.Key == "" || o.TMID == 0 {
+		badRequest(w, ErrMissingParameter)
+		return
+	}
+	saveOverride(w, r, &o, func() error {
+		return ctx.Film().SaveMovieOverride(&o)
+	})
+}
+
+// /api/overrides/movies/{id}
+func apiMovieOverrideDelete(w http.ResponseWriter, r *http.Request) {
+	ctx := contextValue(r)
+	deleteOverride(w, r, ctx.Film().DeleteMovieOverride)
+}
+
+// /api/overrides/tv
+func apiTVOverridePost(w http.ResponseWriter, r *http.Request) {
+	ctx := contextValue(r)
+	var o model.TVMatch
+	if recvOverride(w, r, &o) != nil {
+		return
+	}
+	if o.Name == "" || o.TVID == 0 {
+		badRequest(w, ErrMissingParameter)
+		return
+	}
+	saveOverride(w, r, &o, func() error {
+		return ctx.TV().SaveTVOverride(&o)
+	})
+}
+
+// /api/overrides/tv/{id}
+func apiTVOverrideDelete(w http.ResponseWriter, r *http.Request) {
+	ctx := contextValue(r)
+	deleteOverride(w, r, ctx.TV().DeleteTVOverride)
+}
+
+// /api/scrobblers
+func apiScrobblers(w http.ResponseWriter, r *http.Request) {
+	ctx := contextValue(r)
+	apiView(w, r, ScrobblersView(ctx))
+}
+
+type scrobblerRequest struct {
+	Token    string
+	User     string
+	Password string
+}
+
+func recvScrobbler(w http.ResponseWriter, r *http.Request) (scrobblerRequest, error) {
+	var req scrobblerRequest
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		badRequest(w, err)
+		return req, err
+	}
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		badRequest(w, err)
+		return req, err
+	}
+	return req, nil
+}
+
+// /api/scrobblers/listenbrainz
+func apiScrobblerListenBrainz(w http.ResponseWriter, r *http.Request) {
+	ctx := contextValue(r)
+	req, err := recvScrobbler(w, r)
+	if err != nil {
+		return
+	}
+	if req.Token == "" {
+		badRequest(w, ErrMissingParameter)
+		return
+	}
+	err = ctx.Activity().LinkListenBrainz(ctx.User(), req.Token)
+	if err != nil {
+		if errors.Is(err, listenbrainz.ErrInvalidToken) {
+			badRequest(w, err)
+		} else {
+			serverErr(w, err)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// /api/scrobblers/lastfm
+func apiScrobblerLastfm(w http.ResponseWriter, r *http.Request) {
+	ctx := contextValue(r)
+	req, err := recvScrobbler(w, r)
+	if err != nil {
+		return
+	}
+	if req.User == "" || req.Password == "" {
+		badRequest(w, ErrMissingParameter)
+		return
+	}
+	err = ctx.Activity().LinkLastfm(ctx.User(), req.User, req.Password)
+	if err != nil {
+		if errors.Is(err, lastfm.ErrNotConfigured) {
+			serverErr(w, err)
+		} else {
+			// likely invalid credentials
+			badRequest(w, err)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// /api/scrobblers/{service}
+func apiScrobblerDelete(w http.ResponseWriter, r *http.Request) {
+	ctx := contextValue(r)
+	service := r.PathValue(ParamService)
+	err := ctx.Activity().UnlinkScrobbler(ctx.User(), service)
+	if err != nil {
+		if errors.Is(err, activity.ErrInvalidService) {
+			notFoundErr(w)
+		} else {
+			serverErr(w, err)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
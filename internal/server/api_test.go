@@ -21,9 +21,13 @@ import (
 	"bytes"
 	"encoding/json"
 	"io"
+	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"takeoutfm.dev/takeout/internal/music"
+	"takeoutfm.dev/takeout/model"
 	"takeoutfm.dev/takeout/spiff"
 	"takeoutfm.dev/takeout/view"
 )
@@ -230,3 +234,90 @@ func TestApiPlaylistsGetPlaylistsEmpty(t *testing.T) {
 		t.Error("expected 0 entries")
 	}
 }
+
+func TestRecvStation(t *testing.T) {
+	tests := []struct {
+		body   string
+		status int
+		typ    string
+		ref    string
+	}{
+		{`{"Name":"80s","Ref":"/music/search?q=date:1980-1989&radio=1"}`, 200, music.TypeOther, "/music/search?q=date:1980-1989&radio=1"},
+		{`{"Name":"Artist","Type":"artist","Ref":"/music/artists/100/deep"}`, 200, music.TypeArtist, "/music/artists/100/deep"},
+		{`{"Name":"Bad","Ref":"/api/secret"}`, 400, "", ""},
+		{`{"Ref":"/music/tracks/1"}`, 400, "", ""},
+		{`{"Name":"Mix","Type":"mix","Ref":"/music/mix/1"}`, 400, "", ""},
+		{`{"Name":"Loop","Ref":"/music/stations/1"}`, 400, "", ""},
+		{`{"Name":"Radio","Type":"stream","Source":[{"contentType":"audio/mpeg","url":"https://203.0.113.10/stream.mp3"},{"url":"http://203.0.113.10/stream.pls"}]}`,
+			200, music.TypeStream, `[{"contentType":"audio/mpeg","url":"https://203.0.113.10/stream.mp3"},{"contentType":"","url":"http://203.0.113.10/stream.pls"}]`},
+		{`{"Name":"Radio","Type":"stream","Ref":"https://203.0.113.10/stream.aac"}`, 200, music.TypeStream, `[{"contentType":"","url":"https://203.0.113.10/stream.aac"}]`},
+		{`{"Name":"Radio","Type":"stream","Source":[{"url":"file:///etc/passwd"}]}`, 400, "", ""},
+		{`{"Name":"Radio","Type":"stream"}`, 400, "", ""},
+		{`{"Name":"Local","Type":"stream","Ref":"http://127.0.0.1:8080/stream"}`, 400, "", ""},
+		{`{"Name":"Local","Type":"stream","Ref":"http://localhost/stream"}`, 400, "", ""},
+		{`{"Name":"Private","Type":"stream","Ref":"http://192.168.1.10/stream"}`, 400, "", ""},
+		{`{"Name":"Metadata","Type":"stream","Ref":"http://169.254.169.254/latest/meta-data"}`, 400, "", ""},
+		{`{"Name":"IPv6","Type":"stream","Ref":"http://[::1]/stream"}`, 400, "", ""},
+		{`{"Name":"Image","Ref":"/music/tracks/1","Image":"javascript:alert(1)"}`, 400, "", ""},
+		{`{"Name":"Image","Ref":"/music/tracks/1","Image":"/img/local/cover.jpg"}`, 200, music.TypeOther, "/music/tracks/1"},
+		{`{"Name":"Image","Ref":"/music/tracks/1","Image":"https://example.com/cover.jpg"}`, 400, "", ""},
+		{`{"Name":"Shared","Ref":"/music/tracks/1","Shared":true}`, 403, "", ""},
+	}
+
+	for _, tc := range tests {
+		r := httptest.NewRequest("POST", "https://takeout/api/radio", strings.NewReader(tc.body))
+		r = withContext(r, NewTestContext(t))
+		w := httptest.NewRecorder()
+		var s model.Station
+		err := recvStation(w, r, &s)
+		if tc.status == 200 {
+			if err != nil {
+				t.Error("expect station", tc.body, err)
+				continue
+			}
+			if s.Type != tc.typ || s.Ref != tc.ref {
+				t.Error("expect station", tc.typ, tc.ref, s.Type, s.Ref)
+			}
+		} else if err == nil || w.Code != tc.status {
+			t.Error("expect error", tc.status, tc.body, w.Code)
+		}
+	}
+}
+
+func TestAdminOnly(t *testing.T) {
+	called := false
+	handler := adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+	r := httptest.NewRequest("POST", "https://takeout/api/unmatched/releases/1/match", nil)
+	r = withContext(r, NewTestContext(t))
+	w := httptest.NewRecorder()
+	handler(w, r)
+	if called || w.Code != http.StatusForbidden {
+		t.Error("expect access denied", w.Code)
+	}
+}
+
+func TestSetlistID(t *testing.T) {
+	tests := []struct {
+		id string
+		ok bool
+	}{
+		{"23be88f3", true},
+		{"../search/setlists", false},
+		{"23be88f3?p=2", false},
+		{"", false},
+	}
+	for _, tc := range tests {
+		r := httptest.NewRequest("GET", "https://takeout/api/setlists/x", nil)
+		r.SetPathValue(ParamID, tc.id)
+		w := httptest.NewRecorder()
+		id, ok := setlistID(w, r)
+		if ok != tc.ok || (ok && id != tc.id) {
+			t.Error("expect setlist id", tc.id, ok)
+		}
+		if !ok && w.Code != http.StatusBadRequest {
+			t.Error("expect bad request", tc.id, w.Code)
+		}
+	}
+}
@@ -256,6 +256,18 @@ func authHandler(ctx RequestContext, handler http.HandlerFunc, mask bits) http.H
 	return http.HandlerFunc(fn)
 }
 
+// adminOnly allows the handler only for users that can manage shared content.
+// It's used within an auth handler which provides the user context.
+func adminOnly(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(contextValue(r)) {
+			accessDenied(w)
+			return
+		}
+		handler(w, r)
+	}
+}
+
 // mediaTokenAuthHandler handles media access requests using the media token (or cookie).
 func mediaTokenAuthHandler(ctx RequestContext, handler http.HandlerFunc) http.Handler {
 	return authHandler(ctx, handler, AllowMediaToken|AllowCookie)
@@ -32,7 +32,9 @@ import (
 	"takeoutfm.dev/takeout/internal/progress"
 	"takeoutfm.dev/takeout/internal/tv"
 	"takeoutfm.dev/takeout/lib/client"
+	"takeoutfm.dev/takeout/lib/relay"
 	"takeoutfm.dev/takeout/lib/str"
+	"takeoutfm.dev/takeout/lib/thumb"
 	"takeoutfm.dev/takeout/model"
 )
 
@@ -63,6 +65,9 @@ type Context interface {
 	Film() *film.Film
 	TV() *tv.TV
 	ImageClient() client.Getter
+	ImageProxy() client.Getter
+	Thumb() *thumb.Thumb
+	Relays() *relay.Relays
 
 	LocateTrack(model.Track) string
 	LocateMovie(model.Movie) string
@@ -72,9 +77,11 @@ type Context interface {
 	FindArtist(string) (model.Artist, error)
 	FindRelease(string) (model.Release, error)
 	FindReleaseTracks(model.Release) []model.Track
+	FindTracksAlternates([]model.Track) map[uint][]model.Track
 	FindTrack(string) (model.Track, error)
 	FindStation(string) (model.Station, error)
 	FindPlaylist(string) (model.Playlist, error)
+	FindSmartPlaylist(string) (model.SmartPlaylist, error)
 	FindMovie(string) (model.Movie, error)
 	FindTVSeries(string) (model.TVSeries, error)
 	FindTVEpisode(string) (model.TVEpisode, error)
@@ -102,6 +109,9 @@ type RequestContext struct {
 	session     auth.Session
 	template    *template.Template
 	imageClient client.Getter
+	imageProxy  client.Getter
+	thumb       *thumb.Thumb
+	relays      *relay.Relays
 }
 
 func makeContext(ctx Context, u auth.User, c *config.Config, m *Media) RequestContext {
@@ -111,7 +121,9 @@ func makeContext(ctx Context, u auth.User, c *config.Config, m *Media) RequestCo
 		config:   c,
 		media:    m,
 		progress: ctx.Progress(),
+		relays:   ctx.Relays(),
 		template: ctx.Template(),
+		thumb:    ctx.Thumb(),
 		user:     u,
 	}
 }
@@ -126,6 +138,8 @@ func makeAuthOnlyContext(ctx Context, session auth.Session) RequestContext {
 func makeImageContext(ctx Context, client client.Getter) RequestContext {
 	return RequestContext{
 		imageClient: client,
+		imageProxy:  ctx.ImageProxy(),
+		thumb:       ctx.Thumb(),
 	}
 }
 
@@ -201,6 +215,10 @@ func (ctx RequestContext) FindReleaseTracks(release model.Release) []model.Track
 	return ctx.Music().ReleaseTracks(release)
 }
 
+func (ctx RequestContext) FindTracksAlternates(tracks []model.Track) map[uint][]model.Track {
+	return ctx.Music().TracksAlternates(tracks)
+}
+
 func (ctx RequestContext) FindTrack(id string) (model.Track, error) {
 	return ctx.Music().FindTrack(id)
 }
@@ -213,6 +231,10 @@ func (ctx RequestContext) FindPlaylist(id string) (model.Playlist, error) {
 	return ctx.Music().FindPlaylist(ctx.User(), id)
 }
 
+func (ctx RequestContext) FindSmartPlaylist(id string) (model.SmartPlaylist, error) {
+	return ctx.Music().FindSmartPlaylist(ctx.User(), id)
+}
+
 func (ctx RequestContext) FindMovie(id string) (model.Movie, error) {
 	return ctx.Film().FindMovie(id)
 }
@@ -281,6 +303,18 @@ func (ctx RequestContext) ImageClient() client.Getter {
 	return ctx.imageClient
 }
 
+func (ctx RequestContext) ImageProxy() client.Getter {
+	return ctx.imageProxy
+}
+
+func (ctx RequestContext) Thumb() *thumb.Thumb {
+	return ctx.thumb
+}
+
+func (ctx RequestContext) Relays() *relay.Relays {
+	return ctx.relays
+}
+
 func locateTrack(t model.Track) string {
 	return fmt.Sprintf("/api/tracks/%s/location", t.UUID)
 }
@@ -34,7 +34,9 @@ import (
 	"takeoutfm.dev/takeout/internal/tv"
 	"takeoutfm.dev/takeout/lib/client"
 	"takeoutfm.dev/takeout/lib/gorm"
+	"takeoutfm.dev/takeout/lib/relay"
 	"takeoutfm.dev/takeout/lib/str"
+	"takeoutfm.dev/takeout/lib/thumb"
 	"takeoutfm.dev/takeout/model"
 )
 
@@ -64,6 +66,7 @@ type TestContext struct {
 	p    *progress.Progress
 	f    *film.Film
 	tv   *tv.TV
+	c    *config.Config // optional config to use instead of the default
 }
 
 func NewTestContext(t *testing.T) *TestContext {
@@ -93,6 +96,9 @@ func (c *TestContext) Auth() *auth.Auth {
 }
 
 func (c *TestContext) Config() *config.Config {
+	if c.c != nil {
+		return c.c
+	}
 	config, err := config.TestingConfig()
 	if err != nil {
 		c.t.Fatal(err)
@@ -178,6 +184,18 @@ func (c *TestContext) ImageClient() client.Getter {
 	return nil
 }
 
+func (c *TestContext) ImageProxy() client.Getter {
+	return nil
+}
+
+func (c *TestContext) Thumb() *thumb.Thumb {
+	return nil
+}
+
+func (c *TestContext) Relays() *relay.Relays {
+	return nil
+}
+
 func (c *TestContext) LocateTrack(t model.Track) string {
 	return "/api/tracks/4e3f3533-5f1a-4899-b44b-83268e0b2b39/location"
 }
@@ -217,6 +235,10 @@ func (c *TestContext) FindReleaseTracks(release model.Release) []model.Track {
 	return []model.Track{t}
 }
 
+func (c *TestContext) FindTracksAlternates(tracks []model.Track) map[uint][]model.Track {
+	return nil
+}
+
 func (c *TestContext) FindTrack(id string) (model.Track, error) {
 	if id == TestTrackID {
 		return model.Track{
@@ -249,6 +271,10 @@ func (c *TestContext) FindPlaylist(id string) (model.Playlist, error) {
 	return model.Playlist{}, errors.New("playlist not found")
 }
 
+func (c *TestContext) FindSmartPlaylist(id string) (model.SmartPlaylist, error) {
+	return c.Music().FindSmartPlaylist(auth.User{Name: TestUserID}, id)
+}
+
 func (c *TestContext) FindMovie(id string) (model.Movie, error) {
 	if id == TestMovieID {
 		return model.Movie{Title: "test movie"}, nil
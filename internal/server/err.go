@@ -45,6 +45,8 @@ var (
 	ErrMissingTitle         = errors.New("missing title")
 	ErrMissingParameter     = errors.New("missing required parameter")
 	ErrInvalidParameter     = errors.New("invalid parameter")
+	ErrInvalidRef           = errors.New("invalid ref")
+	ErrInvalidImage         = errors.New("invalid image")
 )
 
 func serverErr(w http.ResponseWriter, err error) {
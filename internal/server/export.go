@@ -0,0 +1,129 @@
+// Copyright 2024 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package server
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"takeoutfm.dev/takeout/internal/activity"
+	"takeoutfm.dev/takeout/lib/log"
+)
+
+type exportPlaylist struct {
+	Name     string
+	Playlist json.RawMessage
+}
+
+func exportContentType(format string) string {
+	switch format {
+	case activity.ExportCSV:
+		return "text/csv"
+	case activity.ExportListenBrainz:
+		return "application/x-ndjson"
+	}
+	return ApplicationJson
+}
+
+func attachment(w http.ResponseWriter, name string) {
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+}
+
+// GET /api/activity/export?format=json|csv|listenbrainz
+func apiActivityExport(w http.ResponseWriter, r *http.Request) {
+	ctx := contextValue(r)
+	format := r.URL.Query().Get(QueryFormat)
+	if format == "" {
+		format = activity.ExportJson
+	}
+	if format != activity.ExportJson && format != activity.ExportCSV &&
+		format != activity.ExportListenBrainz {
+		badRequest(w, activity.ErrInvalidExportFormat)
+		return
+	}
+	w.Header().Set("Content-Type", exportContentType(format))
+	attachment(w, "activity."+activity.ExportExtension(format))
+	err := ctx.Activity().WriteExport(ctx, format, w)
+	if err != nil {
+		log.Println("activity export", err)
+	}
+}
+
+// GET /api/export
+//
+// Zip file with all the user's activity, playlists, progress offsets and
+// podcast subscriptions.
+func apiExport(w http.ResponseWriter, r *http.Request) {
+	ctx := contextValue(r)
+	user := ctx.User()
+
+	w.Header().Set("Content-Type", "application/zip")
+	attachment(w, fmt.Sprintf("takeout-%s-%s.zip", user.Name, time.Now().Format("20060102")))
+
+	z := zip.NewWriter(w)
+	add := func(name string, write func(io.Writer) error) error {
+		f, err := z.Create(name)
+		if err != nil {
+			return err
+		}
+		return write(f)
+	}
+	encode := func(v interface{}) func(io.Writer) error {
+		return func(w io.Writer) error {
+			return json.NewEncoder(w).Encode(v)
+		}
+	}
+	events := ctx.Activity().ExportEvents(ctx)
+	export := func(format string) func(io.Writer) error {
+		return func(w io.Writer) error {
+			return activity.WriteEvents(events, format, w)
+		}
+	}
+
+	var playlists []exportPlaylist
+	for _, p := range ctx.Music().UserPlaylists(user) {
+		playlists = append(playlists, exportPlaylist{Name: p.Name, Playlist: p.Playlist})
+	}
+
+	files := []struct {
+		name  string
+		write func(io.Writer) error
+	}{
+		{"activity.json", export(activity.ExportJson)},
+		{"activity.csv", export(activity.ExportCSV)},
+		{"listens.jsonl", export(activity.ExportListenBrainz)},
+		{"playlists.json", encode(playlists)},
+		{"progress.json", encode(ctx.Progress().Offsets(user))},
+		{"subscriptions.json", encode(ctx.Podcast().SubscriptionsFor(user.Name))},
+	}
+	for _, f := range files {
+		err := add(f.name, f.write)
+		if err != nil {
+			log.Println("export", f.name, err)
+			break
+		}
+	}
+	err := z.Close()
+	if err != nil {
+		log.Println("export", err)
+	}
+}
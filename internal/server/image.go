@@ -20,8 +20,10 @@ package server
 import (
 	"fmt"
 	"net/http"
+	"strconv"
 
 	"takeoutfm.dev/takeout/lib/header"
+	"takeoutfm.dev/takeout/lib/thumb"
 )
 
 const (
@@ -35,26 +37,44 @@ const (
 // the result locally. A forced max-age can be used to extend the the age of
 // the cached image. Reading will use a cache-only client which only reads
 // pre-cached images and will not try to fetch from the source. When nothing is
-// cached, the reader will redirect to the original source. Same config is used
-// for both use cases.
+// cached, the reader will redirect to the original source, unless the image
+// proxy is enabled, in which case the image is fetched and cached on behalf of
+// the client and clients are never redirected. Same config is used for both
+// use cases. Cached images can be resized with the w query parameter,
+// one of the configured widths, and the resized image is cached separately.
 
 func checkImageCache(w http.ResponseWriter, r *http.Request, url string) {
 	ctx := contextValue(r)
 	client := ctx.ImageClient()
 	hdr, img, err := client.Get(url)
-	if err == nil && len(img) > 0 {
-		for k, v := range hdr {
-			switch k {
-			case header.ContentType, header.ContentLength, header.ETag,
-				header.LastModified, header.CacheControl:
-				w.Header().Set(k, v[0])
-			}
+	if err != nil || len(img) == 0 {
+		proxy := ctx.ImageProxy()
+		if proxy == nil {
+			http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+			return
+		}
+		hdr, img, err = proxy.Get(url)
+		if err != nil || len(img) == 0 {
+			notFoundErr(w)
+			return
+		}
+	}
+	if resizeRequested(r) {
+		if v := hdr.Get(header.CacheControl); v != "" {
+			w.Header().Set(header.CacheControl, v)
+		}
+		writeResized(w, r, url, img)
+		return
+	}
+	for k, v := range hdr {
+		switch k {
+		case header.ContentType, header.ContentLength, header.ETag,
+			header.LastModified, header.CacheControl:
+			w.Header().Set(k, v[0])
 		}
-		w.WriteHeader(http.StatusOK)
-		w.Write(img)
-	} else {
-		http.Redirect(w, r, url, http.StatusTemporaryRedirect)
 	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(img)
 }
 
 func imgRelease(w http.ResponseWriter, r *http.Request) {
@@ -103,3 +123,56 @@ func imgArtistBackground(w http.ResponseWriter, r *http.Request) {
 	url := fmt.Sprintf("%s/music/%s/artistbackground/%s", FanArtPrefix, arid, path)
 	checkImageCache(w, r, url)
 }
+
+// imgLocal serves local artwork, either an image file from a bucket or a
+// picture embedded in a track.
+func imgLocal(w http.ResponseWriter, r *http.Request) {
+	ctx := contextValue(r)
+	img, contentType, err := ctx.Music().LocalArtworkImage(r.PathValue("key"))
+	if err != nil {
+		notFoundErr(w)
+		return
+	}
+	if resizeRequested(r) {
+		writeResized(w, r, r.URL.Path, img)
+		return
+	}
+	w.Header().Set(header.ContentType, contentType)
+	w.Header().Set(header.ContentLength, strconv.Itoa(len(img)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(img)
+}
+
+func resizeRequested(r *http.Request) bool {
+	ctx := contextValue(r)
+	return ctx.Thumb() != nil && r.URL.Query().Get(QueryWidth) != ""
+}
+
+// writeResized writes the image resized to the requested width and format.
+// The key identifies the source image for the derivative cache.
+func writeResized(w http.ResponseWriter, r *http.Request, key string, img []byte) {
+	ctx := contextValue(r)
+	t := ctx.Thumb()
+	width, err := strconv.Atoi(r.URL.Query().Get(QueryWidth))
+	if err != nil {
+		badRequest(w, thumb.ErrInvalidWidth)
+		return
+	}
+	format, err := t.Format(r.URL.Query().Get(QueryFormat))
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	data, err := t.Resize(key, img, width, format)
+	if err == thumb.ErrInvalidWidth {
+		badRequest(w, err)
+		return
+	} else if err != nil {
+		serverErr(w, err)
+		return
+	}
+	w.Header().Set(header.ContentType, thumb.ContentType(format))
+	w.Header().Set(header.ContentLength, strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
@@ -0,0 +1,123 @@
+// Copyright 2024 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package server
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"takeoutfm.dev/takeout/lib/client"
+	"takeoutfm.dev/takeout/lib/header"
+	"takeoutfm.dev/takeout/lib/thumb"
+)
+
+func TestWriteResized(t *testing.T) {
+	var buf bytes.Buffer
+	err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 200, 100)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := buf.Bytes()
+
+	ctx := RequestContext{
+		thumb: thumb.New(thumb.Config{
+			CacheDir: t.TempDir(),
+			Widths:   []int{50, 100},
+			Format:   thumb.FormatJPEG,
+		}),
+	}
+
+	status := map[string]int{
+		"/img?w=50":             http.StatusOK,
+		"/img?w=100&format=png": http.StatusOK,
+		"/img?w=75":             http.StatusBadRequest,
+		"/img?w=x":              http.StatusBadRequest,
+		"/img?w=50&format=bmp":  http.StatusBadRequest,
+	}
+	for target, code := range status {
+		r := withContext(httptest.NewRequest("GET", target, nil), ctx)
+		if !resizeRequested(r) {
+			t.Fatal("expect resize", target)
+		}
+		w := httptest.NewRecorder()
+		writeResized(w, r, "test", src)
+		if w.Code != code {
+			t.Error("expect code", target, code, w.Code)
+		}
+	}
+
+	r := withContext(httptest.NewRequest("GET", "/img", nil), ctx)
+	if resizeRequested(r) {
+		t.Error("expect no resize")
+	}
+
+	r = withContext(httptest.NewRequest("GET", "/img?w=50", nil), ctx)
+	w := httptest.NewRecorder()
+	writeResized(w, r, "test", src)
+	if w.Header().Get(header.ContentType) != "image/jpeg" {
+		t.Error("expect jpeg", w.Header().Get(header.ContentType))
+	}
+	img, err := jpeg.Decode(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != 50 || img.Bounds().Dy() != 25 {
+		t.Error("expect 50x25", img.Bounds())
+	}
+}
+
+type imageServer struct{}
+
+func (imageServer) RoundTrip(r *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader("image")),
+		Header:     http.Header{header.ContentType: []string{"image/jpeg"}},
+	}, nil
+}
+
+func TestCheckImageCache(t *testing.T) {
+	const url = "https://image.tmdb.org/t/p/w342/poster.jpg"
+	ctx := RequestContext{
+		imageClient: client.NewCacheOnlyGetter(client.Config{CacheDir: t.TempDir()}),
+	}
+	r := withContext(httptest.NewRequest("GET", "/img/tm/w342/poster.jpg", nil), ctx)
+	w := httptest.NewRecorder()
+	checkImageCache(w, r, url)
+	if w.Code != http.StatusTemporaryRedirect || w.Header().Get("Location") != url {
+		t.Error("expect redirect", w.Code)
+	}
+
+	ctx.imageProxy = client.NewProxyGetter(client.NewTransportGetter(client.Config{}, imageServer{}))
+	r = withContext(httptest.NewRequest("GET", "/img/tm/w342/poster.jpg", nil), ctx)
+	w = httptest.NewRecorder()
+	checkImageCache(w, r, url)
+	if w.Code != http.StatusOK || w.Body.String() != "image" {
+		t.Error("expect proxy", w.Code)
+	}
+	if w.Header().Get(header.ContentType) != "image/jpeg" {
+		t.Error("expect content type", w.Header().Get(header.ContentType))
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2024 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package server
+
+import (
+	"io"
+	"net/http"
+
+	"takeoutfm.dev/takeout/internal/config"
+	"takeoutfm.dev/takeout/model"
+)
+
+// ImportBodyLimit is the maximum size of an uploaded listening history
+// export.
+const ImportBodyLimit = 64 << 20
+
+// ImportActivity imports listening history for the named user from a
+// ListenBrainz or Last.fm export.
+func ImportActivity(config *config.Config, userName, format string, r io.Reader) (model.ImportReport, error) {
+	var report model.ImportReport
+
+	a, err := makeAuth(config)
+	if err != nil {
+		return report, err
+	}
+	defer a.Close()
+
+	user, err := a.User(userName)
+	if err != nil {
+		return report, err
+	}
+
+	mediaName, userConfig, err := mediaConfigFor(config, user)
+	if err != nil {
+		return report, err
+	}
+
+	activity, err := makeActivity(config)
+	if err != nil {
+		return report, err
+	}
+	defer activity.Close()
+
+	ctx := RequestContext{
+		activity: activity,
+		auth:     a,
+		config:   userConfig,
+		media:    makeMedia(mediaName, userConfig),
+		user:     user,
+	}
+	return activity.ImportListens(ctx, format, r)
+}
+
+// POST /api/activity/import?format=listenbrainz|lastfm
+//
+// The request body is limited to ImportBodyLimit bytes.
+func apiActivityImport(w http.ResponseWriter, r *http.Request) {
+	ctx := contextValue(r)
+	format := r.URL.Query().Get(QueryFormat)
+	body := http.MaxBytesReader(w, r.Body, ImportBodyLimit)
+	report, err := ctx.Activity().ImportListens(ctx, format, body)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	apiView(w, r, report)
+}
@@ -19,9 +19,11 @@ package server
 
 import (
 	"net/http"
+	"sync"
 
 	"github.com/go-co-op/gocron"
 
+	"takeoutfm.dev/takeout/internal/activity"
 	"takeoutfm.dev/takeout/internal/auth"
 	"takeoutfm.dev/takeout/internal/config"
 	"takeoutfm.dev/takeout/internal/film"
@@ -29,11 +31,17 @@ import (
 	"takeoutfm.dev/takeout/internal/podcast"
 	"takeoutfm.dev/takeout/internal/tv"
 	"takeoutfm.dev/takeout/lib/log"
+	"takeoutfm.dev/takeout/lib/thumb"
+	"takeoutfm.dev/takeout/model"
 	"time"
 )
 
 type syncFunc func(config *config.Config, mediaConfig *config.Config) error
 
+// syncLock ensures scheduled and watch triggered syncs don't run at the same
+// time.
+var syncLock sync.Mutex
+
 func schedule(config *config.Config) {
 	scheduler := gocron.NewScheduler(time.UTC)
 
@@ -49,6 +57,8 @@ func schedule(config *config.Config) {
 			sched = sched.WaitForSchedule()
 		}
 		sched.Do(func() {
+			syncLock.Lock()
+			defer syncLock.Unlock()
 			list, err := assignedMedia(config)
 			if err != nil {
 				log.Println(err)
@@ -70,6 +80,7 @@ func schedule(config *config.Config) {
 	mediaSync(config.Music.PopularSyncInterval, syncMusicPopular, false)
 	mediaSync(config.Music.SimilarSyncInterval, syncMusicSimilar, false)
 	mediaSync(config.Music.CoverSyncInterval, syncMusicCovers, false)
+	mediaSync(config.Music.SmartSyncInterval, syncSmartPlaylists, false)
 
 	// podcasts
 	mediaSync(config.Podcast.SyncInterval, syncPodcasts, false)
@@ -85,6 +96,38 @@ func schedule(config *config.Config) {
 	mediaSync(config.TV.BackdropSyncInterval, syncTVBackdrops, false)
 	mediaSync(config.TV.StillSyncInterval, syncTVStills, false)
 
+	if config.Activity.ScrobbleInterval > 0 {
+		scheduler.Every(config.Activity.ScrobbleInterval).WaitForSchedule().Do(func() {
+			a := activity.NewActivity(config)
+			err := a.Open()
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			defer a.Close()
+			err = a.SendScrobbles()
+			if err != nil {
+				log.Println(err)
+			}
+		})
+	}
+
+	if config.Activity.MixTime != "" {
+		scheduler.Every(1).Day().At(config.Activity.MixTime).Do(func() {
+			err := createMixStations(config)
+			if err != nil {
+				log.Println(err)
+			}
+		})
+	}
+
+	scheduler.Every(1).Day().WaitForSchedule().Do(func() {
+		err := thumb.New(config.Server.ImageResize).Prune()
+		if err != nil {
+			log.Println(err)
+		}
+	})
+
 	scheduler.Every(time.Minute * 5).WaitForSchedule().Do(func() {
 		a := auth.NewAuth(config)
 		err := a.Open()
@@ -160,6 +203,32 @@ func syncMusicCovers(config *config.Config, mediaConfig *config.Config) error {
 	return nil
 }
 
+func syncSmartPlaylists(config *config.Config, mediaConfig *config.Config) error {
+	m := music.NewMusic(mediaConfig)
+	err := m.Open()
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	a := activity.NewActivity(config)
+	err = a.Open()
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+	for _, p := range m.ScheduledSmartPlaylists() {
+		var plays map[string]model.TrackPlays
+		if music.SmartPlaylistActivity(p) {
+			plays = a.TrackPlays(p.User)
+		}
+		err = m.RefreshSmartPlaylist(&p, plays)
+		if err != nil {
+			log.Println(p.Name, err)
+		}
+	}
+	return nil
+}
+
 func syncMusicFanArt(config *config.Config, mediaConfig *config.Config) error {
 	m := music.NewMusic(mediaConfig)
 	err := m.Open()
@@ -278,6 +347,74 @@ func syncPodcasts(config *config.Config, mediaConfig *config.Config) error {
 	return p.Sync()
 }
 
+// reindex rebuilds outdated search indexes for all assigned media. This
+// runs before jobs are scheduled and requests are served since indexes are
+// removed and created again. Errors are logged so other media are still
+// reindexed.
+func reindex(config *config.Config) {
+	list, err := assignedMedia(config)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for _, mediaName := range list {
+		mediaConfig, err := mediaConfig(config, mediaName)
+		if err != nil {
+			log.Println(mediaName, err)
+			continue
+		}
+		err = reindexMedia(config, mediaConfig)
+		if err != nil {
+			log.Println(mediaName, err)
+		}
+	}
+}
+
+// reindexMedia rebuilds each media search index that was created with an
+// older mapping version.
+func reindexMedia(config *config.Config, mediaConfig *config.Config) error {
+	m := music.NewMusic(mediaConfig)
+	err := m.Open()
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	err = m.Reindex()
+	if err != nil {
+		return err
+	}
+
+	f := film.NewFilm(mediaConfig)
+	err = f.Open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	err = f.Reindex()
+	if err != nil {
+		return err
+	}
+
+	tv := tv.NewTV(mediaConfig)
+	err = tv.Open()
+	if err != nil {
+		return err
+	}
+	defer tv.Close()
+	err = tv.Reindex()
+	if err != nil {
+		return err
+	}
+
+	p := podcast.NewPodcast(mediaConfig)
+	err = p.Open()
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+	return p.Reindex()
+}
+
 func createStations(config *config.Config, mediaConfig *config.Config) error {
 	m := music.NewMusic(mediaConfig)
 	err := m.Open()
@@ -290,7 +427,48 @@ func createStations(config *config.Config, mediaConfig *config.Config) error {
 	return nil
 }
 
+// createMixStations builds new daily mix stations for each user with
+// assigned media.
+func createMixStations(config *config.Config) error {
+	a, err := makeAuth(config)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+	act, err := makeActivity(config)
+	if err != nil {
+		return err
+	}
+	defer act.Close()
+
+	for _, user := range a.Users() {
+		if len(user.MediaList()) == 0 {
+			continue
+		}
+		mediaName, userConfig, err := mediaConfigFor(config, user)
+		if err != nil {
+			log.Println(user.Name, err)
+			continue
+		}
+		ctx := RequestContext{
+			activity: act,
+			auth:     a,
+			config:   userConfig,
+			media:    makeMedia(mediaName, userConfig),
+			user:     user,
+		}
+		err = act.CreateMixStations(ctx)
+		if err != nil {
+			log.Println(user.Name, err)
+		}
+	}
+	return nil
+}
+
 func Job(config *config.Config, name string) error {
+	if name == "mix" {
+		return createMixStations(config)
+	}
 	list, err := assignedMedia(config)
 	if err != nil {
 		return err
@@ -332,6 +510,11 @@ func Job(config *config.Config, name string) error {
 			syncMusicPopular(config, mediaConfig)
 		case "podcasts":
 			syncPodcasts(config, mediaConfig)
+		case "reindex":
+			err = reindexMedia(config, mediaConfig)
+			if err != nil {
+				return err
+			}
 		case "posters":
 			syncTVPosters(config, mediaConfig)
 			syncFilmPosters(config, mediaConfig)
@@ -340,6 +523,8 @@ func Job(config *config.Config, name string) error {
 			syncFilmProfileImages(config, mediaConfig)
 		case "similar":
 			syncMusicSimilar(config, mediaConfig)
+		case "smart":
+			syncSmartPlaylists(config, mediaConfig)
 		case "stills":
 			syncTVStills(config, mediaConfig)
 		case "film":
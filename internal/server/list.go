@@ -0,0 +1,416 @@
+// Copyright 2023 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"takeoutfm.dev/takeout/lib/gorm"
+	"takeoutfm.dev/takeout/model"
+
+	. "takeoutfm.dev/takeout/view"
+)
+
+const (
+	ListSortName     = "name"
+	ListSortAdded    = "added"
+	ListSortReleased = "released"
+	ListSortRating   = "rating"
+	ListSortPlays    = "plays"
+
+	// ListLetterOther matches names that don't start with a letter.
+	ListLetterOther = "#"
+)
+
+// ListOptions are the paging, sort and filter options for library lists. A
+// zero limit includes all items and an empty sort keeps the default order.
+// Requests always have a limit, see recvListOptions.
+type ListOptions struct {
+	Offset int
+	Limit  int
+	Sort   string
+	Desc   bool
+	Genre  string
+	Decade int
+	Letter string
+}
+
+// listFields has the item values used to sort and filter a list. A nil field
+// can't be used to sort or filter.
+type listFields[T any] struct {
+	name     func(T) string
+	added    func(T) time.Time
+	released func(T) time.Time
+	rating   func(T) float64
+	plays    func() func(T) int
+	genre    func(string) func(T) bool
+	columns  listColumns
+}
+
+// listColumns has the database columns for the fields, used to filter, sort
+// and page in the query. An empty column can't be queried.
+type listColumns struct {
+	name     string
+	added    string
+	released string
+	rating   string
+	genre    string // condition using the genre name
+}
+
+// findPage queries the requested page when the options can be expressed
+// with the columns, otherwise all items are queried and paged with listPage.
+func findPage[T any](o ListOptions, f listFields[T],
+	find func(gorm.Page) ([]T, int, error)) ([]T, Page, error) {
+	if q, ok := queryPage(o, f.columns); ok {
+		items, total, err := find(q)
+		if err != nil {
+			return nil, Page{}, err
+		}
+		if items == nil {
+			items = []T{}
+		}
+		return items, Page{Total: total, Offset: o.Offset, Limit: o.Limit}, nil
+	}
+	items, _, err := find(gorm.Page{})
+	if err != nil {
+		return nil, Page{}, err
+	}
+	return listPage(items, o, f)
+}
+
+// queryPage returns the query conditions and order for the options or false
+// if an option needs the item values.
+func queryPage(o ListOptions, c listColumns) (gorm.Page, bool) {
+	q := gorm.Page{Offset: o.Offset, Limit: o.Limit}
+	if o.Genre != "" {
+		if c.genre == "" {
+			return q, false
+		}
+		q.Where = append(q.Where, gorm.Cond{Query: c.genre, Args: []interface{}{o.Genre}})
+	}
+	if o.Decade != 0 {
+		if c.released == "" {
+			return q, false
+		}
+		start := time.Date(o.Decade, 1, 1, 0, 0, 0, 0, time.UTC)
+		q.Where = append(q.Where, gorm.Cond{
+			Query: c.released + " >= ? and " + c.released + " < ?",
+			Args:  []interface{}{start, start.AddDate(10, 0, 0)}})
+	}
+	if o.Letter != "" {
+		// only ascii letters can be compared with sql upper
+		if c.name == "" || len(o.Letter) != 1 || o.Letter[0] < 'A' || o.Letter[0] > 'Z' {
+			return q, false
+		}
+		q.Where = append(q.Where, gorm.Cond{
+			Query: "upper(substr(" + c.name + ", 1, 1)) = ?",
+			Args:  []interface{}{o.Letter}})
+	}
+	var column string
+	switch o.Sort {
+	case "":
+		return q, true
+	case ListSortName:
+		if c.name != "" {
+			column = "lower(" + c.name + ")"
+		}
+	case ListSortAdded:
+		column = c.added
+	case ListSortReleased:
+		column = c.released
+	case ListSortRating:
+		column = c.rating
+	}
+	if column == "" {
+		return q, false
+	}
+	if o.Desc {
+		column += " desc"
+	}
+	// include the id so pages have a stable order
+	q.Order = column + ", id"
+	return q, true
+}
+
+// listPage filters and sorts the items and returns the requested page along
+// with the page details. Options that the fields don't support are invalid.
+func listPage[T any](items []T, o ListOptions, f listFields[T]) ([]T, Page, error) {
+	var filters []func(T) bool
+	if o.Genre != "" {
+		if f.genre == nil {
+			return nil, Page{}, ErrInvalidParameter
+		}
+		filters = append(filters, f.genre(o.Genre))
+	}
+	if o.Decade != 0 {
+		if f.released == nil {
+			return nil, Page{}, ErrInvalidParameter
+		}
+		filters = append(filters, func(v T) bool {
+			year := f.released(v).Year()
+			return year >= o.Decade && year < o.Decade+10
+		})
+	}
+	if o.Letter != "" {
+		filters = append(filters, func(v T) bool {
+			return nameLetter(f.name(v)) == o.Letter
+		})
+	}
+	if len(filters) > 0 {
+		var list []T
+	next:
+		for _, v := range items {
+			for _, match := range filters {
+				if !match(v) {
+					continue next
+				}
+			}
+			list = append(list, v)
+		}
+		items = list
+	}
+
+	var less func(a, b T) bool
+	switch o.Sort {
+	case "":
+	case ListSortName:
+		less = func(a, b T) bool {
+			return strings.ToLower(f.name(a)) < strings.ToLower(f.name(b))
+		}
+	case ListSortAdded:
+		less = func(a, b T) bool {
+			return f.added(a).Before(f.added(b))
+		}
+	case ListSortReleased:
+		if f.released == nil {
+			return nil, Page{}, ErrInvalidParameter
+		}
+		less = func(a, b T) bool {
+			return f.released(a).Before(f.released(b))
+		}
+	case ListSortRating:
+		if f.rating == nil {
+			return nil, Page{}, ErrInvalidParameter
+		}
+		less = func(a, b T) bool {
+			return f.rating(a) < f.rating(b)
+		}
+	case ListSortPlays:
+		if f.plays == nil {
+			return nil, Page{}, ErrInvalidParameter
+		}
+		plays := f.plays()
+		less = func(a, b T) bool {
+			return plays(a) < plays(b)
+		}
+	default:
+		return nil, Page{}, ErrInvalidParameter
+	}
+	if less != nil {
+		sort.SliceStable(items, func(i, j int) bool {
+			if o.Desc {
+				return less(items[j], items[i])
+			}
+			return less(items[i], items[j])
+		})
+	}
+
+	page := Page{Total: len(items), Offset: o.Offset, Limit: o.Limit}
+	if o.Offset >= len(items) {
+		return []T{}, page, nil
+	}
+	items = items[o.Offset:]
+	if o.Limit > 0 && len(items) > o.Limit {
+		items = items[:o.Limit]
+	}
+	return items, page, nil
+}
+
+// nameLetter returns the upper case first letter of the name or
+// ListLetterOther.
+func nameLetter(name string) string {
+	for _, r := range name {
+		if unicode.IsLetter(r) {
+			return string(unicode.ToUpper(r))
+		}
+		break
+	}
+	return ListLetterOther
+}
+
+// recvListOptions parses list options from the query parameters. The sort
+// can be prefixed with - for descending order and the decade is like 1990s.
+// The limit defaults to and can't exceed the configured page limit.
+func recvListOptions(ctx Context, r *http.Request) (ListOptions, error) {
+	pageLimit := ctx.Config().Server.PageLimit
+	o := ListOptions{Limit: pageLimit}
+	var err error
+	values := r.URL.Query()
+	if v := values.Get(QueryOffset); v != "" {
+		o.Offset, err = strconv.Atoi(v)
+		if err != nil || o.Offset < 0 {
+			return o, ErrInvalidOffset
+		}
+	}
+	if v := values.Get(QueryLimit); v != "" {
+		o.Limit, err = strconv.Atoi(v)
+		if err != nil || o.Limit < 0 {
+			return o, ErrInvalidParameter
+		}
+		if o.Limit == 0 || o.Limit > pageLimit {
+			o.Limit = pageLimit
+		}
+	}
+	if v := values.Get(QuerySort); v != "" {
+		o.Sort, o.Desc = strings.TrimPrefix(v, "-"), strings.HasPrefix(v, "-")
+	}
+	o.Genre = strings.TrimSpace(values.Get(QueryGenre))
+	if v := values.Get(QueryDecade); v != "" {
+		o.Decade, err = strconv.Atoi(strings.TrimSuffix(v, "s"))
+		if err != nil || o.Decade%10 != 0 {
+			return o, ErrInvalidParameter
+		}
+	}
+	if v := values.Get(QueryLetter); v != "" {
+		o.Letter = strings.ToUpper(v)
+		if o.Letter != ListLetterOther && nameLetter(o.Letter) != o.Letter {
+			return o, ErrInvalidParameter
+		}
+	}
+	return o, nil
+}
+
+// pageLinks sets the previous and next page links using the request URL with
+// a new offset.
+func pageLinks(u *url.URL, page *Page) {
+	link := func(offset int) string {
+		values := u.Query()
+		values.Set(QueryOffset, strconv.Itoa(offset))
+		values.Set(QueryLimit, strconv.Itoa(page.Limit))
+		return u.Path + "?" + values.Encode()
+	}
+	if page.Limit == 0 {
+		return
+	}
+	if page.Offset > 0 {
+		page.Prev = link(max(page.Offset-page.Limit, 0))
+	}
+	if page.Offset+page.Limit < page.Total {
+		page.Next = link(page.Offset + page.Limit)
+	}
+}
+
+func artistFields(ctx Context) listFields[model.Artist] {
+	return listFields[model.Artist]{
+		name:     func(a model.Artist) string { return a.SortName },
+		added:    func(a model.Artist) time.Time { return a.CreatedAt },
+		released: func(a model.Artist) time.Time { return a.Date },
+		plays: func() func(model.Artist) int {
+			counts := ctx.Activity().ArtistPlays(ctx)
+			return func(a model.Artist) int { return counts[a.Name] }
+		},
+		genre: func(name string) func(model.Artist) bool {
+			return func(a model.Artist) bool { return strings.EqualFold(a.Genre, name) }
+		},
+		columns: listColumns{
+			name:     "sort_name",
+			added:    "created_at",
+			released: "date",
+			genre:    "lower(genre) = lower(?)",
+		},
+	}
+}
+
+func movieFields(ctx Context) listFields[model.Movie] {
+	return listFields[model.Movie]{
+		name:     func(m model.Movie) string { return m.SortTitle },
+		added:    func(m model.Movie) time.Time { return m.CreatedAt },
+		released: func(m model.Movie) time.Time { return m.Date },
+		rating:   func(m model.Movie) float64 { return float64(m.VoteAverage) },
+		plays: func() func(model.Movie) int {
+			counts := ctx.Activity().MoviePlays(ctx.User().Name)
+			return func(m model.Movie) int {
+				return counts[strconv.FormatInt(m.TMID, 10)] + counts[m.IMID]
+			}
+		},
+		genre: func(name string) func(model.Movie) bool {
+			ids := make(map[uint]bool)
+			for _, m := range ctx.Film().Genre(name) {
+				ids[m.ID] = true
+			}
+			return func(m model.Movie) bool { return ids[m.ID] }
+		},
+		columns: listColumns{
+			name:     "sort_title",
+			added:    "created_at",
+			released: "date",
+			rating:   "vote_average",
+			genre:    "movies.tm_id in (select tm_id from genres where name = ?)",
+		},
+	}
+}
+
+func tvSeriesFields(ctx Context) listFields[model.TVSeries] {
+	return listFields[model.TVSeries]{
+		name:     func(s model.TVSeries) string { return s.SortName },
+		added:    func(s model.TVSeries) time.Time { return s.CreatedAt },
+		released: func(s model.TVSeries) time.Time { return s.Date },
+		rating:   func(s model.TVSeries) float64 { return float64(s.VoteAverage) },
+		plays: func() func(model.TVSeries) int {
+			counts := ctx.Activity().TVSeriesPlays(ctx.User().Name)
+			return func(s model.TVSeries) int { return counts[s.TVID] }
+		},
+		genre: func(name string) func(model.TVSeries) bool {
+			ids := make(map[uint]bool)
+			for _, s := range ctx.TV().Genre(name) {
+				ids[s.ID] = true
+			}
+			return func(s model.TVSeries) bool { return ids[s.ID] }
+		},
+		columns: listColumns{
+			name:     "sort_name",
+			added:    "created_at",
+			released: "date",
+			rating:   "vote_average",
+			genre:    "series.tv_id in (select tv_id from genres where name = ?)",
+		},
+	}
+}
+
+func seriesFields(ctx Context) listFields[model.Series] {
+	return listFields[model.Series]{
+		name:     func(s model.Series) string { return s.Title },
+		added:    func(s model.Series) time.Time { return s.CreatedAt },
+		released: func(s model.Series) time.Time { return s.Date },
+		plays: func() func(model.Series) int {
+			counts := ctx.Activity().SeriesPlays(ctx)
+			return func(s model.Series) int { return counts[s.SID] }
+		},
+		columns: listColumns{
+			name:     "title",
+			added:    "created_at",
+			released: "date",
+		},
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright 2024 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "takeoutfm.dev/takeout/view"
+)
+
+type listItem struct {
+	name  string
+	year  int
+	plays int
+}
+
+func listItemFields() listFields[listItem] {
+	return listFields[listItem]{
+		name:     func(v listItem) string { return v.name },
+		added:    func(v listItem) time.Time { return time.Time{} },
+		released: func(v listItem) time.Time { return time.Date(v.year, 1, 1, 0, 0, 0, 0, time.UTC) },
+		plays: func() func(listItem) int {
+			return func(v listItem) int { return v.plays }
+		},
+	}
+}
+
+func listItems() []listItem {
+	return []listItem{
+		{"beta", 1994, 3},
+		{"alpha", 1985, 1},
+		{"100 gecs", 2019, 7},
+		{"Bravo", 1999, 2},
+		{"charlie", 2001, 5},
+	}
+}
+
+func TestListPage(t *testing.T) {
+	items, page, err := listPage(listItems(), ListOptions{Sort: ListSortName, Limit: 2}, listItemFields())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 5 || len(items) != 2 {
+		t.Fatal("expect 2 of 5 items", page, items)
+	}
+	if items[0].name != "100 gecs" || items[1].name != "alpha" {
+		t.Error("expect name order", items)
+	}
+
+	items, _, err = listPage(listItems(), ListOptions{Sort: ListSortPlays, Desc: true, Offset: 1}, listItemFields())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 4 || items[0].name != "charlie" {
+		t.Error("expect plays order", items)
+	}
+
+	items, page, err = listPage(listItems(), ListOptions{Decade: 1990, Letter: "B"}, listItemFields())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 2 || items[0].name != "beta" || items[1].name != "Bravo" {
+		t.Error("expect filtered items", items)
+	}
+
+	items, _, err = listPage(listItems(), ListOptions{Letter: ListLetterOther}, listItemFields())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].name != "100 gecs" {
+		t.Error("expect other letter", items)
+	}
+
+	items, _, err = listPage(listItems(), ListOptions{Offset: 10}, listItemFields())
+	if err != nil || len(items) != 0 {
+		t.Error("expect empty page", items, err)
+	}
+
+	_, _, err = listPage(listItems(), ListOptions{Sort: ListSortRating}, listItemFields())
+	if err != ErrInvalidParameter {
+		t.Error("expect invalid rating sort")
+	}
+	_, _, err = listPage(listItems(), ListOptions{Genre: "rock"}, listItemFields())
+	if err != ErrInvalidParameter {
+		t.Error("expect invalid genre filter")
+	}
+}
+
+func TestQueryPage(t *testing.T) {
+	c := listColumns{name: "sort_name", released: "date", genre: "genre = ?"}
+
+	q, ok := queryPage(ListOptions{Sort: ListSortName, Desc: true, Offset: 10, Limit: 5,
+		Genre: "rock", Decade: 1990, Letter: "B"}, c)
+	if !ok {
+		t.Fatal("expect query")
+	}
+	if q.Order != "lower(sort_name) desc, id" || q.Offset != 10 || q.Limit != 5 {
+		t.Error("expect order and bounds", q)
+	}
+	if len(q.Where) != 3 || q.Where[0].Query != "genre = ?" ||
+		q.Where[1].Query != "date >= ? and date < ?" ||
+		q.Where[2].Query != "upper(substr(sort_name, 1, 1)) = ?" {
+		t.Error("expect conditions", q.Where)
+	}
+	start := q.Where[1].Args[0].(time.Time)
+	end := q.Where[1].Args[1].(time.Time)
+	if start.Year() != 1990 || end.Year() != 2000 {
+		t.Error("expect decade", start, end)
+	}
+
+	q, ok = queryPage(ListOptions{}, c)
+	if !ok || q.Order != "" || len(q.Where) != 0 {
+		t.Error("expect default order", q)
+	}
+
+	for _, o := range []ListOptions{
+		{Sort: ListSortPlays},
+		{Sort: ListSortRating},
+		{Letter: ListLetterOther},
+		{Letter: "É"},
+	} {
+		if _, ok := queryPage(o, c); ok {
+			t.Error("expect item values needed", o)
+		}
+	}
+}
+
+func TestRecvListOptions(t *testing.T) {
+	ctx := NewTestContext(t)
+	pageLimit := ctx.Config().Server.PageLimit
+	r := httptest.NewRequest("GET", "/api/artists?offset=20&limit=10&sort=-added&decade=1980s&letter=b", nil)
+	o, err := recvListOptions(ctx, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Offset != 20 || o.Limit != 10 || o.Sort != ListSortAdded || !o.Desc ||
+		o.Decade != 1980 || o.Letter != "B" {
+		t.Error("expect options", o)
+	}
+
+	for _, q := range []string{"", "limit=0", "limit=1000000"} {
+		r = httptest.NewRequest("GET", "/api/artists?"+q, nil)
+		o, err = recvListOptions(ctx, r)
+		if err != nil || o.Limit != pageLimit {
+			t.Error("expect page limit", q, o.Limit, err)
+		}
+	}
+
+	for _, q := range []string{"offset=-1", "limit=x", "decade=1985", "letter=bb"} {
+		r = httptest.NewRequest("GET", "/api/artists?"+q, nil)
+		if _, err := recvListOptions(ctx, r); err == nil {
+			t.Error("expect error", q)
+		}
+	}
+}
+
+func TestPageLinks(t *testing.T) {
+	r := httptest.NewRequest("GET", "/v?artists=x&offset=10&limit=10", nil)
+	page := Page{Total: 25, Offset: 10, Limit: 10}
+	pageLinks(r.URL, &page)
+	if page.Prev != "/v?artists=x&limit=10&offset=0" {
+		t.Error("expect prev", page.Prev)
+	}
+	if page.Next != "/v?artists=x&limit=10&offset=20" {
+		t.Error("expect next", page.Next)
+	}
+}
@@ -19,6 +19,8 @@ package server
 
 import (
 	"path/filepath"
+	"sync"
+
 	"takeoutfm.dev/takeout/internal/auth"
 	"takeoutfm.dev/takeout/internal/config"
 	"takeoutfm.dev/takeout/internal/film"
@@ -81,9 +83,15 @@ func mediaConfig(root *config.Config, mediaName string) (*config.Config, error)
 	return userConfig, nil
 }
 
-var mediaMap map[string]*Media = make(map[string]*Media)
+// mediaMap is used by request handlers and scheduled jobs.
+var (
+	mediaMu  sync.Mutex
+	mediaMap map[string]*Media = make(map[string]*Media)
+)
 
 func makeMedia(name string, config *config.Config) *Media {
+	mediaMu.Lock()
+	defer mediaMu.Unlock()
 	media, ok := mediaMap[name]
 	if !ok {
 		var err error
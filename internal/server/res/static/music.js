@@ -539,6 +539,22 @@ Takeout.music = (function() {
 	    });
     }
 
+    const doMatch = function(url, id) {
+	return fetch(url, {
+	    credentials: 'include',
+	    method: 'POST',
+	    body: JSON.stringify({ID: id}),
+	    headers: {
+		"Content-type": "application/json"
+	    }}).then(response => {
+		if (response.status == 204) {
+		    forward("/v?unmatched=x");
+		} else {
+		    console.log("doMatch " + response);
+		}
+	    });
+    };
+
     const playClick = function() {
 	if (userPlay == false) {
 	    userPlay = true;
@@ -621,6 +637,13 @@ Takeout.music = (function() {
 	    };
 	});
 
+	const matches = document.querySelectorAll("[data-match]");
+	matches.forEach(e => {
+	    e.onclick = function() {
+		doMatch(e.getAttribute("data-match"), e.getAttribute("data-id"));
+	    };
+	});
+
 	const links = document.querySelectorAll("[data-link]");
 	links.forEach(e => {
 	    e.onclick = function() {
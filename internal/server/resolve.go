@@ -37,8 +37,8 @@ import (
 	"takeoutfm.dev/takeout/view"
 )
 
-func trackEntry(ctx Context, t model.Track) spiff.Entry {
-	return spiff.Entry{
+func trackEntry(ctx Context, t model.Track, alternates []model.Track) spiff.Entry {
+	entry := spiff.Entry{
 		Creator:    t.PreferredArtist(),
 		Album:      t.ReleaseTitle,
 		Title:      t.Title,
@@ -48,6 +48,13 @@ func trackEntry(ctx Context, t model.Track) spiff.Entry {
 		Size:       []int64{t.Size},
 		Date:       date.FormatJson(t.ReleaseDate),
 	}
+	// duplicate copies are alternate locations for the same track
+	for _, alt := range alternates {
+		entry.Location = append(entry.Location, ctx.LocateTrack(alt))
+		entry.Identifier = append(entry.Identifier, alt.ETag)
+		entry.Size = append(entry.Size, alt.Size)
+	}
+	return entry
 }
 
 func movieEntry(ctx Context, m model.Movie) spiff.Entry {
@@ -94,8 +101,9 @@ func episodeEntry(ctx Context, series model.Series, e model.Episode) spiff.Entry
 }
 
 func addTrackEntries(ctx Context, tracks []model.Track, entries []spiff.Entry) []spiff.Entry {
+	alternates := ctx.FindTracksAlternates(tracks)
 	for _, t := range tracks {
-		entries = append(entries, trackEntry(ctx, t))
+		entries = append(entries, trackEntry(ctx, t, alternates[t.ID]))
 	}
 	return entries
 }
@@ -135,6 +143,43 @@ func addPlaylistEntries(ctx Context, playlist model.Playlist, entries []spiff.En
 	return entries
 }
 
+// trackRefTracks returns the tracks for a saved playlist of track refs.
+// Tracks removed since the playlist was saved are skipped.
+func trackRefTracks(ctx Context, data []byte) []model.Track {
+	var ids []int
+	plist, _ := spiff.Unmarshal(data)
+	for _, e := range plist.Spiff.Entries {
+		matches := tracksRegexp.FindStringSubmatch(e.Ref)
+		if matches == nil {
+			continue
+		}
+		ids = append(ids, str.Atoi(matches[1]))
+	}
+	return ctx.Music().LookupTracks(ids)
+}
+
+// smartPlays returns the user listening activity if needed by the smart
+// playlist.
+func smartPlays(ctx Context, p model.SmartPlaylist) map[string]model.TrackPlays {
+	if !music.SmartPlaylistActivity(p) {
+		return nil
+	}
+	return ctx.Activity().TrackPlays(ctx.User().Name)
+}
+
+// smartPlaylistTracks evaluates the smart playlist rules on access or uses
+// the tracks from the last scheduled refresh.
+func smartPlaylistTracks(ctx Context, p model.SmartPlaylist) []model.Track {
+	if p.Refresh == music.SmartRefreshSchedule && len(p.Playlist) > 0 {
+		return trackRefTracks(ctx, p.Playlist)
+	}
+	return ctx.Music().SmartPlaylistTracks(p, smartPlays(ctx, p))
+}
+
+func addSmartPlaylistEntries(ctx Context, p model.SmartPlaylist, entries []spiff.Entry) []spiff.Entry {
+	return addTrackEntries(ctx, smartPlaylistTracks(ctx, p), entries)
+}
+
 // /music/artists/{id}/{res}
 func resolveArtistRef(ctx Context, id, res string, entries []spiff.Entry) ([]spiff.Entry, error) {
 	artist, err := ctx.FindArtist(id)
@@ -419,6 +464,51 @@ func resolvePlaylistRef(ctx Context, id string, entries []spiff.Entry) ([]spiff.
 	return entries, nil
 }
 
+// /music/smart/{id,name}
+func resolveSmartRef(ctx Context, id string, entries []spiff.Entry) ([]spiff.Entry, error) {
+	// note that FindSmartPlaylist checks ctx user
+	p, err := ctx.FindSmartPlaylist(id)
+	if err != nil {
+		return entries, err
+	}
+	entries = addSmartPlaylistEntries(ctx, p, entries)
+	return entries, nil
+}
+
+// /music/mix/{id}
+func resolveMixRef(ctx Context, id string, entries []spiff.Entry) ([]spiff.Entry, error) {
+	s, err := ctx.Music().LookupStation(str.Atoi(id))
+	if err != nil {
+		return entries, err
+	}
+	if !s.Visible(ctx.User().Name) || s.Type != music.TypeMix {
+		return entries, nil
+	}
+	entries = addTrackEntries(ctx, trackRefTracks(ctx, s.Playlist), entries)
+	return entries, nil
+}
+
+// /music/setlists/{id}
+func resolveSetlistRef(ctx Context, id string, entries []spiff.Entry) ([]spiff.Entry, error) {
+	sl, err := ctx.Music().Setlist(id)
+	if err != nil {
+		return entries, err
+	}
+	tracks, _ := ctx.Music().SetlistTracks(sl)
+	entries = addTrackEntries(ctx, tracks, entries)
+	return entries, nil
+}
+
+// /music/recommend/{name}[/{id}]
+func resolveRecommendRef(ctx Context, name, id string, entries []spiff.Entry) ([]spiff.Entry, error) {
+	tracks, err := ctx.Activity().RecommendTracks(ctx, name, id)
+	if err != nil {
+		return entries, err
+	}
+	entries = addTrackEntries(ctx, tracks, entries)
+	return entries, nil
+}
+
 // ref is a json encoded array of ContentDescription records. The result of
 // this is intended to be a list of locations to the same stream encoded in
 // different formats and allow the client to chose the best source.
@@ -525,6 +615,35 @@ func resolveActivityTracksRef(ctx Context, entries []spiff.Entry) ([]spiff.Entry
 	return entries, nil
 }
 
+// resolveStreamEntries resolves the upstream locations of an internet radio
+// stream station.
+func resolveStreamEntries(ctx Context, s *model.Station) ([]spiff.Entry, error) {
+	var entries []spiff.Entry
+	if strings.HasSuffix(s.Ref, ".pls") {
+		return resolvePlsRef(ctx, s.Ref, s.Creator, s.Image, entries)
+	} else if strings.HasPrefix(s.Ref, "[{") {
+		return resolveSourceRef(ctx, s.Ref, s, entries)
+	} else if strings.HasSuffix(s.Ref, ".mp3") ||
+		strings.HasSuffix(s.Ref, ".aac") ||
+		strings.HasSuffix(s.Ref, ".ogg") ||
+		strings.HasSuffix(s.Ref, ".flac") {
+		entries = append(entries, spiff.Entry{
+			Creator:    s.Creator,
+			Album:      "",
+			Title:      s.Name,
+			Image:      s.Image,
+			Location:   []string{s.Ref},
+			Identifier: []string{},
+			Size:       []int64{-1},
+			Date:       date.FormatJson(time.Now()),
+		})
+	} else {
+		// TODO add m3u, others?
+		log.Printf("unsupported stream %s\n", s.Ref)
+	}
+	return entries, nil
+}
+
 func RefreshStation(ctx Context, s *model.Station) *spiff.Playlist {
 	plist := spiff.NewPlaylist(spiff.TypeMusic)
 	plist.Spiff.Location = fmt.Sprintf("/api/stations/%d", s.ID)
@@ -536,39 +655,26 @@ func RefreshStation(ctx Context, s *model.Station) *spiff.Playlist {
 	if s.Type == music.TypeStream {
 		// internet radio streams
 		plist.Type = spiff.TypeStream
-		if strings.HasSuffix(s.Ref, ".pls") {
-			var entries []spiff.Entry
-			entries, err := resolvePlsRef(ctx, s.Ref, s.Creator, s.Image, entries)
-			if err != nil {
-				log.Printf("pls error %s\n", err)
-				return nil
-			}
-			plist.Spiff.Entries = entries
-		} else if strings.HasPrefix(s.Ref, "[{") {
-			var entries []spiff.Entry
-			entries, err := resolveSourceRef(ctx, s.Ref, s, entries)
-			if err != nil {
-				log.Printf("src error %s\n", err)
-				return nil
-			}
-			plist.Spiff.Entries = entries
-		} else if strings.HasSuffix(s.Ref, ".mp3") ||
-			strings.HasSuffix(s.Ref, ".aac") ||
-			strings.HasSuffix(s.Ref, ".ogg") ||
-			strings.HasSuffix(s.Ref, ".flac") {
-			plist.Spiff.Entries = []spiff.Entry{{
-				Creator:    s.Creator,
-				Album:      "",
-				Title:      s.Name,
-				Image:      s.Image,
-				Location:   []string{s.Ref},
-				Identifier: []string{},
-				Size:       []int64{-1},
-				Date:       date.FormatJson(time.Now()),
-			}}
-		} else {
-			// TODO add m3u, others?
-			log.Printf("unsupported stream %s\n", s.Ref)
+		entries, err := resolveStreamEntries(ctx, s)
+		if err != nil {
+			log.Printf("stream error %s\n", err)
+			return nil
+		}
+		if ctx.Relays() != nil {
+			entries = relayStreamEntries(s, entries)
+		}
+		plist.Spiff.Entries = entries
+	} else if s.Type == music.TypePlaylist {
+		// playlist snapshot saved with the station
+		saved, err := spiff.Unmarshal(s.Playlist)
+		if err != nil {
+			log.Printf("station playlist error %s\n", err)
+			return nil
+		}
+		plist.Type = saved.Type
+		plist.Spiff.Entries = saved.Spiff.Entries
+		if plist.Spiff.Entries == nil {
+			plist.Spiff.Entries = []spiff.Entry{}
 		}
 	} else {
 		plist.Spiff.Entries = []spiff.Entry{{Ref: s.Ref}}
@@ -593,6 +699,10 @@ var (
 	searchRegexp       = regexp.MustCompile(`^/music/search.*`)
 	stationsRegexp     = regexp.MustCompile(`^/music/stations/([\w ]+)$`)
 	playlistsRegexp    = regexp.MustCompile(`^/music/playlists/([\w ]+)$`)
+	smartRegexp        = regexp.MustCompile(`^/music/smart/([\w ]+)$`)
+	mixRegexp          = regexp.MustCompile(`^/music/mix/([\d]+)$`)
+	setlistsRegexp     = regexp.MustCompile(`^/music/setlists/([0-9a-zA-Z]+)$`)
+	recommendRegexp    = regexp.MustCompile(`^/music/recommend/([\w]+)(?:/([0-9a-zA-Z-]+))?$`)
 	moviesRegexp       = regexp.MustCompile(`^/movies/([\d]+)$`)
 	tvSeriesRegexp     = regexp.MustCompile(`^/tv/series/([\d]+)$`)
 	tvEpisodesRegexp   = regexp.MustCompile(`^/tv/episodes/([\d]+)$`)
@@ -673,6 +783,42 @@ func Resolve(ctx Context, plist *spiff.Playlist) (err error) {
 			continue
 		}
 
+		matches = smartRegexp.FindStringSubmatch(pathRef)
+		if matches != nil {
+			entries, err = resolveSmartRef(ctx, matches[1], entries)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		matches = mixRegexp.FindStringSubmatch(pathRef)
+		if matches != nil {
+			entries, err = resolveMixRef(ctx, matches[1], entries)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		matches = setlistsRegexp.FindStringSubmatch(pathRef)
+		if matches != nil {
+			entries, err = resolveSetlistRef(ctx, matches[1], entries)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		matches = recommendRegexp.FindStringSubmatch(pathRef)
+		if matches != nil {
+			entries, err = resolveRecommendRef(ctx, matches[1], matches[2], entries)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
 		matches = moviesRegexp.FindStringSubmatch(pathRef)
 		if matches != nil {
 			entries, err = resolveMovieRef(ctx, matches[1], entries)
@@ -780,6 +926,23 @@ func ResolveReleasePlaylist(ctx Context, v *view.Release, path string) *spiff.Pl
 	return plist
 }
 
+func ResolveSetlistPlaylist(ctx Context, v *view.SetlistTracks, path string) *spiff.Playlist {
+	// /music/setlists/{id}
+	plist := spiff.NewPlaylist(spiff.TypeMusic)
+	plist.Spiff.Location = path
+	plist.Spiff.Creator = v.Setlist.Artist
+	plist.Spiff.Title = fmt.Sprintf("%s, %s", v.Setlist.Venue, v.Setlist.Date.Format("Jan 2, 2006"))
+	plist.Spiff.Date = date.FormatJson(v.Setlist.Date)
+	for _, t := range v.Tracks {
+		if img := ctx.TrackImage(t); img != "" {
+			plist.Spiff.Image = img
+			break
+		}
+	}
+	plist.Spiff.Entries = addTrackEntries(ctx, v.Tracks, plist.Spiff.Entries)
+	return plist
+}
+
 func ResolveMoviePlaylist(ctx Context, v *view.Movie, path string) *spiff.Playlist {
 	// /movies/{id}
 	var directing []string
@@ -900,6 +1063,31 @@ func ResolveActivityTracksPlaylist(ctx Context, v *view.TrackStats, res, path st
 	return plist
 }
 
+func ResolveTrackReportPlaylist(ctx Context, v *view.TrackReport, path string) *spiff.Playlist {
+	var tracks []model.Track
+	for _, t := range v.Tracks {
+		tracks = append(tracks, t.Track)
+	}
+
+	image := ""
+	for _, t := range tracks {
+		img := ctx.TrackImage(t)
+		if img != "" {
+			image = img
+			break
+		}
+	}
+
+	plist := spiff.NewPlaylist(spiff.TypeMusic)
+	plist.Spiff.Location = path
+	plist.Spiff.Creator = creators(tracks)
+	plist.Spiff.Title = fmt.Sprintf(ctx.Config().Activity.ReportTracksTitle, v.Name())
+	plist.Spiff.Image = image
+	plist.Spiff.Date = date.FormatJson(time.Now())
+	plist.Spiff.Entries = addTrackEntries(ctx, tracks, plist.Spiff.Entries)
+	return plist
+}
+
 func ResolveTrackPlaylist(ctx Context, track model.Track, path string) *spiff.Playlist {
 	// /music/tracks/{id}/playlist
 	tracks := ctx.Music().TrackRadio(track)
@@ -913,3 +1101,26 @@ func ResolveTrackPlaylist(ctx Context, track model.Track, path string) *spiff.Pl
 	plist.Spiff.Entries = addTrackEntries(ctx, tracks, plist.Spiff.Entries)
 	return plist
 }
+
+func ResolveSmartPlaylist(ctx Context, p model.SmartPlaylist, path string) *spiff.Playlist {
+	// /api/smart/{id}/playlist
+	tracks := smartPlaylistTracks(ctx, p)
+
+	image := ""
+	for _, t := range tracks {
+		img := ctx.TrackImage(t)
+		if img != "" {
+			image = img
+			break
+		}
+	}
+
+	plist := spiff.NewPlaylist(spiff.TypeMusic)
+	plist.Spiff.Location = path
+	plist.Spiff.Creator = creators(tracks)
+	plist.Spiff.Title = p.Name
+	plist.Spiff.Image = image
+	plist.Spiff.Date = date.FormatJson(time.Now())
+	plist.Spiff.Entries = addTrackEntries(ctx, tracks, plist.Spiff.Entries)
+	return plist
+}
@@ -31,7 +31,7 @@ func TestTrackEntry(t *testing.T) {
 		ReleaseTitle: "test release",
 		Title:        "test title",
 	}
-	entry := trackEntry(ctx, track)
+	entry := trackEntry(ctx, track, nil)
 	if entry.Creator != track.Artist {
 		t.Error("expect artist")
 	}
@@ -31,10 +31,13 @@ import (
 	"takeoutfm.dev/takeout/internal/activity"
 	"takeoutfm.dev/takeout/internal/auth"
 	"takeoutfm.dev/takeout/internal/config"
+	"takeoutfm.dev/takeout/internal/dlna"
 	"takeoutfm.dev/takeout/internal/progress"
 	"takeoutfm.dev/takeout/lib/client"
 	"takeoutfm.dev/takeout/lib/log"
+	"takeoutfm.dev/takeout/lib/relay"
 	"takeoutfm.dev/takeout/lib/systemd"
+	"takeoutfm.dev/takeout/lib/thumb"
 )
 
 const (
@@ -164,6 +167,20 @@ func makeProgress(config *config.Config) (*progress.Progress, error) {
 	return p, err
 }
 
+// makeDLNA creates the media server for the configured media. Images are
+// served by the images handler.
+func makeDLNA(config *config.Config, images http.Handler) (*dlna.DLNA, error) {
+	if config.DLNA.Media == "" {
+		return nil, ErrNoMedia
+	}
+	mediaConfig, err := mediaConfig(config, config.DLNA.Media)
+	if err != nil {
+		return nil, err
+	}
+	media := makeMedia(config.DLNA.Media, mediaConfig)
+	return dlna.NewDLNA(config, media.Music(), media.Film(), media.TV(), media.Podcast(), images), nil
+}
+
 // Serve configures and starts the Takeout web, websocket, and API services.
 func Serve(config *config.Config) error {
 	if systemd.HasSystemd() {
@@ -180,7 +197,9 @@ func Serve(config *config.Config) error {
 	progress, err := makeProgress(config)
 	log.CheckError(err)
 
+	reindex(config)
 	schedule(config)
+	watch(config)
 
 	// base context for all requests
 	ctx := RequestContext{
@@ -189,6 +208,15 @@ func Serve(config *config.Config) error {
 		config:   config,
 		progress: progress,
 		template: getTemplates(config),
+		thumb:    thumb.New(config.Server.ImageResize),
+	}
+	if config.Server.ImageProxy {
+		// fetch uncached images on behalf of clients
+		ctx.imageProxy = client.NewProxyGetter(config.NewGetterWith(config.Server.ImageClient))
+	}
+	if config.Server.StreamRelay.Enabled {
+		// connect once to radio streams and relay to all listeners
+		ctx.relays = relay.New(config.Server.StreamRelay, config.Client.UserAgent)
 	}
 	resFileServer := http.FileServer(mountResFS(resStatic))
 	staticHandler := func(w http.ResponseWriter, r *http.Request) {
@@ -242,6 +270,7 @@ func Serve(config *config.Config) error {
 	mux.Handle("GET /api/home", accessTokenAuthHandler(ctx, apiHome))
 	mux.Handle("GET /api/index", accessTokenAuthHandler(ctx, apiIndex))
 	mux.Handle("GET /api/search", accessTokenAuthHandler(ctx, apiSearch))
+	mux.Handle("GET /api/suggest", accessTokenAuthHandler(ctx, apiSuggest))
 
 	// playlist
 	mux.Handle("GET /api/playlist", accessTokenAuthHandler(ctx, apiPlaylist))
@@ -255,6 +284,15 @@ func Serve(config *config.Config) error {
 	mux.Handle("PATCH /api/playlists/{id}/playlist", accessTokenAuthHandler(ctx, apiPlaylistsPatch))
 	mux.Handle("DELETE /api/playlists/{id}", accessTokenAuthHandler(ctx, apiPlaylistsDelete))
 
+	// smart playlists
+	mux.Handle("GET /api/smart", accessTokenAuthHandler(ctx, apiSmartPlaylists))
+	mux.Handle("POST /api/smart", accessTokenAuthHandler(ctx, apiSmartPlaylistsCreate))
+	mux.Handle("GET /api/smart/{id}", accessTokenAuthHandler(ctx, apiSmartPlaylistsGet))
+	mux.Handle("PUT /api/smart/{id}", accessTokenAuthHandler(ctx, apiSmartPlaylistsUpdate))
+	mux.Handle("DELETE /api/smart/{id}", accessTokenAuthHandler(ctx, apiSmartPlaylistsDelete))
+	mux.Handle("GET /api/smart/{id}/playlist", accessTokenAuthHandler(ctx, apiSmartPlaylistsGetPlaylist))
+	mux.Handle("GET /api/smart/{id}/playlist.xspf", accessTokenAuthHandler(ctx, apiSmartPlaylistsGetPlaylist))
+
 	// music
 	mux.Handle("GET /api/artists", accessTokenAuthHandler(ctx, apiArtists))
 	mux.Handle("GET /api/artists/{id}", accessTokenAuthHandler(ctx, apiArtistGet))
@@ -262,16 +300,29 @@ func Serve(config *config.Config) error {
 	mux.Handle("GET /api/artists/{id}/{res}/playlist", accessTokenAuthHandler(ctx, apiArtistGetPlaylist))
 	mux.Handle("GET /api/artists/{id}/{res}/playlist.xspf", accessTokenAuthHandler(ctx, apiArtistGetPlaylist))
 	mux.Handle("GET /api/radio", accessTokenAuthHandler(ctx, apiRadioGet))
+	mux.Handle("POST /api/radio", accessTokenAuthHandler(ctx, apiRadioPost))
+	mux.Handle("PUT /api/radio/{id}", accessTokenAuthHandler(ctx, apiRadioUpdate))
+	mux.Handle("DELETE /api/radio/{id}", accessTokenAuthHandler(ctx, apiRadioDelete))
 	mux.Handle("GET /api/radio/{id}", accessTokenAuthHandler(ctx, apiRadioStationGetPlaylist))
 	mux.Handle("GET /api/radio/{id}/playlist", accessTokenAuthHandler(ctx, apiRadioStationGetPlaylist))
 	mux.Handle("GET /api/radio/{id}/playlist.xspf", accessTokenAuthHandler(ctx, apiRadioStationGetPlaylist))
+	mux.Handle("POST /api/stations", accessTokenAuthHandler(ctx, apiRadioPost))
 	mux.Handle("GET /api/stations/{id}", accessTokenAuthHandler(ctx, apiRadioStationGetPlaylist))
+	mux.Handle("PUT /api/stations/{id}", accessTokenAuthHandler(ctx, apiRadioUpdate))
+	mux.Handle("DELETE /api/stations/{id}", accessTokenAuthHandler(ctx, apiRadioDelete))
 	mux.Handle("GET /api/stations/{id}/playlist", accessTokenAuthHandler(ctx, apiRadioStationGetPlaylist))
 	mux.Handle("GET /api/stations/{id}/playlist.xspf", accessTokenAuthHandler(ctx, apiRadioStationGetPlaylist))
+	mux.Handle("GET /api/streams/{id}", mediaTokenAuthHandler(ctx, apiStream))
+	mux.Handle("GET /api/streams/{id}/nowplaying", accessTokenAuthHandler(ctx, apiStreamNowPlaying))
+	mux.Handle("POST /api/streams/{id}/recordings", accessTokenAuthHandler(ctx, adminOnly(apiStreamRecord)))
 	mux.Handle("GET /api/releases/{id}", accessTokenAuthHandler(ctx, apiReleaseGet))
 	mux.Handle("GET /api/releases/{id}/playlist", accessTokenAuthHandler(ctx, apiReleaseGetPlaylist))
 	mux.Handle("GET /api/releases/{id}/playlist.xspf", accessTokenAuthHandler(ctx, apiReleaseGetPlaylist))
+	mux.Handle("GET /api/setlists/{id}", accessTokenAuthHandler(ctx, apiSetlistGet))
+	mux.Handle("GET /api/setlists/{id}/playlist", accessTokenAuthHandler(ctx, apiSetlistGetPlaylist))
+	mux.Handle("GET /api/setlists/{id}/playlist.xspf", accessTokenAuthHandler(ctx, apiSetlistGetPlaylist))
 	mux.Handle("GET /api/tracks/{id}/playlist", accessTokenAuthHandler(ctx, apiTrackPlaylist))
+	mux.Handle("GET /api/tracks/{uuid}/lyrics", accessTokenAuthHandler(ctx, apiTrackLyrics))
 
 	// people
 	mux.Handle("GET /api/profiles/{peid}", accessTokenAuthHandler(ctx, apiProfileGet))
@@ -323,10 +374,57 @@ func Serve(config *config.Config) error {
 	// /activity/tracks/lastweek/stats
 	// /activity/tracks/lastweek/chart
 	mux.Handle("POST /api/activity", accessTokenAuthHandler(ctx, apiActivityPost))
+	mux.Handle("POST /api/activity/import", accessTokenAuthHandler(ctx, apiActivityImport))
+	mux.Handle("GET /api/activity/export", accessTokenAuthHandler(ctx, apiActivityExport))
+	mux.Handle("GET /api/export", accessTokenAuthHandler(ctx, apiExport))
 	mux.Handle("GET /api/activity/tracks/{res}", accessTokenAuthHandler(ctx, apiActivityTrackHistory))
 	mux.Handle("GET /api/activity/tracks/{res}/stats", accessTokenAuthHandler(ctx, apiActivityTrackStats))
 	mux.Handle("GET /api/activity/tracks/{res}/counts", accessTokenAuthHandler(ctx, apiActivityTrackCounts))
 	mux.Handle("GET /api/activity/tracks/{res}/chart", accessTokenAuthHandler(ctx, apiActivityTrackChart))
+	mux.Handle("GET /api/activity/movies", accessTokenAuthHandler(ctx, apiActivityMovieHistory))
+	mux.Handle("GET /api/activity/movies/{res}", accessTokenAuthHandler(ctx, apiActivityMovieHistory))
+	mux.Handle("GET /api/activity/movies/{res}/stats", accessTokenAuthHandler(ctx, apiActivityMovieStats))
+	mux.Handle("GET /api/activity/movies/{res}/counts", accessTokenAuthHandler(ctx, apiActivityMovieCounts))
+	mux.Handle("GET /api/activity/movies/{res}/chart", accessTokenAuthHandler(ctx, apiActivityMovieChart))
+	mux.Handle("GET /api/activity/tv", accessTokenAuthHandler(ctx, apiActivityTVHistory))
+	mux.Handle("GET /api/activity/tv/{res}", accessTokenAuthHandler(ctx, apiActivityTVHistory))
+	mux.Handle("GET /api/activity/tv/{res}/stats", accessTokenAuthHandler(ctx, apiActivityTVStats))
+	mux.Handle("GET /api/activity/tv/{res}/counts", accessTokenAuthHandler(ctx, apiActivityTVCounts))
+	mux.Handle("GET /api/activity/tv/{res}/chart", accessTokenAuthHandler(ctx, apiActivityTVChart))
+	mux.Handle("GET /api/activity/podcasts", accessTokenAuthHandler(ctx, apiActivityPodcastHistory))
+	mux.Handle("GET /api/activity/podcasts/{res}", accessTokenAuthHandler(ctx, apiActivityPodcastHistory))
+	mux.Handle("GET /api/activity/podcasts/{res}/stats", accessTokenAuthHandler(ctx, apiActivityPodcastStats))
+	mux.Handle("GET /api/activity/podcasts/{res}/counts", accessTokenAuthHandler(ctx, apiActivityPodcastCounts))
+	mux.Handle("GET /api/activity/podcasts/{res}/chart", accessTokenAuthHandler(ctx, apiActivityPodcastChart))
+	mux.Handle("GET /api/activity/report/{res}", accessTokenAuthHandler(ctx, apiActivityReport))
+	mux.Handle("GET /api/activity/report/{res}/playlist", accessTokenAuthHandler(ctx, apiActivityReportPlaylist))
+	mux.Handle("GET /api/activity/report/{res}/chart", accessTokenAuthHandler(ctx, apiActivityReportChart))
+
+	// unmatched media
+	mux.Handle("GET /api/unmatched", accessTokenAuthHandler(ctx, adminOnly(apiUnmatched)))
+	mux.Handle("GET /api/unmatched/releases/{id}/candidates", accessTokenAuthHandler(ctx, adminOnly(apiUnmatchedReleaseCandidates)))
+	mux.Handle("POST /api/unmatched/releases/{id}/match", accessTokenAuthHandler(ctx, adminOnly(apiUnmatchedReleaseMatch)))
+	mux.Handle("GET /api/unmatched/movies/{id}/candidates", accessTokenAuthHandler(ctx, adminOnly(apiUnmatchedMovieCandidates)))
+	mux.Handle("POST /api/unmatched/movies/{id}/match", accessTokenAuthHandler(ctx, adminOnly(apiUnmatchedMovieMatch)))
+	mux.Handle("GET /api/unmatched/tv/{id}/candidates", accessTokenAuthHandler(ctx, adminOnly(apiUnmatchedTVCandidates)))
+	mux.Handle("POST /api/unmatched/tv/{id}/match", accessTokenAuthHandler(ctx, adminOnly(apiUnmatchedTVMatch)))
+
+	// scrobble services
+	mux.Handle("GET /api/scrobblers", accessTokenAuthHandler(ctx, apiScrobblers))
+	mux.Handle("POST /api/scrobblers/listenbrainz", accessTokenAuthHandler(ctx, apiScrobblerListenBrainz))
+	mux.Handle("POST /api/scrobblers/lastfm", accessTokenAuthHandler(ctx, apiScrobblerLastfm))
+	mux.Handle("DELETE /api/scrobblers/{service}", accessTokenAuthHandler(ctx, apiScrobblerDelete))
+
+	// metadata overrides
+	mux.Handle("GET /api/overrides", accessTokenAuthHandler(ctx, adminOnly(apiOverrides)))
+	mux.Handle("POST /api/overrides/releases", accessTokenAuthHandler(ctx, adminOnly(apiReleaseOverridePost)))
+	mux.Handle("DELETE /api/overrides/releases/{id}", accessTokenAuthHandler(ctx, adminOnly(apiReleaseOverrideDelete)))
+	mux.Handle("POST /api/overrides/tracks", accessTokenAuthHandler(ctx, adminOnly(apiTrackOverridePost)))
+	mux.Handle("DELETE /api/overrides/tracks/{id}", accessTokenAuthHandler(ctx, adminOnly(apiTrackOverrideDelete)))
+	mux.Handle("POST /api/overrides/movies", accessTokenAuthHandler(ctx, adminOnly(apiMovieOverridePost)))
+	mux.Handle("DELETE /api/overrides/movies/{id}", accessTokenAuthHandler(ctx, adminOnly(apiMovieOverrideDelete)))
+	mux.Handle("POST /api/overrides/tv", accessTokenAuthHandler(ctx, adminOnly(apiTVOverridePost)))
+	mux.Handle("DELETE /api/overrides/tv/{id}", accessTokenAuthHandler(ctx, adminOnly(apiTVOverrideDelete)))
 
 	// TODO - disable for now, work in progress
 	// settings
@@ -343,6 +441,7 @@ func Serve(config *config.Config) error {
 	mux.Handle("GET /img/tm/{size}/{path}", imageHandler(ctx, imgTMDB, client))
 	mux.Handle("GET /img/fa/{arid}/t/{path}", imageHandler(ctx, imgArtistThumb, client))
 	mux.Handle("GET /img/fa/{arid}/b/{path}", imageHandler(ctx, imgArtistBackground, client))
+	mux.Handle("GET /img/local/{key}", mediaTokenAuthHandler(ctx, imgLocal))
 
 	// // swaggerHandler := func(w http.ResponseWriter, r *http.Request) {
 	// // 	http.Redirect(w, r, "/static/swagger.json", 302)
@@ -393,6 +492,15 @@ func Serve(config *config.Config) error {
 		log.CheckError(err)
 	}()
 
+	if config.DLNA.Enabled {
+		d, err := makeDLNA(config, mux)
+		log.CheckError(err)
+		go func() {
+			err := d.ListenAndServe()
+			log.Printf("dlna error %s\n", err)
+		}()
+	}
+
 	systemd.StartWatchdogNotify()
 
 	log.Printf("%s v%s listening on %s", takeout.AppName, takeout.Version, config.Server.Listen)
@@ -0,0 +1,221 @@
+// Copyright 2023 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"takeoutfm.dev/takeout/internal/config"
+	"takeoutfm.dev/takeout/internal/music"
+	"takeoutfm.dev/takeout/lib/bucket"
+	"takeoutfm.dev/takeout/lib/header"
+	"takeoutfm.dev/takeout/lib/relay"
+	"takeoutfm.dev/takeout/model"
+	"takeoutfm.dev/takeout/spiff"
+
+	. "takeoutfm.dev/takeout/view"
+)
+
+const (
+	QuerySource   = "source"
+	QueryDuration = "duration"
+)
+
+var (
+	ErrInvalidSource   = errors.New("invalid stream source")
+	ErrInvalidDuration = errors.New("invalid duration")
+)
+
+// Radio streams can be relayed by the server which connects once to the
+// upstream source and sends the audio to all listeners. This avoids mixed
+// content issues with HTTP-only streams in HTTPS clients. Each upstream
+// location of a station is a numbered source and the relay locations are
+// listed first, followed by the original upstream locations.
+
+func streamLocation(s *model.Station, source int) string {
+	return fmt.Sprintf("/api/streams/%d?%s=%d", s.ID, QuerySource, source)
+}
+
+// relayStreamEntries adds relay locations for each upstream location.
+func relayStreamEntries(s *model.Station, entries []spiff.Entry) []spiff.Entry {
+	source := 0
+	for i := range entries {
+		var locations []string
+		var sizes []int64
+		for range entries[i].Location {
+			locations = append(locations, streamLocation(s, source))
+			sizes = append(sizes, -1)
+			source++
+		}
+		entries[i].Location = append(locations, entries[i].Location...)
+		entries[i].Size = append(sizes, entries[i].Size...)
+	}
+	return entries
+}
+
+// streamSource returns the upstream location for the source number.
+func streamSource(ctx Context, s *model.Station, source int) (string, error) {
+	entries, err := resolveStreamEntries(ctx, s)
+	if err != nil {
+		return "", err
+	}
+	for _, e := range entries {
+		if source < len(e.Location) {
+			return e.Location[source], nil
+		}
+		source -= len(e.Location)
+	}
+	return "", ErrInvalidSource
+}
+
+// relayStream finds the visible stream station and the relay for the
+// requested source.
+func relayStream(w http.ResponseWriter, r *http.Request) (model.Station, *relay.Stream, bool) {
+	ctx := contextValue(r)
+	relays := ctx.Relays()
+	station, err := ctx.FindStation(r.PathValue(ParamID))
+	if err != nil || relays == nil ||
+		station.Type != music.TypeStream || !station.Visible(ctx.User().Name) {
+		notFoundErr(w)
+		return station, nil, false
+	}
+	source := 0
+	if v := r.URL.Query().Get(QuerySource); v != "" {
+		source, err = strconv.Atoi(v)
+		if err != nil || source < 0 {
+			badRequest(w, ErrInvalidSource)
+			return station, nil, false
+		}
+	}
+	url, err := streamSource(ctx, &station, source)
+	if err != nil {
+		notFoundErr(w)
+		return station, nil, false
+	}
+	return station, relays.Stream(url), true
+}
+
+// GET /api/streams/1?source=0
+func apiStream(w http.ResponseWriter, r *http.Request) {
+	_, stream, ok := relayStream(w, r)
+	if !ok {
+		return
+	}
+	l, err := stream.Listen()
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	defer l.Close()
+	go func() {
+		// stop when the client goes away even if upstream is stalled
+		<-r.Context().Done()
+		l.Close()
+	}()
+	for k := range l.Header {
+		w.Header().Set(k, l.Header.Get(k))
+	}
+	w.Header().Set(header.CacheControl, "no-store")
+	w.WriteHeader(http.StatusOK)
+	l.WriteTo(w)
+}
+
+// GET /api/streams/1/nowplaying?source=0
+func apiStreamNowPlaying(w http.ResponseWriter, r *http.Request) {
+	station, stream, ok := relayStream(w, r)
+	if !ok {
+		return
+	}
+	apiView(w, r, StreamNowPlayingView(station, stream))
+}
+
+// POST /api/streams/1/recordings?duration=1h&source=0
+//
+// Recordings use server storage and bandwidth so only admins can record.
+func apiStreamRecord(w http.ResponseWriter, r *http.Request) {
+	ctx := contextValue(r)
+	station, stream, ok := relayStream(w, r)
+	if !ok {
+		return
+	}
+	d, err := time.ParseDuration(r.URL.Query().Get(QueryDuration))
+	if err != nil || d <= 0 {
+		badRequest(w, ErrInvalidDuration)
+		return
+	}
+	if max := ctx.Config().Server.StreamRelay.MaxRecording; max > 0 && d > max {
+		d = max
+	}
+	dest, err := recordingBucket(ctx)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	start := time.Now()
+	hdr, err := stream.Record(d, func(body io.Reader, contentType string) error {
+		return dest.Put(recordingKey(station, start, contentType), body, contentType)
+	})
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	w.Header().Set(header.ContentType, ApplicationJson)
+	w.WriteHeader(http.StatusAccepted)
+	apiView(w, r, StreamRecording{
+		Station: station,
+		Key:     recordingKey(station, start, hdr.Get(header.ContentType)),
+		Start:   start,
+		End:     start.Add(d),
+	})
+}
+
+// recordingBucket returns the first writable recordings bucket.
+func recordingBucket(ctx Context) (bucket.Writer, error) {
+	buckets, err := bucket.OpenMedia(ctx.Config().Buckets, config.MediaRecordings)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range buckets {
+		if w, ok := b.(bucket.Writer); ok {
+			return w, nil
+		}
+	}
+	return nil, bucket.ErrNoBucket
+}
+
+// recordingKey is the station ID and start time with an extension for the
+// stream content type.
+func recordingKey(s model.Station, start time.Time, contentType string) string {
+	ext := "audio"
+	switch contentType {
+	case "audio/mpeg", "audio/mp3":
+		ext = "mp3"
+	case "audio/aac", "audio/aacp":
+		ext = "aac"
+	case "audio/ogg", "application/ogg":
+		ext = "ogg"
+	case "audio/flac":
+		ext = "flac"
+	}
+	return fmt.Sprintf("%d/%s.%s", s.ID, start.Format("20060102-150405"), ext)
+}
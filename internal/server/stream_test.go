@@ -0,0 +1,61 @@
+// Copyright 2023 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package server
+
+import (
+	"testing"
+	"time"
+
+	"takeoutfm.dev/takeout/model"
+	"takeoutfm.dev/takeout/spiff"
+)
+
+func TestRelayStreamEntries(t *testing.T) {
+	s := &model.Station{Name: "Test Radio"}
+	s.ID = 7
+	entries := relayStreamEntries(s, []spiff.Entry{
+		{Location: []string{"http://a/aac", "http://a/mp3"}, Size: []int64{-1, -1}},
+		{Location: []string{"http://b/mp3"}, Size: []int64{-1}},
+	})
+	expect := [][]string{
+		{"/api/streams/7?source=0", "/api/streams/7?source=1", "http://a/aac", "http://a/mp3"},
+		{"/api/streams/7?source=2", "http://b/mp3"},
+	}
+	for i, e := range entries {
+		if len(e.Location) != len(expect[i]) || len(e.Size) != len(e.Location) {
+			t.Fatal("expect locations", e.Location, e.Size)
+		}
+		for j := range e.Location {
+			if e.Location[j] != expect[i][j] {
+				t.Error("expect location", expect[i][j], e.Location[j])
+			}
+		}
+	}
+}
+
+func TestRecordingKey(t *testing.T) {
+	s := model.Station{}
+	s.ID = 3
+	start := time.Date(2026, 10, 19, 8, 30, 0, 0, time.UTC)
+	if v := recordingKey(s, start, "audio/mpeg"); v != "3/20261019-083000.mp3" {
+		t.Error("expect mp3 key", v)
+	}
+	if v := recordingKey(s, start, "audio/x-unknown"); v != "3/20261019-083000.audio" {
+		t.Error("expect default key", v)
+	}
+}
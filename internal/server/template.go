@@ -38,6 +38,7 @@ import (
 	"takeoutfm.dev/takeout/internal/film"
 	"takeoutfm.dev/takeout/lib/date"
 	"takeoutfm.dev/takeout/lib/log"
+	"takeoutfm.dev/takeout/lib/search"
 	"takeoutfm.dev/takeout/model"
 	"takeoutfm.dev/takeout/view"
 )
@@ -83,6 +84,8 @@ func doFuncMap() template.FuncMap {
 			switch o.(type) {
 			case *view.TrackStats:
 				link = fmt.Sprintf("/api/activity/tracks/%s/chart", o.(*view.TrackStats).Interval)
+			case *view.TrackReport:
+				link = fmt.Sprintf("/api/activity/report/%s/chart", o.(*view.TrackReport).Interval)
 			}
 			return link
 		},
@@ -307,6 +310,13 @@ func doFuncMap() template.FuncMap {
 	}
 }
 
+// templateListOptions returns the list options for template views, which
+// ignore invalid options.
+func templateListOptions(ctx Context, r *http.Request) ListOptions {
+	o, _ := recvListOptions(ctx, r)
+	return o
+}
+
 func viewHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := contextValue(r)
 	var result interface{}
@@ -328,7 +338,13 @@ func viewHandler(w http.ResponseWriter, r *http.Request) {
 		temp = "artist.html"
 	} else if v := r.URL.Query().Get("artists"); v != "" {
 		// /v?artists=x
-		result = ArtistsView(ctx)
+		view, err := ArtistsView(ctx, templateListOptions(ctx, r))
+		if err != nil {
+			badRequest(w, err)
+			return
+		}
+		pageLinks(r.URL, &view.Page)
+		result = view
 		temp = "artists.html"
 	} else if v := r.URL.Query().Get("popular"); v != "" {
 		// /v?popular={artist-id}
@@ -357,7 +373,7 @@ func viewHandler(w http.ResponseWriter, r *http.Request) {
 		temp = "home.html"
 	} else if v := r.URL.Query().Get("q"); v != "" {
 		// /v?q={pattern}
-		result = SearchView(ctx, strings.TrimSpace(v))
+		result = SearchView(ctx, search.Request{Query: strings.TrimSpace(v)})
 		temp = "search.html"
 	} else if v := r.URL.Query().Get("radio"); v != "" {
 		// /v?radio=x
@@ -370,7 +386,13 @@ func viewHandler(w http.ResponseWriter, r *http.Request) {
 		temp = "playlists.html"
 	} else if v := r.URL.Query().Get("movies"); v != "" {
 		// /v?movies=x
-		result = MoviesView(ctx)
+		view, err := MoviesView(ctx, templateListOptions(ctx, r))
+		if err != nil {
+			badRequest(w, err)
+			return
+		}
+		pageLinks(r.URL, &view.Page)
+		result = view
 		temp = "movies.html"
 	} else if v := r.URL.Query().Get("movie"); v != "" {
 		// /v?movie={movie-id}
@@ -387,12 +409,24 @@ func viewHandler(w http.ResponseWriter, r *http.Request) {
 	} else if v := r.URL.Query().Get("genre"); v != "" {
 		// /v?genre={genre-name}
 		name := strings.TrimSpace(v)
-		result = GenreView(ctx, name)
+		view, err := GenreView(ctx, name, templateListOptions(ctx, r))
+		if err != nil {
+			badRequest(w, err)
+			return
+		}
+		pageLinks(r.URL, &view.Page)
+		result = view
 		temp = "genre.html"
 	} else if v := r.URL.Query().Get("keyword"); v != "" {
 		// /v?keyword={keyword-name}
 		name := strings.TrimSpace(v)
-		result = KeywordView(ctx, name)
+		view, err := KeywordView(ctx, name, templateListOptions(ctx, r))
+		if err != nil {
+			badRequest(w, err)
+			return
+		}
+		pageLinks(r.URL, &view.Page)
+		result = view
 		temp = "keyword.html"
 	} else if v := r.URL.Query().Get("watch"); v != "" {
 		// /v?watch={movie-id}
@@ -403,7 +437,13 @@ func viewHandler(w http.ResponseWriter, r *http.Request) {
 		temp = "watch.html"
 	} else if v := r.URL.Query().Get("tv"); v != "" {
 		// /v?tv=x
-		result = TVShowsView(ctx)
+		view, err := TVShowsView(ctx, templateListOptions(ctx, r))
+		if err != nil {
+			badRequest(w, err)
+			return
+		}
+		pageLinks(r.URL, &view.Page)
+		result = view
 		temp = "shows.html"
 	} else if v := r.URL.Query().Get("tvseries"); v != "" {
 		// /v?tvseries={series-id}
@@ -421,7 +461,13 @@ func viewHandler(w http.ResponseWriter, r *http.Request) {
 		temp = "tvepisode.html"
 	} else if v := r.URL.Query().Get("podcasts"); v != "" {
 		// /v?podcasts=x
-		result = PodcastsView(ctx)
+		view, err := PodcastsView(ctx, templateListOptions(ctx, r))
+		if err != nil {
+			badRequest(w, err)
+			return
+		}
+		pageLinks(r.URL, &view.Page)
+		result = view
 		temp = "podcasts.html"
 	} else if v := r.URL.Query().Get("series"); v != "" {
 		// /v?series={series-id}
@@ -437,11 +483,69 @@ func viewHandler(w http.ResponseWriter, r *http.Request) {
 		episode, _ := p.LookupEpisode(id)
 		result = EpisodeView(ctx, episode)
 		temp = "episode.html"
+	} else if v := r.URL.Query().Get("unmatched"); v != "" {
+		// /v?unmatched=x
+		if !isAdmin(ctx) {
+			accessDenied(w)
+			return
+		}
+		result = UnmatchedView(ctx)
+		temp = "unmatched.html"
+	} else if v := r.URL.Query().Get("unmatched_release"); v != "" {
+		// /v?unmatched_release={track-id}
+		if !isAdmin(ctx) {
+			accessDenied(w)
+			return
+		}
+		id, _ := strconv.Atoi(v)
+		u, _ := ctx.Music().UnmatchedRelease(id)
+		result = ReleaseCandidatesView(ctx, u)
+		temp = "candidates.html"
+	} else if v := r.URL.Query().Get("unmatched_movie"); v != "" {
+		// /v?unmatched_movie={unmatched-id}
+		if !isAdmin(ctx) {
+			accessDenied(w)
+			return
+		}
+		id, _ := strconv.Atoi(v)
+		u, _ := ctx.Film().LookupUnmatchedMovie(id)
+		view, err := MovieCandidatesView(ctx, u)
+		if err != nil {
+			serverErr(w, err)
+			return
+		}
+		result = view
+		temp = "candidates.html"
+	} else if v := r.URL.Query().Get("unmatched_tv"); v != "" {
+		// /v?unmatched_tv={unmatched-id}
+		if !isAdmin(ctx) {
+			accessDenied(w)
+			return
+		}
+		id, _ := strconv.Atoi(v)
+		u, _ := ctx.TV().LookupUnmatchedEpisode(id)
+		view, err := SeriesCandidatesView(ctx, u)
+		if err != nil {
+			serverErr(w, err)
+			return
+		}
+		result = view
+		temp = "candidates.html"
 	} else if v := r.URL.Query().Get("activity"); v != "" {
 		// v?activity={lastyear}
 		d := date.NewInterval(time.Now(), v)
 		result = TrackStatsView(ctx, v, d)
 		temp = "activity.html"
+	} else if v := r.URL.Query().Get("report"); v != "" {
+		// v?report={2024}
+		d := date.NewReportInterval(time.Now(), v)
+		view, err := TrackReportView(ctx, v, d)
+		if err != nil {
+			badRequest(w, err)
+			return
+		}
+		result = view
+		temp = "report.html"
 	} else {
 		result = IndexView(ctx)
 		temp = "index.html"
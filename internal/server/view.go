@@ -19,11 +19,16 @@ package server
 
 import (
 	"fmt"
+	"strconv"
 	"time"
 
 	"takeoutfm.dev/takeout/internal/music"
 	"takeoutfm.dev/takeout/internal/people"
 	"takeoutfm.dev/takeout/lib/date"
+	"takeoutfm.dev/takeout/lib/gorm"
+	"takeoutfm.dev/takeout/lib/relay"
+	"takeoutfm.dev/takeout/lib/search"
+	"takeoutfm.dev/takeout/lib/setlist"
 	"takeoutfm.dev/takeout/model"
 
 	. "takeoutfm.dev/takeout/view"
@@ -37,6 +42,7 @@ func IndexView(ctx Context) *Index {
 	view.HasShows = ctx.TV().HasShows()
 	view.HasPodcasts = ctx.Podcast().HasPodcasts()
 	view.HasPlaylists = ctx.Music().HasPlaylists(ctx.User())
+	view.IsAdmin = isAdmin(ctx)
 	return view
 }
 
@@ -49,6 +55,7 @@ func HomeView(ctx Context) *Home {
 
 	view.AddedReleases = m.RecentlyAdded()
 	view.NewReleases = m.RecentlyReleased()
+	view.RecommendMusic = ctx.Activity().RecommendMusic(ctx)
 	view.AddedMovies = f.RecentlyAdded()
 	view.NewMovies = f.RecentlyReleased()
 	view.RecommendMovies = f.Recommend()
@@ -58,10 +65,11 @@ func HomeView(ctx Context) *Home {
 	return view
 }
 
-func ArtistsView(ctx Context) *Artists {
+func ArtistsView(ctx Context, o ListOptions) (*Artists, error) {
+	var err error
 	view := &Artists{}
-	view.Artists = ctx.Music().Artists()
-	return view
+	view.Artists, view.Page, err = findPage(o, artistFields(ctx), ctx.Music().ArtistsPage)
+	return view, err
 }
 
 func ArtistView(ctx Context, artist model.Artist) *Artist {
@@ -125,6 +133,45 @@ func PopularView(ctx Context, artist model.Artist) *Popular {
 	return view
 }
 
+func setlistView(sl setlist.Setlist) Setlist {
+	return Setlist{
+		ID:      sl.Id,
+		Artist:  sl.Artist.Name,
+		Date:    sl.EventTime(),
+		Venue:   sl.Venue.Name,
+		City:    sl.Venue.City.Name,
+		Country: sl.Venue.City.Country.Name,
+		Tour:    sl.Tour.Name,
+		URL:     sl.Url,
+		Ref:     fmt.Sprintf("/music/setlists/%s", sl.Id),
+	}
+}
+
+func SetlistsView(ctx Context, artist model.Artist) (*Setlists, error) {
+	list, err := ctx.Music().ArtistSetlists(artist)
+	if err != nil {
+		return nil, err
+	}
+	view := &Setlists{Artist: artist, Setlists: []Setlist{}}
+	for _, sl := range list {
+		view.Setlists = append(view.Setlists, setlistView(sl))
+	}
+	return view, nil
+}
+
+func SetlistView(ctx Context, sl setlist.Setlist) *SetlistTracks {
+	tracks, missing := ctx.Music().SetlistTracks(sl)
+	view := &SetlistTracks{
+		Setlist: setlistView(sl),
+		Tracks:  tracks,
+		Missing: []string{},
+	}
+	for _, song := range missing {
+		view.Missing = append(view.Missing, song.Name)
+	}
+	return view
+}
+
 func SinglesView(ctx Context, artist model.Artist) *Singles {
 	m := ctx.Music()
 	view := &Singles{}
@@ -161,25 +208,51 @@ func ReleaseView(ctx Context, release model.Release) *Release {
 	return view
 }
 
-func SearchView(ctx Context, query string) *Search {
+func LyricsView(ctx Context, t model.Track) (*Lyrics, error) {
+	lyrics, err := ctx.Music().TrackLyrics(t)
+	if err != nil {
+		return nil, err
+	}
+	view := &Lyrics{}
+	view.Track = t
+	view.Synced = lyrics.Synced
+	for _, line := range lyrics.Lines {
+		view.Lines = append(view.Lines, LyricsLine{Time: line.Time.Seconds(), Text: line.Text})
+	}
+	return view, nil
+}
+
+// SearchView returns artists, releases and stations matching the query along
+// with tracks, movies, podcast episodes and tv episodes matching the search
+// request.
+func SearchView(ctx Context, r search.Request) *Search {
 	m := ctx.Music()
 	f := ctx.Film()
 	p := ctx.Podcast()
 	tv := ctx.TV()
 	view := &Search{}
-	artists, releases, _, stations := m.Query(query)
-	view.Artists = artists
-	view.Releases = releases
-	for _, s := range stations {
-		if s.Visible(ctx.User().Name) {
-			view.Stations = append(view.Stations, s)
+	if r.Query != "" {
+		artists, releases, _, stations := m.Query(r.Query)
+		view.Artists = artists
+		view.Releases = releases
+		for _, s := range stations {
+			if s.Visible(ctx.User().Name) {
+				view.Stations = append(view.Stations, s)
+			}
 		}
 	}
-	view.Query = query
-	view.Tracks = m.Search(query)
-	view.Movies = f.Search(query)
-	view.Series, view.Episodes = p.Search(query)
-	view.TVEpisodes = tv.Search(query)
+	view.Query = r.Query
+
+	var result search.Result
+	view.Tracks, view.TrackResult.Scores, result = m.SearchRequest(r)
+	view.TrackResult.Total, view.TrackResult.Facets = result.Total, result.Facets
+	view.Movies, view.MovieResult.Scores, result = f.SearchRequest(r)
+	view.MovieResult.Total, view.MovieResult.Facets = result.Total, result.Facets
+	view.Series, view.Episodes, view.EpisodeResult.Scores, result = p.SearchRequest(r)
+	view.EpisodeResult.Total, view.EpisodeResult.Facets = result.Total, result.Facets
+	view.TVEpisodes, view.TVEpisodeResult.Scores, result = tv.SearchRequest(r)
+	view.TVEpisodeResult.Total, view.TVEpisodeResult.Facets = result.Total, result.Facets
+
 	view.Hits = len(view.Artists) +
 		len(view.Releases) +
 		len(view.Stations) +
@@ -191,6 +264,46 @@ func SearchView(ctx Context, query string) *Search {
 	return view
 }
 
+// SuggestView returns search suggestions for the prefix from each media
+// index. People from movies and tv are combined.
+func SuggestView(ctx Context, prefix string) *Suggest {
+	view := &Suggest{Query: prefix}
+	artists, releases, tracks := ctx.Music().Suggest(prefix)
+	for _, a := range artists {
+		view.Artists = append(view.Artists, Suggestion{Name: a.Name, ID: int64(a.ID)})
+	}
+	for _, r := range releases {
+		view.Releases = append(view.Releases, Suggestion{Name: r.Name, ID: int64(r.ID),
+			Ref: fmt.Sprintf("/music/releases/%d/tracks", r.ID)})
+	}
+	for _, t := range tracks {
+		view.Tracks = append(view.Tracks, Suggestion{Name: t.Title, ID: int64(t.ID),
+			Ref: fmt.Sprintf("/music/tracks/%d", t.ID)})
+	}
+	movies, moviePeople := ctx.Film().Suggest(prefix)
+	for _, m := range movies {
+		view.Movies = append(view.Movies, Suggestion{Name: m.Title, ID: int64(m.ID),
+			Ref: fmt.Sprintf("/movies/%d", m.ID)})
+	}
+	series, tvPeople := ctx.TV().Suggest(prefix)
+	for _, s := range series {
+		view.Series = append(view.Series, Suggestion{Name: s.Name, ID: int64(s.ID),
+			Ref: fmt.Sprintf("/tv/series/%d", s.ID)})
+	}
+	for _, s := range ctx.Podcast().Suggest(prefix) {
+		view.Podcasts = append(view.Podcasts, Suggestion{Name: s.Title, ID: int64(s.ID),
+			Ref: fmt.Sprintf("/podcasts/series/%d", s.ID)})
+	}
+	seen := make(map[int64]bool)
+	for _, p := range append(moviePeople, tvPeople...) {
+		if !seen[p.PEID] {
+			seen[p.PEID] = true
+			view.People = append(view.People, Suggestion{Name: p.Name, ID: p.PEID})
+		}
+	}
+	return view
+}
+
 func RadioView(ctx Context) *Radio {
 	m := ctx.Music()
 	view := &Radio{}
@@ -208,6 +321,8 @@ func RadioView(ctx Context) *Radio {
 			view.Series = append(view.Series, s)
 		case music.TypeStream:
 			view.Stream = append(view.Stream, s)
+		case music.TypeMix:
+			view.Mix = append(view.Mix, s)
 		default:
 			view.Other = append(view.Other, s)
 		}
@@ -215,11 +330,27 @@ func RadioView(ctx Context) *Radio {
 	return view
 }
 
-func MoviesView(ctx Context) *Movies {
+func StreamNowPlayingView(station model.Station, stream *relay.Stream) *StreamNowPlaying {
+	view := &StreamNowPlaying{
+		Station:   station,
+		Listeners: stream.Listeners(),
+		History:   []StreamTitle{},
+	}
+	for _, t := range stream.NowPlaying() {
+		view.History = append(view.History, StreamTitle{Title: t.Title, URL: t.URL, Time: t.Time})
+	}
+	if len(view.History) > 0 {
+		view.Title = view.History[0].Title
+	}
+	return view
+}
+
+func MoviesView(ctx Context, o ListOptions) (*Movies, error) {
+	var err error
 	f := ctx.Film()
 	view := &Movies{}
-	view.Movies = f.Movies()
-	return view
+	view.Movies, view.Page, err = findPage(o, movieFields(ctx), f.MoviesPage)
+	return view, err
 }
 
 func MovieView(ctx Context, m model.Movie) *Movie {
@@ -266,20 +397,24 @@ func ProfileView(ctx Context, p model.Person) *Profile {
 	return view
 }
 
-func GenreView(ctx Context, name string) *Genre {
+func GenreView(ctx Context, name string, o ListOptions) (*Genre, error) {
+	var err error
 	f := ctx.Film()
 	view := &Genre{}
 	view.Name = name
-	view.Movies = f.Genre(name)
-	return view
+	view.Movies, view.Page, err = findPage(o, movieFields(ctx),
+		func(q gorm.Page) ([]model.Movie, int, error) { return f.GenrePage(name, q) })
+	return view, err
 }
 
-func KeywordView(ctx Context, name string) *Keyword {
+func KeywordView(ctx Context, name string, o ListOptions) (*Keyword, error) {
+	var err error
 	f := ctx.Film()
 	view := &Keyword{}
 	view.Name = name
-	view.Movies = f.Keyword(name)
-	return view
+	view.Movies, view.Page, err = findPage(o, movieFields(ctx),
+		func(q gorm.Page) ([]model.Movie, int, error) { return f.KeywordPage(name, q) })
+	return view, err
 }
 
 func WatchView(ctx Context, m model.Movie) *Watch {
@@ -297,11 +432,12 @@ func TVListView(ctx Context) *TVList {
 	return view
 }
 
-func TVShowsView(ctx Context) *TVShows {
+func TVShowsView(ctx Context, o ListOptions) (*TVShows, error) {
+	var err error
 	tv := ctx.TV()
 	view := &TVShows{}
-	view.Series = tv.Series()
-	return view
+	view.Series, view.Page, err = findPage(o, tvSeriesFields(ctx), tv.SeriesPage)
+	return view, err
 }
 
 func TVSeriesView(ctx Context, s model.TVSeries) *TVSeries {
@@ -344,11 +480,12 @@ func TVEpisodeView(ctx Context, e model.TVEpisode) *TVEpisode {
 	return view
 }
 
-func PodcastsView(ctx Context) *Podcasts {
+func PodcastsView(ctx Context, o ListOptions) (*Podcasts, error) {
+	var err error
 	p := ctx.Podcast()
 	view := &Podcasts{}
-	view.Series = p.Series()
-	return view
+	view.Series, view.Page, err = findPage(o, seriesFields(ctx), p.SeriesPage)
+	return view, err
 }
 
 func PodcastsSubscribedView(ctx Context) *Podcasts {
@@ -403,12 +540,70 @@ func TrackStatsView(ctx Context, interval string, d date.DateRange) *TrackStats
 	return view
 }
 
+func TrackReportView(ctx Context, interval string, d date.DateRange) (*TrackReport, error) {
+	if d.IsZero() {
+		return nil, ErrInvalidParameter
+	}
+	return ctx.Activity().TrackReport(ctx, interval, d), nil
+}
+
 func TrackHistoryView(ctx Context, d date.DateRange) *TrackHistory {
 	view := &TrackHistory{}
 	view.Tracks = ctx.Activity().Tracks(ctx, d.Start, d.End)
 	return view
 }
 
+func MovieHistoryView(ctx Context, d date.DateRange) *MovieHistory {
+	view := &MovieHistory{}
+	view.Movies = ctx.Activity().Movies(ctx, d.Start, d.End)
+	return view
+}
+
+func MovieStatsView(ctx Context, interval string, d date.DateRange) *MovieStats {
+	view := &MovieStats{}
+	view.Interval = interval
+	view.Movies = ctx.Activity().TopMovies(ctx, d.Start, d.End)
+	view.MovieCount = len(view.Movies)
+	for _, m := range view.Movies {
+		view.WatchCount += m.Count
+	}
+	return view
+}
+
+func TVHistoryView(ctx Context, d date.DateRange) *TVHistory {
+	view := &TVHistory{}
+	view.Episodes = ctx.Activity().TVEpisodes(ctx, d.Start, d.End)
+	return view
+}
+
+func TVStatsView(ctx Context, interval string, d date.DateRange) *TVStats {
+	view := &TVStats{}
+	view.Interval = interval
+	view.Series = ctx.Activity().TopTVSeries(ctx, d.Start, d.End)
+	view.SeriesCount = len(view.Series)
+	for _, s := range view.Series {
+		view.EpisodeCount += s.Count
+	}
+	return view
+}
+
+func PodcastHistoryView(ctx Context, d date.DateRange) *PodcastHistory {
+	view := &PodcastHistory{}
+	view.Episodes = ctx.Activity().PodcastEpisodes(ctx, d.Start, d.End)
+	return view
+}
+
+func PodcastStatsView(ctx Context, interval string, d date.DateRange) *PodcastStats {
+	view := &PodcastStats{}
+	view.Interval = interval
+	view.Series = ctx.Activity().TopPodcasts(ctx, d.Start, d.End)
+	view.SeriesCount = len(view.Series)
+	for _, s := range view.Series {
+		view.EpisodeCount += s.Count
+	}
+	return view
+}
+
 func TrackDayCountsView(ctx Context, d date.DateRange) *TrackCounts {
 	return ctx.Activity().TrackDayCounts(ctx, d)
 }
@@ -442,3 +637,101 @@ func PlaylistsView(ctx Context, playlists []model.Playlist) *Playlists {
 	view.Playlists = list
 	return view
 }
+
+func SmartPlaylistView(ctx Context, p model.SmartPlaylist) *SmartPlaylist {
+	return NewSmartPlaylist(p)
+}
+
+func SmartPlaylistsView(ctx Context, playlists []model.SmartPlaylist) *SmartPlaylists {
+	view := &SmartPlaylists{}
+	list := make([]SmartPlaylist, len(playlists))
+	for i := range playlists {
+		list[i] = *NewSmartPlaylist(playlists[i])
+	}
+	view.SmartPlaylists = list
+	return view
+}
+
+func UnmatchedView(ctx Context) *Unmatched {
+	view := &Unmatched{}
+	view.Releases = ctx.Music().UnmatchedReleases()
+	view.Movies = ctx.Film().UnmatchedMovies()
+	view.TVEpisodes = ctx.TV().UnmatchedEpisodes()
+	return view
+}
+
+func ScrobblersView(ctx Context) *Scrobblers {
+	view := &Scrobblers{}
+	view.Accounts = ctx.Activity().ScrobbleAccounts(ctx.User())
+	view.Queued = ctx.Activity().QueuedScrobbles(ctx.User())
+	return view
+}
+
+func OverridesView(ctx Context) *Overrides {
+	view := &Overrides{}
+	view.Releases = ctx.Music().ReleaseOverrides()
+	view.Tracks = ctx.Music().TrackOverrides()
+	view.Movies = ctx.Film().MovieOverrides()
+	view.TV = ctx.TV().TVOverrides()
+	return view
+}
+
+func ReleaseCandidatesView(ctx Context, u model.UnmatchedRelease) *MatchCandidates {
+	view := &MatchCandidates{}
+	view.Title = fmt.Sprintf("%s \u2013 %s", u.Artist, u.Release)
+	view.Reason = u.Reason
+	view.Match = fmt.Sprintf("/api/unmatched/releases/%d/match", u.ID)
+	for _, r := range ctx.Music().ReleaseCandidates(u) {
+		title := r.Name
+		if r.Disambiguation != "" {
+			title = fmt.Sprintf("%s (%s)", r.Name, r.Disambiguation)
+		}
+		view.Candidates = append(view.Candidates, MatchCandidate{
+			ID:     r.REID,
+			Title:  title,
+			Date:   r.ReleaseDate.Format("2006-01-02"),
+			Detail: fmt.Sprintf("%d discs, %d tracks, %s %s", r.DiscCount, r.TrackCount, r.Country, r.Status),
+		})
+	}
+	return view
+}
+
+func MovieCandidatesView(ctx Context, u model.UnmatchedMovie) (*MatchCandidates, error) {
+	view := &MatchCandidates{}
+	view.Title = u.Title
+	view.Reason = u.Reason
+	view.Match = fmt.Sprintf("/api/unmatched/movies/%d/match", u.ID)
+	results, err := ctx.Film().MovieCandidates(u)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range results {
+		view.Candidates = append(view.Candidates, MatchCandidate{
+			ID:     strconv.Itoa(r.ID),
+			Title:  r.Title,
+			Date:   r.ReleaseDate,
+			Detail: r.Overview,
+		})
+	}
+	return view, nil
+}
+
+func SeriesCandidatesView(ctx Context, u model.UnmatchedTVEpisode) (*MatchCandidates, error) {
+	view := &MatchCandidates{}
+	view.Title = u.Series
+	view.Reason = u.Reason
+	view.Match = fmt.Sprintf("/api/unmatched/tv/%d/match", u.ID)
+	results, err := ctx.TV().SeriesCandidates(u)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range results {
+		view.Candidates = append(view.Candidates, MatchCandidate{
+			ID:     strconv.Itoa(r.ID),
+			Title:  r.Name,
+			Date:   r.FirstAirDate,
+			Detail: r.Overview,
+		})
+	}
+	return view, nil
+}
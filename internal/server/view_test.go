@@ -18,10 +18,16 @@
 package server
 
 import (
+	"fmt"
 	"testing"
 	"time"
 
+	"gorm.io/driver/sqlite"
+	g "gorm.io/gorm"
+
 	"takeoutfm.dev/takeout/lib/date"
+	"takeoutfm.dev/takeout/lib/search"
+	"takeoutfm.dev/takeout/lib/setlist"
 	"takeoutfm.dev/takeout/model"
 )
 
@@ -43,10 +49,22 @@ func TestHomeView(t *testing.T) {
 
 func TestArtistsView(t *testing.T) {
 	ctx := NewTestContext(t)
-	view := ArtistsView(ctx)
+	view, err := ArtistsView(ctx, ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
 	if view == nil {
 		t.Fatal("expect view")
 	}
+
+	view, err = ArtistsView(ctx, ListOptions{Sort: ListSortName, Desc: true,
+		Genre: "rock", Decade: 1990, Letter: "B", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if view.Page.Total != 0 || view.Artists == nil {
+		t.Error("expect empty page", view.Page)
+	}
 }
 
 func TestArtistView(t *testing.T) {
@@ -92,12 +110,72 @@ func TestReleaseView(t *testing.T) {
 func TestSearchView(t *testing.T) {
 	ctx := NewTestContext(t)
 	query := "test query"
-	view := SearchView(ctx, query)
+	view := SearchView(ctx, search.Request{Query: query})
 	if view == nil {
 		t.Fatal("expect view")
 	}
 }
 
+func TestSuggestView(t *testing.T) {
+	ctx := NewTestContext(t)
+	config := ctx.Config()
+	config.Search.IndexDir = t.TempDir()
+	config.Music.SearchIndexName = "music"
+	ctx.c = config
+	defer search.Remove(config.Search, config.Music.SearchIndexName)
+
+	// create the index and tables
+	view := SuggestView(ctx, "suggest")
+	if len(view.Artists) != 0 || len(view.Tracks) != 0 {
+		t.Fatal("expect no suggestions", view)
+	}
+
+	db, err := g.Open(sqlite.Open(config.Music.DB.Source), &g.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	artist := model.Artist{Name: "Suggested Artist"}
+	track := model.Track{Artist: artist.Name, Title: "Suggested Song", Key: "suggest/01.flac"}
+	if err := db.Create(&artist).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&track).Error; err != nil {
+		t.Fatal(err)
+	}
+	defer db.Unscoped().Delete(&track)
+	defer db.Unscoped().Delete(&artist)
+
+	s := search.NewSearcher(config.Search)
+	err = s.Open(config.Music.SearchIndexName, search.Mapping{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Index(search.IndexMap{track.Key: search.FieldMap{
+		"artist": artist.Name, "title": track.Title}})
+	s.Close()
+
+	view = SuggestView(ctx, "sugg")
+	if len(view.Artists) != 1 || view.Artists[0].Name != artist.Name ||
+		view.Artists[0].ID != int64(artist.ID) {
+		t.Error("expect artist suggestion", view.Artists)
+	}
+	if len(view.Tracks) != 1 || view.Tracks[0].ID != int64(track.ID) ||
+		view.Tracks[0].Ref != fmt.Sprintf("/music/tracks/%d", track.ID) {
+		t.Error("expect track suggestion", view.Tracks)
+	}
+	if len(view.Releases) != 0 || len(view.Movies) != 0 {
+		t.Error("expect only music suggestions", view)
+	}
+}
+
+func TestLyricsView(t *testing.T) {
+	ctx := NewTestContext(t)
+	_, err := LyricsView(ctx, model.Track{Key: "test key"})
+	if err == nil {
+		t.Error("expect no lyrics")
+	}
+}
+
 func TestRadioView(t *testing.T) {
 	ctx := NewTestContext(t)
 	view := RadioView(ctx)
@@ -108,10 +186,21 @@ func TestRadioView(t *testing.T) {
 
 func TestMoviesView(t *testing.T) {
 	ctx := NewTestContext(t)
-	view := MoviesView(ctx)
+	view, err := MoviesView(ctx, ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
 	if view == nil {
 		t.Fatal("expect view")
 	}
+
+	view, err = MoviesView(ctx, ListOptions{Sort: ListSortRating, Genre: "Drama", Decade: 1990})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if view.Page.Total != 0 {
+		t.Error("expect empty page", view.Page)
+	}
 }
 
 func TestMovieView(t *testing.T) {
@@ -140,7 +229,10 @@ func TestProfileView(t *testing.T) {
 
 func TestGenreView(t *testing.T) {
 	ctx := NewTestContext(t)
-	view := GenreView(ctx, "test genre")
+	view, err := GenreView(ctx, "test genre", ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
 	if view == nil {
 		t.Fatal("expect view")
 	}
@@ -148,7 +240,10 @@ func TestGenreView(t *testing.T) {
 
 func TestKeywordView(t *testing.T) {
 	ctx := NewTestContext(t)
-	view := KeywordView(ctx, "test keyword")
+	view, err := KeywordView(ctx, "test keyword", ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
 	if view == nil {
 		t.Fatal("expect view")
 	}
@@ -156,7 +251,10 @@ func TestKeywordView(t *testing.T) {
 
 func TestPodcastsView(t *testing.T) {
 	ctx := NewTestContext(t)
-	view := PodcastsView(ctx)
+	view, err := PodcastsView(ctx, ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
 	if view == nil {
 		t.Fatal("expect view")
 	}
@@ -220,3 +318,47 @@ func TestTrackStatsView(t *testing.T) {
 		t.Fatal("expect view")
 	}
 }
+
+func TestTrackReportView(t *testing.T) {
+	ctx := NewTestContext(t)
+	view, err := TrackReportView(ctx, "lastmonth", date.NewReportInterval(time.Now(), "lastmonth"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if view.ListenCount != 0 || len(view.Hours) != 24 {
+		t.Error("expect empty report", view)
+	}
+
+	_, err = TrackReportView(ctx, "yesterday", date.NewReportInterval(time.Now(), "yesterday"))
+	if err != ErrInvalidParameter {
+		t.Error("expect invalid range", err)
+	}
+}
+
+func TestUnmatchedView(t *testing.T) {
+	ctx := NewTestContext(t)
+	view := UnmatchedView(ctx)
+	if view == nil {
+		t.Fatal("expect view")
+	}
+}
+
+func TestSetlistView(t *testing.T) {
+	sl := setlist.Setlist{
+		Id:        "23be88f3",
+		EventDate: "27-10-2022",
+		Artist:    setlist.Artist{Name: "Iron Maiden"},
+		Venue: setlist.Venue{Name: "Amalie Arena",
+			City: setlist.City{Name: "Tampa", Country: setlist.Country{Name: "United States"}}},
+	}
+	v := setlistView(sl)
+	if v.Ref != "/music/setlists/23be88f3" {
+		t.Error("expect ref", v.Ref)
+	}
+	if !setlistsRegexp.MatchString(v.Ref) || !validStationRef(v.Ref) {
+		t.Error("expect ref to resolve", v.Ref)
+	}
+	if v.Date.Year() != 2022 || v.City != "Tampa" || v.Country != "United States" {
+		t.Error("expect setlist details", v)
+	}
+}
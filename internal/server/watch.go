@@ -0,0 +1,131 @@
+// Copyright 2024 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package server
+
+import (
+	"takeoutfm.dev/takeout/internal/config"
+	"takeoutfm.dev/takeout/internal/film"
+	"takeoutfm.dev/takeout/internal/music"
+	"takeoutfm.dev/takeout/internal/tv"
+	"takeoutfm.dev/takeout/lib/bucket"
+	"takeoutfm.dev/takeout/lib/log"
+)
+
+// watch starts file system watchers for local buckets that have FS.Watch
+// enabled. Periodic syncs remain scheduled and are used as the fallback when
+// the watcher overflows.
+func watch(config *config.Config) {
+	list, err := assignedMedia(config)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for _, mediaName := range list {
+		mediaConfig, err := mediaConfig(config, mediaName)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		for _, bc := range mediaConfig.Buckets {
+			if bc.FS.Root == "" || bc.FS.Watch == false {
+				continue
+			}
+			b, err := bucket.Open(bc)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			w, ok := b.(bucket.Watcher)
+			if !ok {
+				continue
+			}
+			changesCh, err := w.Watch()
+			if err != nil {
+				log.Printf("watch %s: %s\n", bc.FS.Root, err)
+				continue
+			}
+			log.Printf("watching %s\n", bc.FS.Root)
+			go func(bc bucket.Config) {
+				for changes := range changesCh {
+					watchSync(config, mediaConfig, bc, changes)
+				}
+			}(bc)
+		}
+	}
+}
+
+func watchSync(cfg *config.Config, mediaConfig *config.Config, bc bucket.Config, changes bucket.Changes) {
+	syncLock.Lock()
+	defer syncLock.Unlock()
+
+	var err error
+	if changes.Overflow {
+		// events were lost so sync as scheduled
+		log.Printf("watch overflow %s\n", bc.FS.Root)
+		switch bc.Media {
+		case config.MediaMusic:
+			err = syncMusic(cfg, mediaConfig)
+		case config.MediaFilm:
+			err = syncFilm(cfg, mediaConfig)
+		case config.MediaTV:
+			err = syncTV(cfg, mediaConfig)
+		}
+	} else if len(changes.Dirs) > 0 {
+		for _, dir := range changes.Dirs {
+			log.Printf("watch changed %s\n", dir)
+		}
+		err = watchSyncDirs(mediaConfig, bc.Media, changes.Dirs)
+	}
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// watchSyncDirs syncs all files within the changed directories. Modified
+// times aren't used since moved files can keep older times.
+func watchSyncDirs(mediaConfig *config.Config, media string, dirs []string) error {
+	switch media {
+	case config.MediaMusic:
+		m := music.NewMusic(mediaConfig)
+		err := m.Open()
+		if err != nil {
+			return err
+		}
+		defer m.Close()
+		syncOptions := music.NewSyncOptions()
+		syncOptions.Dirs = dirs
+		m.Sync(syncOptions)
+	case config.MediaFilm:
+		f := film.NewFilm(mediaConfig)
+		err := f.Open()
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return f.SyncDirs(dirs)
+	case config.MediaTV:
+		tv := tv.NewTV(mediaConfig)
+		err := tv.Open()
+		if err != nil {
+			return err
+		}
+		defer tv.Close()
+		return tv.SyncDirs(dirs)
+	}
+	return nil
+}
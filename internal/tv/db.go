@@ -22,6 +22,7 @@ import (
 	"time"
 
 	"takeoutfm.dev/takeout/internal/people"
+	tgorm "takeoutfm.dev/takeout/lib/gorm"
 	. "takeoutfm.dev/takeout/model"
 	"gorm.io/driver/mysql"
 	"gorm.io/driver/postgres"
@@ -51,7 +52,8 @@ func (tv *TV) openDB() (err error) {
 	tv.db.AutoMigrate(
 		&TVSeriesCast{}, &TVSeriesCrew{},
 		&TVEpisodeCast{}, &TVEpisodeCrew{},
-		&TVGenre{}, &TVKeyword{}, &TVSeries{}, &TVEpisode{}, &Person{})
+		&TVGenre{}, &TVKeyword{}, &TVSeries{}, &TVEpisode{}, &Person{},
+		&TVMatch{}, &UnmatchedTVEpisode{})
 	return
 }
 
@@ -69,6 +71,16 @@ func (tv *TV) Series() []TVSeries {
 	return series
 }
 
+// SeriesPage returns a page of series and the total matching series.
+func (tv *TV) SeriesPage(page tgorm.Page) ([]TVSeries, int, error) {
+	if page.Order == "" {
+		page.Order = "sort_name"
+	}
+	var series []TVSeries
+	total, err := page.Find(tv.db, &series)
+	return series, total, err
+}
+
 func (tv *TV) Episodes() []TVEpisode {
 	var episodes []TVEpisode
 	tv.db.Order("tv_id asc, season asc, episode asc").Find(&episodes)
@@ -296,6 +308,17 @@ func (tv *TV) Person(peid int) (Person, error) {
 	return people.Person(tv.db, peid)
 }
 
+func (tv *TV) peopleNamed(names []string) []Person {
+	return people.PeopleNamed(tv.db, names)
+}
+
+// seriesNamed returns the series with one of the names.
+func (tv *TV) seriesNamed(names []string) []TVSeries {
+	var series []TVSeries
+	tv.db.Where("name in (?)", names).Order("date").Find(&series)
+	return series
+}
+
 func (tv *TV) LookupSeries(id int) (TVSeries, error) {
 	var series TVSeries
 	err := tv.db.First(&series, id).Error
@@ -444,3 +467,75 @@ func (tv *TV) createEpisode(e *TVEpisode) error {
 func (tv *TV) updateEpisode(e *TVEpisode) error {
 	return tv.db.Save(e).Error
 }
+
+func (tv *TV) UnmatchedEpisodes() []UnmatchedTVEpisode {
+	var list []UnmatchedTVEpisode
+	tv.db.Order("series, season, episode, key").Find(&list)
+	return list
+}
+
+func (tv *TV) LookupUnmatchedEpisode(id int) (UnmatchedTVEpisode, error) {
+	var u UnmatchedTVEpisode
+	err := tv.db.First(&u, id).Error
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		return UnmatchedTVEpisode{}, ErrEpisodeNotFound
+	}
+	return u, err
+}
+
+func (tv *TV) unmatchedSeriesEpisodes(series, year string) []UnmatchedTVEpisode {
+	var list []UnmatchedTVEpisode
+	tv.db.Where("series = ? and year = ?", series, year).Find(&list)
+	return list
+}
+
+// Create or replace the unmatched episode with the same key.
+func (tv *TV) saveUnmatchedEpisode(u *UnmatchedTVEpisode) error {
+	var curr UnmatchedTVEpisode
+	err := tv.db.First(&curr, "key = ?", u.Key).Error
+	if err == nil {
+		u.ID = curr.ID
+		u.CreatedAt = curr.CreatedAt
+		return tv.db.Save(u).Error
+	}
+	return tv.db.Create(u).Error
+}
+
+func (tv *TV) deleteUnmatchedEpisode(key string) {
+	var list []UnmatchedTVEpisode
+	tv.db.Where("key = ?", key).Find(&list)
+	for _, o := range list {
+		tv.db.Unscoped().Delete(o)
+	}
+}
+
+func (tv *TV) deleteUnmatchedEpisodes() {
+	tv.db.Unscoped().Where("1 = 1").Delete(&UnmatchedTVEpisode{})
+}
+
+func (tv *TV) tvMatch(name, year string) (TVMatch, error) {
+	var match TVMatch
+	err := tv.db.First(&match, "name = ? and year = ?", name, year).Error
+	return match, err
+}
+
+// Create or replace the series match with the same name and year.
+func (tv *TV) saveTVMatch(match *TVMatch) error {
+	curr, err := tv.tvMatch(match.Name, match.Year)
+	if err == nil {
+		match.ID = curr.ID
+		match.CreatedAt = curr.CreatedAt
+		return tv.db.Save(match).Error
+	}
+	return tv.db.Create(match).Error
+}
+
+func (tv *TV) tvMatches() []TVMatch {
+	var list []TVMatch
+	tv.db.Order("name, year").Find(&list)
+	return list
+}
+
+func (tv *TV) deleteTVMatch(id int) error {
+	return tv.db.Unscoped().Delete(&TVMatch{}, id).Error
+}
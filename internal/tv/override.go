@@ -0,0 +1,96 @@
+// Copyright 2024 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package tv
+
+import (
+	"errors"
+
+	"takeoutfm.dev/takeout/lib/bucket"
+	"takeoutfm.dev/takeout/lib/log"
+	"takeoutfm.dev/takeout/lib/search"
+	. "takeoutfm.dev/takeout/model"
+)
+
+var (
+	ErrInvalidOverride = errors.New("invalid override")
+)
+
+// TVOverrides returns all saved TMDB ids for series names and years,
+// including those chosen from unmatched episodes.
+func (tv *TV) TVOverrides() []TVMatch {
+	return tv.tvMatches()
+}
+
+// SaveTVOverride pins the series name and year to the TMDB id. Existing and
+// unmatched episodes for the series are synced again using the TMDB id.
+func (tv *TV) SaveTVOverride(match *TVMatch) error {
+	if match.Name == "" || match.TVID == 0 {
+		return ErrInvalidOverride
+	}
+	err := tv.saveTVMatch(match)
+	if err != nil {
+		return err
+	}
+
+	tvid := int(match.TVID)
+	err = tv.syncUnmatchedSeries(match.Name, match.Year, tvid)
+	if err != nil {
+		return err
+	}
+
+	s, err := tv.newSearch()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	context := syncContext{series: make(map[int]struct{})}
+	index := make(search.IndexMap)
+	for _, e := range tv.Episodes() {
+		if e.TVID == match.TVID {
+			continue
+		}
+		matches := tvRegexp.FindStringSubmatch(e.Key)
+		if matches == nil || matches[1] != match.Name || matches[2] != match.Year {
+			continue
+		}
+		tv.deleteEpisodeCast(e)
+		tv.deleteEpisodeCrew(e)
+		tv.deleteEpisode(int(e.TVID), e.Season, e.Episode)
+		o := &bucket.Object{
+			Key:          e.Key,
+			ETag:         e.ETag,
+			Size:         e.Size,
+			LastModified: e.LastModified,
+		}
+		fields, err := tv.syncSeriesEpisode(&context, o, tvid, e.Season, e.Episode)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		index[e.Key] = fields
+	}
+	s.Index(index)
+	return nil
+}
+
+// DeleteTVOverride removes the saved TMDB id. Episodes remain as-is until
+// the next sync.
+func (tv *TV) DeleteTVOverride(id int) error {
+	return tv.deleteTVMatch(id)
+}
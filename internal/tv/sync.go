@@ -55,13 +55,35 @@ const (
 )
 
 type syncContext struct {
-	series map[string]int
+	series map[int]struct{}
 }
 
 func (tv *TV) Sync() error {
 	return tv.SyncSince(time.Time{})
 }
 
+// Reindex rebuilds the search index when it was created with an older
+// mapping version. The index is migrated from its stored fields, or all
+// episodes are synced again to index them when that fails.
+func (tv *TV) Reindex() error {
+	name := tv.config.TV.SearchIndexName
+	outdated, err := search.Outdated(tv.config.Search, name)
+	if err != nil || !outdated {
+		return err
+	}
+	log.Printf("reindex %s\n", name)
+	err = search.Migrate(tv.config.Search, name, searchMapping)
+	if err == nil {
+		return nil
+	}
+	log.Printf("migrate %s: %s\n", name, err)
+	err = search.Remove(tv.config.Search, name)
+	if err != nil {
+		return err
+	}
+	return tv.Sync()
+}
+
 func (tv *TV) SyncSince(lastSync time.Time) error {
 	for _, bucket := range tv.buckets {
 		err := tv.syncBucket(bucket, lastSync)
@@ -72,6 +94,30 @@ func (tv *TV) SyncSince(lastSync time.Time) error {
 	return nil
 }
 
+// SyncDirs syncs all episodes within the directories of local buckets,
+// regardless of modified time. Unmatched episodes elsewhere are kept.
+func (tv *TV) SyncDirs(dirs []string) error {
+	for _, b := range tv.buckets {
+		l, ok := b.(bucket.DirLister)
+		if !ok {
+			continue
+		}
+		for _, dir := range dirs {
+			objectCh, err := l.ListDir(dir)
+			if err == bucket.ErrNotInBucket {
+				continue
+			} else if err != nil {
+				return err
+			}
+			err = tv.syncObjects(objectCh)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 var (
 	fuzzyNameRegexp = regexp.MustCompile(`[^a-zA-Z0-9]`)
 
@@ -84,6 +130,8 @@ var (
 	// Sopranos (2007) - S06E21 - Made in America.mkv
 	// Name (Date) - SXXEYY[ - Optional].mkv
 	tvRegexp = regexp.MustCompile(`.*/(.+?)\s*\(([\d]+)\)\s+[^\d]*(S\d\dE\d\d)[^\d]*?(?:\s-\s(.+))?\.(mkv|mp4)$`)
+
+	videoRegexp = regexp.MustCompile(`\.(mkv|mp4)$`)
 )
 
 func (tv *TV) syncBucket(bucket bucket.Bucket, lastSync time.Time) error {
@@ -91,7 +139,14 @@ func (tv *TV) syncBucket(bucket bucket.Bucket, lastSync time.Time) error {
 	if err != nil {
 		return err
 	}
+	if lastSync.IsZero() {
+		// full sync will find all unmatched episodes again
+		tv.deleteUnmatchedEpisodes()
+	}
+	return tv.syncObjects(objectCh)
+}
 
+func (tv *TV) syncObjects(objectCh chan *bucket.Object) error {
 	s, err := tv.newSearch()
 	if err != nil {
 		return err
@@ -99,7 +154,7 @@ func (tv *TV) syncBucket(bucket bucket.Bucket, lastSync time.Time) error {
 	defer s.Close()
 
 	context := syncContext{}
-	context.series = make(map[string]int)
+	context.series = make(map[int]struct{})
 
 	var matches []string
 	for o := range objectCh {
@@ -112,6 +167,8 @@ func (tv *TV) syncBucket(bucket bucket.Bucket, lastSync time.Time) error {
 			if err != nil {
 				log.Println(err)
 			}
+		} else if videoRegexp.MatchString(o.Path) {
+			tv.unmatchedEpisode(o, "", "", 0, 0, ReasonInvalidName)
 		}
 	}
 	return nil
@@ -123,13 +180,35 @@ func fuzzyName(name string) string {
 
 func (tv *TV) doEpisode(context *syncContext, o *bucket.Object, s search.Searcher, series, year, detail string) error {
 	season, episode, err := parseEpisode(detail)
+	if err != nil {
+		tv.unmatchedEpisode(o, series, year, 0, 0, err.Error())
+		return err
+	}
+
+	index := make(search.IndexMap)
+
+	if match, err := tv.tvMatch(series, year); err == nil {
+		// manually matched series takes precedence
+		fields, err := tv.syncSeriesEpisode(context, o, int(match.TVID), season, episode)
+		if err != nil {
+			tv.unmatchedEpisode(o, series, year, season, episode, err.Error())
+			return err
+		}
+		index[o.Key] = fields
+		s.Index(index)
+		tv.deleteUnmatchedEpisode(o.Key)
+		return nil
+	}
 
 	results, err := tv.tmdb.TVSearch(series)
 	if err != nil {
 		return err
 	}
 
-	index := make(search.IndexMap)
+	reason := ReasonNoResults
+	if len(results) > 0 {
+		reason = ReasonNoMatch
+	}
 
 	for _, r := range results {
 		fmt.Printf("result %s %s\n", r.Name, r.FirstAirDate)
@@ -137,33 +216,45 @@ func (tv *TV) doEpisode(context *syncContext, o *bucket.Object, s search.Searche
 			strings.Contains(r.FirstAirDate, year) {
 			log.Println("matched", r.Name, r.FirstAirDate)
 
-			_, ok := context.series[r.Name]
-			if !ok {
-				_, err := tv.syncSeries(r.ID)
-				if err == nil {
-					context.series[r.Name] = r.ID
-				} else {
-					log.Println(err)
-				}
-			}
-
-			fields, err := tv.syncEpisode(o, r.ID, season, episode)
+			fields, err := tv.syncSeriesEpisode(context, o, r.ID, season, episode)
 			if err != nil {
 				log.Println(err)
+				reason = err.Error()
 				continue
 			}
 
 			// only episode fields are stored in the index
 			index[o.Key] = fields
+			reason = ""
 			break
 		}
 	}
 
 	s.Index(index)
 
+	if reason != "" {
+		tv.unmatchedEpisode(o, series, year, season, episode, reason)
+	} else {
+		tv.deleteUnmatchedEpisode(o.Key)
+	}
+
 	return nil
 }
 
+// sync the series once per context and then the episode
+func (tv *TV) syncSeriesEpisode(context *syncContext, o *bucket.Object, tvid, season, episode int) (search.FieldMap, error) {
+	_, ok := context.series[tvid]
+	if !ok {
+		_, err := tv.syncSeries(tvid)
+		if err == nil {
+			context.series[tvid] = struct{}{}
+		} else {
+			log.Println(err)
+		}
+	}
+	return tv.syncEpisode(o, tvid, season, episode)
+}
+
 func parseEpisode(s string) (int, int, error) {
 	matches := episodeRegexp.FindStringSubmatch(s)
 	if len(matches) != 3 {
@@ -28,10 +28,14 @@ import (
 	"takeoutfm.dev/takeout/internal/people"
 	"takeoutfm.dev/takeout/lib/bucket"
 	"takeoutfm.dev/takeout/lib/search"
+	"takeoutfm.dev/takeout/lib/thumb"
 	"takeoutfm.dev/takeout/lib/tmdb"
 	. "takeoutfm.dev/takeout/model"
 )
 
+// StillLargeWidth is the width of large stills resized from the original.
+const StillLargeWidth = 1280
+
 var (
 	ErrEpisodeNotFound = errors.New("episode not found")
 	ErrInvalidEpisode  = errors.New("invalid episode")
@@ -100,35 +104,86 @@ func (tv *TV) FindPerson(identifier string) (Person, error) {
 	return people.FindPerson(tv.db, identifier)
 }
 
-func (tv *TV) newSearch() (search.Searcher, error) {
-	keywords := []string{
+// searchFacets are the facets counted for episode search requests.
+var searchFacets = []search.Facet{
+	{Name: FieldGenre, Field: FieldGenre},
+	{Name: search.FacetDecade, Field: FieldDate, Decades: true},
+	{Name: FieldRating, Field: FieldRating},
+}
+
+// searchMapping has the fields indexed as keywords and suggest fields.
+var searchMapping = search.Mapping{
+	Keywords: []string{
 		FieldGenre,
 		FieldKeyword,
-	}
+		FieldRating,
+	},
+	Suggest: []string{
+		FieldCast,
+		FieldCrew,
+		FieldSeries,
+	},
+}
+
+func (tv *TV) newSearch() (search.Searcher, error) {
 	s := tv.config.NewSearcher()
-	err := s.Open(tv.config.TV.SearchIndexName, keywords)
+	err := s.Open(tv.config.TV.SearchIndexName, searchMapping)
 	if err != nil {
 		return nil, err
 	}
 	return s, nil
 }
 
-func (tv *TV) Search(q string, limit ...int) []TVEpisode {
+// Suggest returns series and cast or crew with names matching the prefix,
+// most common first.
+func (tv *TV) Suggest(prefix string) (series []TVSeries, people []Person) {
 	s, err := tv.newSearch()
 	if err != nil {
-		return []TVEpisode{}
+		return
 	}
 	defer s.Close()
+	names, _ := s.Suggest(prefix, tv.config.TV.SuggestSeriesLimit, FieldSeries)
+	series = search.Named(names, tv.seriesNamed(names),
+		func(s TVSeries) string { return s.Name })
+	names, _ = s.Suggest(prefix, tv.config.TV.SuggestPersonLimit, FieldCast, FieldCrew)
+	people = search.Named(names, tv.peopleNamed(names),
+		func(p Person) string { return p.Name })
+	return
+}
 
+func (tv *TV) Search(q string, limit ...int) []TVEpisode {
 	l := tv.config.TV.SearchLimit
 	if len(limit) == 1 {
 		l = limit[0]
 	}
+	episodes, _, _ := tv.search(search.Request{Query: q, Limit: l, Advanced: true})
+	return episodes
+}
+
+// SearchRequest searches episodes with the request filters, sort and paging
+// and returns the episodes in result order, the score for each episode and
+// the result with counts for the genre, decade and rating facets.
+// The limit is at most the configured search limit.
+func (tv *TV) SearchRequest(r search.Request) ([]TVEpisode, []float64, search.Result) {
+	if r.Limit == 0 || r.Limit > tv.config.TV.SearchLimit {
+		r.Limit = tv.config.TV.SearchLimit
+	}
+	r.Facets = searchFacets
+	return tv.search(r)
+}
 
-	keys, err := s.Search(q, l)
+func (tv *TV) search(r search.Request) ([]TVEpisode, []float64, search.Result) {
+	s, err := tv.newSearch()
 	if err != nil {
-		return nil
+		return []TVEpisode{}, nil, search.Result{}
 	}
+	defer s.Close()
+
+	result, err := s.SearchRequest(r)
+	if err != nil {
+		return nil, nil, result
+	}
+	keys := result.Keys()
 
 	// split potentially large # of result keys into chunks to query
 	chunkSize := 100
@@ -142,7 +197,10 @@ func (tv *TV) Search(q string, limit ...int) []TVEpisode {
 		episodes = append(episodes, tv.episodesFor(chunk)...)
 	}
 
-	return episodes
+	episodes, scores := search.Ordered(result.Hits, episodes, func(e TVEpisode) string {
+		return e.Key
+	})
+	return episodes, scores, result
 }
 
 func (tv *TV) EpisodeURL(e TVEpisode) *url.URL {
@@ -189,7 +247,8 @@ func EpisodeStillLarge(e TVEpisode) string {
 	if e.StillPath == "" {
 		return ""
 	}
-	return strings.Join([]string{"/img/tm/", tmdb.StillOriginal, e.StillPath}, "")
+	// originals are resized since there's no large still size
+	return thumb.URL(strings.Join([]string{"/img/tm/", tmdb.StillOriginal, e.StillPath}, ""), StillLargeWidth)
 }
 
 func (tv *TV) HasShows() bool {
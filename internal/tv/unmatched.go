@@ -0,0 +1,111 @@
+// Copyright 2024 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package tv
+
+import (
+	"takeoutfm.dev/takeout/lib/bucket"
+	"takeoutfm.dev/takeout/lib/log"
+	"takeoutfm.dev/takeout/lib/search"
+	"takeoutfm.dev/takeout/lib/tmdb"
+	. "takeoutfm.dev/takeout/model"
+)
+
+const (
+	ReasonInvalidName = "name doesn't match 'Series (Year) - S01E01.mkv'"
+	ReasonNoResults   = "no search results"
+	ReasonNoMatch     = "no result with the same name and year"
+)
+
+func (tv *TV) unmatchedEpisode(o *bucket.Object, series, year string, season, episode int, reason string) {
+	log.Printf("unmatched %s: %s\n", o.Key, reason)
+	u := UnmatchedTVEpisode{
+		Key:          o.Key,
+		Series:       series,
+		Year:         year,
+		Season:       season,
+		Episode:      episode,
+		Reason:       reason,
+		Size:         o.Size,
+		ETag:         o.ETag,
+		LastModified: o.LastModified,
+	}
+	err := tv.saveUnmatchedEpisode(&u)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// SeriesCandidates searches TMDB for series that may match the unmatched
+// episode.
+func (tv *TV) SeriesCandidates(u UnmatchedTVEpisode) ([]tmdb.TVResult, error) {
+	if u.Series == "" {
+		return nil, ErrInvalidEpisode
+	}
+	return tv.tmdb.TVSearch(u.Series)
+}
+
+// MatchSeries syncs all unmatched episodes with the same series name and year
+// as the unmatched episode using the TMDB id. The choice is saved and used by
+// future syncs.
+func (tv *TV) MatchSeries(u UnmatchedTVEpisode, tvid int) error {
+	if u.Series == "" || u.Season == 0 || u.Episode == 0 {
+		// file name needs to be fixed
+		return ErrInvalidEpisode
+	}
+
+	match := TVMatch{Name: u.Series, Year: u.Year, TVID: int64(tvid)}
+	err := tv.saveTVMatch(&match)
+	if err != nil {
+		return err
+	}
+
+	return tv.syncUnmatchedSeries(u.Series, u.Year, tvid)
+}
+
+// syncUnmatchedSeries syncs all unmatched episodes with the series name and
+// year using the TMDB id.
+func (tv *TV) syncUnmatchedSeries(series, year string, tvid int) error {
+	s, err := tv.newSearch()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	context := syncContext{series: make(map[int]struct{})}
+	index := make(search.IndexMap)
+	for _, e := range tv.unmatchedSeriesEpisodes(series, year) {
+		if e.Season == 0 || e.Episode == 0 {
+			continue
+		}
+		o := &bucket.Object{
+			Key:          e.Key,
+			ETag:         e.ETag,
+			Size:         e.Size,
+			LastModified: e.LastModified,
+		}
+		fields, err := tv.syncSeriesEpisode(&context, o, tvid, e.Season, e.Episode)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		index[e.Key] = fields
+		tv.deleteUnmatchedEpisode(e.Key)
+	}
+	s.Index(index)
+	return nil
+}
@@ -23,12 +23,14 @@ package bucket // import "takeoutfm.dev/takeout/lib/bucket"
 
 import (
 	"errors"
+	"io"
 	"net/url"
 	"time"
 )
 
 var (
-	ErrNoBucket = errors.New("no bucket configuration")
+	ErrNoBucket    = errors.New("no bucket configuration")
+	ErrNotInBucket = errors.New("not in bucket")
 )
 
 type Config struct {
@@ -45,6 +47,30 @@ type Bucket interface {
 	IsLocal() bool
 }
 
+// DirLister is implemented by buckets that can list all objects within a
+// directory, regardless of modified time.
+type DirLister interface {
+	ListDir(dir string) (chan *Object, error)
+}
+
+// Container is implemented by buckets that can determine if a key is within
+// the bucket without listing.
+type Container interface {
+	Contains(key string) bool
+}
+
+// Writer is implemented by buckets that can store new objects. The key is
+// relative to the bucket root or object prefix.
+type Writer interface {
+	Put(key string, r io.Reader, contentType string) error
+}
+
+// Reader is implemented by buckets that can read objects. The key is the
+// object key from List.
+type Reader interface {
+	Open(key string) (io.ReadCloser, error)
+}
+
 type Object struct {
 	Key          string
 	Path         string // Key modified by rewrite rules
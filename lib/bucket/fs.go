@@ -22,17 +22,30 @@
 package bucket // import "takeoutfm.dev/takeout/lib/bucket"
 
 import (
+	"io"
+	"io/fs"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"takeoutfm.dev/takeout/lib/hash"
 	"takeoutfm.dev/takeout/lib/log"
 )
 
+const (
+	DefaultHashWorkers = 4
+)
+
 type FSConfig struct {
-	Root string
+	Root          string
+	Watch         bool          // watch Root for changes using inotify
+	WatchDelay    time.Duration // quiet time before changes are reported
+	HashCacheFile string        // persistent etag cache, disabled if empty
+	HashWorkers   int           // concurrent hashing, default is DefaultHashWorkers
+	Fingerprint   bool          // use hash.Fingerprint instead of hash.MD5Sum for etags
 }
 
 type fileBucket struct {
@@ -47,8 +60,50 @@ func (f *fileBucket) IsLocal() bool {
 	return true //f.config.Local
 }
 
+type fileEntry struct {
+	path string
+	info fs.FileInfo
+}
+
+// List regular files modified after lastSync. Files are hashed concurrently
+// and previously computed etags are reused from the hash cache when the file
+// is unchanged. Note that changing Fingerprint will change all etags.
 func (f *fileBucket) List(lastSync time.Time) (objectCh chan *Object, err error) {
+	return f.list(f.config.FS.Root, lastSync)
+}
+
+// ListDir lists all regular files within the directory, which must be within
+// the bucket root.
+func (f *fileBucket) ListDir(dir string) (chan *Object, error) {
+	if !f.Contains(dir) {
+		return nil, ErrNotInBucket
+	}
+	return f.list(filepath.Clean(dir), time.Time{})
+}
+
+// Contains returns true if the key is the bucket root or within it.
+func (f *fileBucket) Contains(key string) bool {
+	root := filepath.Clean(f.config.FS.Root)
+	key = filepath.Clean(key)
+	return key == root || strings.HasPrefix(key, root+string(filepath.Separator))
+}
+
+func (f *fileBucket) list(root string, lastSync time.Time) (objectCh chan *Object, err error) {
+	var cache *hashCache
+	if f.config.FS.HashCacheFile != "" {
+		cache, err = loadHashCache(f.config.FS.HashCacheFile, f.config.FS.Root)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	workers := f.config.FS.HashWorkers
+	if workers <= 0 {
+		workers = DefaultHashWorkers
+	}
+
 	objectCh = make(chan *Object)
+	fileCh := make(chan fileEntry)
 
 	walk := func(path string, entry os.DirEntry, err error) error {
 		if err != nil {
@@ -60,34 +115,115 @@ func (f *fileBucket) List(lastSync time.Time) (objectCh chan *Object, err error)
 				return err
 			}
 			if info.ModTime().After(lastSync) {
-				var etag string
-				etag, err = hash.MD5Sum(path)
-				if err != nil {
-					log.Printf("etag %s: %s\n", path, err)
-				} else {
-					objectCh <- &Object{
-						Key:          path,
-						Path:         rewrite(f.config.RewriteRules, path),
-						ETag:         etag,
-						Size:         info.Size(),
-						LastModified: info.ModTime(),
-					}
-				}
+				fileCh <- fileEntry{path: path, info: info}
 			}
 		}
 		return err
 	}
 
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for e := range fileCh {
+				etag, err := f.etag(cache, e.path, e.info)
+				if err != nil {
+					log.Printf("etag %s: %s\n", e.path, err)
+					continue
+				}
+				objectCh <- &Object{
+					Key:          e.path,
+					Path:         rewrite(f.config.RewriteRules, e.path),
+					ETag:         etag,
+					Size:         e.info.Size(),
+					LastModified: e.info.ModTime(),
+				}
+			}
+		}()
+	}
+
 	go func() {
 		defer close(objectCh)
-		err = filepath.WalkDir(f.config.FS.Root, walk)
+		err := filepath.WalkDir(root, walk)
+		if err != nil {
+			log.Printf("walk %s: %s\n", root, err)
+		}
+		close(fileCh)
+		wg.Wait()
+		if cache != nil {
+			if err == nil && lastSync.IsZero() {
+				// all files within root were listed
+				cache.prune(root)
+			}
+			if err := cache.save(); err != nil {
+				log.Printf("hash cache %s: %s\n", f.config.FS.HashCacheFile, err)
+			}
+		}
 	}()
 
 	return
 }
 
+func (f *fileBucket) etag(cache *hashCache, path string, info fs.FileInfo) (string, error) {
+	fingerprint := f.config.FS.Fingerprint
+	if cache != nil {
+		if etag, ok := cache.get(path, info, fingerprint); ok {
+			return etag, nil
+		}
+	}
+	var etag string
+	var err error
+	if fingerprint {
+		etag, err = hash.Fingerprint(path, info.Size(), info.ModTime())
+	} else {
+		etag, err = hash.MD5Sum(path)
+	}
+	if err != nil {
+		return "", err
+	}
+	if cache != nil {
+		cache.put(path, info, fingerprint, etag)
+	}
+	return etag, nil
+}
+
 func (fileBucket) ObjectURL(key string) *url.URL {
 	url, err := url.Parse("file://" + key)
 	log.CheckError(err)
 	return url
 }
+
+// Open reads the file, which must be within the bucket root.
+func (f *fileBucket) Open(key string) (io.ReadCloser, error) {
+	if !f.Contains(key) {
+		return nil, ErrNotInBucket
+	}
+	return os.Open(key)
+}
+
+// Put writes the object to a temp file which is renamed when complete so
+// partial objects aren't listed.
+func (f *fileBucket) Put(key string, r io.Reader, contentType string) error {
+	path := filepath.Join(f.config.FS.Root, filepath.Clean("/"+key))
+	dir := filepath.Dir(path)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".put-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,123 @@
+// Copyright 2023 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package bucket
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFSPut(t *testing.T) {
+	root := t.TempDir()
+	b, err := Open(Config{FS: FSConfig{Root: root}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, ok := b.(Writer)
+	if !ok {
+		t.Fatal("expect writer")
+	}
+	err = w.Put("../radio/2026/show.mp3", strings.NewReader("audio"), "audio/mpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(root, "radio", "2026", "show.mp3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "audio" {
+		t.Error("expect data", string(data))
+	}
+	entries, _ := os.ReadDir(filepath.Join(root, "radio", "2026"))
+	if len(entries) != 1 {
+		t.Error("expect no temp files", entries)
+	}
+}
+
+func TestFSListDir(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"a/1.flac", "a/2.flac", "b/3.flac"} {
+		path := filepath.Join(root, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte(name), 0644)
+	}
+	// moved in with an old modified time
+	old := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	os.Chtimes(filepath.Join(root, "a/2.flac"), old, old)
+
+	b, err := Open(Config{FS: FSConfig{Root: root}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, ok := b.(DirLister)
+	if !ok {
+		t.Fatal("expect dir lister")
+	}
+	objectCh, err := l.ListDir(filepath.Join(root, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for o := range objectCh {
+		keys = append(keys, o.Key)
+	}
+	sort.Strings(keys)
+	if len(keys) != 2 || keys[0] != filepath.Join(root, "a/1.flac") ||
+		keys[1] != filepath.Join(root, "a/2.flac") {
+		t.Error("expect dir objects", keys)
+	}
+
+	if _, err := l.ListDir(filepath.Dir(root)); err != ErrNotInBucket {
+		t.Error("expect not in bucket", err)
+	}
+}
+
+func TestFSOpen(t *testing.T) {
+	root := t.TempDir()
+	b, err := Open(Config{FS: FSConfig{Root: root}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := filepath.Join(root, "cover.jpg")
+	err = os.WriteFile(key, []byte("image"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, ok := b.(Reader)
+	if !ok {
+		t.Fatal("expect reader")
+	}
+	rc, err := r.Open(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil || string(data) != "image" {
+		t.Error("expect data", string(data), err)
+	}
+	_, err = r.Open(filepath.Join(filepath.Dir(root), "other.jpg"))
+	if err != ErrNotInBucket {
+		t.Error("expect not in bucket", err)
+	}
+}
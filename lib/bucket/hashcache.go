@@ -0,0 +1,183 @@
+// Copyright 2024 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package bucket // import "takeoutfm.dev/takeout/lib/bucket"
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+type hashEntry struct {
+	Size        int64
+	ModTime     int64
+	Inode       uint64
+	Fingerprint bool
+	ETag        string
+}
+
+// hashCacheFile is the on-disk format with entries keyed by bucket root and
+// then by path, allowing buckets to share the same file.
+type hashCacheFile map[string]map[string]hashEntry
+
+// hashCacheMu serializes reading and writing of cache files since buckets
+// sharing a file can list concurrently.
+var hashCacheMu sync.Mutex
+
+// hashCache persists file etags keyed by path and is only valid while the
+// size, modification time and inode remain the same.
+type hashCache struct {
+	mu      sync.Mutex
+	file    string
+	root    string
+	entries map[string]hashEntry
+	seen    map[string]struct{}
+	dirty   bool
+}
+
+func loadHashCache(file, root string) (*hashCache, error) {
+	hashCacheMu.Lock()
+	defer hashCacheMu.Unlock()
+	all, err := readHashCacheFile(file)
+	if err != nil {
+		return nil, err
+	}
+	c := &hashCache{
+		file:    file,
+		root:    root,
+		entries: all[root],
+		seen:    make(map[string]struct{}),
+	}
+	if c.entries == nil {
+		c.entries = make(map[string]hashEntry)
+	}
+	return c, nil
+}
+
+func readHashCacheFile(file string) (hashCacheFile, error) {
+	all := make(hashCacheFile)
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return all, nil
+		}
+		return nil, err
+	}
+	err = json.Unmarshal(data, &all)
+	if err != nil {
+		// unknown or older format, start over
+		return make(hashCacheFile), nil
+	}
+	return all, nil
+}
+
+func newHashEntry(info fs.FileInfo, fingerprint bool) hashEntry {
+	return hashEntry{
+		Size:        info.Size(),
+		ModTime:     info.ModTime().UnixNano(),
+		Inode:       inode(info),
+		Fingerprint: fingerprint,
+	}
+}
+
+func (c *hashCache) get(path string, info fs.FileInfo, fingerprint bool) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seen[path] = struct{}{}
+	e, ok := c.entries[path]
+	if !ok {
+		return "", false
+	}
+	e.ETag = ""
+	if e != newHashEntry(info, fingerprint) {
+		return "", false
+	}
+	return c.entries[path].ETag, true
+}
+
+func (c *hashCache) put(path string, info fs.FileInfo, fingerprint bool, etag string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := newHashEntry(info, fingerprint)
+	e.ETag = etag
+	c.entries[path] = e
+	c.dirty = true
+}
+
+// prune removes entries within dir for files that weren't seen, use only
+// after listing all files in dir.
+func (c *hashCache) prune(dir string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	prefix := strings.TrimSuffix(dir, string(filepath.Separator)) + string(filepath.Separator)
+	for path := range c.entries {
+		if !strings.HasPrefix(path, prefix) {
+			continue
+		}
+		if _, ok := c.seen[path]; !ok {
+			delete(c.entries, path)
+			c.dirty = true
+		}
+	}
+}
+
+// save merges the entries with those of other buckets in the cache file and
+// writes the result to a temp file which is then renamed.
+func (c *hashCache) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return nil
+	}
+	hashCacheMu.Lock()
+	defer hashCacheMu.Unlock()
+	all, err := readHashCacheFile(c.file)
+	if err != nil {
+		return err
+	}
+	all[c.root] = c.entries
+	data, err := json.Marshal(all)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(c.file), filepath.Base(c.file)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	err = os.Rename(tmp.Name(), c.file)
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	c.dirty = false
+	return nil
+}
@@ -0,0 +1,156 @@
+// Copyright 2024 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package bucket
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHashCache(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.mp3")
+	if err := os.WriteFile(path, []byte("takeout"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(dir, "cache.json")
+	c, err := loadHashCache(file, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.get(path, info, false); ok {
+		t.Error("expect cache miss")
+	}
+	c.put(path, info, false, "etag")
+	gone := filepath.Join(dir, "gone.mp3")
+	c.put(gone, info, false, "gone")
+	c.put("/other/keep.mp3", info, false, "keep")
+	c.prune(dir)
+	if err := c.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = loadHashCache(file, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if etag, ok := c.get(path, info, false); !ok || etag != "etag" {
+		t.Error("expect cache hit")
+	}
+	if _, ok := c.get(path, info, true); ok {
+		t.Error("expect miss with fingerprint")
+	}
+	if _, ok := c.entries[gone]; ok {
+		t.Error("expect pruned entry")
+	}
+	if _, ok := c.entries["/other/keep.mp3"]; !ok {
+		t.Error("expect entry outside dir")
+	}
+
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(path, later, later)
+	info, _ = os.Stat(path)
+	if _, ok := c.get(path, info, false); ok {
+		t.Error("expect miss after modification")
+	}
+}
+
+func TestListHashCache(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "media")
+	os.Mkdir(root, 0755)
+	for _, name := range []string{"a.flac", "b.flac", "c.flac"} {
+		os.WriteFile(filepath.Join(root, name), []byte(name), 0644)
+	}
+	file := filepath.Join(dir, "cache.json")
+	b := newFSBucket(Config{FS: FSConfig{Root: root, HashCacheFile: file, HashWorkers: 2}})
+
+	list := func() map[string]string {
+		ch, err := b.List(time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		etags := make(map[string]string)
+		for o := range ch {
+			etags[o.Key] = o.ETag
+		}
+		return etags
+	}
+
+	first := list()
+	if len(first) != 3 {
+		t.Error("expect 3 objects")
+	}
+	if _, err := os.Stat(file); err != nil {
+		t.Error("expect cache file")
+	}
+	second := list()
+	for k, v := range first {
+		if second[k] != v {
+			t.Error("expect same etag", k)
+		}
+	}
+}
+
+func TestSharedHashCache(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "cache.json")
+	var buckets []Bucket
+	for _, name := range []string{"music", "video"} {
+		root := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Join(root, "sub"), 0755)
+		os.WriteFile(filepath.Join(root, "a.flac"), []byte(name), 0644)
+		os.WriteFile(filepath.Join(root, "sub", "b.flac"), []byte(name), 0644)
+		buckets = append(buckets,
+			newFSBucket(Config{FS: FSConfig{Root: root, HashCacheFile: file}}))
+	}
+	for _, b := range buckets {
+		ch, err := b.List(time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for range ch {
+		}
+	}
+
+	// list a sub directory which shouldn't prune other entries
+	ch, err := buckets[0].(DirLister).ListDir(filepath.Join(dir, "music", "sub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range ch {
+	}
+
+	for _, name := range []string{"music", "video"} {
+		root := filepath.Join(dir, name)
+		c, err := loadHashCache(file, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(c.entries) != 2 {
+			t.Error("expect 2 entries for", name, len(c.entries))
+		}
+	}
+}
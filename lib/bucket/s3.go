@@ -22,13 +22,16 @@
 package bucket // import "takeoutfm.dev/takeout/lib/bucket"
 
 import (
+	"io"
 	"net/url"
+	"path"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 )
 
 type S3Config struct {
@@ -42,8 +45,9 @@ type S3Config struct {
 }
 
 type s3bucket struct {
-	config Config
-	s3     *s3.S3
+	config   Config
+	s3       *s3.S3
+	uploader *s3manager.Uploader
 }
 
 func newS3Bucket(config Config) (*s3bucket, error) {
@@ -60,8 +64,9 @@ func newS3Bucket(config Config) (*s3bucket, error) {
 		return nil, err
 	}
 	bucket := &s3bucket{
-		config: config,
-		s3:     s3.New(session),
+		config:   config,
+		s3:       s3.New(session),
+		uploader: s3manager.NewUploader(session),
 	}
 	return bucket, nil
 }
@@ -119,3 +124,25 @@ func (b *s3bucket) ObjectURL(key string) *url.URL {
 	url, _ := url.Parse(urlStr)
 	return url
 }
+
+// Open reads the object.
+func (b *s3bucket) Open(key string) (io.ReadCloser, error) {
+	resp, err := b.s3.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(b.config.S3.BucketName),
+		Key:    aws.String(key)})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Put uploads the object using multipart uploads as needed since the reader
+// size may not be known.
+func (b *s3bucket) Put(key string, r io.Reader, contentType string) error {
+	_, err := b.uploader.Upload(&s3manager.UploadInput{
+		Bucket:      aws.String(b.config.S3.BucketName),
+		Key:         aws.String(path.Join(b.config.S3.ObjectPrefix, key)),
+		Body:        r,
+		ContentType: aws.String(contentType)})
+	return err
+}
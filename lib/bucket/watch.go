@@ -0,0 +1,158 @@
+// Copyright 2024 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package bucket // import "takeoutfm.dev/takeout/lib/bucket"
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"takeoutfm.dev/takeout/lib/log"
+)
+
+const (
+	DefaultWatchDelay = 30 * time.Second
+)
+
+// Watcher is implemented by buckets that can report changes as they happen
+// instead of relying only on periodic listing.
+type Watcher interface {
+	Watch() (chan Changes, error)
+}
+
+// Changes is a debounced batch of file system events. Dirs are the top-level
+// directories below the bucket root that had changes, which for typical
+// layouts are artist, genre or series directories. Overflow indicates events
+// were dropped and a full scan is needed to catch up.
+type Changes struct {
+	Dirs     []string
+	Overflow bool
+}
+
+// Watch the bucket root and all sub-directories. Events are collected until
+// there's been no activity for WatchDelay and then sent as a single batch.
+func (f *fileBucket) Watch() (changesCh chan Changes, err error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	err = addWatches(w, f.config.FS.Root)
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+
+	delay := f.config.FS.WatchDelay
+	if delay == 0 {
+		delay = DefaultWatchDelay
+	}
+
+	changesCh = make(chan Changes)
+
+	go func() {
+		defer close(changesCh)
+		defer w.Close()
+
+		timer := time.NewTimer(delay)
+		timer.Stop()
+
+		pending := make(map[string]struct{})
+		overflow := false
+
+		for {
+			select {
+			case e, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if e.Op == fsnotify.Chmod {
+					continue
+				}
+				if e.Has(fsnotify.Create) {
+					// new directories need their own watches
+					info, err := os.Stat(e.Name)
+					if err == nil && info.IsDir() {
+						err = addWatches(w, e.Name)
+						if err != nil {
+							log.Printf("watch %s: %s\n", e.Name, err)
+						}
+					}
+				}
+				dir := topDir(f.config.FS.Root, e.Name)
+				if dir != "" {
+					pending[dir] = struct{}{}
+				}
+				timer.Reset(delay)
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				if errors.Is(err, fsnotify.ErrEventOverflow) {
+					overflow = true
+					timer.Reset(delay)
+				} else {
+					log.Printf("watch %s: %s\n", f.config.FS.Root, err)
+				}
+			case <-timer.C:
+				changes := Changes{Overflow: overflow}
+				for dir := range pending {
+					changes.Dirs = append(changes.Dirs, dir)
+				}
+				sort.Strings(changes.Dirs)
+				pending = make(map[string]struct{})
+				overflow = false
+				changesCh <- changes
+			}
+		}
+	}()
+
+	return changesCh, nil
+}
+
+// Add a watch for root and each directory below it.
+func addWatches(w *fsnotify.Watcher, root string) error {
+	return filepath.WalkDir(root, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return w.Add(path)
+		}
+		return nil
+	})
+}
+
+// Map a changed path to the top-level directory below root.
+//
+// Examples with root /media/Music:
+// /media/Music/Gary Numan/Replicas (1979)/01-Me! I Disconnect From You.flac
+// -> /media/Music/Gary Numan
+// /media/Music/Gary Numan
+// -> /media/Music/Gary Numan
+func topDir(root, path string) string {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return ""
+	}
+	parts := strings.Split(rel, string(filepath.Separator))
+	return filepath.Join(root, parts[0])
+}
@@ -0,0 +1,81 @@
+// Copyright 2024 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package bucket // import "takeoutfm.dev/takeout/lib/bucket"
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTopDir(t *testing.T) {
+	root := "/media/Music"
+	if topDir(root, "/media/Music/Gary Numan/Replicas (1979)/01-Me! I Disconnect From You.flac") !=
+		"/media/Music/Gary Numan" {
+		t.Error("expect artist dir")
+	}
+	if topDir(root, "/media/Music/Gary Numan") != "/media/Music/Gary Numan" {
+		t.Error("expect artist dir")
+	}
+	if topDir(root, "/media/Music") != "" {
+		t.Error("expect no dir for root")
+	}
+	if topDir(root, "/media/Film/Movie (2000).mkv") != "" {
+		t.Error("expect no dir outside root")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	root := t.TempDir()
+	artist := filepath.Join(root, "Artist")
+	if err := os.Mkdir(artist, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	b := newFSBucket(Config{FS: FSConfig{Root: root, Watch: true, WatchDelay: 100 * time.Millisecond}})
+	changesCh, err := b.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// new release directory and tracks within the artist directory
+	release := filepath.Join(artist, "Release (2024)")
+	if err := os.Mkdir(release, 0755); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	for _, name := range []string{"01-One.flac", "02-Two.flac"} {
+		err := os.WriteFile(filepath.Join(release, name), []byte("test"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case changes := <-changesCh:
+		if changes.Overflow {
+			t.Error("expect no overflow")
+		}
+		if len(changes.Dirs) != 1 || changes.Dirs[0] != artist {
+			t.Errorf("expect %s got %v", artist, changes.Dirs)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("expect changes")
+	}
+}
@@ -134,17 +134,30 @@ func NewTransportGetter(config Config, transport http.RoundTripper) Getter {
 	return c
 }
 
-var DefaultLimiter RateLimiter = &timeLimiter{}
+var DefaultLimiter RateLimiter = &timeLimiter{interval: time.Second}
 var UnlimitedLimiter RateLimiter = unlimitedLimiter(0)
 
 type timeLimiter struct {
-	lastRequest sync.Map
+	interval    time.Duration
+	mu          sync.Mutex
+	lastRequest map[string]time.Time
 }
 
+// RateLimit waits until the interval has passed since the last request to
+// the host. Each caller reserves the next time so concurrent requests are
+// spaced out instead of all waking up together.
 func (l *timeLimiter) RateLimit(host string) {
-	t := time.Now()
-	time.Sleep(time.Second)
-	l.lastRequest.Store(host, t)
+	l.mu.Lock()
+	if l.lastRequest == nil {
+		l.lastRequest = make(map[string]time.Time)
+	}
+	next := l.lastRequest[host].Add(l.interval)
+	if now := time.Now(); next.Before(now) {
+		next = now
+	}
+	l.lastRequest[host] = next
+	l.mu.Unlock()
+	time.Sleep(time.Until(next))
 }
 
 type unlimitedLimiter int
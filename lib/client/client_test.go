@@ -21,7 +21,10 @@ import (
 	"bytes"
 	"io/ioutil"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 type errorServer struct {
@@ -157,3 +160,72 @@ func TestGetPLS(t *testing.T) {
 		t.Error("expect length -1")
 	}
 }
+
+type countServer struct {
+	count atomic.Int32
+}
+
+func (s *countServer) RoundTrip(r *http.Request) (*http.Response, error) {
+	s.count.Add(1)
+	time.Sleep(100 * time.Millisecond)
+	return &http.Response{
+		StatusCode: 200,
+		Body:       ioutil.NopCloser(bytes.NewBufferString(r.URL.Path)),
+		Header:     make(http.Header),
+	}, nil
+}
+
+func TestProxyGetter(t *testing.T) {
+	server := &countServer{}
+	c := NewProxyGetter(NewTransportGetter(Config{UserAgent: "test/1.0"}, server))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			body, err := c.GetBody("https://host/image.jpg")
+			if err != nil {
+				t.Error(err)
+			}
+			if string(body) != "/image.jpg" {
+				t.Error("expect body", string(body))
+			}
+		}()
+	}
+	wg.Wait()
+	if server.count.Load() != 1 {
+		t.Error("expect single fetch", server.count.Load())
+	}
+
+	body, err := c.GetBody("https://host/other.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "/other.jpg" || server.count.Load() != 2 {
+		t.Error("expect other fetch", string(body), server.count.Load())
+	}
+}
+
+func TestTimeLimiter(t *testing.T) {
+	l := &timeLimiter{interval: 50 * time.Millisecond}
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.RateLimit("host")
+		}()
+	}
+	wg.Wait()
+	if d := time.Since(start); d < 150*time.Millisecond {
+		t.Error("expect concurrent requests spaced out", d)
+	}
+
+	start = time.Now()
+	l.RateLimit("other")
+	if d := time.Since(start); d > 25*time.Millisecond {
+		t.Error("expect other host not limited", d)
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2023 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"net/http"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// proxyGetter is used to fetch on behalf of many concurrent requests.
+// Requests for the same url are collapsed into a single fetch. Fetches to
+// the same host are spaced out by the getter's rate limiter.
+type proxyGetter struct {
+	Getter
+	group singleflight.Group
+}
+
+type proxyResult struct {
+	header http.Header
+	body   []byte
+}
+
+func NewProxyGetter(getter Getter) Getter {
+	return &proxyGetter{Getter: getter}
+}
+
+func (p *proxyGetter) Get(urlStr string) (http.Header, []byte, error) {
+	v, err, _ := p.group.Do(urlStr, func() (interface{}, error) {
+		hdr, body, err := p.Getter.Get(urlStr)
+		return proxyResult{header: hdr, body: body}, err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	result := v.(proxyResult)
+	return result.header, result.body, nil
+}
+
+func (p *proxyGetter) GetBody(urlStr string) ([]byte, error) {
+	_, body, err := p.Get(urlStr)
+	return body, err
+}
@@ -138,3 +138,22 @@ func NewInterval(t time.Time, name string) DateRange {
 	}
 	return NewDateRange(start, end)
 }
+
+// NewReportInterval returns a year or month range for reports. Name is a
+// year (2024), a year and month (2024-03), or one of year, lastyear, month
+// and lastmonth relative to t. Dates are in the location of t.
+func NewReportInterval(t time.Time, name string) DateRange {
+	switch name {
+	case "year", "thisyear", "lastyear", "month", "thismonth", "lastmonth":
+		return NewInterval(t, name)
+	}
+	d, err := time.ParseInLocation("2006-01", name, t.Location())
+	if err == nil {
+		return NewDateRange(StartOfMonth(d), EndOfMonth(d))
+	}
+	d, err = time.ParseInLocation("2006", name, t.Location())
+	if err == nil {
+		return NewDateRange(StartOfYear(d), EndOfYear(d))
+	}
+	return DateRange{}
+}
@@ -145,6 +145,31 @@ func TestIsYear(t *testing.T) {
 	}
 }
 
+func TestNewReportInterval(t *testing.T) {
+	l := time.Now().Location()
+	now := time.Date(2024, time.June, 15, 10, 0, 0, 0, l)
+
+	d := NewReportInterval(now, "2023")
+	if d.IsYear() == false || d.Start.Year() != 2023 {
+		t.Error("expect year 2023", d)
+	}
+
+	d = NewReportInterval(now, "2023-02")
+	if d.IsMonth() == false || d.Start.Month() != time.February || d.DayCount() != 28 {
+		t.Error("expect feb 2023", d)
+	}
+
+	d = NewReportInterval(now, "lastyear")
+	if d.IsYear() == false || d.Start.Year() != 2023 {
+		t.Error("expect last year", d)
+	}
+
+	d = NewReportInterval(now, "yesterday")
+	if d.IsZero() == false {
+		t.Error("expect zero range", d)
+	}
+}
+
 func TestMonthCount(t *testing.T) {
 	l := time.Now().Location()
 
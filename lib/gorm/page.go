@@ -0,0 +1,60 @@
+// Copyright 2023 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package gorm
+
+import (
+	g "gorm.io/gorm"
+)
+
+// Cond is a where condition with its arguments.
+type Cond struct {
+	Query string
+	Args  []interface{}
+}
+
+// Page has the conditions, order and bounds used to query a page of rows. A
+// zero limit includes all rows.
+type Page struct {
+	Where  []Cond
+	Order  string
+	Offset int
+	Limit  int
+}
+
+// Find queries the page of rows into dest, which is a pointer to a slice of
+// models, and returns the total number of rows matching the conditions.
+func (p Page) Find(db *g.DB, dest interface{}) (int, error) {
+	tx := db.Model(dest)
+	for _, c := range p.Where {
+		tx = tx.Where(c.Query, c.Args...)
+	}
+	var total int64
+	if err := tx.Session(&g.Session{}).Count(&total).Error; err != nil {
+		return 0, err
+	}
+	if p.Order != "" {
+		tx = tx.Order(p.Order)
+	}
+	if p.Offset > 0 {
+		tx = tx.Offset(p.Offset)
+	}
+	if p.Limit > 0 {
+		tx = tx.Limit(p.Limit)
+	}
+	return int(total), tx.Find(dest).Error
+}
@@ -20,8 +20,15 @@ package hash // import "takeoutfm.dev/takeout/lib/hash"
 import (
 	"crypto/md5"
 	"encoding/hex"
+	"fmt"
 	"io"
 	"os"
+	"time"
+)
+
+const (
+	// Number of bytes read from the start and end of a file for Fingerprint.
+	FingerprintBlock = 64 * 1024
 )
 
 func MD5Hex(s string) string {
@@ -42,5 +49,37 @@ func MD5Sum(path string) (string, error) {
 	}
 
 	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Fingerprint is a cheap alternative to MD5Sum for large files. The result is
+// the MD5 of the size, modification time and up to FingerprintBlock bytes from
+// both the start and end of the file.
+func Fingerprint(path string, size int64, modTime time.Time) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := md5.New()
+	fmt.Fprintf(h, "%d:%d:", size, modTime.UnixNano())
+
+	if _, err := io.CopyN(h, f, FingerprintBlock); err != nil && err != io.EOF {
+		return "", err
+	}
+	if size > 2*FingerprintBlock {
+		if _, err := f.Seek(-FingerprintBlock, io.SeekEnd); err != nil {
+			return "", err
+		}
+		if _, err := io.CopyN(h, f, FingerprintBlock); err != nil && err != io.EOF {
+			return "", err
+		}
+	} else if size > FingerprintBlock {
+		// remaining bytes
+		if _, err := io.Copy(h, f); err != nil {
+			return "", err
+		}
+	}
 
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
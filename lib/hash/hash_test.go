@@ -18,7 +18,10 @@
 package hash // import "takeoutfm.dev/takeout/lib/hash"
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestHash(t *testing.T) {
@@ -27,3 +30,58 @@ func TestHash(t *testing.T) {
 		t.Error("wrong hash")
 	}
 }
+
+func TestFingerprint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.flac")
+	data := make([]byte, 3*FingerprintBlock)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	a, err := Fingerprint(path, int64(len(data)), mtime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Fingerprint(path, int64(len(data)), mtime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a != b {
+		t.Error("expect same fingerprint")
+	}
+
+	c, err := Fingerprint(path, int64(len(data)), mtime.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == c {
+		t.Error("expect different fingerprint with new mtime")
+	}
+
+	// change a byte in the middle which isn't part of the fingerprint
+	data[len(data)/2] = 1
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	d, err := Fingerprint(path, int64(len(data)), mtime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a != d {
+		t.Error("expect same fingerprint")
+	}
+
+	// change a byte at the end
+	data[len(data)-1] = 1
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	e, err := Fingerprint(path, int64(len(data)), mtime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == e {
+		t.Error("expect different fingerprint")
+	}
+}
@@ -15,19 +15,22 @@
 // You should have received a copy of the GNU Affero General Public License
 // along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
 
-package player // import "takeoutfm.dev/takeout/player"
+// Package icy supports reading SHOUTcast/Icecast streams with interleaved
+// ICY metadata.
+package icy // import "takeoutfm.dev/takeout/lib/icy"
 
 import (
 	"errors"
 	"io"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
 const (
-	maxMetadataLength = 1024
-	maxIntervalLength = 4 * 1024 * 1024
+	MaxMetadataLength = 1024
+	MaxInterval       = 4 * 1024 * 1024
 )
 
 var (
@@ -36,25 +39,27 @@ var (
 
 	metaRegexp = regexp.MustCompile(`^(\w+)=["'](.+)["']$`)
 
-	HeaderIcyBr          = http.CanonicalHeaderKey("Icy-Br")
-	HeaderIcyDescription = http.CanonicalHeaderKey("Icy-Description")
-	HeaderIcyGenre       = http.CanonicalHeaderKey("Icy-Genre")
-	HeaderIcyMetaData    = http.CanonicalHeaderKey("Icy-MetaData")
-	HeaderIcyMetaInt     = http.CanonicalHeaderKey("Icy-MetaInt")
-	HeaderIcyName        = http.CanonicalHeaderKey("Icy-Name")
-	HeaderIcyPublic      = http.CanonicalHeaderKey("Icy-Pub")
-	HeaderIcyUrl         = http.CanonicalHeaderKey("Icy-Url")
+	HeaderBr          = http.CanonicalHeaderKey("Icy-Br")
+	HeaderDescription = http.CanonicalHeaderKey("Icy-Description")
+	HeaderGenre       = http.CanonicalHeaderKey("Icy-Genre")
+	HeaderMetaData    = http.CanonicalHeaderKey("Icy-MetaData")
+	HeaderMetaInt     = http.CanonicalHeaderKey("Icy-MetaInt")
+	HeaderName        = http.CanonicalHeaderKey("Icy-Name")
+	HeaderPublic      = http.CanonicalHeaderKey("Icy-Pub")
+	HeaderUrl         = http.CanonicalHeaderKey("Icy-Url")
 )
 
-type Icy struct {
-	OnMetadata func(IcyMetadata)
+// Reader removes the metadata from the stream and reports each metadata
+// change using OnMetadata.
+type Reader struct {
+	OnMetadata func(Metadata)
 	reader     io.ReadCloser
 	interval   int
 	offset     int
 	metadata   []byte
 }
 
-type IcyHeaders struct {
+type Headers struct {
 	Bitrate     int
 	Description string
 	Genre       string
@@ -64,22 +69,42 @@ type IcyHeaders struct {
 	Url         string
 }
 
-type IcyMetadata struct {
+type Metadata struct {
 	StreamTitle string
 	StreamUrl   string
 }
 
-func NewIcyReader(interval int, reader io.ReadCloser, onMetaData func(IcyMetadata)) *Icy {
-	return &Icy{interval: interval, reader: reader, OnMetadata: onMetaData}
+// ParseHeaders returns the ICY headers from the stream response.
+func ParseHeaders(h http.Header) Headers {
+	return Headers{
+		Bitrate:     atoi(h.Get(HeaderBr)),
+		Description: h.Get(HeaderDescription),
+		Genre:       h.Get(HeaderGenre),
+		Interval:    atoi(h.Get(HeaderMetaInt)),
+		Name:        h.Get(HeaderName),
+		Public:      h.Get(HeaderPublic) == "1" || h.Get(HeaderPublic) == "true",
+		Url:         h.Get(HeaderUrl),
+	}
+}
+
+func atoi(s string) int {
+	v, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return 0
+	}
+	return v
+}
+
+func NewReader(interval int, reader io.ReadCloser, onMetaData func(Metadata)) *Reader {
+	return &Reader{interval: interval, reader: reader, OnMetadata: onMetaData}
 }
 
-func (icy *Icy) Read(p []byte) (n int, err error) {
+func (icy *Reader) Read(p []byte) (n int, err error) {
 	m := icy.interval - icy.offset
 	if len(p) > m {
-		n, err = io.ReadFull(icy.reader, p[:m])
-	} else {
-		n, err = icy.reader.Read(p)
+		p = p[:m]
 	}
+	n, err = icy.reader.Read(p)
 	if err != nil {
 		return
 	}
@@ -88,12 +113,12 @@ func (icy *Icy) Read(p []byte) (n int, err error) {
 		if icy.metadata == nil {
 			icy.metadata = make([]byte, 64)
 		}
-		_, err = icy.reader.Read(icy.metadata[:1])
+		_, err = io.ReadFull(icy.reader, icy.metadata[:1])
 		if err != nil {
 			return
 		}
 		l := int(icy.metadata[0]) * 16
-		if l > maxMetadataLength {
+		if l > MaxMetadataLength {
 			err = ErrInvalidMetadataLength
 			return
 		}
@@ -106,10 +131,10 @@ func (icy *Icy) Read(p []byte) (n int, err error) {
 			if err != nil {
 				return
 			}
-			var data IcyMetadata
+			var data Metadata
 			//StreamTitle='Joy Division - Ceremony';
 			//StreamTitle='The Stream Title';StreamUrl='https://example.com';
-			parts := strings.Split(string(icy.metadata), ";")
+			parts := strings.Split(strings.TrimRight(string(icy.metadata[:l]), "\x00"), ";")
 			for _, part := range parts {
 				matches := metaRegexp.FindStringSubmatch(part)
 				if matches != nil {
@@ -131,6 +156,6 @@ func (icy *Icy) Read(p []byte) (n int, err error) {
 	return n, err
 }
 
-func (icy *Icy) Close() error {
+func (icy *Reader) Close() error {
 	return icy.reader.Close()
 }
@@ -0,0 +1,77 @@
+// Copyright 2023 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package icy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"testing"
+)
+
+func metadataBlock(s string) []byte {
+	n := (len(s) + 15) / 16
+	block := make([]byte, 1+n*16)
+	block[0] = byte(n)
+	copy(block[1:], s)
+	return block
+}
+
+func TestReader(t *testing.T) {
+	var stream bytes.Buffer
+	stream.WriteString("abcdefgh")
+	stream.Write(metadataBlock("StreamTitle='Joy Division - Ceremony';"))
+	stream.WriteString("ijklmnop")
+	stream.Write([]byte{0})
+	stream.WriteString("qrstuvwx")
+	stream.Write(metadataBlock("StreamTitle='New Order - Ceremony';StreamUrl='https://example.com';"))
+	stream.WriteString("yz")
+
+	var titles []Metadata
+	r := NewReader(8, io.NopCloser(&stream), func(m Metadata) {
+		titles = append(titles, m)
+	})
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "abcdefghijklmnopqrstuvwxyz" {
+		t.Error("expect audio", string(data))
+	}
+	if len(titles) != 2 {
+		t.Fatal("expect 2 titles", titles)
+	}
+	if titles[0].StreamTitle != "Joy Division - Ceremony" || titles[0].StreamUrl != "" {
+		t.Error("expect first title", titles[0])
+	}
+	if titles[1].StreamTitle != "New Order - Ceremony" || titles[1].StreamUrl != "https://example.com" {
+		t.Error("expect second title", titles[1])
+	}
+}
+
+func TestParseHeaders(t *testing.T) {
+	h := make(http.Header)
+	h.Set("icy-br", "128")
+	h.Set("icy-metaint", "16000")
+	h.Set("icy-name", "Radio")
+	h.Set("icy-pub", "1")
+	headers := ParseHeaders(h)
+	if headers.Bitrate != 128 || headers.Interval != 16000 || headers.Name != "Radio" || !headers.Public {
+		t.Error("expect headers", headers)
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2024 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package lastfm // import "takeoutfm.dev/takeout/lib/lastfm"
+
+import (
+	"errors"
+	"time"
+
+	lfm "github.com/shkh/lastfm-go/lastfm"
+	"takeoutfm.dev/takeout/lib/client"
+)
+
+var (
+	ErrNotConfigured = errors.New("lastfm key and secret not configured")
+)
+
+// Scrobble is a track listen submitted to a user's Last.fm account.
+type Scrobble struct {
+	Artist      string
+	Track       string
+	Album       string
+	AlbumArtist string
+	TrackNumber int
+	Duration    int // seconds
+	MBID        string
+	Timestamp   time.Time
+}
+
+func (s Scrobble) params() lfm.P {
+	p := lfm.P{
+		"artist": s.Artist,
+		"track":  s.Track,
+	}
+	if s.Album != "" {
+		p["album"] = s.Album
+	}
+	if s.AlbumArtist != "" {
+		p["albumArtist"] = s.AlbumArtist
+	}
+	if s.TrackNumber > 0 {
+		p["trackNumber"] = s.TrackNumber
+	}
+	if s.Duration > 0 {
+		p["duration"] = s.Duration
+	}
+	if s.MBID != "" {
+		p["mbid"] = s.MBID
+	}
+	return p
+}
+
+func (m *Lastfm) api(session string) (*lfm.Api, error) {
+	if m.config.Key == "" || m.config.Secret == "" {
+		return nil, ErrNotConfigured
+	}
+	client.DefaultLimiter.RateLimit("last.fm")
+	api := lfm.New(m.config.Key, m.config.Secret)
+	if session != "" {
+		api.SetSession(session)
+	}
+	return api, nil
+}
+
+// MobileSession authenticates the Last.fm user and returns a session key
+// that can be used for future scrobbles. The password isn't retained.
+func (m *Lastfm) MobileSession(user, password string) (string, error) {
+	api, err := m.api("")
+	if err != nil {
+		return "", err
+	}
+	err = api.Login(user, password)
+	if err != nil {
+		return "", err
+	}
+	return api.GetSessionKey(), nil
+}
+
+// Scrobble submits a listen for the session user.
+func (m *Lastfm) Scrobble(session string, s Scrobble) error {
+	api, err := m.api(session)
+	if err != nil {
+		return err
+	}
+	p := s.params()
+	p["timestamp"] = s.Timestamp.Unix()
+	// ignored scrobbles are rejected by Last.fm and not worth retrying
+	_, err = api.Track.Scrobble(p)
+	return err
+}
+
+// NowPlaying updates the track currently playing for the session user.
+func (m *Lastfm) NowPlaying(session string, s Scrobble) error {
+	api, err := m.api(session)
+	if err != nil {
+		return err
+	}
+	_, err = api.Track.UpdateNowPlaying(s.params())
+	return err
+}
@@ -21,13 +21,19 @@ import (
 	"takeoutfm.dev/takeout/lib/client"
 )
 
+const (
+	apiURL = "https://api.listenbrainz.org"
+)
+
 type ListenBrainz struct {
 	client client.Getter
+	url    string
 }
 
 func NewListenBrainz(client client.Getter) *ListenBrainz {
 	return &ListenBrainz{
 		client: client,
+		url:    apiURL,
 	}
 }
 
@@ -63,7 +69,7 @@ func (l *ListenBrainz) ArtistTopTracks(arid string) ([]TopTrack, error) {
 
 	client.DefaultLimiter.RateLimit("listenbrainz.org")
 
-	url := "https://api.listenbrainz.org/1/popularity/top-recordings-for-artist/" + arid
+	url := l.url + "/1/popularity/top-recordings-for-artist/" + arid
 	err := l.client.GetJson(url, &results)
 	if err != nil {
 		return nil, err
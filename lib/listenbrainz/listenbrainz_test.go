@@ -18,6 +18,9 @@
 package listenbrainz // import "takeoutfm.dev/takeout/lib/listenbrainz"
 
 import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"takeoutfm.dev/takeout/lib/client"
@@ -34,3 +37,59 @@ func TestArtistTopTracks(t *testing.T) {
 		t.Log(track.Rank(), track.Track())
 	}
 }
+
+func TestSubmitListens(t *testing.T) {
+	var got submission
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Token secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/1/submit-listens":
+			json.NewDecoder(r.Body).Decode(&got)
+			w.Write([]byte(`{"status":"ok"}`))
+		case "/1/validate-token":
+			w.Write([]byte(`{"valid":true,"user_name":"test"}`))
+		}
+	}))
+	defer server.Close()
+
+	l := NewListenBrainz(client.NewDefaultGetter())
+	l.url = server.URL
+
+	listens := []Listen{{
+		ListenedAt: 1700000000,
+		TrackMetadata: TrackMetadata{
+			ArtistName: "Gary Numan",
+			TrackName:  "Cars",
+			AdditionalInfo: AdditionalInfo{
+				RecordingMBID: "cc8ebd10-0c05-4a1d-9d51-8a0ee0c9e1a5",
+			},
+		},
+	}}
+	err := l.SubmitListens("secret", ListenTypeSingle, listens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ListenType != ListenTypeSingle || len(got.Payload) != 1 {
+		t.Fatal("expect single listen")
+	}
+	info := got.Payload[0].TrackMetadata.AdditionalInfo
+	if info.RecordingMBID == "" || info.SubmissionClient != SubmissionClient {
+		t.Error("expect additional info")
+	}
+
+	user, err := l.ValidateToken("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user != "test" {
+		t.Errorf("expect user name got %s", user)
+	}
+
+	_, err = l.ValidateToken("wrong")
+	if err != ErrInvalidToken {
+		t.Error("expect invalid token")
+	}
+}
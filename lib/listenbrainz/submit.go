@@ -0,0 +1,132 @@
+// Copyright 2024 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package listenbrainz // import "takeoutfm.dev/takeout/lib/listenbrainz"
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"takeoutfm.dev/takeout/lib/client"
+)
+
+const (
+	ListenTypeSingle     = "single"
+	ListenTypePlayingNow = "playing_now"
+	ListenTypeImport     = "import"
+
+	SubmissionClient = "TakeoutFM"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid listenbrainz token")
+)
+
+type AdditionalInfo struct {
+	RecordingMBID    string `json:"recording_mbid,omitempty"`
+	ReleaseMBID      string `json:"release_mbid,omitempty"`
+	ReleaseGroupMBID string `json:"release_group_mbid,omitempty"`
+	TrackNumber      int    `json:"tracknumber,omitempty"`
+	DurationMs       int    `json:"duration_ms,omitempty"`
+	SubmissionClient string `json:"submission_client,omitempty"`
+}
+
+type TrackMetadata struct {
+	ArtistName     string         `json:"artist_name"`
+	TrackName      string         `json:"track_name"`
+	ReleaseName    string         `json:"release_name,omitempty"`
+	AdditionalInfo AdditionalInfo `json:"additional_info"`
+}
+
+type Listen struct {
+	ListenedAt    int64         `json:"listened_at,omitempty"`
+	TrackMetadata TrackMetadata `json:"track_metadata"`
+}
+
+type submission struct {
+	ListenType string   `json:"listen_type"`
+	Payload    []Listen `json:"payload"`
+}
+
+type validation struct {
+	Valid    bool   `json:"valid"`
+	UserName string `json:"user_name"`
+}
+
+// do sends requests without the response cache used by the client getter.
+func (l *ListenBrainz) do(method, token, path string, body io.Reader, result interface{}) error {
+	client.DefaultLimiter.RateLimit("listenbrainz.org")
+
+	req, err := http.NewRequest(method, l.url+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Token "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	c := http.Client{Timeout: 30 * time.Second}
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return ErrInvalidToken
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("listenbrainz %s: %s", path, resp.Status)
+	}
+	if result != nil {
+		return json.NewDecoder(resp.Body).Decode(result)
+	}
+	return nil
+}
+
+// SubmitListens submits listens for the user with the token. Use
+// ListenTypeSingle for one listen, ListenTypeImport for many, and
+// ListenTypePlayingNow without a listen time for the current track.
+func (l *ListenBrainz) SubmitListens(token, listenType string, listens []Listen) error {
+	for i := range listens {
+		listens[i].TrackMetadata.AdditionalInfo.SubmissionClient = SubmissionClient
+	}
+	data, err := json.Marshal(submission{ListenType: listenType, Payload: listens})
+	if err != nil {
+		return err
+	}
+	return l.do(http.MethodPost, token, "/1/submit-listens", bytes.NewReader(data), nil)
+}
+
+// ValidateToken checks the user token and returns the ListenBrainz user name.
+func (l *ListenBrainz) ValidateToken(token string) (string, error) {
+	var result validation
+	err := l.do(http.MethodGet, token, "/1/validate-token", nil, &result)
+	if err != nil {
+		return "", err
+	}
+	if !result.Valid {
+		return "", ErrInvalidToken
+	}
+	return result.UserName, nil
+}
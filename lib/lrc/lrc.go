@@ -0,0 +1,152 @@
+// Copyright 2023 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package lrc provides support for lyrics in the LRC format with optional
+// line timestamps, as well as plain unsynced lyrics.
+package lrc // import "takeoutfm.dev/takeout/lib/lrc"
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Line struct {
+	Time time.Duration
+	Text string
+}
+
+type Lyrics struct {
+	Synced bool
+	Tags   map[string]string
+	Lines  []Line
+}
+
+var (
+	// [mm:ss], [mm:ss.xx] or [mm:ss:xx]
+	timeRegexp = regexp.MustCompile(`^\[(\d+):(\d{1,2})(?:[.:](\d{1,3}))?\]`)
+	// [ar:Artist], [offset:+250], etc.
+	tagRegexp = regexp.MustCompile(`^\[([a-zA-Z#]+):(.*)\]\s*$`)
+	// enhanced word timing <mm:ss.xx>
+	wordRegexp = regexp.MustCompile(`<\d+:\d{1,2}(?:[.:]\d{1,3})?>`)
+)
+
+func parse(in string) Lyrics {
+	return Parse(strings.NewReader(in))
+}
+
+// Parse reads LRC or plain text lyrics. Lines with multiple timestamps are
+// repeated for each time and the offset tag is applied. Lyrics without any
+// timestamps are returned unsynced with all lines.
+func Parse(in io.Reader) Lyrics {
+	scanner := bufio.NewScanner(in)
+
+	lyrics := Lyrics{Tags: make(map[string]string)}
+	var plain []Line
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if matches := tagRegexp.FindStringSubmatch(line); matches != nil &&
+			!timeRegexp.MatchString(line) {
+			lyrics.Tags[strings.ToLower(matches[1])] = strings.TrimSpace(matches[2])
+			continue
+		}
+
+		var times []time.Duration
+		for {
+			matches := timeRegexp.FindStringSubmatch(line)
+			if matches == nil {
+				break
+			}
+			times = append(times, timestamp(matches[1], matches[2], matches[3]))
+			line = line[len(matches[0]):]
+		}
+		text := strings.TrimSpace(wordRegexp.ReplaceAllString(line, ""))
+		if len(times) == 0 {
+			plain = append(plain, Line{Text: text})
+			continue
+		}
+		for _, t := range times {
+			lyrics.Lines = append(lyrics.Lines, Line{Time: t, Text: text})
+		}
+	}
+
+	if len(lyrics.Lines) == 0 {
+		lyrics.Lines = trimBlank(plain)
+		return lyrics
+	}
+
+	lyrics.Synced = true
+	offset, _ := strconv.Atoi(lyrics.Tags["offset"])
+	for i := range lyrics.Lines {
+		// positive offset shows lines sooner
+		t := lyrics.Lines[i].Time - time.Duration(offset)*time.Millisecond
+		lyrics.Lines[i].Time = max(t, 0)
+	}
+	sort.SliceStable(lyrics.Lines, func(i, j int) bool {
+		return lyrics.Lines[i].Time < lyrics.Lines[j].Time
+	})
+	return lyrics
+}
+
+func timestamp(min, sec, frac string) time.Duration {
+	m, _ := strconv.Atoi(min)
+	s, _ := strconv.Atoi(sec)
+	d := time.Duration(m)*time.Minute + time.Duration(s)*time.Second
+	if frac != "" {
+		f, _ := strconv.Atoi(frac)
+		// xx is hundredths and xxx is milliseconds
+		for i := len(frac); i < 3; i++ {
+			f *= 10
+		}
+		d += time.Duration(f) * time.Millisecond
+	}
+	return d
+}
+
+func trimBlank(lines []Line) []Line {
+	for len(lines) > 0 && lines[0].Text == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && lines[len(lines)-1].Text == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Text returns the lyrics text without timestamps.
+func (l Lyrics) Text() string {
+	var lines []string
+	for _, line := range l.Lines {
+		lines = append(lines, line.Text)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Index returns the index of the line at the position, or -1 for unsynced
+// lyrics or a position before the first line.
+func (l Lyrics) Index(pos time.Duration) int {
+	if !l.Synced {
+		return -1
+	}
+	return sort.Search(len(l.Lines), func(i int) bool {
+		return l.Lines[i].Time > pos
+	}) - 1
+}
@@ -0,0 +1,81 @@
+// Copyright 2023 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package lrc // import "takeoutfm.dev/takeout/lib/lrc"
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSynced(t *testing.T) {
+	data := `[ar:Gary Numan]
+[ti:Cars]
+[offset:+500]
+[00:12.00]Here in my car
+[00:15.50][01:15.50]I feel safest of all
+[00:19.123]<00:19.12>I can <00:20.00>lock all my doors
+
+[00:25]
+`
+	lyrics := parse(data)
+	if !lyrics.Synced {
+		t.Fatal("expect synced")
+	}
+	if lyrics.Tags["ar"] != "Gary Numan" || lyrics.Tags["ti"] != "Cars" {
+		t.Error("expect tags", lyrics.Tags)
+	}
+	if len(lyrics.Lines) != 5 {
+		t.Fatal("expect 5 lines", lyrics.Lines)
+	}
+	expect := []Line{
+		{11500 * time.Millisecond, "Here in my car"},
+		{15 * time.Second, "I feel safest of all"},
+		{18623 * time.Millisecond, "I can lock all my doors"},
+		{24500 * time.Millisecond, ""},
+		{75 * time.Second, "I feel safest of all"},
+	}
+	for i, line := range expect {
+		if lyrics.Lines[i] != line {
+			t.Error("expect line", i, line, lyrics.Lines[i])
+		}
+	}
+
+	if lyrics.Index(5*time.Second) != -1 {
+		t.Error("expect no line")
+	}
+	if lyrics.Index(16*time.Second) != 1 {
+		t.Error("expect line 1")
+	}
+	if lyrics.Index(10*time.Minute) != 4 {
+		t.Error("expect last line")
+	}
+}
+
+func TestParsePlain(t *testing.T) {
+	data := "\nHere in my car\r\nI feel safest of all\n\n"
+	lyrics := parse(data)
+	if lyrics.Synced {
+		t.Error("expect unsynced")
+	}
+	if lyrics.Text() != "Here in my car\nI feel safest of all" {
+		t.Error("expect text", lyrics.Text())
+	}
+	if lyrics.Index(time.Minute) != -1 {
+		t.Error("expect no index")
+	}
+}
@@ -518,7 +518,7 @@ type SearchResult struct {
 func (m *MusicBrainz) SearchReleaseGroup(arid string, name string) (SearchResult, error) {
 	url := fmt.Sprintf(
 		`https://musicbrainz.org/ws/2/release-group/?fmt=json&query=arid:%s+AND+release:"%s"`,
-		arid, url.QueryEscape(name))
+		arid, url.QueryEscape(EscapeTerm(name)))
 	var result SearchResult
 	err := m.client.GetJson(url, &result)
 	return result, err
@@ -722,6 +722,18 @@ func (m *MusicBrainz) SearchRecordings(query string) ([]Recording, error) {
 	return recordings, nil
 }
 
+var termReplacer = strings.NewReplacer(
+	`\`, `\\`, `+`, `\+`, `-`, `\-`, `&`, `\&`, `|`, `\|`, `!`, `\!`,
+	`(`, `\(`, `)`, `\)`, `{`, `\{`, `}`, `\}`, `[`, `\[`, `]`, `\]`,
+	`^`, `\^`, `"`, `\"`, `~`, `\~`, `*`, `\*`, `?`, `\?`, `:`, `\:`,
+	`/`, `\/`)
+
+// EscapeTerm escapes Lucene special characters and quotes so the term can be
+// safely used within a search query such as artist:"%s".
+func EscapeTerm(s string) string {
+	return termReplacer.Replace(s)
+}
+
 func queryEscape(s string) string {
 	s = url.QueryEscape(s)
 	// s = strings.ReplaceAll(s, "'", "%27")
@@ -249,3 +249,19 @@ func TestCoverArtArchive(t *testing.T) {
 	}
 
 }
+
+func TestEscapeTerm(t *testing.T) {
+	tests := map[string]string{
+		`Help Us Stranger`:    `Help Us Stranger`,
+		`AC/DC`:               `AC\/DC`,
+		`"Heroes"`:            `\"Heroes\"`,
+		`What's Up? (Remix)`:  `What's Up\? \(Remix\)`,
+		`Re: A\B`:             `Re\: A\\B`,
+		`Love + Hate - Other`: `Love \+ Hate \- Other`,
+	}
+	for in, out := range tests {
+		if got := EscapeTerm(in); got != out {
+			t.Errorf("%s: expect %s got %s", in, out, got)
+		}
+	}
+}
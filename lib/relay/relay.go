@@ -0,0 +1,455 @@
+// Copyright 2023 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package relay connects once to an upstream internet radio stream and fans
+// out the audio to any number of listeners. ICY metadata is removed from the
+// audio and kept as the now playing history.
+package relay // import "takeoutfm.dev/takeout/lib/relay"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"takeoutfm.dev/takeout/lib/header"
+	"takeoutfm.dev/takeout/lib/icy"
+	"takeoutfm.dev/takeout/lib/log"
+)
+
+const (
+	DefaultBufferSize   = 64
+	DefaultIdleTimeout  = 10 * time.Second
+	DefaultHistoryLimit = 10
+
+	chunkSize      = 16 * 1024
+	connectTimeout = 10 * time.Second
+)
+
+var (
+	ErrClosed         = errors.New("stream closed")
+	ErrTooSlow        = errors.New("listener too slow")
+	ErrPrivateAddress = errors.New("private address not allowed")
+)
+
+type Config struct {
+	Enabled      bool
+	BufferSize   int           // chunks queued for each listener
+	IdleTimeout  time.Duration // upstream is kept open after the last listener
+	HistoryLimit int           // now playing titles kept for each stream
+	MaxRecording time.Duration
+	AllowPrivate bool // allow upstreams on loopback, private or link-local addresses
+}
+
+// Title is a now playing title from the stream metadata.
+type Title struct {
+	Title string
+	URL   string `json:",omitempty"`
+	Time  time.Time
+}
+
+// Relays has a stream for each upstream URL.
+type Relays struct {
+	config    Config
+	userAgent string
+	client    *http.Client
+	mu        sync.Mutex
+	streams   map[string]*Stream
+}
+
+func New(config Config, userAgent string) *Relays {
+	if config.BufferSize <= 0 {
+		config.BufferSize = DefaultBufferSize
+	}
+	if config.IdleTimeout <= 0 {
+		config.IdleTimeout = DefaultIdleTimeout
+	}
+	if config.HistoryLimit <= 0 {
+		config.HistoryLimit = DefaultHistoryLimit
+	}
+	// no overall client timeout since streams don't end
+	dialer := &net.Dialer{Timeout: connectTimeout}
+	if !config.AllowPrivate {
+		// checked when connecting so redirects, playlist entries and
+		// DNS changes can't reach private services
+		dialer.Control = publicControl
+	}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   connectTimeout,
+		ResponseHeaderTimeout: connectTimeout,
+	}
+	return &Relays{
+		config:    config,
+		userAgent: userAgent,
+		client:    &http.Client{Transport: transport},
+		streams:   make(map[string]*Stream),
+	}
+}
+
+// PublicIP is true if the address isn't loopback, private, unspecified or
+// link-local.
+func PublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast())
+}
+
+// publicControl rejects connections to addresses that aren't public.
+func publicControl(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !PublicIP(ip) {
+		return ErrPrivateAddress
+	}
+	return nil
+}
+
+func (r *Relays) Config() Config {
+	return r.config
+}
+
+// Stream returns the stream for the upstream URL. Streams only connect
+// upstream while there are listeners.
+func (r *Relays) Stream(url string) *Stream {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.prune()
+	s, ok := r.streams[url]
+	if !ok {
+		s = &Stream{relays: r, url: url, listeners: make(map[*Listener]bool)}
+		s.cond = sync.NewCond(&s.mu)
+		r.streams[url] = s
+	}
+	s.used = time.Now()
+	return s
+}
+
+// Streams returns the number of streams being tracked.
+func (r *Relays) Streams() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.streams)
+}
+
+// prune removes streams that have been idle longer than the idle timeout.
+// This must be called with the lock held.
+func (r *Relays) prune() {
+	for url, s := range r.streams {
+		if time.Since(s.used) > r.config.IdleTimeout && s.idleDisconnected() {
+			delete(r.streams, url)
+		}
+	}
+}
+
+type Stream struct {
+	relays    *Relays
+	url       string
+	used      time.Time // guarded by the relays lock
+	mu        sync.Mutex
+	cond      *sync.Cond // signaled when connecting is done
+	listeners map[*Listener]bool
+	header    http.Header
+	cancel    context.CancelFunc
+	gen       int
+	idle      *time.Timer
+	history   []Title
+
+	connecting bool
+	attempt    int
+	err        error // result of the last connect attempt
+}
+
+// Listener receives audio chunks from C until the stream ends or the
+// listener is closed. Header has the content headers for the stream.
+type Listener struct {
+	C      <-chan []byte
+	Header http.Header
+	stream *Stream
+	ch     chan []byte
+	err    error // set before ch is closed
+}
+
+// Listen adds a listener and connects upstream if needed. Only one
+// connection is attempted at a time and other listeners wait for the result.
+func (s *Stream) Listen() (*Listener, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.connecting {
+		attempt := s.attempt
+		for s.connecting {
+			s.cond.Wait()
+		}
+		if s.attempt != attempt && s.err != nil {
+			return nil, s.err
+		}
+	}
+	if s.cancel == nil {
+		s.connecting = true
+		s.attempt++
+		s.mu.Unlock()
+		resp, cancel, err := s.connect()
+		s.mu.Lock()
+		s.connecting = false
+		s.err = err
+		s.cond.Broadcast()
+		if err != nil {
+			return nil, err
+		}
+		s.start(resp, cancel)
+	}
+	if s.idle != nil {
+		s.idle.Stop()
+		s.idle = nil
+	}
+	ch := make(chan []byte, s.relays.config.BufferSize)
+	l := &Listener{C: ch, Header: s.header.Clone(), stream: s, ch: ch}
+	s.listeners[l] = true
+	return l, nil
+}
+
+// Close removes the listener. The upstream connection is closed after the
+// idle timeout when there are no more listeners.
+func (l *Listener) Close() {
+	s := l.stream
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(l)
+	if len(s.listeners) == 0 && s.cancel != nil && s.idle == nil {
+		gen := s.gen
+		s.idle = time.AfterFunc(s.relays.config.IdleTimeout, func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if len(s.listeners) == 0 && s.gen == gen && s.cancel != nil {
+				s.cancel()
+				s.cancel = nil
+			}
+			s.idle = nil
+		})
+	}
+}
+
+// WriteTo writes the audio to w until the stream ends or there's a write
+// error. ErrTooSlow is returned if the listener was removed for falling
+// behind.
+func (l *Listener) WriteTo(w io.Writer) (int64, error) {
+	var total int64
+	for chunk := range l.C {
+		n, err := w.Write(chunk)
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+	if l.err != nil {
+		return total, l.err
+	}
+	return total, ErrClosed
+}
+
+// remove must be called with the lock held.
+func (s *Stream) remove(l *Listener) {
+	if s.listeners[l] {
+		delete(s.listeners, l)
+		close(l.ch)
+	}
+}
+
+// Record listens to the stream for the duration in the background and then
+// passes the audio to put. The recording ends early if the stream ends. The
+// stream headers are returned once recording has started.
+func (s *Stream) Record(d time.Duration, put func(r io.Reader, contentType string) error) (http.Header, error) {
+	l, err := s.Listen()
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		err := record(l, d, put)
+		if err != nil {
+			log.Printf("record %s: %s\n", s.url, err)
+		}
+	}()
+	return l.Header, nil
+}
+
+// record spools the audio to a temporary file so a slow put can't make the
+// listener fall behind and be removed. Nothing is put if the recording fails.
+func record(l *Listener, d time.Duration, put func(r io.Reader, contentType string) error) error {
+	timer := time.AfterFunc(d, l.Close)
+	defer timer.Stop()
+	defer l.Close()
+
+	f, err := os.CreateTemp("", "takeout-recording-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	_, err = l.WriteTo(f)
+	if err != ErrClosed {
+		return err
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	return put(f, l.Header.Get(header.ContentType))
+}
+
+// NowPlaying returns the recent titles with the current title first.
+func (s *Stream) NowPlaying() []Title {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Title{}, s.history...)
+}
+
+// Listeners returns the number of current listeners.
+func (s *Stream) Listeners() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.listeners)
+}
+
+// idleDisconnected is true if the stream has no listeners and isn't
+// connected upstream.
+func (s *Stream) idleDisconnected() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.listeners) == 0 && s.cancel == nil && !s.connecting
+}
+
+// connect makes the upstream request and must be called without the lock
+// held.
+func (s *Stream) connect() (*http.Response, context.CancelFunc, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	req.Header.Set(header.UserAgent, s.relays.userAgent)
+	req.Header.Set(icy.HeaderMetaData, "1")
+	resp, err := s.relays.client.Do(req)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		cancel()
+		return nil, nil, fmt.Errorf("http error %d: %s", resp.StatusCode, s.url)
+	}
+	return resp, cancel, nil
+}
+
+// start reads from the upstream response and must be called with the lock
+// held.
+func (s *Stream) start(resp *http.Response, cancel context.CancelFunc) {
+	// metadata is removed so only pass on the descriptive headers
+	s.header = make(http.Header)
+	for _, k := range []string{header.ContentType, icy.HeaderBr, icy.HeaderName,
+		icy.HeaderGenre, icy.HeaderDescription, icy.HeaderUrl} {
+		if v := resp.Header.Get(k); v != "" {
+			s.header.Set(k, v)
+		}
+	}
+
+	var reader io.ReadCloser = resp.Body
+	headers := icy.ParseHeaders(resp.Header)
+	if headers.Interval > 0 && headers.Interval <= icy.MaxInterval {
+		reader = icy.NewReader(headers.Interval, resp.Body, s.onMetadata)
+	}
+
+	s.gen++
+	s.cancel = cancel
+	go s.run(s.gen, reader)
+}
+
+func (s *Stream) run(gen int, reader io.ReadCloser) {
+	defer reader.Close()
+	for {
+		buf := make([]byte, chunkSize)
+		n, err := reader.Read(buf)
+		if n > 0 {
+			s.broadcast(gen, buf[:n])
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	// upstream ended so end all listeners
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.gen == gen {
+		for l := range s.listeners {
+			s.remove(l)
+		}
+		if s.cancel != nil {
+			s.cancel()
+			s.cancel = nil
+		}
+	}
+}
+
+// broadcast sends the chunk to all listeners. Listeners that can't keep up
+// are removed rather than slowing down everyone else.
+func (s *Stream) broadcast(gen int, chunk []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.gen != gen {
+		return
+	}
+	for l := range s.listeners {
+		select {
+		case l.ch <- chunk:
+		default:
+			l.err = ErrTooSlow
+			s.remove(l)
+		}
+	}
+}
+
+func (s *Stream) onMetadata(m icy.Metadata) {
+	if m.StreamTitle == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.history) > 0 && s.history[0].Title == m.StreamTitle {
+		return
+	}
+	title := Title{Title: m.StreamTitle, URL: m.StreamUrl, Time: time.Now()}
+	s.history = append([]Title{title}, s.history...)
+	if len(s.history) > s.relays.config.HistoryLimit {
+		s.history = s.history[:s.relays.config.HistoryLimit]
+	}
+}
@@ -0,0 +1,269 @@
+// Copyright 2023 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package relay
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRelay(t *testing.T) {
+	var connections atomic.Int32
+	release := make(chan bool)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		connections.Add(1)
+		if r.Header.Get("Icy-MetaData") != "1" {
+			t.Error("expect metadata request")
+		}
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.Header().Set("Icy-Name", "Test Radio")
+		w.Header().Set("Icy-Metaint", "4")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-release
+		title := "StreamTitle='Joy Division - Atmosphere';"
+		block := make([]byte, 1+48)
+		block[0] = 3
+		copy(block[1:], title)
+		w.Write([]byte("abcd"))
+		w.Write(block)
+		w.Write([]byte("efgh"))
+	}))
+	defer upstream.Close()
+
+	relays := New(Config{IdleTimeout: time.Millisecond, AllowPrivate: true}, "test")
+	stream := relays.Stream(upstream.URL)
+	if relays.Stream(upstream.URL) != stream {
+		t.Fatal("expect same stream")
+	}
+
+	var listeners []*Listener
+	for range 2 {
+		l, err := stream.Listen()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if l.Header.Get("Content-Type") != "audio/mpeg" ||
+			l.Header.Get("Icy-Name") != "Test Radio" {
+			t.Error("expect headers", l.Header)
+		}
+		if l.Header.Get("Icy-Metaint") != "" {
+			t.Error("expect no metaint header")
+		}
+		listeners = append(listeners, l)
+	}
+	if stream.Listeners() != 2 {
+		t.Error("expect 2 listeners")
+	}
+	close(release)
+
+	var wg sync.WaitGroup
+	results := make([]bytes.Buffer, len(listeners))
+	for i, l := range listeners {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.WriteTo(&results[i])
+			l.Close()
+		}()
+	}
+	wg.Wait()
+
+	for _, b := range results {
+		if b.String() != "abcdefgh" {
+			t.Error("expect audio", b.String())
+		}
+	}
+	if connections.Load() != 1 {
+		t.Error("expect one upstream connection", connections.Load())
+	}
+	titles := stream.NowPlaying()
+	if len(titles) != 1 || titles[0].Title != "Joy Division - Atmosphere" {
+		t.Error("expect title", titles)
+	}
+	if stream.Listeners() != 0 {
+		t.Error("expect no listeners")
+	}
+}
+
+func TestRelayError(t *testing.T) {
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	defer upstream.Close()
+	relays := New(Config{AllowPrivate: true}, "test")
+	_, err := relays.Stream(upstream.URL).Listen()
+	if err == nil {
+		t.Error("expect error")
+	}
+}
+
+func TestRelayPrivate(t *testing.T) {
+	called := false
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer upstream.Close()
+	relays := New(Config{}, "test")
+	_, err := relays.Stream(upstream.URL).Listen()
+	if !errors.Is(err, ErrPrivateAddress) {
+		t.Error("expect private address error", err)
+	}
+	if called {
+		t.Error("expect no upstream request")
+	}
+}
+
+func TestRecord(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/aac")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("recorded audio"))
+	}))
+	defer upstream.Close()
+
+	relays := New(Config{AllowPrivate: true}, "test")
+	done := make(chan string)
+	hdr, err := relays.Stream(upstream.URL).Record(time.Minute, func(r io.Reader, contentType string) error {
+		data, err := io.ReadAll(r)
+		done <- contentType + " " + string(data)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Get("Content-Type") != "audio/aac" {
+		t.Error("expect content type", hdr)
+	}
+	result := <-done
+	if result != "audio/aac recorded audio" {
+		t.Error("expect recording", result)
+	}
+}
+
+func TestRecordSlowPut(t *testing.T) {
+	chunk := strings.Repeat("x", 1024)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/aac")
+		w.WriteHeader(http.StatusOK)
+		for range 200 {
+			w.Write([]byte(chunk))
+			w.(http.Flusher).Flush()
+			time.Sleep(time.Millisecond)
+		}
+	}))
+	defer upstream.Close()
+
+	relays := New(Config{AllowPrivate: true, BufferSize: 4}, "test")
+	done := make(chan int)
+	_, err := relays.Stream(upstream.URL).Record(time.Minute, func(r io.Reader, contentType string) error {
+		// slower than the upstream
+		time.Sleep(100 * time.Millisecond)
+		data, err := io.ReadAll(r)
+		done <- len(data)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := <-done; n != 200*len(chunk) {
+		t.Error("expect complete recording", n)
+	}
+}
+
+func TestRelayConnecting(t *testing.T) {
+	var connections atomic.Int32
+	connected := make(chan bool)
+	release := make(chan bool)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if connections.Add(1) == 1 {
+			connected <- true
+		}
+		<-release
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer upstream.Close()
+
+	relays := New(Config{IdleTimeout: time.Millisecond, AllowPrivate: true}, "test")
+	stream := relays.Stream(upstream.URL)
+
+	var wg sync.WaitGroup
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l, err := stream.Listen()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			l.Close()
+		}()
+	}
+	<-connected
+
+	// the stream is usable while connecting upstream
+	done := make(chan bool)
+	go func() {
+		stream.NowPlaying()
+		stream.Listeners()
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("expect no blocking while connecting")
+	}
+	close(release)
+	wg.Wait()
+
+	if connections.Load() != 1 {
+		t.Error("expect one upstream connection", connections.Load())
+	}
+}
+
+func TestRelayPrune(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("audio"))
+	}))
+	defer upstream.Close()
+
+	relays := New(Config{IdleTimeout: time.Millisecond, AllowPrivate: true}, "test")
+	l, err := relays.Stream(upstream.URL).Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.WriteTo(io.Discard)
+	l.Close()
+	time.Sleep(10 * time.Millisecond)
+
+	relays.Stream(upstream.URL + "/other")
+	if relays.Streams() != 1 {
+		t.Error("expect idle stream removed", relays.Streams())
+	}
+}
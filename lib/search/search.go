@@ -20,10 +20,28 @@
 package search // import "takeoutfm.dev/takeout/lib/search"
 
 import (
-	"github.com/blevesearch/bleve/v2"
-	"github.com/blevesearch/bleve/v2/analysis/analyzer/keyword"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/custom"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/v2/analysis/char/asciifolding"
+	"github.com/blevesearch/bleve/v2/analysis/lang/en"
+	"github.com/blevesearch/bleve/v2/analysis/token/edgengram"
+	"github.com/blevesearch/bleve/v2/analysis/token/lowercase"
+	"github.com/blevesearch/bleve/v2/analysis/tokenizer/unicode"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/search/query"
 )
 
 type FieldMap map[string]interface{}
@@ -33,77 +51,673 @@ type Config struct {
 	IndexDir string
 }
 
+// Mapping lists the fields indexed differently from the default text
+// mapping. Keywords are fields where only exact matches are wanted. Facets
+// are text fields also indexed as keywords so their full values can be
+// counted and filtered. Suggest fields are facets also indexed as edge
+// ngrams for prefix matches.
+type Mapping struct {
+	Keywords []string
+	Facets   []string
+	Suggest  []string
+}
+
 type Searcher interface {
-	Open(name string, keywords []string) error
+	Open(name string, m Mapping) error
 	Index(m IndexMap)
 	Search(q string, limit int) ([]string, error)
+	SearchRequest(r Request) (Result, error)
+	Suggest(prefix string, limit int, fields ...string) ([]string, error)
 	Delete(keys []string) error
 	Close()
 }
 
+const (
+	// FacetDecade is the facet name used for dates counted by decade.
+	FacetDecade = "decade"
+
+	// DefaultFacetSize is the number of terms counted when a facet has no
+	// size.
+	DefaultFacetSize = 10
+
+	// facetSuffix names the keyword copy of a text field used for facets
+	// and filters.
+	facetSuffix = "_facet"
+
+	// suggestSuffix names the edge ngram copy of a text field used for
+	// prefix matches.
+	suggestSuffix        = "_suggest"
+	suggestAnalyzer      = "suggest"
+	suggestQueryAnalyzer = "suggest_query"
+	suggestFilter        = "suggest_edge_ngram"
+	suggestMaxGram       = 20
+
+	// textAnalyzer is the default analyzer for text fields. Accents are
+	// folded to ascii, and english possessives and plurals are removed.
+	// Stop words are kept so titles like "The The" can be found.
+	textAnalyzer = "text"
+
+	// fuzzyLen is the minimum word length that allows a typo.
+	fuzzyLen = 4
+
+	// MappingVersion is stored in each index when created. Indexes with a
+	// different version need to be rebuilt.
+	MappingVersion = "2"
+	versionKey     = "mapping_version"
+
+	firstDecade = 1900
+)
+
+// Request is a search query with optional filters, facets, sort order and
+// paging.
+type Request struct {
+	Query   string
+	Filters []Filter
+	Facets  []Facet
+	Sort    []string // field names, prefix with - for descending
+	Offset  int
+	Limit   int
+
+	// Advanced uses the query string syntax with fields and operators,
+	// otherwise the query is plain text that allows typos.
+	Advanced bool
+}
+
+// Filter restricts results to documents with a facet value. Name is the
+// facet name, or a field name when there is no facet with that name.
+type Filter struct {
+	Name  string
+	Value string
+}
+
+// Facet counts the top terms of a field. Decades counts dates by decade and
+// Ranges counts numbers in buckets from each range value up to the next.
+type Facet struct {
+	Name    string
+	Field   string
+	Size    int
+	Decades bool
+	Ranges  []float64
+}
+
+type Hit struct {
+	Key   string
+	Score float64
+}
+
+type FacetCount struct {
+	Value string
+	Count int
+}
+
+type Result struct {
+	Hits   []Hit
+	Total  int
+	Facets map[string][]FacetCount
+}
+
 type search struct {
 	config Config
 	index  bleve.Index
+	facets map[string]bool
+	shared bool
 }
 
+// indexes has the open file indexes, keyed by path. Opening an index takes
+// much longer than a search so indexes are opened once and shared by all
+// searchers in the process. Only one process can have an index open.
+var (
+	indexesMu sync.Mutex
+	indexes   = make(map[string]bleve.Index)
+)
+
 func NewSearcher(config Config) Searcher {
 	return &search{config: config}
 }
 
-func (s *search) Open(name string, keywords []string) error {
-	mapping := bleve.NewIndexMapping()
+// Open creates or opens the named index using the mapping. The mapping is
+// only used when the index is created.
+func (s *search) Open(name string, m Mapping) error {
+	indexMapping, facets, err := newIndexMapping(m)
+	if err != nil {
+		return err
+	}
+	s.facets = facets
+
+	path := s.config.path(name)
+	indexesMu.Lock()
+	defer indexesMu.Unlock()
+	if index, ok := indexes[path]; ok {
+		s.index, s.shared = index, true
+		return nil
+	}
+	index, err := bleve.New(path, indexMapping)
+	if err == bleve.ErrorIndexPathExists {
+		index, err = bleve.Open(path)
+		if err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	} else {
+		err = index.SetInternal([]byte(versionKey), []byte(MappingVersion))
+		if err != nil {
+			index.Close()
+			return err
+		}
+	}
+	s.index = index
+	if path != "" {
+		// in memory indexes aren't shared
+		indexes[path] = index
+		s.shared = true
+	}
+
+	return nil
+}
+
+// newIndexMapping returns the index mapping with analyzers and field
+// mappings for the mapping, along with the facet fields.
+func newIndexMapping(m Mapping) (*mapping.IndexMappingImpl, map[string]bool, error) {
+	indexMapping := bleve.NewIndexMapping()
+	err := indexMapping.AddCustomTokenFilter(suggestFilter, map[string]interface{}{
+		"type": edgengram.Name,
+		"min":  1.0,
+		"max":  float64(suggestMaxGram),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	analyzers := map[string][]string{
+		textAnalyzer:         {lowercase.Name, en.PossessiveName, en.PluralStemmerName},
+		suggestAnalyzer:      {lowercase.Name, suggestFilter},
+		suggestQueryAnalyzer: {lowercase.Name},
+	}
+	for name, filters := range analyzers {
+		err = indexMapping.AddCustomAnalyzer(name, map[string]interface{}{
+			"type":          custom.Name,
+			"char_filters":  []string{asciifolding.Name},
+			"tokenizer":     unicode.Name,
+			"token_filters": filters,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	indexMapping.DefaultAnalyzer = textAnalyzer
+
 	// Note that keywords are fields where we want only exact matches.
 	// see https://blevesearch.com/docs/Analyzers/
 	keywordFieldMapping := bleve.NewTextFieldMapping()
 	keywordFieldMapping.Analyzer = keyword.Name
 	keywordMapping := bleve.NewDocumentMapping()
-	for _, v := range keywords {
+	for _, v := range m.Keywords {
 		keywordMapping.AddFieldMappingsAt(v, keywordFieldMapping)
 	}
-	mapping.AddDocumentMapping("_default", keywordMapping)
+	facets := make(map[string]bool)
+	suggest := make(map[string]bool)
+	for _, v := range m.Suggest {
+		suggest[v] = true
+	}
+	names := append(append([]string{}, m.Facets...), m.Suggest...)
+	for _, v := range names {
+		if facets[v] {
+			continue
+		}
+		facets[v] = true
+		facetFieldMapping := bleve.NewTextFieldMapping()
+		facetFieldMapping.Analyzer = keyword.Name
+		facetFieldMapping.Name = v + facetSuffix
+		fields := []*mapping.FieldMapping{bleve.NewTextFieldMapping(), facetFieldMapping}
+		if suggest[v] {
+			suggestFieldMapping := bleve.NewTextFieldMapping()
+			suggestFieldMapping.Analyzer = suggestAnalyzer
+			suggestFieldMapping.Name = v + suggestSuffix
+			fields = append(fields, suggestFieldMapping)
+		}
+		keywordMapping.AddFieldMappingsAt(v, fields...)
+	}
+	indexMapping.AddDocumentMapping("_default", keywordMapping)
+	return indexMapping, facets, nil
+}
 
+func (c Config) path(name string) string {
 	path := "" // in memory
-	if s.config.IndexDir != "" && name != "" {
-		path = filepath.Join(s.config.IndexDir, name+".bleve")
+	if c.IndexDir != "" && name != "" {
+		path = filepath.Join(c.IndexDir, name+".bleve")
 	} else if name != "" {
 		path = name
 	}
+	return path
+}
 
-	index, err := bleve.New(path, mapping)
-	if err == bleve.ErrorIndexPathExists {
+// Outdated reports whether the named index exists and was created with a
+// different mapping version.
+func Outdated(config Config, name string) (bool, error) {
+	path := config.path(name)
+	if path == "" {
+		return false, nil
+	}
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	indexesMu.Lock()
+	defer indexesMu.Unlock()
+	index, ok := indexes[path]
+	if !ok {
+		var err error
 		index, err = bleve.Open(path)
+		if err != nil {
+			return false, err
+		}
+		defer index.Close()
+	}
+	version, err := index.GetInternal([]byte(versionKey))
+	if err != nil {
+		return false, err
+	}
+	return string(version) != MappingVersion, nil
+}
+
+// Remove closes and deletes the named index so it will be created again with
+// the current mapping. Searchers using the index must be opened again.
+func Remove(config Config, name string) error {
+	path := config.path(name)
+	if path == "" {
+		return nil
+	}
+	indexesMu.Lock()
+	defer indexesMu.Unlock()
+	if index, ok := indexes[path]; ok {
+		index.Close()
+		delete(indexes, path)
+	}
+	return os.RemoveAll(path)
+}
+
+// Migrate rebuilds the named index with the current mapping from the fields
+// stored in the index, so an outdated index can be used without syncing
+// everything again. Searchers using the index must be opened again.
+func Migrate(config Config, name string, m Mapping) error {
+	path := config.path(name)
+	if path == "" {
+		return nil
+	}
+	indexMapping, _, err := newIndexMapping(m)
+	if err != nil {
+		return err
+	}
+	indexesMu.Lock()
+	defer indexesMu.Unlock()
+	old, ok := indexes[path]
+	if ok {
+		delete(indexes, path)
+	} else {
+		old, err = bleve.Open(path)
 		if err != nil {
 			return err
 		}
-	} else if err != nil {
+	}
+
+	tmp := path + ".migrate"
+	os.RemoveAll(tmp)
+	index, err := bleve.New(tmp, indexMapping)
+	if err != nil {
+		old.Close()
 		return err
 	}
-	s.index = index
+	err = copyIndex(old, index)
+	if err == nil {
+		err = index.SetInternal([]byte(versionKey), []byte(MappingVersion))
+	}
+	index.Close()
+	old.Close()
+	if err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	err = os.RemoveAll(path)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
 
-	return nil
+// copyIndex indexes the stored fields of each document in src into dst.
+// Facet and suggest copies are skipped since the mapping creates them from
+// the source fields.
+func copyIndex(src, dst bleve.Index) error {
+	const size = 1000
+	var after []string
+	for {
+		searchRequest := bleve.NewSearchRequestOptions(bleve.NewMatchAllQuery(), size, 0, false)
+		searchRequest.Fields = []string{"*"}
+		searchRequest.SortBy([]string{"_id"})
+		if after != nil {
+			searchRequest.SetSearchAfter(after)
+		}
+		searchResult, err := src.Search(searchRequest)
+		if err != nil {
+			return err
+		}
+		b := dst.NewBatch()
+		for _, hit := range searchResult.Hits {
+			fields := make(FieldMap)
+			for k, v := range hit.Fields {
+				if strings.HasSuffix(k, facetSuffix) || strings.HasSuffix(k, suggestSuffix) {
+					continue
+				}
+				fields[k] = v
+			}
+			err = b.Index(hit.ID, fields)
+			if err != nil {
+				return err
+			}
+		}
+		err = dst.Batch(b)
+		if err != nil {
+			return err
+		}
+		if len(searchResult.Hits) < size {
+			return nil
+		}
+		after = []string{searchResult.Hits[len(searchResult.Hits)-1].ID}
+	}
 }
 
+// Close releases the index. Shared indexes are kept open for other
+// searchers.
 func (s *search) Close() {
-	if s.index != nil {
+	if s.index != nil && !s.shared {
 		s.index.Close()
-		s.index = nil
 	}
+	s.index = nil
 }
 
 // see https://blevesearch.com/docs/Query-String-Query/
 func (s *search) Search(q string, limit int) ([]string, error) {
-	query := bleve.NewQueryStringQuery(q)
-	searchRequest := bleve.NewSearchRequest(query)
-	searchRequest.Size = limit
+	result, err := s.SearchRequest(Request{Query: q, Limit: limit, Advanced: true})
+	if err != nil {
+		return nil, err
+	}
+	return result.Keys(), nil
+}
+
+// SearchRequest runs the request query with filters applied and returns the
+// scored hits along with counts for each requested facet.
+func (s *search) SearchRequest(r Request) (Result, error) {
+	var result Result
+	q, err := s.query(r)
+	if err != nil {
+		return result, err
+	}
+	searchRequest := bleve.NewSearchRequestOptions(q, r.Limit, r.Offset, false)
+	if len(r.Sort) > 0 {
+		searchRequest.SortBy(r.Sort)
+	}
+	for _, f := range r.Facets {
+		searchRequest.AddFacet(f.Name, s.facetRequest(f))
+	}
+	searchResult, err := s.index.Search(searchRequest)
+	if err != nil {
+		return result, err
+	}
+
+	result.Total = int(searchResult.Total)
+	for _, hit := range searchResult.Hits {
+		result.Hits = append(result.Hits, Hit{Key: hit.ID, Score: hit.Score})
+	}
+	result.Facets = make(map[string][]FacetCount)
+	for name, facet := range searchResult.Facets {
+		counts := []FacetCount{}
+		for _, t := range facet.Terms.Terms() {
+			counts = append(counts, FacetCount{Value: t.Term, Count: t.Count})
+		}
+		for _, r := range facet.NumericRanges {
+			counts = append(counts, FacetCount{Value: r.Name, Count: r.Count})
+		}
+		for _, r := range facet.DateRanges {
+			counts = append(counts, FacetCount{Value: r.Name, Count: r.Count})
+		}
+		result.Facets[name] = counts
+	}
+	return result, nil
+}
+
+// Suggest returns the most common values of the fields that have a word
+// starting with the prefix, or a word within a small edit distance of it.
+// The fields must be suggest fields in the index mapping.
+func (s *search) Suggest(prefix string, limit int, fields ...string) ([]string, error) {
+	prefix = strings.TrimSpace(prefix)
+	if prefix == "" || len(fields) == 0 {
+		return []string{}, nil
+	}
+	var queries []query.Query
+	for _, field := range fields {
+		// match all prefix words against the edge ngrams without
+		// ngramming the prefix itself
+		pq := bleve.NewMatchQuery(prefix)
+		pq.SetField(field + suggestSuffix)
+		pq.Analyzer = suggestQueryAnalyzer
+		pq.SetOperator(query.MatchQueryOperatorAnd)
+		// allow typos in longer prefixes
+		fq := bleve.NewMatchQuery(prefix)
+		fq.SetField(field)
+		fq.SetFuzziness(1)
+		fq.SetOperator(query.MatchQueryOperatorAnd)
+		queries = append(queries, pq)
+		if utf8.RuneCountInString(prefix) >= fuzzyLen {
+			queries = append(queries, fq)
+		}
+	}
+	searchRequest := bleve.NewSearchRequestOptions(bleve.NewDisjunctionQuery(queries...), 0, 0, false)
+	for _, field := range fields {
+		searchRequest.AddFacet(field, bleve.NewFacetRequest(s.facetField(field), limit))
+	}
 	searchResult, err := s.index.Search(searchRequest)
 	if err != nil {
 		return nil, err
 	}
+
+	// merge facet terms from each field by count
+	counts := make(map[string]int)
+	for _, facet := range searchResult.Facets {
+		for _, t := range facet.Terms.Terms() {
+			counts[t.Term] += t.Count
+		}
+	}
+	values := make([]string, 0, len(counts))
+	for k := range counts {
+		values = append(values, k)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if counts[values[i]] == counts[values[j]] {
+			return values[i] < values[j]
+		}
+		return counts[values[i]] > counts[values[j]]
+	})
+	if len(values) > limit {
+		values = values[:limit]
+	}
+	return values, nil
+}
+
+func (s *search) query(r Request) (query.Query, error) {
+	var q query.Query
+	if strings.TrimSpace(r.Query) == "" {
+		q = bleve.NewMatchAllQuery()
+	} else if r.Advanced {
+		q = bleve.NewQueryStringQuery(r.Query)
+	} else {
+		q = simpleQuery(r.Query)
+	}
+	if len(r.Filters) == 0 {
+		return q, nil
+	}
+	queries := []query.Query{q}
+	for _, f := range r.Filters {
+		fq, err := s.filterQuery(r.Facets, f)
+		if err != nil {
+			return nil, err
+		}
+		queries = append(queries, fq)
+	}
+	return bleve.NewConjunctionQuery(queries...), nil
+}
+
+// simpleQuery matches all words in the text without any query syntax so
+// quotes, colons and other operators are treated as plain text. Longer words
+// also match with a typo, scoring lower than an exact match.
+func simpleQuery(text string) query.Query {
+	var queries []query.Query
+	for _, word := range strings.Fields(text) {
+		exact := bleve.NewMatchQuery(word)
+		exact.SetOperator(query.MatchQueryOperatorAnd)
+		if utf8.RuneCountInString(word) < fuzzyLen {
+			queries = append(queries, exact)
+			continue
+		}
+		exact.SetBoost(2)
+		fuzzy := bleve.NewMatchQuery(word)
+		fuzzy.SetOperator(query.MatchQueryOperatorAnd)
+		fuzzy.SetFuzziness(1)
+		queries = append(queries, bleve.NewDisjunctionQuery(exact, fuzzy))
+	}
+	return bleve.NewConjunctionQuery(queries...)
+}
+
+// filterQuery matches the filter value using the facet of the same name so
+// values returned in facet counts can be used directly as filters.
+func (s *search) filterQuery(facets []Facet, f Filter) (query.Query, error) {
+	facet := Facet{Name: f.Name, Field: f.Name}
+	for _, v := range facets {
+		if v.Name == f.Name {
+			facet = v
+			break
+		}
+	}
+	switch {
+	case facet.Decades:
+		start, end, err := decade(f.Value)
+		if err != nil {
+			return nil, err
+		}
+		q := bleve.NewDateRangeQuery(start, end)
+		q.SetField(facet.Field)
+		return q, nil
+	case len(facet.Ranges) > 0:
+		min, err := strconv.ParseFloat(f.Value, 64)
+		if err != nil {
+			return nil, err
+		}
+		var max *float64
+		for _, v := range facet.Ranges {
+			if v > min {
+				max = &v
+				break
+			}
+		}
+		inclusive := true
+		q := bleve.NewNumericRangeInclusiveQuery(&min, max, &inclusive, nil)
+		q.SetField(facet.Field)
+		return q, nil
+	default:
+		q := bleve.NewTermQuery(f.Value)
+		q.SetField(s.facetField(facet.Field))
+		return q, nil
+	}
+}
+
+func (s *search) facetRequest(f Facet) *bleve.FacetRequest {
+	size := f.Size
+	if size == 0 {
+		size = DefaultFacetSize
+	}
+	switch {
+	case f.Decades:
+		facet := bleve.NewFacetRequest(f.Field, size)
+		for year := firstDecade; year <= time.Now().Year(); year += 10 {
+			name := fmt.Sprintf("%ds", year)
+			start, end, _ := decade(name)
+			facet.AddDateTimeRange(name, start, end)
+		}
+		return facet
+	case len(f.Ranges) > 0:
+		facet := bleve.NewFacetRequest(f.Field, size)
+		for i := range f.Ranges {
+			min := f.Ranges[i]
+			var max *float64
+			if i+1 < len(f.Ranges) {
+				max = &f.Ranges[i+1]
+			}
+			facet.AddNumericRange(strconv.FormatFloat(min, 'f', -1, 64), &min, max)
+		}
+		return facet
+	default:
+		return bleve.NewFacetRequest(s.facetField(f.Field), size)
+	}
+}
+
+func (s *search) facetField(field string) string {
+	if s.facets[field] {
+		return field + facetSuffix
+	}
+	return field
+}
+
+// decade parses a decade name like 1990s into the start and end dates.
+func decade(name string) (time.Time, time.Time, error) {
+	year, err := strconv.Atoi(strings.TrimSuffix(name, "s"))
+	if err != nil || year%10 != 0 {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid decade %s", name)
+	}
+	start := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(10, 0, 0), nil
+}
+
+// Keys returns the hit keys in result order.
+func (r Result) Keys() []string {
 	var keys []string
-	for _, hit := range searchResult.Hits {
-		keys = append(keys, hit.ID)
+	for _, hit := range r.Hits {
+		keys = append(keys, hit.Key)
+	}
+	return keys
+}
+
+// Named returns the first item with each name, in the order of the names.
+// Names without an item are skipped.
+func Named[T any](names []string, items []T, name func(T) string) []T {
+	m := make(map[string]T, len(items))
+	for _, v := range items {
+		if _, ok := m[name(v)]; !ok {
+			m[name(v)] = v
+		}
+	}
+	list := make([]T, 0, len(names))
+	for _, n := range names {
+		if v, ok := m[n]; ok {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// Ordered returns the items in hit order along with the score for each
+// item. Hits without a matching item are skipped.
+func Ordered[T any](hits []Hit, items []T, key func(T) string) ([]T, []float64) {
+	m := make(map[string]T, len(items))
+	for _, v := range items {
+		m[key(v)] = v
+	}
+	var list []T
+	var scores []float64
+	for _, hit := range hits {
+		if v, ok := m[hit.Key]; ok {
+			list = append(list, v)
+			scores = append(scores, hit.Score)
+		}
 	}
-	return keys, nil
+	return list, scores
 }
 
 func (s *search) Index(m IndexMap) {
@@ -18,14 +18,20 @@
 package search // import "takeoutfm.dev/takeout/lib/search"
 
 import (
+	"math/rand"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
 )
 
 type TestSearch struct {
 	t *testing.T
 }
 
-func (t TestSearch) Open(name string, keywords []string) error {
+func (t TestSearch) Open(name string, m Mapping) error {
 	return nil
 }
 
@@ -36,6 +42,14 @@ func (t TestSearch) Search(q string, limit int) ([]string, error) {
 	return []string{}, nil
 }
 
+func (t TestSearch) SearchRequest(r Request) (Result, error) {
+	return Result{}, nil
+}
+
+func (t TestSearch) Suggest(prefix string, limit int, fields ...string) ([]string, error) {
+	return []string{}, nil
+}
+
 func (t TestSearch) Delete(keys []string) error {
 	return nil
 }
@@ -50,7 +64,7 @@ func NewTestSearch(t *testing.T) Searcher {
 func TestSearchIndex(t *testing.T) {
 	c := Config{IndexDir: ""}
 	s := NewSearcher(c)
-	err := s.Open("", []string{})
+	err := s.Open("", Mapping{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -109,3 +123,377 @@ func TestSearchIndex(t *testing.T) {
 		t.Error("expect mixed result")
 	}
 }
+
+func TestSearchFacets(t *testing.T) {
+	c := Config{IndexDir: ""}
+	s := NewSearcher(c)
+	err := s.Open("", Mapping{Keywords: []string{"genre", "type"}, Facets: []string{"artist"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index := make(IndexMap)
+	index["1"] = FieldMap{"artist": "Gary Numan", "title": "Cars", "genre": "new wave",
+		"type": "single", "date": "1979-09-07", "rating": 4.5}
+	index["2"] = FieldMap{"artist": "Gary Numan", "title": "Metal", "genre": "new wave",
+		"date": "1979-09-07", "rating": 3.5}
+	index["3"] = FieldMap{"artist": "Nine Inch Nails", "title": "Metal", "genre": "industrial",
+		"type": "single", "date": "1992-01-01"}
+	s.Index(index)
+
+	facets := []Facet{
+		{Name: "artist", Field: "artist"},
+		{Name: "genre", Field: "genre"},
+		{Name: "decade", Field: "date", Decades: true},
+		{Name: "rating", Field: "rating", Ranges: []float64{1, 2, 3, 4, 5}},
+	}
+	result, err := s.SearchRequest(Request{Query: "metal", Facets: facets, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Total != 2 || len(result.Hits) != 2 {
+		t.Fatalf("expect 2 hits, got %d", result.Total)
+	}
+	if result.Hits[0].Score == 0 {
+		t.Error("expect score")
+	}
+	count := func(facet, value string) int {
+		for _, v := range result.Facets[facet] {
+			if v.Value == value {
+				return v.Count
+			}
+		}
+		return 0
+	}
+	if count("artist", "Nine Inch Nails") != 1 || count("artist", "Gary Numan") != 1 {
+		t.Error("expect artist counts")
+	}
+	if count("decade", "1970s") != 1 || count("decade", "1990s") != 1 {
+		t.Error("expect decade counts")
+	}
+	if count("rating", "3") != 1 {
+		t.Error("expect rating count")
+	}
+
+	result, err = s.SearchRequest(Request{Facets: facets, Limit: 10,
+		Filters: []Filter{{Name: "decade", Value: "1970s"}, {Name: "artist", Value: "Gary Numan"}},
+		Sort:    []string{"title"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Hits) != 2 || result.Hits[0].Key != "1" {
+		t.Error("expect sorted filtered hits")
+	}
+
+	result, err = s.SearchRequest(Request{Query: "metal", Facets: facets, Limit: 10,
+		Filters: []Filter{{Name: "type", Value: "single"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Hits) != 1 || result.Hits[0].Key != "3" {
+		t.Error("expect type filter hit")
+	}
+
+	result, err = s.SearchRequest(Request{Facets: facets, Limit: 1, Offset: 1,
+		Filters: []Filter{{Name: "rating", Value: "3"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Total != 1 || len(result.Hits) != 0 {
+		t.Error("expect offset past rating hit")
+	}
+}
+
+func TestSearchSuggest(t *testing.T) {
+	c := Config{IndexDir: ""}
+	s := NewSearcher(c)
+	err := s.Open("", Mapping{Suggest: []string{"artist", "title"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index := make(IndexMap)
+	index["1"] = FieldMap{"artist": "Gary Numan", "title": "Cars"}
+	index["2"] = FieldMap{"artist": "Gary Numan", "title": "Metal"}
+	index["3"] = FieldMap{"artist": "Nine Inch Nails", "title": "Metal"}
+	index["4"] = FieldMap{"artist": "Numbers", "title": "Carousel"}
+	s.Index(index)
+
+	values, err := s.Suggest("num", 10, "artist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 2 || values[0] != "Gary Numan" || values[1] != "Numbers" {
+		t.Errorf("expect prefix artists, got %v", values)
+	}
+
+	values, err = s.Suggest("gary nu", 10, "artist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 1 || values[0] != "Gary Numan" {
+		t.Errorf("expect all words to match, got %v", values)
+	}
+
+	values, err = s.Suggest("nailz", 10, "artist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 1 || values[0] != "Nine Inch Nails" {
+		t.Errorf("expect fuzzy artist, got %v", values)
+	}
+
+	values, err = s.Suggest("car", 1, "artist", "title")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 1 {
+		t.Errorf("expect limit, got %v", values)
+	}
+}
+
+func TestSearchShared(t *testing.T) {
+	c := Config{IndexDir: t.TempDir()}
+	a := NewSearcher(c)
+	err := a.Open("shared", Mapping{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := NewSearcher(c)
+	err = b.Open("shared", Mapping{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Index(IndexMap{"1": FieldMap{"title": "Cars"}})
+	a.Close()
+
+	keys, err := b.Search("cars", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != "1" {
+		t.Error("expect shared index", keys)
+	}
+	b.Close()
+
+	err = Remove(c, "shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNamed(t *testing.T) {
+	type item struct {
+		id   int
+		name string
+	}
+	items := []item{{1, "b"}, {2, "a"}, {3, "b"}}
+	list := Named([]string{"a", "c", "b"}, items, func(v item) string { return v.name })
+	if len(list) != 2 || list[0].id != 2 || list[1].id != 1 {
+		t.Error("expect first item for each name", list)
+	}
+}
+
+// BenchmarkSuggest suggests artists, releases and titles from an index of
+// 100k tracks, which should take well under 50ms.
+func BenchmarkSuggest(b *testing.B) {
+	s := NewSearcher(Config{})
+	err := s.Open("", Mapping{Suggest: []string{"artist", "release", "title"}})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer s.Close()
+
+	words := strings.Fields("love night heart fire dream city blue star rain " +
+		"summer light dance world girl time road river moon gold wild " +
+		"black white ghost angel storm ocean queen king little shadow")
+	r := rand.New(rand.NewSource(1))
+	name := func(n int) string {
+		var v []string
+		for range n {
+			v = append(v, words[r.Intn(len(words))])
+		}
+		return strings.Join(v, " ")
+	}
+	index := s.(*search).index
+	batch := index.NewBatch()
+	for i := range 100000 {
+		batch.Index(strconv.Itoa(i), FieldMap{
+			"artist":  name(2) + " " + strconv.Itoa(i%5000),
+			"release": name(3),
+			"title":   name(3),
+		})
+		if batch.Size() == 1000 {
+			if err := index.Batch(batch); err != nil {
+				b.Fatal(err)
+			}
+			batch = index.NewBatch()
+		}
+	}
+
+	b.ResetTimer()
+	for range b.N {
+		for _, field := range []string{"artist", "release", "title"} {
+			_, err := s.Suggest("dre", 5, field)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestSearchSimple(t *testing.T) {
+	c := Config{IndexDir: ""}
+	s := NewSearcher(c)
+	err := s.Open("", Mapping{Keywords: []string{"type"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index := make(IndexMap)
+	index["1"] = FieldMap{"artist": "Björk", "title": "Jóga", "type": "single"}
+	index["2"] = FieldMap{"artist": "Sigur Rós", "title": "Hoppípolla"}
+	index["3"] = FieldMap{"artist": "Beyoncé", "title": "Halo", "type": "single"}
+	index["4"] = FieldMap{"artist": "Radiohead", "title": "Karma Police's Cars"}
+	s.Index(index)
+
+	search := func(q string, advanced bool) []string {
+		result, err := s.SearchRequest(Request{Query: q, Limit: 10, Advanced: advanced})
+		if err != nil {
+			t.Fatalf("%s: %s", q, err)
+		}
+		return result.Keys()
+	}
+
+	tests := []struct {
+		query string
+		key   string
+	}{
+		{"bjork", "1"},
+		{"BJÖRK joga", "1"},
+		{"sigur ros", "2"},
+		{"beyonce", "3"},
+		{"radiohed", "4"},
+		{"karma police car", "4"},
+		{`"sigur: ros`, "2"},
+	}
+	for _, v := range tests {
+		keys := search(v.query, false)
+		if len(keys) != 1 || keys[0] != v.key {
+			t.Errorf("%s: expect %s, got %v", v.query, v.key, keys)
+		}
+	}
+
+	if keys := search("+type:single +artist:bjork", true); len(keys) != 1 || keys[0] != "1" {
+		t.Errorf("expect advanced result, got %v", keys)
+	}
+	if keys := search("+type:single +artist:bjork", false); len(keys) != 0 {
+		t.Errorf("expect no simple result, got %v", keys)
+	}
+	if _, err := s.SearchRequest(Request{Query: `artist:"bjork`, Advanced: true}); err == nil {
+		t.Error("expect advanced query error")
+	}
+}
+
+func TestSearchOutdated(t *testing.T) {
+	c := Config{IndexDir: t.TempDir()}
+
+	// index created without a mapping version
+	old, err := bleve.New(c.path("old"), bleve.NewIndexMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	old.Close()
+
+	outdated, err := Outdated(c, "old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !outdated {
+		t.Error("expect outdated")
+	}
+
+	err = Remove(c, "old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSearcher(c)
+	err = s.Open("old", Mapping{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	outdated, err = Outdated(c, "old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outdated {
+		t.Error("expect current")
+	}
+
+	outdated, err = Outdated(c, "missing")
+	if err != nil || outdated {
+		t.Error("expect missing index is not outdated")
+	}
+}
+
+func TestSearchMigrate(t *testing.T) {
+	c := Config{IndexDir: t.TempDir()}
+
+	// index created without facets or a mapping version
+	old, err := bleve.New(c.path("old"), bleve.NewIndexMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	old.Index("1", FieldMap{"artist": "Gary Numan", "title": "Cars",
+		"date": time.Date(1979, time.September, 7, 0, 0, 0, 0, time.UTC), "rating": 4.5})
+	old.Index("2", FieldMap{"artist": "Nine Inch Nails", "title": "Metal",
+		"date": time.Date(1992, time.January, 1, 0, 0, 0, 0, time.UTC), "rating": 3.5})
+	old.Close()
+
+	m := Mapping{Suggest: []string{"artist"}}
+	err = Migrate(c, "old", m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outdated, err := Outdated(c, "old")
+	if err != nil || outdated {
+		t.Error("expect current", err)
+	}
+
+	s := NewSearcher(c)
+	err = s.Open("old", m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Remove(c, "old")
+	defer s.Close()
+	facets := []Facet{
+		{Name: "artist", Field: "artist"},
+		{Name: "decade", Field: "date", Decades: true},
+		{Name: "rating", Field: "rating", Ranges: []float64{1, 2, 3, 4, 5}},
+	}
+	result, err := s.SearchRequest(Request{
+		Filters: []Filter{{Name: "artist", Value: "Gary Numan"}},
+		Facets:  facets, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Hits) != 1 || result.Hits[0].Key != "1" {
+		t.Error("expect artist filter", result.Hits)
+	}
+	counts := make(map[string]int)
+	for _, name := range []string{"artist", "decade", "rating"} {
+		for _, fc := range result.Facets[name] {
+			counts[name+":"+fc.Value] = fc.Count
+		}
+	}
+	if counts["artist:Gary Numan"] != 1 || counts["decade:1970s"] != 1 || counts["rating:4"] != 1 {
+		t.Error("expect migrated facets", result.Facets)
+	}
+	names, err := s.Suggest("nin", 10, "artist")
+	if err != nil || len(names) != 1 || names[0] != "Nine Inch Nails" {
+		t.Error("expect migrated suggest", names, err)
+	}
+}
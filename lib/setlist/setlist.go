@@ -18,7 +18,9 @@
 package setlist // import "takeoutfm.dev/takeout/lib/setlist"
 
 import (
+	"encoding/json"
 	"fmt"
+	"sync"
 	"time"
 
 	"takeoutfm.dev/takeout/lib/client"
@@ -26,17 +28,26 @@ import (
 
 type Config struct {
 	ApiKey string
+	MaxAge time.Duration // how long fetched results are cached
 }
 
 type Client struct {
-	config Config
-	client client.Getter
+	config  Config
+	client  client.Getter
+	cacheMu sync.Mutex
+	cache   map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	data    []byte
+	expires time.Time
 }
 
 func NewClient(config Config, client client.Getter) *Client {
 	return &Client{
 		config: config,
 		client: client,
+		cache:  make(map[string]cacheEntry),
 	}
 }
 
@@ -74,9 +85,10 @@ type Tour struct {
 }
 
 type Song struct {
-	Name string `json:"name"`
-	Info string `json:"info"`
-	Tape bool   `json:"tape"`
+	Name  string  `json:"name"`
+	Info  string  `json:"info"`
+	Tape  bool    `json:"tape"`
+	Cover *Artist `json:"cover,omitempty"`
 }
 
 type Set struct {
@@ -110,6 +122,21 @@ func (s Setlist) EventTime() time.Time {
 	return t
 }
 
+// Songs returns the songs from all sets and encores in order. Songs played
+// from tape, like intros, aren't included.
+func (s Setlist) Songs() []Song {
+	var songs []Song
+	for _, set := range s.Sets.Set {
+		for _, song := range set.Songs {
+			if song.Tape || song.Name == "" {
+				continue
+			}
+			songs = append(songs, song)
+		}
+	}
+	return songs
+}
+
 type SetlistPage struct {
 	Type         string    `json:"type"`
 	ItemsPerPage int       `json:"itemsPerPage"`
@@ -124,6 +151,28 @@ func (s *Client) ArtistYear(arid string, year int) []Setlist {
 	return s.fetchAll(format + "&p=%d")
 }
 
+// ArtistSetlists returns a page of the most recent setlists for the artist.
+func (s *Client) ArtistSetlists(arid string, page int) (*SetlistPage, error) {
+	url := fmt.Sprintf("https://api.setlist.fm/rest/1.0/artist/%s/setlists?p=%d", arid, page)
+	var result SetlistPage
+	err := s.fetch(url, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Setlist returns the setlist with the setlist.fm id.
+func (s *Client) Setlist(id string) (*Setlist, error) {
+	url := fmt.Sprintf("https://api.setlist.fm/rest/1.0/setlist/%s", id)
+	var result Setlist
+	err := s.fetch(url, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
 func (s *Client) fetchAll(format string) []Setlist {
 	var list []Setlist
 	total := 0
@@ -141,16 +190,56 @@ func (s *Client) fetchAll(format string) []Setlist {
 }
 
 func (s *Client) fetchPage(url string) *SetlistPage {
-	time.Sleep(time.Second)
+	var result SetlistPage
+	err := s.fetch(url, &result)
+	if err != nil {
+		fmt.Println(err)
+	}
+	return &result
+}
 
+// fetch gets the url into result. Results are cached for MaxAge since
+// setlist.fm allows few requests, and requests are rate limited by the
+// client.
+func (s *Client) fetch(url string, result interface{}) error {
+	if data, ok := s.cached(url); ok {
+		return json.Unmarshal(data, result)
+	}
 	headers := map[string]string{
 		"Accept":    "application/json",
 		"x-api-key": s.config.ApiKey,
 	}
-	var result SetlistPage
-	err := s.client.GetJsonWith(headers, url, &result)
+	err := s.client.GetJsonWith(headers, url, result)
 	if err != nil {
-		fmt.Println(err)
+		return err
 	}
-	return &result
+	if s.config.MaxAge > 0 {
+		data, err := json.Marshal(result)
+		if err == nil {
+			s.store(url, data)
+		}
+	}
+	return nil
+}
+
+func (s *Client) cached(url string) ([]byte, bool) {
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	entry, ok := s.cache[url]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.data, true
+}
+
+func (s *Client) store(url string, data []byte) {
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	now := time.Now()
+	for k, v := range s.cache {
+		if now.After(v.expires) {
+			delete(s.cache, k)
+		}
+	}
+	s.cache[url] = cacheEntry{data: data, expires: now.Add(s.config.MaxAge)}
 }
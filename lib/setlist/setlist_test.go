@@ -25,6 +25,7 @@ import (
 	"net/http"
 	"strings"
 	"testing"
+	"time"
 
 	"takeoutfm.dev/takeout/lib/client"
 )
@@ -54,6 +55,13 @@ func (s setlistServer) RoundTrip(r *http.Request) (*http.Response, error) {
 			file := fmt.Sprintf("test/artist_ca891d65-d9b0-4258-89f7-e6ba29d83767_2022_%s.json", page)
 			body = jsonFile(file)
 		}
+	} else if strings.HasPrefix(r.URL.Path, "/rest/1.0/artist/") {
+		arid := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/rest/1.0/artist/"), "/setlists")
+		page := r.URL.Query().Get("p")
+		body = jsonFile(fmt.Sprintf("test/artist_%s_%s.json", arid, page))
+	} else if strings.HasPrefix(r.URL.Path, "/rest/1.0/setlist/") {
+		id := strings.TrimPrefix(r.URL.Path, "/rest/1.0/setlist/")
+		body = jsonFile(fmt.Sprintf("test/setlist_%s.json", id))
 	}
 	return &http.Response{
 		StatusCode: 200,
@@ -91,3 +99,71 @@ func TestSetlist(t *testing.T) {
 		}
 	}
 }
+
+func TestArtistSetlists(t *testing.T) {
+	s := makeClient(t)
+	result, err := s.ArtistSetlists("ca891d65-d9b0-4258-89f7-e6ba29d83767", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Setlist) != 5 {
+		t.Fatal("expect 5 setlists", len(result.Setlist))
+	}
+	if result.Setlist[0].Id != "23be88f3" {
+		t.Error("expect setlist id", result.Setlist[0].Id)
+	}
+}
+
+func TestSetlistSongs(t *testing.T) {
+	s := makeClient(t)
+	sl, err := s.Setlist("23be88f3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sl.Venue.Name != "Amalie Arena" {
+		t.Error("expect venue", sl.Venue.Name)
+	}
+	if sl.EventTime().Format("2006-01-02") != "2022-10-27" {
+		t.Error("expect event date", sl.EventTime())
+	}
+	songs := sl.Songs()
+	if len(songs) == 0 {
+		t.Fatal("expect songs")
+	}
+	if songs[0].Name != "Senjutsu" {
+		t.Error("expect tape songs skipped", songs[0].Name)
+	}
+	for _, song := range songs {
+		if song.Tape {
+			t.Error("expect no tape songs", song.Name)
+		}
+	}
+}
+
+type countServer struct {
+	setlistServer
+	count int
+}
+
+func (s *countServer) RoundTrip(r *http.Request) (*http.Response, error) {
+	s.count++
+	return s.setlistServer.RoundTrip(r)
+}
+
+func TestSetlistCache(t *testing.T) {
+	server := &countServer{setlistServer: setlistServer{t: t}}
+	c := client.NewTransportGetter(client.Config{UserAgent: "test/1.0"}, server)
+	s := NewClient(Config{ApiKey: apiKey, MaxAge: time.Hour}, c)
+	for i := 0; i < 2; i++ {
+		sl, err := s.Setlist("23be88f3")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sl.Venue.Name != "Amalie Arena" || len(sl.Songs()) == 0 {
+			t.Error("expect cached setlist", sl.Venue.Name)
+		}
+	}
+	if server.count != 1 {
+		t.Error("expect single fetch", server.count)
+	}
+}
@@ -0,0 +1,245 @@
+// Copyright 2023 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package thumb creates resized image derivatives which are cached on disk.
+package thumb // import "takeoutfm.dev/takeout/lib/thumb"
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nfnt/resize"
+	"takeoutfm.dev/takeout/lib/hash"
+)
+
+const (
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+
+	DefaultQuality   = 85
+	DefaultMaxPixels = 50_000_000
+	DefaultMaxAge    = 30 * 24 * time.Hour
+
+	// WidthParam is the query parameter used to request a resized image.
+	WidthParam = "w"
+)
+
+var (
+	ErrInvalidWidth  = errors.New("invalid width")
+	ErrInvalidFormat = errors.New("invalid format")
+	ErrTooLarge      = errors.New("image too large")
+)
+
+// Config for resized images. Widths are the allowed target widths and
+// Quality is used for JPEG output. Source images with more than MaxPixels
+// are not decoded, at most Workers images are resized at once, and cached
+// derivatives not used within MaxAge are pruned.
+type Config struct {
+	CacheDir  string
+	Widths    []int
+	Quality   int
+	Format    string
+	MaxPixels int
+	Workers   int
+	MaxAge    time.Duration
+}
+
+type Thumb struct {
+	config  Config
+	workers chan struct{}
+}
+
+func New(config Config) *Thumb {
+	if config.MaxPixels <= 0 {
+		config.MaxPixels = DefaultMaxPixels
+	}
+	if config.Workers <= 0 {
+		config.Workers = runtime.NumCPU()
+	}
+	if config.MaxAge <= 0 {
+		config.MaxAge = DefaultMaxAge
+	}
+	return &Thumb{config: config, workers: make(chan struct{}, config.Workers)}
+}
+
+// URL returns the image path with the width requested.
+func URL(path string, width int) string {
+	return path + "?" + WidthParam + "=" + strconv.Itoa(width)
+}
+
+// Format returns the supported output format for the requested format, or
+// the configured format when empty.
+func (t *Thumb) Format(format string) (string, error) {
+	if format == "" {
+		format = t.config.Format
+	}
+	format = strings.ToLower(format)
+	if format == "jpg" {
+		format = FormatJPEG
+	}
+	if format != FormatJPEG && format != FormatPNG {
+		return "", ErrInvalidFormat
+	}
+	return format, nil
+}
+
+// ContentType returns the content type for the output format.
+func ContentType(format string) string {
+	return "image/" + format
+}
+
+// Resize returns the image scaled down to the width in the requested format,
+// or the configured format when empty. Derivatives are cached on disk using
+// the key, which is typically the source URL. Images already narrower than
+// the width are only converted.
+func (t *Thumb) Resize(key string, src []byte, width int, format string) ([]byte, error) {
+	if !slices.Contains(t.config.Widths, width) {
+		return nil, ErrInvalidWidth
+	}
+	format, err := t.Format(format)
+	if err != nil {
+		return nil, err
+	}
+
+	path := t.cachePath(key, width, format)
+	if data, err := os.ReadFile(path); err == nil {
+		// keep used derivatives from being pruned
+		now := time.Now()
+		os.Chtimes(path, now, now)
+		return data, nil
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width*cfg.Height > t.config.MaxPixels {
+		return nil, ErrTooLarge
+	}
+
+	t.workers <- struct{}{}
+	defer func() { <-t.workers }()
+
+	img, _, err := image.Decode(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	if img.Bounds().Dx() > width {
+		img = resize.Resize(uint(width), 0, img, resize.Lanczos3)
+	}
+
+	var buf bytes.Buffer
+	switch format {
+	case FormatJPEG:
+		err = jpeg.Encode(&buf, opaque(img), &jpeg.Options{Quality: t.quality()})
+	case FormatPNG:
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data := buf.Bytes()
+	err = t.save(path, data)
+	return data, err
+}
+
+func (t *Thumb) quality() int {
+	if t.config.Quality <= 0 || t.config.Quality > 100 {
+		return DefaultQuality
+	}
+	return t.config.Quality
+}
+
+func (t *Thumb) cachePath(key string, width int, format string) string {
+	name := hash.MD5Hex(fmt.Sprintf("%s|%d|%s|%d", key, width, format, t.quality()))
+	return filepath.Join(t.config.CacheDir, name[:2], name+"."+format)
+}
+
+// Prune removes cached derivatives that haven't been used within the max
+// age.
+func (t *Thumb) Prune() error {
+	if t.config.CacheDir == "" {
+		return nil
+	}
+	expire := time.Now().Add(-t.config.MaxAge)
+	err := filepath.WalkDir(t.config.CacheDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if info.ModTime().Before(expire) {
+			os.Remove(path)
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// save writes the derivative to a temporary file which is renamed so
+// partially written files are never read.
+func (t *Thumb) save(path string, data []byte) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".thumb")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// opaque draws images with transparency over a white background since JPEG
+// has no alpha channel.
+func opaque(img image.Image) image.Image {
+	if _, ok := img.(*image.YCbCr); ok {
+		return img
+	}
+	dst := image.NewRGBA(img.Bounds())
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Over)
+	return dst
+}
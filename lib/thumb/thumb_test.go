@@ -0,0 +1,127 @@
+// Copyright 2023 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package thumb // import "takeoutfm.dev/takeout/lib/thumb"
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"testing"
+	"time"
+)
+
+func testImage(t *testing.T, w, h int) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.NRGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestResize(t *testing.T) {
+	th := New(Config{CacheDir: t.TempDir(), Widths: []int{50, 250}, Quality: 80, Format: FormatJPEG})
+	src := testImage(t, 200, 100)
+
+	data, err := th.Resize("test", src, 50, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != FormatJPEG {
+		t.Error("expect jpeg", format)
+	}
+	if img.Bounds().Dx() != 50 || img.Bounds().Dy() != 25 {
+		t.Error("expect 50x25", img.Bounds())
+	}
+
+	// cached
+	path := th.cachePath("test", 50, FormatJPEG)
+	if _, err := os.Stat(path); err != nil {
+		t.Error("expect cached", err)
+	}
+	cached, err := th.Resize("test", nil, 50, "jpg")
+	if err != nil || !bytes.Equal(cached, data) {
+		t.Error("expect cached data", err)
+	}
+
+	// no upscale
+	data, err = th.Resize("test", src, 250, FormatPNG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, format, err = image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != FormatPNG || img.Bounds().Dx() != 200 {
+		t.Error("expect png 200", format, img.Bounds())
+	}
+
+	if _, err := th.Resize("test", src, 100, ""); err != ErrInvalidWidth {
+		t.Error("expect invalid width")
+	}
+	if _, err := th.Resize("test", src, 50, "webp"); err != ErrInvalidFormat {
+		t.Error("expect invalid format")
+	}
+}
+
+func TestResizeTooLarge(t *testing.T) {
+	th := New(Config{CacheDir: t.TempDir(), Widths: []int{50}, MaxPixels: 100})
+	if _, err := th.Resize("test", testImage(t, 20, 10), 50, FormatPNG); err != ErrTooLarge {
+		t.Error("expect too large", err)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	th := New(Config{CacheDir: t.TempDir(), Widths: []int{50}, MaxAge: time.Hour})
+	src := testImage(t, 100, 100)
+	_, err := th.Resize("old", src, 50, FormatPNG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = th.Resize("new", src, 50, FormatPNG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := th.cachePath("old", 50, FormatPNG)
+	past := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(old, past, past)
+
+	err = th.Prune()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(old); err == nil {
+		t.Error("expect old derivative pruned")
+	}
+	if _, err := os.Stat(th.cachePath("new", 50, FormatPNG)); err != nil {
+		t.Error("expect new derivative", err)
+	}
+}
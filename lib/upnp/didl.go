@@ -0,0 +1,115 @@
+// Copyright 2023 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package upnp
+
+import (
+	"encoding/xml"
+	"fmt"
+	"time"
+)
+
+const (
+	ClassContainer      = "object.container"
+	ClassStorageFolder  = "object.container.storageFolder"
+	ClassMusicArtist    = "object.container.person.musicArtist"
+	ClassMusicAlbum     = "object.container.album.musicAlbum"
+	ClassPlaylist       = "object.container.playlistContainer"
+	ClassMusicTrack     = "object.item.audioItem.musicTrack"
+	ClassAudioBroadcast = "object.item.audioItem.audioBroadcast"
+	ClassAudioItem      = "object.item.audioItem"
+	ClassMovie          = "object.item.videoItem.movie"
+	ClassVideoItem      = "object.item.videoItem"
+)
+
+// Object has the properties common to containers and items.
+type Object struct {
+	ID          string `xml:"id,attr"`
+	ParentID    string `xml:"parentID,attr"`
+	Restricted  int    `xml:"restricted,attr"`
+	Title       string `xml:"dc:title"`
+	Creator     string `xml:"dc:creator,omitempty"`
+	Date        string `xml:"dc:date,omitempty"`
+	Description string `xml:"dc:description,omitempty"`
+	Class       string `xml:"upnp:class"`
+	Artist      string `xml:"upnp:artist,omitempty"`
+	Album       string `xml:"upnp:album,omitempty"`
+	Genre       string `xml:"upnp:genre,omitempty"`
+	TrackNumber int    `xml:"upnp:originalTrackNumber,omitempty"`
+	Episode     int    `xml:"upnp:episodeNumber,omitempty"`
+	Season      int    `xml:"upnp:episodeSeason,omitempty"`
+	AlbumArtURI string `xml:"upnp:albumArtURI,omitempty"`
+	Res         []Res  `xml:"res"`
+}
+
+type Container struct {
+	XMLName    xml.Name `xml:"container"`
+	ChildCount int      `xml:"childCount,attr,omitempty"`
+	Object
+}
+
+type Item struct {
+	XMLName xml.Name `xml:"item"`
+	Object
+}
+
+// Res is a resource used to stream an item.
+type Res struct {
+	ProtocolInfo string `xml:"protocolInfo,attr"`
+	Size         int64  `xml:"size,attr,omitempty"`
+	Duration     string `xml:"duration,attr,omitempty"`
+	URL          string `xml:",chardata"`
+}
+
+type didlLite struct {
+	XMLName    xml.Name `xml:"DIDL-Lite"`
+	XMLNS      string   `xml:"xmlns,attr"`
+	XMLNSDC    string   `xml:"xmlns:dc,attr"`
+	XMLNSUPnP  string   `xml:"xmlns:upnp,attr"`
+	XMLNSDLNA  string   `xml:"xmlns:dlna,attr"`
+	Containers []Container
+	Items      []Item
+}
+
+// DIDL returns the DIDL-Lite document with the containers and items.
+func DIDL(containers []Container, items []Item) (string, error) {
+	doc := didlLite{
+		XMLNS:      "urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/",
+		XMLNSDC:    "http://purl.org/dc/elements/1.1/",
+		XMLNSUPnP:  "urn:schemas-upnp-org:metadata-1-0/upnp/",
+		XMLNSDLNA:  "urn:schemas-dlna-org:metadata-1-0/",
+		Containers: containers,
+		Items:      items,
+	}
+	data, err := xml.Marshal(doc)
+	return string(data), err
+}
+
+// ProtocolInfo returns the http-get protocol info for the content type.
+// Seekable resources support byte range requests.
+func ProtocolInfo(contentType string, seekable bool) string {
+	if seekable {
+		return fmt.Sprintf("http-get:*:%s:DLNA.ORG_OP=01;DLNA.ORG_CI=0", contentType)
+	}
+	return fmt.Sprintf("http-get:*:%s:*", contentType)
+}
+
+// Duration formats the duration as H:MM:SS.mmm.
+func Duration(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
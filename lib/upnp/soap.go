@@ -0,0 +1,142 @@
+// Copyright 2023 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package upnp
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"takeoutfm.dev/takeout/lib/header"
+)
+
+const (
+	ErrorInvalidAction = 401
+	ErrorInvalidArgs   = 402
+	ErrorActionFailed  = 501
+	ErrorNoSuchObject  = 701
+)
+
+var (
+	ErrInvalidAction = errors.New("invalid action")
+)
+
+const soapEnvelope = "http://schemas.xmlsoap.org/soap/envelope/"
+
+// Action is a SOAP control request with the service type, action name and
+// the input arguments.
+type Action struct {
+	ServiceType string
+	Name        string
+	Args        map[string]string
+}
+
+// Arg is an output argument. Output arguments are ordered as described in
+// the service description.
+type Arg struct {
+	Name  string
+	Value string
+}
+
+// ParseAction reads the action from the SOAPACTION header and the arguments
+// from the request envelope.
+func ParseAction(r *http.Request) (Action, error) {
+	var action Action
+	soapAction := strings.Trim(r.Header.Get("SOAPACTION"), `"`)
+	serviceType, name, ok := strings.Cut(soapAction, "#")
+	if !ok {
+		return action, ErrInvalidAction
+	}
+	action.ServiceType = serviceType
+	action.Name = name
+	action.Args = make(map[string]string)
+
+	// arguments are the children of the action element
+	decoder := xml.NewDecoder(io.LimitReader(r.Body, 1<<20))
+	depth := 0
+	var arg string
+	var value strings.Builder
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return action, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			if depth == 3 && t.Name.Local != name {
+				return action, ErrInvalidAction
+			}
+			if depth == 4 {
+				arg = t.Name.Local
+				value.Reset()
+			}
+		case xml.CharData:
+			if depth == 4 {
+				value.Write(t)
+			}
+		case xml.EndElement:
+			if depth == 4 {
+				action.Args[arg] = value.String()
+			}
+			depth--
+		}
+	}
+	return action, nil
+}
+
+// WriteResponse writes the action response envelope with the output
+// arguments.
+func WriteResponse(w http.ResponseWriter, action Action, args []Arg) {
+	var body bytes.Buffer
+	fmt.Fprintf(&body, `<u:%sResponse xmlns:u="%s">`, action.Name, action.ServiceType)
+	for _, arg := range args {
+		fmt.Fprintf(&body, "<%s>", arg.Name)
+		xml.EscapeText(&body, []byte(arg.Value))
+		fmt.Fprintf(&body, "</%s>", arg.Name)
+	}
+	fmt.Fprintf(&body, `</u:%sResponse>`, action.Name)
+	writeEnvelope(w, http.StatusOK, body.Bytes())
+}
+
+// WriteFault writes a UPnP error using the error code and description.
+func WriteFault(w http.ResponseWriter, code int, description string) {
+	var body bytes.Buffer
+	body.WriteString(`<s:Fault><faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring>`)
+	body.WriteString(`<detail><UPnPError xmlns="urn:schemas-upnp-org:control-1-0">`)
+	fmt.Fprintf(&body, "<errorCode>%d</errorCode><errorDescription>", code)
+	xml.EscapeText(&body, []byte(description))
+	body.WriteString(`</errorDescription></UPnPError></detail></s:Fault>`)
+	writeEnvelope(w, http.StatusInternalServerError, body.Bytes())
+}
+
+func writeEnvelope(w http.ResponseWriter, code int, body []byte) {
+	w.Header().Set(header.ContentType, ContentTypeXML)
+	w.Header().Set("EXT", "")
+	w.WriteHeader(code)
+	fmt.Fprintf(w, `%s<s:Envelope xmlns:s="%s" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>`,
+		xml.Header, soapEnvelope)
+	w.Write(body)
+	io.WriteString(w, `</s:Body></s:Envelope>`)
+}
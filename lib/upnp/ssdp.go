@@ -0,0 +1,197 @@
+// Copyright 2023 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package upnp
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"takeoutfm.dev/takeout/lib/log"
+)
+
+const (
+	SSDPAll        = "ssdp:all"
+	SSDPRootDevice = "upnp:rootdevice"
+	SSDPAlive      = "ssdp:alive"
+	SSDPByeBye     = "ssdp:byebye"
+	SSDPDiscover   = `"ssdp:discover"`
+
+	DefaultMaxAge = 30 * time.Minute
+)
+
+var ssdpGroup = &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}
+
+// Advertiser announces a device using SSDP and responds to searches. The
+// UUID is the device UDN and Types has the device type followed by the
+// service types.
+type Advertiser struct {
+	UUID      string
+	Location  string
+	Server    string
+	Types     []string
+	MaxAge    time.Duration
+	Interface *net.Interface
+
+	conn *net.UDPConn
+	done chan struct{}
+	once sync.Once
+}
+
+// Start listens for searches and periodically announces the device until
+// closed.
+func (a *Advertiser) Start() error {
+	if a.MaxAge == 0 {
+		a.MaxAge = DefaultMaxAge
+	}
+	conn, err := net.ListenMulticastUDP("udp4", a.Interface, ssdpGroup)
+	if err != nil {
+		return err
+	}
+	a.conn = conn
+	a.done = make(chan struct{})
+	go a.listen()
+	go a.announce()
+	return nil
+}
+
+// Close announces that the device is no longer available.
+func (a *Advertiser) Close() error {
+	var err error
+	a.once.Do(func() {
+		close(a.done)
+		a.notifyAll(SSDPByeBye)
+		err = a.conn.Close()
+	})
+	return err
+}
+
+func (a *Advertiser) announce() {
+	a.notifyAll(SSDPAlive)
+	ticker := time.NewTicker(a.MaxAge / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			a.notifyAll(SSDPAlive)
+		case <-a.done:
+			return
+		}
+	}
+}
+
+func (a *Advertiser) notifyAll(nts string) {
+	for _, nt := range a.targets() {
+		_, err := a.conn.WriteToUDP(a.notify(nt, nts), ssdpGroup)
+		if err != nil {
+			log.Printf("ssdp notify error %s\n", err)
+		}
+	}
+}
+
+func (a *Advertiser) listen() {
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := a.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-a.done:
+				return
+			default:
+			}
+			log.Printf("ssdp read error %s\n", err)
+			continue
+		}
+		req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(buf[:n])))
+		if err != nil || req.Method != "M-SEARCH" || req.Header.Get("MAN") != SSDPDiscover {
+			continue
+		}
+		targets := a.searchTargets(req.Header.Get("ST"))
+		if len(targets) == 0 {
+			continue
+		}
+		// respond within MX seconds, capped to avoid holding on to
+		// many responses
+		mx, _ := strconv.Atoi(req.Header.Get("MX"))
+		mx = min(max(mx, 1), 3)
+		delay := time.Duration(rand.Int63n(int64(mx) * int64(time.Second) / 2))
+		go func() {
+			time.Sleep(delay)
+			for _, st := range targets {
+				_, err := a.conn.WriteToUDP(a.response(st), addr)
+				if err != nil {
+					log.Printf("ssdp response error %s\n", err)
+				}
+			}
+		}()
+	}
+}
+
+func (a *Advertiser) targets() []string {
+	return append([]string{SSDPRootDevice, a.UUID}, a.Types...)
+}
+
+func (a *Advertiser) searchTargets(st string) []string {
+	targets := a.targets()
+	if st == SSDPAll {
+		return targets
+	}
+	if slices.Contains(targets, st) {
+		return []string{st}
+	}
+	return nil
+}
+
+func (a *Advertiser) usn(nt string) string {
+	if nt == a.UUID {
+		return nt
+	}
+	return a.UUID + "::" + nt
+}
+
+func (a *Advertiser) notify(nt, nts string) []byte {
+	return []byte(fmt.Sprintf("NOTIFY * HTTP/1.1\r\n"+
+		"HOST: %s\r\n"+
+		"CACHE-CONTROL: max-age=%d\r\n"+
+		"LOCATION: %s\r\n"+
+		"NT: %s\r\n"+
+		"NTS: %s\r\n"+
+		"SERVER: %s\r\n"+
+		"USN: %s\r\n\r\n",
+		ssdpGroup, int(a.MaxAge.Seconds()), a.Location, nt, nts, a.Server, a.usn(nt)))
+}
+
+func (a *Advertiser) response(st string) []byte {
+	return []byte(fmt.Sprintf("HTTP/1.1 200 OK\r\n"+
+		"CACHE-CONTROL: max-age=%d\r\n"+
+		"DATE: %s\r\n"+
+		"EXT:\r\n"+
+		"LOCATION: %s\r\n"+
+		"SERVER: %s\r\n"+
+		"ST: %s\r\n"+
+		"USN: %s\r\n\r\n",
+		int(a.MaxAge.Seconds()), time.Now().UTC().Format(http.TimeFormat),
+		a.Location, a.Server, st, a.usn(st)))
+}
@@ -0,0 +1,181 @@
+// Copyright 2023 defsub
+//
+// This file is part of TakeoutFM.
+//
+// TakeoutFM is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// TakeoutFM is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License for
+// more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with TakeoutFM.  If not, see <https://www.gnu.org/licenses/>.
+
+package upnp
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testUUID = "uuid:0b5b3a6c-4ad3-4bd5-9a3e-3e1c2c0b7f3a"
+
+func TestDescription(t *testing.T) {
+	d := Device{
+		DeviceType:   DeviceMediaServer,
+		FriendlyName: "Takeout & Friends",
+		UDN:          testUUID,
+		ServiceList: []Service{{
+			ServiceType: ServiceContentDirectory,
+			ServiceID:   ServiceIDContentDirectory,
+		}},
+	}
+	data, err := d.Description()
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := string(data)
+	for _, s := range []string{
+		`<root xmlns="urn:schemas-upnp-org:device-1-0"`,
+		"<friendlyName>Takeout &amp; Friends</friendlyName>",
+		"<UDN>" + testUUID + "</UDN>",
+		"<serviceType>" + ServiceContentDirectory + "</serviceType>",
+	} {
+		if !strings.Contains(doc, s) {
+			t.Error("expect", s, doc)
+		}
+	}
+}
+
+func TestParseAction(t *testing.T) {
+	body := `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+<s:Body><u:Browse xmlns:u="urn:schemas-upnp-org:service:ContentDirectory:1">
+<ObjectID>music/artists</ObjectID>
+<BrowseFlag>BrowseDirectChildren</BrowseFlag>
+<Filter>*</Filter>
+<StartingIndex>10</StartingIndex>
+<RequestedCount>5</RequestedCount>
+<SortCriteria></SortCriteria>
+</u:Browse></s:Body></s:Envelope>`
+	r := httptest.NewRequest("POST", "/control", strings.NewReader(body))
+	r.Header.Set("SOAPACTION", `"urn:schemas-upnp-org:service:ContentDirectory:1#Browse"`)
+	action, err := ParseAction(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if action.Name != "Browse" || action.ServiceType != ServiceContentDirectory {
+		t.Error("expect browse", action)
+	}
+	if action.Args["ObjectID"] != "music/artists" || action.Args["StartingIndex"] != "10" ||
+		action.Args["RequestedCount"] != "5" {
+		t.Error("expect args", action.Args)
+	}
+
+	r = httptest.NewRequest("POST", "/control", strings.NewReader(body))
+	r.Header.Set("SOAPACTION", `"urn:schemas-upnp-org:service:ContentDirectory:1#Search"`)
+	_, err = ParseAction(r)
+	if err != ErrInvalidAction {
+		t.Error("expect invalid action", err)
+	}
+}
+
+func TestWriteResponse(t *testing.T) {
+	w := httptest.NewRecorder()
+	action := Action{ServiceType: ServiceContentDirectory, Name: "Browse"}
+	WriteResponse(w, action, []Arg{{"Result", "<DIDL-Lite/>"}, {"NumberReturned", "0"}})
+	body := w.Body.String()
+	if !strings.Contains(body, `<u:BrowseResponse xmlns:u="`+ServiceContentDirectory+`">`) {
+		t.Error("expect response", body)
+	}
+	if !strings.Contains(body, "<Result>&lt;DIDL-Lite/&gt;</Result><NumberReturned>0</NumberReturned>") {
+		t.Error("expect escaped args", body)
+	}
+
+	w = httptest.NewRecorder()
+	WriteFault(w, ErrorNoSuchObject, "no such object")
+	if w.Code != http.StatusInternalServerError ||
+		!strings.Contains(w.Body.String(), "<errorCode>701</errorCode>") {
+		t.Error("expect fault", w.Code, w.Body.String())
+	}
+}
+
+func TestDIDL(t *testing.T) {
+	doc, err := DIDL([]Container{{
+		ChildCount: 2,
+		Object: Object{ID: "release/1", ParentID: "artist/1", Restricted: 1,
+			Title: "Album", Class: ClassMusicAlbum},
+	}}, []Item{{
+		Object: Object{ID: "track/x", ParentID: "release/1", Restricted: 1,
+			Title: "Track & Title", Class: ClassMusicTrack, TrackNumber: 3,
+			Res: []Res{{
+				ProtocolInfo: ProtocolInfo("audio/flac", true),
+				Size:         1024,
+				Duration:     Duration(3*time.Minute + 25*time.Second),
+				URL:          "http://host/res/track/x?a=1&b=2",
+			}},
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		`<DIDL-Lite xmlns="urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/" xmlns:dc="http://purl.org/dc/elements/1.1/"`,
+		`<container childCount="2" id="release/1" parentID="artist/1" restricted="1"><dc:title>Album</dc:title><upnp:class>object.container.album.musicAlbum</upnp:class></container>`,
+		`<dc:title>Track &amp; Title</dc:title>`,
+		`<upnp:originalTrackNumber>3</upnp:originalTrackNumber>`,
+		`<res protocolInfo="http-get:*:audio/flac:DLNA.ORG_OP=01;DLNA.ORG_CI=0" size="1024" duration="0:03:25.000">http://host/res/track/x?a=1&amp;b=2</res>`,
+	} {
+		if !strings.Contains(doc, s) {
+			t.Error("expect", s, doc)
+		}
+	}
+}
+
+func TestSSDP(t *testing.T) {
+	a := &Advertiser{
+		UUID:     testUUID,
+		Location: "http://192.168.1.2:8200/dlna/device.xml",
+		Server:   "Linux UPnP/1.0 Takeout/1.0",
+		Types:    []string{DeviceMediaServer, ServiceContentDirectory},
+		MaxAge:   DefaultMaxAge,
+	}
+	if len(a.searchTargets(SSDPAll)) != 4 {
+		t.Error("expect all targets", a.searchTargets(SSDPAll))
+	}
+	if st := a.searchTargets(ServiceContentDirectory); len(st) != 1 {
+		t.Error("expect cds target", st)
+	}
+	if st := a.searchTargets("urn:schemas-upnp-org:device:MediaRenderer:1"); len(st) != 0 {
+		t.Error("expect no target", st)
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(a.response(DeviceMediaServer))), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.Get("ST") != DeviceMediaServer ||
+		resp.Header.Get("USN") != testUUID+"::"+DeviceMediaServer ||
+		resp.Header.Get("LOCATION") != a.Location ||
+		resp.Header.Get("CACHE-CONTROL") != "max-age=1800" {
+		t.Error("expect response headers", resp.Header)
+	}
+
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(a.notify(testUUID, SSDPAlive))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Method != "NOTIFY" || req.Header.Get("NTS") != SSDPAlive ||
+		req.Header.Get("USN") != testUUID || req.Host != "239.255.255.250:1900" {
+		t.Error("expect notify", req.Method, req.Host, req.Header)
+	}
+}
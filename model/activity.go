@@ -49,10 +49,65 @@ func (s Scrobble) PreferredArtist() string {
 	return s.Artist
 }
 
+// Linked scrobble service account used to forward user listens.
+type ScrobbleAccount struct {
+	gorm.Model
+	User    string `gorm:"uniqueIndex:idx_scrobble_account" json:"-"`
+	Service string `gorm:"uniqueIndex:idx_scrobble_account"`
+	Name    string // user name on the service
+	Token   string `json:"-"` // ListenBrainz user token or Last.fm session key
+}
+
+// Scrobble waiting to be forwarded to a linked service. Failed deliveries are
+// retried later so listens made while offline aren't lost.
+type QueuedScrobble struct {
+	gorm.Model
+	User        string   `gorm:"index:idx_queued_scrobble_user"`
+	Service     string   `gorm:"index:idx_queued_scrobble_user"`
+	Scrobble    Scrobble `gorm:"embedded"`
+	REID        string
+	RGID        string
+	Attempts    int
+	NextAttempt time.Time `gorm:"index:idx_queued_scrobble_next"`
+	LastError   string
+}
+
+// Result of importing listening history exported from another service.
+type ImportReport struct {
+	Listens    int      // listens read
+	Imported   int      // new track events
+	Duplicates int      // listens with an existing event
+	Unmatched  int      // listens without a matching track
+	Invalid    int      // listens without an artist, title or time
+	Missing    []string // sample of unmatched listens
+}
+
+// Activity event with resolved media metadata, used to export user
+// activity. Type is track, movie, tvepisode or episode.
+type ExportEvent struct {
+	Type    string
+	Date    time.Time
+	Artist  string `json:",omitempty"`
+	Release string `json:",omitempty"`
+	Series  string `json:",omitempty"`
+	Title   string `json:",omitempty"`
+	RID     string `json:",omitempty"`
+	REID    string `json:",omitempty"`
+	RGID    string `json:",omitempty"`
+	TMID    string `json:",omitempty"`
+	IMID    string `json:",omitempty"`
+	EID     string `json:",omitempty"`
+	TVID    int64  `json:",omitempty"`
+	Season  int    `json:",omitempty"`
+	Episode int    `json:",omitempty"`
+}
+
 type Events struct {
-	MovieEvents   []MovieEvent
-	EpisodeEvents []EpisodeEvent
-	TrackEvents   []TrackEvent
+	MovieEvents     []MovieEvent
+	EpisodeEvents   []EpisodeEvent
+	TrackEvents     []TrackEvent
+	TVEpisodeEvents []TVEpisodeEvent
+	TrackSkipEvents []TrackSkipEvent
 }
 
 type MovieEvent struct {
@@ -70,8 +125,8 @@ func (m *MovieEvent) IsValid() bool {
 
 type TrackEvent struct {
 	gorm.Model
-	User string    `gorm:"index:idx_track_user" json:"-"`
-	Date time.Time `gorm:"uniqueIndex:idx_track_date"` // TODO dup index name w/ music
+	User string    `gorm:"index:idx_track_user;uniqueIndex:idx_track_user_date,priority:1" json:"-"`
+	Date time.Time `gorm:"uniqueIndex:idx_track_user_date,priority:2"`
 	RID  string
 	RGID string
 	ETag string `gorm:"-"`
@@ -92,6 +147,51 @@ func (e *EpisodeEvent) IsValid() bool {
 	return e.User != "" && e.Date.IsZero() == false && e.EID != ""
 }
 
+// Track skipped by the user before it finished playing. Skips are not listens
+// and aren't included in charts or scrobbled.
+type TrackSkipEvent struct {
+	gorm.Model
+	User string    `gorm:"index:idx_track_skip_user;uniqueIndex:idx_track_skip_user_date,priority:1" json:"-"`
+	Date time.Time `gorm:"uniqueIndex:idx_track_skip_user_date,priority:2"`
+	RID  string
+	RGID string
+	ETag string `gorm:"-"`
+}
+
+func (t *TrackSkipEvent) IsValid() bool {
+	return t.User != "" && t.Date.IsZero() == false && t.RID != ""
+}
+
+// Listening activity for a recording used to evaluate smart playlists.
+type TrackPlays struct {
+	Count int
+	Last  time.Time
+	Skips int
+}
+
+// TV episode watch event using the TMDB series ID and episode numbering.
+type TVEpisodeEvent struct {
+	gorm.Model
+	User    string    `gorm:"index:idx_tv_episode_user" json:"-"`
+	Date    time.Time `gorm:"uniqueIndex:idx_tv_episode_date"`
+	TVID    int64
+	Season  int
+	Episode int
+	ETag    string `gorm:"-"`
+}
+
+func (e *TVEpisodeEvent) IsValid() bool {
+	return e.User != "" && e.Date.IsZero() == false && e.TVID != 0 && e.Episode != 0
+}
+
+// Music recommended from the user's listening activity. Ref is a station ref
+// that resolves to the same recommendation.
+type RecommendMusic struct {
+	Name   string
+	Ref    string
+	Tracks []Track
+}
+
 type ActivityArtist struct {
 	Artist Artist
 	Count  int
@@ -117,6 +217,27 @@ type ActivityEpisode struct {
 	Count   int
 }
 
+type ActivityGenre struct {
+	Genre string
+	Count int
+}
+
+type ActivityTVEpisode struct {
+	Series  TVSeries
+	Episode TVEpisode
+	Count   int
+}
+
+type ActivityTVSeries struct {
+	Series TVSeries
+	Count  int
+}
+
+type ActivitySeries struct {
+	Series Series
+	Count  int
+}
+
 type ActivityCount struct {
 	Date  time.Time
 	Count int
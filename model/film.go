@@ -133,3 +133,23 @@ type Trailer struct {
 	Official bool
 	URL      string
 }
+
+// TMDB movie chosen for a bucket object. This is honored by future syncs
+// instead of searching.
+type MovieMatch struct {
+	gorm.Model
+	Key  string `gorm:"uniqueIndex:idx_movie_match_key"`
+	TMID int64
+}
+
+// Bucket objects that couldn't be matched to a TMDB movie.
+type UnmatchedMovie struct {
+	gorm.Model
+	Key          string `gorm:"uniqueIndex:idx_unmatched_movie_key"`
+	Title        string
+	Year         string
+	Reason       string
+	Size         int64
+	ETag         string
+	LastModified time.Time
+}
@@ -45,6 +45,7 @@ type CoverArt interface {
 	HasOtherArtwork() bool
 	HasGroupArtwork() bool
 	ArtworkMBIDs() (string, string)
+	LocalArtworkPath() string
 }
 
 // Release info from MusicBrainz.
@@ -72,6 +73,7 @@ type Release struct {
 	Media          []Media `gorm:"-"`
 	SingleName     string  `gorm:"index:idx_release_single_name"`
 	GroupName      string  `gorm:"index:idx_release_group_name"`
+	LocalArtwork   string  // local image or audio file with embedded picture
 }
 
 func (r Release) HasArtwork() bool {
@@ -98,6 +100,10 @@ func (r Release) ArtworkMBIDs() (string, string) {
 	return r.REID, r.RGID
 }
 
+func (r Release) LocalArtworkPath() string {
+	return r.LocalArtwork
+}
+
 func (r Release) Official() bool {
 	return r.Status == "Official"
 }
@@ -174,6 +180,19 @@ type Track struct {
 	BackArtwork  bool
 	OtherArtwork string
 	GroupArtwork bool
+	Bucket       int    // index of the bucket with this track
+	Alternate    bool   `gorm:"default:false;index:idx_track_alternate"` // duplicate of a preferred track
+	Length       int    // recording length in seconds from MusicBrainz
+	Lyrics       string `gorm:"-" json:"-"` // lyrics found during sync
+	LocalArtwork string // local image or audio file with embedded picture
+}
+
+// Track lyrics from embedded tags or sidecar LRC files, keyed by track key.
+type Lyrics struct {
+	gorm.Model
+	Key    string `gorm:"uniqueIndex:idx_lyrics_key"`
+	Text   string // LRC or plain text
+	Synced bool
 }
 
 func (t *Track) BeforeCreate(tx *g.DB) (err error) {
@@ -213,6 +232,21 @@ func (t Track) ArtworkMBIDs() (string, string) {
 	return t.REID, t.RGID
 }
 
+func (t Track) LocalArtworkPath() string {
+	return t.LocalArtwork
+}
+
+// Local artwork found in a track directory, either an image file like
+// cover.jpg or a picture embedded in a track.
+type LocalArtwork struct {
+	gorm.Model
+	Dir      string `gorm:"uniqueIndex:idx_local_artwork_dir"`
+	Path     string `gorm:"index:idx_local_artwork_path"`
+	Key      string `gorm:"index:idx_local_artwork_key"` // opaque key used in urls
+	Bucket   int    // index of the bucket with the image file
+	Embedded bool
+}
+
 type Playlist struct {
 	gorm.Model
 	User       string `gorm:"uniqueIndex:idx_playlist"`
@@ -262,3 +296,66 @@ type ArtistBackground struct {
 // 	Title   string
 // 	Date    time.Time
 // }
+
+// Tracks grouped by artist and release that couldn't be assigned a
+// MusicBrainz release.
+type UnmatchedRelease struct {
+	ID         uint // first track ID
+	Artist     string
+	Release    string
+	Date       string
+	TrackCount int
+	DiscCount  int
+	Reason     string
+	Tracks     []Track
+}
+
+// Release pinned for all tracks within a bucket folder, either set directly
+// or chosen for unmatched tracks. The release is either the REID or the best
+// release in the RGID, which is resolved once and saved as the REID. Name, if
+// set, replaces the MusicBrainz release name.
+type ReleaseOverride struct {
+	gorm.Model
+	Folder string `gorm:"uniqueIndex:idx_release_override_folder"`
+	REID   string
+	RGID   string
+	Name   string
+}
+
+// Track title and numbering forced for a bucket key. Empty or zero fields are
+// not overridden.
+type TrackOverride struct {
+	gorm.Model
+	Key      string `gorm:"uniqueIndex:idx_track_override_key"`
+	Title    string
+	TrackNum int
+	DiscNum  int
+}
+
+// Smart playlist with tracks selected by rules that match library fields and
+// the user's listening activity. Refresh is either access, where rules are
+// evaluated each time the playlist is used, or schedule where the playlist is
+// periodically rebuilt.
+type SmartPlaylist struct {
+	gorm.Model
+	User       string    `gorm:"uniqueIndex:idx_smart_playlist" json:"-"`
+	Name       string    `gorm:"uniqueIndex:idx_smart_playlist"`
+	Rule       SmartRule `gorm:"serializer:json"`
+	Sort       string
+	Order      string // asc or desc
+	Limit      int
+	Refresh    string
+	Playlist   []byte `json:"-"`
+	TrackCount int
+	Refreshed  time.Time
+}
+
+// Smart playlist rule. A rule either matches a field or combines nested rules
+// where Match is all (and) or any (or).
+type SmartRule struct {
+	Match string      `json:",omitempty"`
+	Rules []SmartRule `json:",omitempty"`
+	Field string      `json:",omitempty"`
+	Op    string      `json:",omitempty"`
+	Value string      `json:",omitempty"`
+}
@@ -182,3 +182,26 @@ func (c TVEpisodeCrew) HasJob(name string) bool {
 func (c TVEpisodeCrew) GetPerson() Person {
 	return c.Person
 }
+
+// TMDB series chosen for a series name and year. This is honored by future
+// syncs instead of searching.
+type TVMatch struct {
+	gorm.Model
+	Name string `gorm:"uniqueIndex:idx_tv_match"`
+	Year string `gorm:"uniqueIndex:idx_tv_match"`
+	TVID int64
+}
+
+// Bucket objects that couldn't be matched to a TMDB series episode.
+type UnmatchedTVEpisode struct {
+	gorm.Model
+	Key          string `gorm:"uniqueIndex:idx_unmatched_episode_key"`
+	Series       string
+	Year         string
+	Season       int
+	Episode      int
+	Reason       string
+	Size         int64
+	ETag         string
+	LastModified time.Time
+}
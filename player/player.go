@@ -26,7 +26,6 @@ import (
 	"io"
 	"net/http"
 	"net/url"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -38,6 +37,7 @@ import (
 	"github.com/faiface/beep/vorbis"
 	"github.com/faiface/beep/wav"
 	"takeoutfm.dev/takeout/client"
+	"takeoutfm.dev/takeout/lib/icy"
 	"takeoutfm.dev/takeout/lib/log"
 	"takeoutfm.dev/takeout/spiff"
 )
@@ -45,8 +45,8 @@ import (
 type playing struct {
 	format   beep.Format
 	streamer beep.StreamSeekCloser
-	headers  IcyHeaders
-	metadata IcyMetadata
+	headers  icy.Headers
+	metadata icy.Metadata
 }
 
 type playerAction int32
@@ -161,6 +161,15 @@ func (p *Player) Track() (string, string, string, string) {
 	return track.Title, track.Album, track.Creator, track.Image
 }
 
+// Location returns the first location of the current track.
+func (p *Player) Location() string {
+	location := p.current().Location
+	if len(location) == 0 {
+		return ""
+	}
+	return location[0]
+}
+
 func (p *Player) ETag() string {
 	id := p.current().Identifier
 	if len(id) == 0 {
@@ -313,7 +322,7 @@ func (p *Player) playIndex(index int) {
 	}
 	req.Header.Add(client.HeaderUserAgent, p.context.UserAgent())
 	if p.IsStream() {
-		req.Header.Add(HeaderIcyMetaData, "1")
+		req.Header.Add(icy.HeaderMetaData, "1")
 	}
 
 	c := http.Client{}
@@ -324,22 +333,15 @@ func (p *Player) playIndex(index int) {
 	}
 	reader := resp.Body
 
-	var headers *IcyHeaders
-	if p.IsStream() && resp.Header.Get(HeaderIcyMetaInt) != "" {
-		headers = &IcyHeaders{
-			Bitrate:     atoi(resp.Header.Get(HeaderIcyBr)),
-			Description: resp.Header.Get(HeaderIcyDescription),
-			Genre:       resp.Header.Get(HeaderIcyGenre),
-			Interval:    atoi(resp.Header.Get(HeaderIcyMetaInt)),
-			Name:        resp.Header.Get(HeaderIcyName),
-			Public:      resp.Header.Get(HeaderIcyPublic) == "true",
-			Url:         resp.Header.Get(HeaderIcyUrl),
-		}
+	var headers *icy.Headers
+	if p.IsStream() && resp.Header.Get(icy.HeaderMetaInt) != "" {
+		h := icy.ParseHeaders(resp.Header)
+		headers = &h
 		if headers.Interval > 0 {
-			if headers.Interval > maxIntervalLength {
-				panic(ErrInvalidIntervalLength)
+			if headers.Interval > icy.MaxInterval {
+				panic(icy.ErrInvalidIntervalLength)
 			} else if headers.Interval > 0 {
-				reader = NewIcyReader(headers.Interval, reader, p.onIcyMetadata)
+				reader = icy.NewReader(headers.Interval, reader, p.onIcyMetadata)
 			}
 		}
 	}
@@ -375,14 +377,6 @@ func (p *Player) playIndex(index int) {
 	p.onTrack()
 }
 
-func atoi(s string) int {
-	i, err := strconv.Atoi(s)
-	if err != nil {
-		i = 0
-	}
-	return i
-}
-
 func decoder(reader io.ReadCloser, contentType, path string) (beep.StreamSeekCloser, beep.Format, error) {
 	if contentType == "audio/flac" ||
 		contentType == "audio/x-flac" ||
@@ -414,7 +408,7 @@ func (p *Player) onPause() {
 	}
 }
 
-func (p *Player) onIcyMetadata(data IcyMetadata) {
+func (p *Player) onIcyMetadata(data icy.Metadata) {
 	p.playing.metadata = data
 	p.onTrack()
 }
@@ -19,8 +19,10 @@
 package view // import "takeoutfm.dev/takeout/view"
 
 import (
+	"fmt"
 	"time"
 
+	"takeoutfm.dev/takeout/lib/search"
 	"takeoutfm.dev/takeout/model"
 )
 
@@ -38,6 +40,7 @@ type Index struct {
 	HasShows     bool
 	HasPodcasts  bool
 	HasPlaylists bool
+	IsAdmin      bool
 }
 
 type Home struct {
@@ -46,13 +49,25 @@ type Home struct {
 	AddedMovies     []model.Movie
 	NewMovies       []model.Movie
 	RecommendMovies []model.Recommend
+	RecommendMusic  []model.RecommendMusic
 	NewEpisodes     []model.Episode
 	NewSeries       []model.Series
 	AddedTVEpisodes []model.TVEpisode
 }
 
+// Page describes the part of a list included in a response. Prev and Next
+// are template links to the neighboring pages.
+type Page struct {
+	Total  int
+	Offset int
+	Limit  int
+	Prev   string `json:"-"`
+	Next   string `json:"-"`
+}
+
 type Artists struct {
 	Artists []model.Artist
+	Page
 }
 
 type Artist struct {
@@ -74,6 +89,33 @@ type Popular struct {
 	Popular []model.Track
 }
 
+// Setlist is a concert setlist from setlist.fm. The Ref resolves the songs
+// to local tracks.
+type Setlist struct {
+	ID      string
+	Artist  string
+	Date    time.Time
+	Venue   string
+	City    string
+	Country string
+	Tour    string
+	URL     string
+	Ref     string
+}
+
+type Setlists struct {
+	Artist   model.Artist
+	Setlists []Setlist
+}
+
+// SetlistTracks has the local tracks for the setlist songs and the songs
+// that couldn't be found.
+type SetlistTracks struct {
+	Setlist Setlist
+	Tracks  []model.Track
+	Missing []string
+}
+
 type Singles struct {
 	Artist  model.Artist
 	Singles []model.Track
@@ -94,6 +136,19 @@ type Release struct {
 	Similar []model.Release
 }
 
+// Lyrics for a track. Line times are in seconds and only set when the lyrics
+// are synced.
+type Lyrics struct {
+	Track  model.Track
+	Synced bool
+	Lines  []LyricsLine
+}
+
+type LyricsLine struct {
+	Time float64
+	Text string
+}
+
 type Search struct {
 	Artists    []model.Artist
 	Releases   []model.Release
@@ -105,6 +160,39 @@ type Search struct {
 	TVEpisodes []model.TVEpisode
 	Query      string
 	Hits       int
+
+	TrackResult     SearchResult
+	MovieResult     SearchResult
+	EpisodeResult   SearchResult
+	TVEpisodeResult SearchResult
+}
+
+// Suggest has search suggestions for a prefix, most common first.
+type Suggest struct {
+	Query    string
+	Artists  []Suggestion
+	Releases []Suggestion
+	Tracks   []Suggestion
+	Movies   []Suggestion
+	Series   []Suggestion
+	People   []Suggestion // ID is the PEID
+	Podcasts []Suggestion
+}
+
+// Suggestion is a suggested name with the ID of the named item and a ref
+// when the item can be played.
+type Suggestion struct {
+	Name string
+	ID   int64
+	Ref  string `json:",omitempty"`
+}
+
+// SearchResult has the total number of matches, the score of each returned
+// item and the facet counts that can be used as search filters.
+type SearchResult struct {
+	Total  int
+	Scores []float64
+	Facets map[string][]search.FacetCount
 }
 
 type Radio struct {
@@ -115,10 +203,35 @@ type Radio struct {
 	Series  []model.Station
 	Other   []model.Station
 	Stream  []model.Station
+	Mix     []model.Station
+}
+
+// StreamNowPlaying has the current and recent titles of a relayed radio
+// stream, most recent first.
+type StreamNowPlaying struct {
+	Station   model.Station
+	Title     string
+	Listeners int
+	History   []StreamTitle
+}
+
+type StreamTitle struct {
+	Title string
+	URL   string `json:",omitempty"`
+	Time  time.Time
+}
+
+// StreamRecording is a radio stream recording in progress.
+type StreamRecording struct {
+	Station model.Station
+	Key     string
+	Start   time.Time
+	End     time.Time
 }
 
 type Movies struct {
 	Movies []model.Movie
+	Page
 }
 
 type Movie struct {
@@ -159,11 +272,13 @@ type TVCredits struct {
 type Genre struct {
 	Name   string
 	Movies []model.Movie
+	Page
 }
 
 type Keyword struct {
 	Name   string
 	Movies []model.Movie
+	Page
 }
 
 type Watch struct {
@@ -178,6 +293,7 @@ type TVList struct {
 
 type TVShows struct {
 	Series []model.TVSeries
+	Page
 }
 
 type TVSeries struct {
@@ -209,6 +325,7 @@ type TVEpisode struct {
 
 type Podcasts struct {
 	Series []model.Series
+	Page
 }
 
 type Series struct {
@@ -239,10 +356,78 @@ type TrackStats struct {
 	ListenCount  int
 }
 
+// Year or month listening summary.
+type TrackReport struct {
+	Interval      string
+	Start         time.Time
+	End           time.Time
+	ListenCount   int
+	ListenTime    int // seconds, estimated for tracks without a length
+	ArtistCount   int
+	ReleaseCount  int
+	TrackCount    int
+	Artists       []model.ActivityArtist
+	Releases      []model.ActivityRelease
+	Tracks        []model.ActivityTrack
+	Genres        []model.ActivityGenre
+	NewArtists    []model.ActivityArtist // first listened to in this interval
+	LongestStreak int                    // consecutive days with listens
+	StreakStart   time.Time
+	TopDay        model.ActivityCount
+	Hours         []int // listens by hour of day
+}
+
+// Name is the year or the month and year for monthly reports.
+func (r *TrackReport) Name() string {
+	if r.Start.Year() == r.End.Year() && r.Start.Month() == r.End.Month() {
+		return fmt.Sprintf("%s %d", r.Start.Month(), r.Start.Year())
+	}
+	return fmt.Sprintf("%d", r.Start.Year())
+}
+
+func (r *TrackReport) ListenHours() int {
+	return r.ListenTime / 3600
+}
+
 type TrackHistory struct {
 	Tracks []model.ActivityTrack
 }
 
+type MovieHistory struct {
+	Movies []model.ActivityMovie
+}
+
+type MovieStats struct {
+	Interval   string
+	Movies     []model.ActivityMovie
+	MovieCount int
+	WatchCount int
+}
+
+type TVHistory struct {
+	Episodes []model.ActivityTVEpisode
+}
+
+type TVStats struct {
+	Interval     string
+	Series       []model.ActivityTVSeries
+	SeriesCount  int
+	EpisodeCount int
+}
+
+type PodcastHistory struct {
+	Episodes []model.ActivityEpisode
+}
+
+type PodcastStats struct {
+	Interval     string
+	Series       []model.ActivitySeries
+	SeriesCount  int
+	EpisodeCount int
+}
+
+// Counts by day or month; listens for tracks, episodes for podcasts, and
+// minutes watched for movies and tv.
 type TrackCounts struct {
 	Counts []model.ActivityCount
 }
@@ -296,3 +481,65 @@ type Playlists struct {
 func NewPlaylist(p model.Playlist) *Playlist {
 	return &Playlist{ID: int(p.ID), Name: p.Name, TrackCount: p.TrackCount}
 }
+
+type SmartPlaylist struct {
+	ID         int
+	Name       string
+	Rule       model.SmartRule
+	Sort       string
+	Order      string
+	Limit      int
+	Refresh    string
+	TrackCount int
+	Refreshed  time.Time
+}
+
+type SmartPlaylists struct {
+	SmartPlaylists []SmartPlaylist
+}
+
+func NewSmartPlaylist(p model.SmartPlaylist) *SmartPlaylist {
+	return &SmartPlaylist{
+		ID:         int(p.ID),
+		Name:       p.Name,
+		Rule:       p.Rule,
+		Sort:       p.Sort,
+		Order:      p.Order,
+		Limit:      p.Limit,
+		Refresh:    p.Refresh,
+		TrackCount: p.TrackCount,
+		Refreshed:  p.Refreshed,
+	}
+}
+
+type Unmatched struct {
+	Releases   []model.UnmatchedRelease
+	Movies     []model.UnmatchedMovie
+	TVEpisodes []model.UnmatchedTVEpisode
+}
+
+type Scrobblers struct {
+	Accounts []model.ScrobbleAccount
+	Queued   []model.QueuedScrobble
+}
+
+type Overrides struct {
+	Releases []model.ReleaseOverride
+	Tracks   []model.TrackOverride
+	Movies   []model.MovieMatch
+	TV       []model.TVMatch
+}
+
+type MatchCandidate struct {
+	ID     string
+	Title  string
+	Date   string
+	Detail string
+}
+
+type MatchCandidates struct {
+	Title      string
+	Reason     string
+	Match      string // POST {"ID": ...} to match
+	Candidates []MatchCandidate
+}